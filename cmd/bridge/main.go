@@ -16,6 +16,7 @@ import (
 	"audiobookshelf-sonos-bridge/internal/abs"
 	"audiobookshelf-sonos-bridge/internal/cache"
 	"audiobookshelf-sonos-bridge/internal/config"
+	"audiobookshelf-sonos-bridge/internal/pathmap"
 	"audiobookshelf-sonos-bridge/internal/sonos"
 	"audiobookshelf-sonos-bridge/internal/store"
 	"audiobookshelf-sonos-bridge/internal/stream"
@@ -61,6 +62,12 @@ func main() {
 	cacheStore := store.NewCacheStore(db)
 	deviceStore := store.NewDeviceStore(db)
 	playbackStore := store.NewPlaybackStore(db)
+	listeningStore := store.NewListeningStore(db)
+	pathMappingStore := store.NewPathMappingStore(db)
+	itemSettingsStore := store.NewItemSettingsStore(db)
+	pinStore := store.NewPinStore(db)
+	jobStore := store.NewJobStore(db)
+	revocationStore := store.NewRevocationStore(db)
 
 	// Startup cleanup
 	slog.Info("performing startup cleanup")
@@ -73,6 +80,14 @@ func main() {
 		slog.Info("reset stale cache entries", "count", count)
 	}
 
+	// Queue transcoding jobs that were running again
+	jobCount, err := jobStore.ResetRunning()
+	if err != nil {
+		slog.Warn("failed to reset running transcoding jobs", "error", err)
+	} else if jobCount > 0 {
+		slog.Info("requeued interrupted transcoding jobs", "count", jobCount)
+	}
+
 	// Stop all playback sessions that were left playing from previous run
 	playbackCount, err := playbackStore.StopAllPlaying()
 	if err != nil {
@@ -81,20 +96,30 @@ func main() {
 		slog.Info("stopped stale playback sessions", "count", playbackCount)
 	}
 
-	// Delete stale playback sessions (older than 24 hours)
-	staleCount, err := playbackStore.DeleteStale(24 * time.Hour)
+	// Delete stale playback sessions (older than 24 hours) and revoke their stream tokens
+	staleIDs, err := playbackStore.DeleteStale(24 * time.Hour)
 	if err != nil {
 		slog.Warn("failed to delete stale playback sessions", "error", err)
-	} else if staleCount > 0 {
-		slog.Info("deleted stale playback sessions", "count", staleCount)
+	} else if len(staleIDs) > 0 {
+		for _, id := range staleIDs {
+			if err := revocationStore.Revoke(store.RevokePlayback, id); err != nil {
+				slog.Warn("failed to revoke stream tokens of stale playback session", "error", err)
+			}
+		}
+		slog.Info("deleted stale playback sessions", "count", len(staleIDs))
 	}
 
-	// Clean up old sessions (not used in 7 days)
-	sessionCount, err := sessionStore.DeleteOlderThan(time.Now().Add(-7 * 24 * time.Hour))
+	// Clean up old sessions (not used in 7 days) and revoke their stream tokens
+	sessionIDs, err := sessionStore.DeleteOlderThan(time.Now().Add(-7 * 24 * time.Hour))
 	if err != nil {
 		slog.Warn("failed to cleanup old sessions", "error", err)
-	} else if sessionCount > 0 {
-		slog.Info("cleaned up old sessions", "count", sessionCount)
+	} else if len(sessionIDs) > 0 {
+		for _, id := range sessionIDs {
+			if err := revocationStore.Revoke(store.RevokeSession, id); err != nil {
+				slog.Warn("failed to revoke stream tokens of old session", "error", err)
+			}
+		}
+		slog.Info("cleaned up old sessions", "count", len(sessionIDs))
 	}
 
 	// Initialize Audiobookshelf client
@@ -103,16 +128,60 @@ func main() {
 	// Initialize cache subsystem
 	cacheIndex := cache.NewIndex(cacheStore, cfg.CacheDir)
 	transcoder := cache.NewTranscoder()
-	cacheWorker := cache.NewWorker(cacheIndex, transcoder, cfg.TranscodeWorkers)
+	cacheWorker := cache.NewWorker(cacheIndex, transcoder, jobStore, cfg.TranscodeWorkers)
+	cacheWorker.SetPassthrough(cfg.Passthrough)
+
+	// Background jobs (warmup, bulk) run only in the allowed hours, with lower priority
+	ioClass := cfg.BackgroundIOClass
+	if ioClass == "none" {
+		ioClass = ""
+	}
+	cacheWorker.SetBackground(cache.BackgroundConfig{
+		Allowed: cfg.BackgroundAllowed,
+		MaxJobs: cfg.BackgroundWorkers,
+		Nice:    cfg.BackgroundNice,
+		IOClass: ioClass,
+	})
+
+	cacheEvictor := cache.NewEvictor(cacheIndex, playbackStore, cache.EvictorConfig{
+		MaxBytes:      cfg.CacheMaxSize,
+		HighWatermark: cfg.CacheHighWatermark,
+		LowWatermark:  cfg.CacheLowWatermark,
+	})
 
 	// Cleanup temp files on startup
 	if err := cacheIndex.CleanupTempFiles(); err != nil {
 		slog.Warn("failed to cleanup temp files", "error", err)
 	}
 
-	// Initialize stream token generator (1 hour TTL)
-	tokenGen := stream.NewTokenGenerator(cfg.SessionSecret, time.Hour)
+	// Invalidate outputs of changed transcode profiles
+	if pruned, err := cacheIndex.PruneOutdatedProfiles(); err != nil {
+		slog.Warn("failed to prune outdated cache profiles", "error", err)
+	} else if pruned > 0 {
+		slog.Info("pruned cache entries of outdated profiles", "count", pruned)
+	}
+
+	// Initialize stream token generator and restrict streaming to the allowed networks
+	tokenGen := stream.NewTokenGenerator(cfg.SessionSecret, cfg.StreamTokenTTL)
+	tokenGen.SetRevocations(revocationStore)
+	tokenGen.SetSpeakerBinding(cfg.StreamBindSpeaker)
 	streamHandler := stream.NewHandler(tokenGen, cacheIndex, cfg.PublicURL)
+	networkFilter, err := stream.NewNetworkFilter(cfg.AllowedNetworks, cfg.TrustedProxies)
+	if err != nil {
+		slog.Error("invalid stream network configuration", "error", err)
+		os.Exit(1)
+	}
+	streamHandler.SetNetworkFilter(networkFilter)
+	if cfg.HLS {
+		streamHandler.SetHLS(cache.NewHLS(cacheIndex))
+	}
+	var liveTranscoder *cache.Live
+	if cfg.LiveStreaming {
+		// Live streams share the transcoding capacity of the worker pool
+		liveTranscoder = cache.NewLive(cfg.TranscodeWorkers)
+		streamHandler.SetLive(liveTranscoder)
+		cacheWorker.SetLive(liveTranscoder)
+	}
 
 	// Initialize auth handler
 	authHandler, err := web.NewAuthHandler(absClient, sessionStore, cfg.SessionSecret)
@@ -120,6 +189,14 @@ func main() {
 		slog.Error("failed to initialize auth handler", "error", err)
 		os.Exit(1)
 	}
+	authHandler.SetTokenRevoker(tokenGen)
+
+	// Initialize path mapping resolver (derives mappings from ABS library folders)
+	pathResolver := pathmap.NewResolver(pathMappingStore, sessionStore, absClient, authHandler, cfg.MediaDir, cfg.MapABSPathToLocal)
+	authHandler.OnLogin(pathResolver.OnLogin)
+
+	// Jobs restored from the queue download remote files with a session's token
+	cacheWorker.SetClientProvider(cache.SessionClientProvider(absClient, sessionStore, authHandler))
 
 	// Load templates
 	templates, err := loadTemplates()
@@ -131,9 +208,25 @@ func main() {
 	// Initialize Sonos discovery
 	discovery := sonos.NewDiscovery(deviceStore)
 
+	// Initialize ABS listening session tracking
+	listeningTracker := web.NewListeningTracker(listeningStore)
+
+	// Initialize real-time progress updates from ABS
+	remoteProgress := web.NewRemoteProgressWatcher(absClient, sessionStore, authHandler)
+
 	// Initialize handlers
-	libraryHandler := web.NewLibraryHandler(authHandler, templates, cacheStore)
+	libraryHandler := web.NewLibraryHandler(authHandler, templates, cacheStore, itemSettingsStore, pinStore, cfg.AudioPreset)
 	sonosHandler := web.NewSonosHandler(discovery, templates)
+	diagnosticsHandler := web.NewDiagnosticsHandler(authHandler, pathResolver)
+	cacheAdminHandler := web.NewCacheAdminHandler(
+		authHandler,
+		cacheStore,
+		cacheIndex,
+		cacheWorker,
+		playbackStore,
+		pathResolver.Map,
+		cfg.CacheMaxSize,
+	)
 	playerHandler := web.NewPlayerHandler(
 		authHandler,
 		cacheIndex,
@@ -143,23 +236,49 @@ func main() {
 		templates,
 		deviceStore,
 		playbackStore,
-		cfg.MapABSPathToLocal,
+		listeningTracker,
+		remoteProgress,
+		pathResolver.Map,
+		itemSettingsStore,
+		cfg.AudioPreset,
+		cfg.HLS,
+		liveTranscoder,
 	)
 
 	// Initialize progress syncer
-	progressSyncer := web.NewProgressSyncer(absClient, playbackStore, sessionStore, deviceStore, authHandler)
+	progressSyncer := web.NewProgressSyncer(absClient, playbackStore, sessionStore, deviceStore, listeningTracker, remoteProgress, playerHandler, authHandler)
 
 	// Initialize sleep timer worker
-	sleepTimerWorker := web.NewSleepTimerWorker(playbackStore, sessionStore, deviceStore, absClient, authHandler)
+	sleepTimerWorker := web.NewSleepTimerWorker(playbackStore, sessionStore, deviceStore, listeningTracker, remoteProgress, absClient, authHandler)
+
+	// Initialize stream token renewal for playbacks that outlive the token TTL
+	tokenRenewer := web.NewStreamTokenRenewer(playerHandler)
 
 	// Initialize cache warmup job
+	warmupConfig := cache.DefaultWarmupConfig
+	warmupConfig.BatchSize = cfg.WarmupBudget
+	warmupConfig.UserBudget = cfg.WarmupUserBudget
 	warmupJob := cache.NewWarmupJob(
 		cacheIndex,
 		cacheWorker,
 		absClient,
 		sessionStore,
+		pinStore,
+		authHandler,
+		web.NewCacheJobResolver(pathResolver.Map),
+		warmupConfig,
+	)
+
+	// Initialize the scanner for changed source files
+	sourceScanner := cache.NewSourceScanner(
+		cacheIndex,
+		cacheWorker,
+		absClient,
+		sessionStore,
+		playbackStore,
 		authHandler,
-		cache.DefaultWarmupConfig,
+		web.NewCacheJobResolver(pathResolver.Map),
+		cache.DefaultScanInterval,
 	)
 
 	// Setup HTTP router
@@ -233,6 +352,11 @@ if (savedLibrary) {
 		return authHandler.RequireAuth(http.HandlerFunc(h))
 	}
 
+	// Helper for handlers limited to ABS admin and root users
+	admin := func(h http.HandlerFunc) http.Handler {
+		return authHandler.RequireAuth(authHandler.RequireAdmin(http.HandlerFunc(h)))
+	}
+
 	// Library routes (protected)
 	mux.Handle("GET /library", auth(libraryHandler.HandleLibraries))
 	mux.Handle("GET /libraries", auth(libraryHandler.HandleLibraries))
@@ -241,10 +365,16 @@ if (savedLibrary) {
 	mux.Handle("GET /library/series/{id}", auth(libraryHandler.HandleSeriesDetail))
 	mux.Handle("GET /library/authors", auth(libraryHandler.HandleAuthors))
 	mux.Handle("GET /library/genres", auth(libraryHandler.HandleGenres))
+	mux.Handle("GET /library/collections", auth(libraryHandler.HandleCollections))
+	mux.Handle("GET /library/collections/{id}", auth(libraryHandler.HandleCollectionDetail))
+	mux.Handle("GET /library/playlists", auth(libraryHandler.HandlePlaylists))
+	mux.Handle("GET /library/playlists/{id}", auth(libraryHandler.HandlePlaylistDetail))
 	mux.Handle("GET /libraries/{id}/items", auth(libraryHandler.HandleLibraryItems))
 	mux.Handle("GET /libraries/{id}/filterdata", auth(libraryHandler.HandleFilterData))
 	mux.Handle("GET /cover/{id}", auth(libraryHandler.HandleCover))
 	mux.Handle("GET /item/{id}", auth(libraryHandler.HandleItem))
+	mux.Handle("POST /item/{id}/audio-preset", auth(libraryHandler.HandleSetAudioPreset))
+	mux.Handle("POST /item/{id}/pin", auth(libraryHandler.HandleSetPinned))
 
 	// Sonos routes (protected)
 	mux.Handle("GET /sonos/devices", auth(sonosHandler.HandleGetDevices))
@@ -257,12 +387,20 @@ if (savedLibrary) {
 	mux.Handle("GET /player/{id}", auth(playerHandler.HandlePlayer))
 	mux.Handle("GET /status", auth(playerHandler.HandleStatus))
 	mux.Handle("GET /cache/status/{id}", auth(playerHandler.HandleCacheStatus))
+	mux.Handle("GET /cache/status/{id}/events", auth(playerHandler.HandleCacheEvents))
 
 	// Transport control routes (protected)
 	mux.Handle("POST /transport/pause", auth(playerHandler.HandlePause))
 	mux.Handle("POST /transport/resume", auth(playerHandler.HandleResume))
 	mux.Handle("POST /transport/seek", auth(playerHandler.HandleSeek))
+	mux.Handle("POST /transport/speed", auth(playerHandler.HandleSetSpeed))
 	mux.Handle("POST /transport/stop", auth(playerHandler.HandleStop))
+	mux.Handle("POST /transport/remote-progress/dismiss", auth(playerHandler.HandleDismissRemoteProgress))
+	mux.Handle("GET /bookmarks", auth(playerHandler.HandleListBookmarks))
+	mux.Handle("POST /bookmarks", auth(playerHandler.HandleCreateBookmark))
+	mux.Handle("POST /bookmarks/{time}/jump", auth(playerHandler.HandleJumpToBookmark))
+	mux.Handle("PATCH /bookmarks/{time}", auth(playerHandler.HandleUpdateBookmark))
+	mux.Handle("DELETE /bookmarks/{time}", auth(playerHandler.HandleDeleteBookmark))
 	mux.Handle("POST /transport/volume", auth(playerHandler.HandleSetVolume))
 	mux.Handle("POST /transport/mute", auth(playerHandler.HandleToggleMute))
 
@@ -286,6 +424,21 @@ if (savedLibrary) {
 	mux.Handle("DELETE /sleep-timer", auth(playerHandler.HandleDeleteSleepTimer))
 	mux.Handle("GET /sleep-timer", auth(playerHandler.HandleGetSleepTimer))
 
+	// Diagnostics routes (admin only)
+	mux.Handle("GET /diagnostics/paths", admin(diagnosticsHandler.HandlePathMappings))
+	mux.Handle("POST /diagnostics/paths/resolve", admin(diagnosticsHandler.HandleResolvePathMappings))
+
+	// Cache administration (admin only)
+	mux.Handle("GET /admin/cache", admin(cacheAdminHandler.HandlePage))
+	mux.Handle("GET /api/cache", admin(cacheAdminHandler.HandleList))
+	mux.Handle("POST /api/cache/enqueue", admin(cacheAdminHandler.HandleEnqueue))
+	mux.Handle("POST /api/cache/{id}/retry", admin(cacheAdminHandler.HandleRetry))
+	mux.Handle("POST /api/cache/{id}/retranscode", admin(cacheAdminHandler.HandleRetranscode))
+	mux.Handle("POST /api/cache/{id}/cancel", admin(cacheAdminHandler.HandleCancel))
+	mux.Handle("GET /api/cache/{id}/attempts", admin(cacheAdminHandler.HandleAttempts))
+	mux.Handle("GET /api/cache/{id}/attempts/{attempt}/log", admin(cacheAdminHandler.HandleAttemptLog))
+	mux.Handle("DELETE /api/cache/{id}", admin(cacheAdminHandler.HandleDelete))
+
 	// Wrap with logging middleware
 	handler_http := web.LoggingMiddleware(logger)(mux)
 
@@ -304,9 +457,17 @@ if (savedLibrary) {
 
 	// Start background services
 	cacheWorker.Start(ctx)
+	cacheEvictor.Start(ctx)
+	pathResolver.Start(ctx)
+	remoteProgress.Start(ctx)
 	progressSyncer.Start(ctx)
 	sleepTimerWorker.Start(ctx)
+	tokenRenewer.Start(ctx)
 	warmupJob.Start(ctx)
+	sourceScanner.Start(ctx)
+
+	// Queue pending cache entries whose jobs were lost
+	go cacheWorker.Replay(ctx, web.NewCacheJobResolver(pathResolver.Map))
 
 	// Log path mappings
 	slog.Info("path mappings configured",
@@ -340,9 +501,14 @@ if (savedLibrary) {
 	slog.Info("shutting down server")
 
 	// Stop background services
+	sourceScanner.Stop()
 	warmupJob.Stop()
 	sleepTimerWorker.Stop()
+	tokenRenewer.Stop()
 	progressSyncer.Stop()
+	remoteProgress.Stop()
+	pathResolver.Stop()
+	cacheEvictor.Stop()
 	cacheWorker.Stop()
 
 	// Graceful shutdown with timeout
@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -152,6 +153,15 @@ func (c *Client) Login(ctx context.Context, username, password string) (*User, e
 	return &loginResp.User, nil
 }
 
+// GetMe returns the user the client's token belongs to.
+func (c *Client) GetMe(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.get(ctx, "/api/me", &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // GetLibraries returns all libraries accessible to the user.
 func (c *Client) GetLibraries(ctx context.Context) ([]Library, error) {
 	var resp LibrariesResponse
@@ -193,12 +203,20 @@ func (c *Client) SearchLibrary(ctx context.Context, libraryID, query string, lim
 	seen := make(map[string]bool)
 
 	// Convert search results to ItemsResponse format
-	items := make([]LibraryItem, 0, len(resp.Book))
+	items := make([]LibraryItem, 0, len(resp.Book)+len(resp.Podcast))
 	for _, result := range resp.Book {
 		items = append(items, result.LibraryItem)
 		seen[result.LibraryItem.ID] = true
 	}
 
+	// Podcast libraries return their matches under "podcast"
+	for _, result := range resp.Podcast {
+		if !seen[result.LibraryItem.ID] {
+			items = append(items, result.LibraryItem)
+			seen[result.LibraryItem.ID] = true
+		}
+	}
+
 	// Also fetch books from matching authors
 	for _, author := range resp.Authors {
 		if author.ID == "" {
@@ -293,7 +311,13 @@ func (c *Client) GetItemsInProgress(ctx context.Context, limit int) ([]ItemInPro
 
 // GetProgress returns the playback progress for an item.
 func (c *Client) GetProgress(ctx context.Context, itemID string) (*Progress, error) {
-	path := fmt.Sprintf("/api/me/progress/%s", itemID)
+	return c.GetEpisodeProgress(ctx, itemID, "")
+}
+
+// GetEpisodeProgress returns the playback progress for a podcast episode.
+// An empty episodeID returns the progress of the item itself.
+func (c *Client) GetEpisodeProgress(ctx context.Context, itemID, episodeID string) (*Progress, error) {
+	path := progressPath(itemID, episodeID)
 
 	var resp Progress
 	if err := c.get(ctx, path, &resp); err != nil {
@@ -301,6 +325,7 @@ func (c *Client) GetProgress(ctx context.Context, itemID string) (*Progress, err
 		if err == ErrNotFound {
 			return &Progress{
 				LibraryItemID: itemID,
+				EpisodeID:     episodeID,
 				CurrentTime:   0,
 				Progress:      0,
 			}, nil
@@ -312,7 +337,13 @@ func (c *Client) GetProgress(ctx context.Context, itemID string) (*Progress, err
 
 // UpdateProgress updates the playback progress for an item.
 func (c *Client) UpdateProgress(ctx context.Context, itemID string, update ProgressUpdate) error {
-	path := fmt.Sprintf("/api/me/progress/%s", itemID)
+	return c.UpdateEpisodeProgress(ctx, itemID, "", update)
+}
+
+// UpdateEpisodeProgress updates the playback progress for a podcast episode.
+// An empty episodeID updates the progress of the item itself.
+func (c *Client) UpdateEpisodeProgress(ctx context.Context, itemID, episodeID string, update ProgressUpdate) error {
+	path := progressPath(itemID, episodeID)
 
 	body, err := json.Marshal(update)
 	if err != nil {
@@ -343,6 +374,139 @@ func (c *Client) UpdateProgress(ctx context.Context, itemID string, update Progr
 	return nil
 }
 
+// progressPath returns the ABS progress endpoint for an item or podcast episode.
+func progressPath(itemID, episodeID string) string {
+	if episodeID != "" {
+		return fmt.Sprintf("/api/me/progress/%s/%s", itemID, episodeID)
+	}
+	return fmt.Sprintf("/api/me/progress/%s", itemID)
+}
+
+// GetMediaProgress returns all progress entries of the current user.
+// Podcast episodes have their own entries identified by EpisodeID.
+func (c *Client) GetMediaProgress(ctx context.Context) ([]Progress, error) {
+	var resp MeResponse
+	if err := c.get(ctx, "/api/me", &resp); err != nil {
+		return nil, err
+	}
+	return resp.MediaProgress, nil
+}
+
+// GetBookmarks returns the user's bookmarks of an item, ordered by time.
+func (c *Client) GetBookmarks(ctx context.Context, itemID string) ([]Bookmark, error) {
+	var resp MeResponse
+	if err := c.get(ctx, "/api/me", &resp); err != nil {
+		return nil, err
+	}
+
+	bookmarks := make([]Bookmark, 0)
+	for _, b := range resp.Bookmarks {
+		if b.LibraryItemID == itemID {
+			bookmarks = append(bookmarks, b)
+		}
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].Time < bookmarks[j].Time
+	})
+	return bookmarks, nil
+}
+
+// CreateBookmark creates a bookmark in an item.
+func (c *Client) CreateBookmark(ctx context.Context, itemID string, req BookmarkRequest) (*Bookmark, error) {
+	path := fmt.Sprintf("/api/me/item/%s/bookmark", itemID)
+
+	var resp Bookmark
+	if err := c.send(ctx, "POST", path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateBookmark renames the bookmark of an item at the given time.
+func (c *Client) UpdateBookmark(ctx context.Context, itemID string, req BookmarkRequest) (*Bookmark, error) {
+	path := fmt.Sprintf("/api/me/item/%s/bookmark", itemID)
+
+	var resp Bookmark
+	if err := c.send(ctx, "PATCH", path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteBookmark deletes the bookmark of an item at the given time.
+func (c *Client) DeleteBookmark(ctx context.Context, itemID string, timeSec float64) error {
+	path := fmt.Sprintf("/api/me/item/%s/bookmark/%s", itemID, strconv.FormatFloat(timeSec, 'f', -1, 64))
+	return c.send(ctx, "DELETE", path, nil, nil)
+}
+
+// StartPlaybackSession opens a playback session for an item or podcast episode.
+// An empty episodeID starts a session for the item itself.
+func (c *Client) StartPlaybackSession(ctx context.Context, itemID, episodeID string, req PlaybackSessionRequest) (*PlaybackSession, error) {
+	path := fmt.Sprintf("/api/items/%s/play", itemID)
+	if episodeID != "" {
+		path = fmt.Sprintf("/api/items/%s/play/%s", itemID, episodeID)
+	}
+
+	var resp PlaybackSession
+	if err := c.send(ctx, "POST", path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SyncPlaybackSession reports the current position and the time listened since
+// the previous sync. ABS updates the media progress of the session's item as well.
+func (c *Client) SyncPlaybackSession(ctx context.Context, sessionID string, sync SessionSync) error {
+	path := fmt.Sprintf("/api/session/%s/sync", sessionID)
+	return c.send(ctx, "POST", path, sync, nil)
+}
+
+// ClosePlaybackSession syncs the final state and closes a playback session.
+func (c *Client) ClosePlaybackSession(ctx context.Context, sessionID string, sync SessionSync) error {
+	path := fmt.Sprintf("/api/session/%s/close", sessionID)
+	return c.send(ctx, "POST", path, sync, nil)
+}
+
+// GetCollections returns the collections of a library.
+func (c *Client) GetCollections(ctx context.Context, libraryID string) ([]Collection, error) {
+	path := fmt.Sprintf("/api/libraries/%s/collections?limit=0", libraryID)
+
+	var resp CollectionsResponse
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// GetCollection returns a collection with its books.
+func (c *Client) GetCollection(ctx context.Context, collectionID string) (*Collection, error) {
+	var collection Collection
+	if err := c.get(ctx, "/api/collections/"+collectionID, &collection); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// GetPlaylists returns the current user's playlists in a library.
+func (c *Client) GetPlaylists(ctx context.Context, libraryID string) ([]Playlist, error) {
+	path := fmt.Sprintf("/api/libraries/%s/playlists?limit=0", libraryID)
+
+	var resp PlaylistsResponse
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// GetPlaylist returns a playlist with its items in play order.
+func (c *Client) GetPlaylist(ctx context.Context, playlistID string) (*Playlist, error) {
+	var playlist Playlist
+	if err := c.get(ctx, "/api/playlists/"+playlistID, &playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
 // GetCover proxies a cover image request and returns the response body.
 func (c *Client) GetCover(ctx context.Context, itemID string) (io.ReadCloser, string, error) {
 	path := fmt.Sprintf("/api/items/%s/cover", itemID)
@@ -372,6 +536,40 @@ func (c *Client) GetCover(ctx context.Context, itemID string) (io.ReadCloser, st
 	return resp.Body, contentType, nil
 }
 
+// GetLibraryFile streams a library file (identified by its inode) through the
+// authenticated file API. The caller must close the returned body.
+// Audio files can take much longer than DefaultTimeout to transfer, so the
+// request is only bounded by ctx.
+func (c *Client) GetLibraryFile(ctx context.Context, itemID, ino string) (io.ReadCloser, int64, error) {
+	path := fmt.Sprintf("/api/items/%s/file/%s", itemID, ino)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+
+	httpClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("file request failed: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, nil
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, 0, ErrUnauthorized
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, 0, ErrNotFound
+	default:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
 // get performs a GET request and decodes the JSON response with retry logic.
 func (c *Client) get(ctx context.Context, path string, result interface{}) error {
 	var lastErr error
@@ -446,6 +644,54 @@ func (c *Client) doGet(ctx context.Context, path string, result interface{}) err
 	return nil
 }
 
+// send performs a single request with a JSON body and optionally decodes the JSON response.
+// Write requests are not retried to avoid applying them twice.
+func (c *Client) send(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // serverError represents a server-side error.
 type serverError struct {
 	StatusCode int
@@ -3,6 +3,7 @@ package abs
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -271,6 +272,373 @@ func TestClient_UpdateProgress(t *testing.T) {
 	}
 }
 
+func TestClient_UpdateEpisodeProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			t.Errorf("expected PATCH, got %s", r.Method)
+		}
+		if r.URL.Path != "/api/me/progress/item-123/ep-1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	err := client.UpdateEpisodeProgress(context.Background(), "item-123", "ep-1", ProgressUpdate{
+		Duration:    1200,
+		CurrentTime: 600,
+		Progress:    0.5,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestClient_GetEpisodeProgress_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/me/progress/item-123/ep-1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	progress, err := client.GetEpisodeProgress(context.Background(), "item-123", "ep-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if progress.EpisodeID != "ep-1" {
+		t.Errorf("expected episode ID ep-1, got %s", progress.EpisodeID)
+	}
+	if progress.CurrentTime != 0 {
+		t.Errorf("expected current time 0, got %f", progress.CurrentTime)
+	}
+}
+
+func TestClient_GetItem_Podcast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "pod-1",
+			"mediaType": "podcast",
+			"media": {
+				"metadata": {"title": "Test Podcast", "author": "Test Host"},
+				"episodes": [
+					{"id": "ep-1", "title": "Episode 1", "publishedAt": 1700000000000,
+					 "audioFile": {"index": 1, "duration": 1200.5, "metadata": {"path": "/podcasts/test/ep1.mp3"}}}
+				]
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	item, err := client.GetItem(context.Background(), "pod-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !item.IsPodcast() {
+		t.Error("expected item to be a podcast")
+	}
+	if item.GetAuthorName() != "Test Host" {
+		t.Errorf("expected author 'Test Host', got %s", item.GetAuthorName())
+	}
+
+	episode := item.GetEpisode("ep-1")
+	if episode == nil {
+		t.Fatal("expected episode ep-1 to be found")
+	}
+	if episode.GetDuration() != 1200.5 {
+		t.Errorf("expected duration 1200.5, got %f", episode.GetDuration())
+	}
+	if episode.AudioFile.Metadata.Path != "/podcasts/test/ep1.mp3" {
+		t.Errorf("unexpected audio file path: %s", episode.AudioFile.Metadata.Path)
+	}
+	if item.GetEpisode("missing") != nil {
+		t.Error("expected nil for unknown episode")
+	}
+}
+
+func TestClient_GetBookmarks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/me" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MeResponse{
+			ID: "user-1",
+			Bookmarks: []Bookmark{
+				{LibraryItemID: "item-123", Title: "Later", Time: 900},
+				{LibraryItemID: "item-456", Title: "Other book", Time: 100},
+				{LibraryItemID: "item-123", Title: "Earlier", Time: 300},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	bookmarks, err := client.GetBookmarks(context.Background(), "item-123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(bookmarks) != 2 {
+		t.Fatalf("expected 2 bookmarks, got %d", len(bookmarks))
+	}
+	if bookmarks[0].Title != "Earlier" || bookmarks[1].Title != "Later" {
+		t.Errorf("expected bookmarks ordered by time, got %v", bookmarks)
+	}
+}
+
+func TestClient_BookmarkCRUD(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var req BookmarkRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Bookmark{
+			LibraryItemID: "item-123",
+			Title:         req.Title,
+			Time:          req.Time,
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	ctx := context.Background()
+
+	bookmark, err := client.CreateBookmark(ctx, "item-123", BookmarkRequest{Title: "Start", Time: 1234.5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bookmark.Title != "Start" || bookmark.Time != 1234.5 {
+		t.Errorf("unexpected bookmark: %+v", bookmark)
+	}
+
+	if _, err := client.UpdateBookmark(ctx, "item-123", BookmarkRequest{Title: "Renamed", Time: 1234.5}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.DeleteBookmark(ctx, "item-123", 1234.5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"POST /api/me/item/item-123/bookmark",
+		"PATCH /api/me/item/item-123/bookmark",
+		"DELETE /api/me/item/item-123/bookmark/1234.5",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+}
+
+func TestClient_StartPlaybackSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if r.URL.Path != "/api/items/item-123/play/ep-1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		var req PlaybackSessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if !req.ForceDirectPlay {
+			t.Error("expected forceDirectPlay to be set")
+		}
+		if req.DeviceInfo.ClientName != "Sonos Bridge" {
+			t.Errorf("expected client name Sonos Bridge, got %s", req.DeviceInfo.ClientName)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PlaybackSession{
+			ID:            "play-session-1",
+			LibraryItemID: "item-123",
+			EpisodeID:     "ep-1",
+			CurrentTime:   42,
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	session, err := client.StartPlaybackSession(context.Background(), "item-123", "ep-1", PlaybackSessionRequest{
+		DeviceInfo:      DeviceInfo{ClientName: "Sonos Bridge"},
+		ForceDirectPlay: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if session.ID != "play-session-1" {
+		t.Errorf("expected session ID play-session-1, got %s", session.ID)
+	}
+	if session.CurrentTime != 42 {
+		t.Errorf("expected current time 42, got %f", session.CurrentTime)
+	}
+}
+
+func TestClient_SyncPlaybackSession(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		var sync SessionSync
+		if err := json.NewDecoder(r.Body).Decode(&sync); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if sync.TimeListened != 30 {
+			t.Errorf("expected time listened 30, got %f", sync.TimeListened)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	sync := SessionSync{CurrentTime: 630, TimeListened: 30, Duration: 1200}
+
+	if err := client.SyncPlaybackSession(context.Background(), "play-session-1", sync); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.ClosePlaybackSession(context.Background(), "play-session-1", sync); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(paths) != 2 || paths[0] != "/api/session/play-session-1/sync" || paths[1] != "/api/session/play-session-1/close" {
+		t.Errorf("unexpected paths: %v", paths)
+	}
+}
+
+func TestClient_SyncPlaybackSession_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	err := client.SyncPlaybackSession(context.Background(), "expired", SessionSync{})
+	if err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_GetCollections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/libraries/lib-1/collections" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"id":"col-1","libraryId":"lib-1","name":"Favoriten","books":[{"id":"item-1","mediaType":"book"},{"id":"item-2","mediaType":"book"}]}],"total":1}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	collections, err := client.GetCollections(context.Background(), "lib-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(collections) != 1 {
+		t.Fatalf("expected 1 collection, got %d", len(collections))
+	}
+	if collections[0].Name != "Favoriten" || len(collections[0].Books) != 2 {
+		t.Errorf("unexpected collection: %+v", collections[0])
+	}
+}
+
+func TestClient_GetPlaylist(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/playlists/pl-1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "pl-1",
+			"name": "Abendprogramm",
+			"items": [
+				{"libraryItemId": "book-1", "libraryItem": {"id": "book-1", "mediaType": "book"}},
+				{"libraryItemId": "pod-1", "episodeId": "ep-1", "episode": {"id": "ep-1", "title": "Folge 1"}, "libraryItem": {"id": "pod-1", "mediaType": "podcast"}}
+			]
+		}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+	playlist, err := client.GetPlaylist(context.Background(), "pl-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(playlist.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(playlist.Items))
+	}
+	if playlist.Items[1].Episode == nil || playlist.Items[1].Episode.Title != "Folge 1" {
+		t.Errorf("expected episode to be decoded, got %+v", playlist.Items[1])
+	}
+
+	next := playlist.Next("book-1", "")
+	if next == nil || next.LibraryItemID != "pod-1" || next.EpisodeID != "ep-1" {
+		t.Errorf("expected episode to follow book, got %+v", next)
+	}
+	if next := playlist.Next("pod-1", "ep-1"); next != nil {
+		t.Errorf("expected no item after last entry, got %+v", next)
+	}
+	if next := playlist.Next("unknown", ""); next != nil {
+		t.Errorf("expected no item for unknown entry, got %+v", next)
+	}
+}
+
+func TestClient_GetLibraryFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/api/items/item-1/file/12345" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("audio data"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithToken("test-token")
+
+	body, size, err := client.GetLibraryFile(context.Background(), "item-1", "12345")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if string(data) != "audio data" || size != int64(len(data)) {
+		t.Errorf("unexpected file: %q (size %d)", data, size)
+	}
+
+	if _, _, err := client.GetLibraryFile(context.Background(), "item-1", "999"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
 func TestClient_Unauthorized(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
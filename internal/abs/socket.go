@@ -0,0 +1,297 @@
+package abs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Socket events emitted by Audiobookshelf.
+const (
+	// EventUserItemProgressUpdated is sent when the user's progress on an item changes on any device.
+	EventUserItemProgressUpdated = "user_item_progress_updated"
+	// EventInit is sent after the socket has been authenticated.
+	EventInit = "init"
+	// EventInvalidToken is sent when the auth token was rejected.
+	EventInvalidToken = "invalid_token"
+	// EventAuthFailed is sent by older ABS versions when the auth token was rejected.
+	EventAuthFailed = "auth_failed"
+)
+
+// ErrSocketClosed is returned when the server closes the socket connection.
+var ErrSocketClosed = fmt.Errorf("socket closed")
+
+// ProgressUpdatedEvent is the payload of the user_item_progress_updated event.
+type ProgressUpdatedEvent struct {
+	ID                string   `json:"id"`
+	SessionID         string   `json:"sessionId"` // ABS playback session that caused the update (empty for direct updates)
+	DeviceDescription string   `json:"deviceDescription"`
+	Data              Progress `json:"data"`
+}
+
+// EventHandler handles the payload of a socket event.
+type EventHandler func(data json.RawMessage)
+
+// Subscriber receives real-time events from the Audiobookshelf socket.
+// It speaks the Socket.IO protocol over Engine.IO long-polling, which only
+// needs plain HTTP requests.
+type Subscriber struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	mu         sync.RWMutex
+	handlers   map[string][]EventHandler
+}
+
+// engineOpen is the Engine.IO handshake response.
+type engineOpen struct {
+	SID          string `json:"sid"`
+	PingInterval int    `json:"pingInterval"` // milliseconds
+	PingTimeout  int    `json:"pingTimeout"`  // milliseconds
+}
+
+// Engine.IO packet separator for polling payloads.
+const enginePacketSeparator = "\x1e"
+
+// Subscribe returns a socket subscriber authenticated with the client's token.
+func (c *Client) Subscribe() *Subscriber {
+	return &Subscriber{
+		baseURL: c.baseURL,
+		token:   c.token,
+		// Poll requests are held open by the server; deadlines are set per request
+		httpClient: &http.Client{Transport: c.httpClient.Transport},
+		handlers:   make(map[string][]EventHandler),
+	}
+}
+
+// On registers a handler for an event. Handlers run on the receiving goroutine.
+func (s *Subscriber) On(event string, handler EventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[event] = append(s.handlers[event], handler)
+}
+
+// OnProgressUpdated registers a handler for user item progress updates.
+func (s *Subscriber) OnProgressUpdated(handler func(ProgressUpdatedEvent)) {
+	s.On(EventUserItemProgressUpdated, func(data json.RawMessage) {
+		var event ProgressUpdatedEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			slog.Debug("failed to decode progress event", "error", err)
+			return
+		}
+		handler(event)
+	})
+}
+
+// Run connects, authenticates and dispatches events until the context is
+// cancelled or the connection is lost. Reconnecting is left to the caller.
+func (s *Subscriber) Run(ctx context.Context) error {
+	open, err := s.handshake(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Connect to the default namespace, then authenticate like the ABS web client
+	if err := s.send(ctx, open.SID, "40"); err != nil {
+		return err
+	}
+	if err := s.emit(ctx, open.SID, "auth", s.token); err != nil {
+		return err
+	}
+
+	// The server answers every poll within the ping interval (at least with a ping)
+	pollTimeout := time.Duration(open.PingInterval+open.PingTimeout)*time.Millisecond + 5*time.Second
+
+	for {
+		packets, err := s.poll(ctx, open.SID, pollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		for _, packet := range packets {
+			if packet == "" {
+				continue
+			}
+			switch packet[0] {
+			case '1': // close
+				return ErrSocketClosed
+			case '2': // ping
+				if err := s.send(ctx, open.SID, "3"); err != nil {
+					return err
+				}
+			case '4': // message
+				if err := s.handleMessage(packet[1:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handleMessage handles a Socket.IO packet.
+func (s *Subscriber) handleMessage(msg string) error {
+	if msg == "" {
+		return nil
+	}
+
+	switch msg[0] {
+	case '1': // disconnect
+		return ErrSocketClosed
+	case '4': // connect error
+		return fmt.Errorf("socket connect error: %s", msg[1:])
+	case '2': // event
+	default:
+		return nil
+	}
+
+	// Strip optional namespace and ack ID: 2/ns,12["event",data]
+	payload := msg[1:]
+	if strings.HasPrefix(payload, "/") {
+		if i := strings.Index(payload, ","); i >= 0 {
+			payload = payload[i+1:]
+		}
+	}
+	payload = strings.TrimLeft(payload, "0123456789")
+
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &args); err != nil || len(args) == 0 {
+		slog.Debug("ignoring malformed socket event", "payload", payload)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return nil
+	}
+
+	switch name {
+	case EventInvalidToken, EventAuthFailed:
+		return ErrUnauthorized
+	case EventInit:
+		slog.Debug("socket authenticated")
+	}
+
+	var data json.RawMessage
+	if len(args) > 1 {
+		data = args[1]
+	}
+
+	s.mu.RLock()
+	handlers := s.handlers[name]
+	s.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+// handshake opens an Engine.IO polling session.
+func (s *Subscriber) handshake(ctx context.Context) (*engineOpen, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	body, err := s.do(ctx, "GET", "", "")
+	if err != nil {
+		return nil, fmt.Errorf("socket handshake failed: %w", err)
+	}
+
+	// The open packet comes first, possibly followed by more packets
+	packet, _, _ := strings.Cut(body, enginePacketSeparator)
+	if !strings.HasPrefix(packet, "0") {
+		return nil, fmt.Errorf("unexpected handshake packet: %q", packet)
+	}
+
+	var open engineOpen
+	if err := json.Unmarshal([]byte(packet[1:]), &open); err != nil {
+		return nil, fmt.Errorf("failed to decode handshake: %w", err)
+	}
+	if open.SID == "" {
+		return nil, fmt.Errorf("handshake without session ID")
+	}
+	return &open, nil
+}
+
+// poll waits for the next packets from the server.
+func (s *Subscriber) poll(ctx context.Context, sid string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := s.do(ctx, "GET", sid, "")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(body, enginePacketSeparator), nil
+}
+
+// emit sends a Socket.IO event with a single argument.
+func (s *Subscriber) emit(ctx context.Context, sid, event string, arg interface{}) error {
+	payload, err := json.Marshal([]interface{}{event, arg})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return s.send(ctx, sid, "42"+string(payload))
+}
+
+// send posts a packet to the server.
+func (s *Subscriber) send(ctx context.Context, sid, packet string) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+
+	_, err := s.do(ctx, "POST", sid, packet)
+	return err
+}
+
+// do performs a single Engine.IO polling request.
+func (s *Subscriber) do(ctx context.Context, method, sid, body string) (string, error) {
+	query := url.Values{}
+	query.Set("EIO", "4")
+	query.Set("transport", "polling")
+	if sid != "" {
+		query.Set("sid", sid)
+	}
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, s.baseURL+"/socket.io/?"+query.Encode(), reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != "" {
+		httpReq.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	}
+
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("socket request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read socket response: %w", err)
+	}
+
+	// An unknown session ID means the server dropped the connection
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", ErrSocketClosed
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return string(data), nil
+}
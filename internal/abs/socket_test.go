@@ -0,0 +1,175 @@
+package abs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSocketServer implements the server side of Socket.IO over Engine.IO polling.
+type fakeSocketServer struct {
+	t        *testing.T
+	token    string
+	outgoing chan string
+	mu       sync.Mutex
+	received []string
+}
+
+func newFakeSocketServer(t *testing.T, token string) *fakeSocketServer {
+	return &fakeSocketServer{
+		t:        t,
+		token:    token,
+		outgoing: make(chan string, 16),
+	}
+}
+
+func (f *fakeSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/socket.io/" {
+		f.t.Errorf("unexpected path: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("EIO") != "4" || r.URL.Query().Get("transport") != "polling" {
+		f.t.Errorf("unexpected query: %s", r.URL.RawQuery)
+	}
+
+	sid := r.URL.Query().Get("sid")
+	switch {
+	case sid == "":
+		io.WriteString(w, `0{"sid":"sid-1","upgrades":["websocket"],"pingInterval":200,"pingTimeout":200}`)
+	case sid != "sid-1":
+		w.WriteHeader(http.StatusBadRequest)
+	case r.Method == "POST":
+		body, _ := io.ReadAll(r.Body)
+		f.handlePacket(string(body))
+		io.WriteString(w, "ok")
+	default:
+		select {
+		case packet := <-f.outgoing:
+			io.WriteString(w, packet)
+		case <-time.After(100 * time.Millisecond):
+			io.WriteString(w, "2")
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func (f *fakeSocketServer) handlePacket(packet string) {
+	f.mu.Lock()
+	f.received = append(f.received, packet)
+	f.mu.Unlock()
+
+	switch {
+	case packet == "40":
+		f.outgoing <- `40{"sid":"socket-1"}`
+	case strings.HasPrefix(packet, `42["auth",`):
+		if packet != `42["auth","`+f.token+`"]` {
+			f.outgoing <- `42["invalid_token"]`
+			return
+		}
+		f.outgoing <- `42["init",{"userId":"user-1"}]`
+	}
+}
+
+func (f *fakeSocketServer) receivedPackets() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.received...)
+}
+
+func TestSubscriber_ProgressUpdated(t *testing.T) {
+	fake := newFakeSocketServer(t, "test-token")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	subscriber := NewClient(server.URL).WithToken("test-token").Subscribe()
+
+	events := make(chan ProgressUpdatedEvent, 1)
+	subscriber.OnProgressUpdated(func(event ProgressUpdatedEvent) {
+		events <- event
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- subscriber.Run(ctx)
+	}()
+
+	// Two packets in one poll response, the event uses an ack ID
+	fake.outgoing <- "2\x1e421" + `["user_item_progress_updated",{"id":"progress-1","sessionId":"mobile-session","deviceDescription":"iPhone","data":{"libraryItemId":"item-123","currentTime":1800,"lastUpdate":1700000000000}}]`
+
+	select {
+	case event := <-events:
+		if event.SessionID != "mobile-session" {
+			t.Errorf("expected session ID mobile-session, got %s", event.SessionID)
+		}
+		if event.Data.LibraryItemID != "item-123" {
+			t.Errorf("expected item ID item-123, got %s", event.Data.LibraryItemID)
+		}
+		if event.Data.CurrentTime != 1800 {
+			t.Errorf("expected current time 1800, got %f", event.Data.CurrentTime)
+		}
+	case err := <-done:
+		t.Fatalf("subscriber stopped early: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for progress event")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	packets := fake.receivedPackets()
+	if len(packets) < 3 || packets[0] != "40" || packets[1] != `42["auth","test-token"]` {
+		t.Errorf("unexpected packets: %v", packets)
+	}
+
+	pongs := 0
+	for _, p := range packets {
+		if p == "3" {
+			pongs++
+		}
+	}
+	if pongs == 0 {
+		t.Error("expected pings to be answered")
+	}
+}
+
+func TestSubscriber_InvalidToken(t *testing.T) {
+	fake := newFakeSocketServer(t, "test-token")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	subscriber := NewClient(server.URL).WithToken("wrong-token").Subscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := subscriber.Run(ctx); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestSubscriber_ServerClose(t *testing.T) {
+	fake := newFakeSocketServer(t, "test-token")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	subscriber := NewClient(server.URL).WithToken("test-token").Subscribe()
+	fake.outgoing <- "1"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := subscriber.Run(ctx); err != ErrSocketClosed {
+		t.Errorf("expected ErrSocketClosed, got %v", err)
+	}
+}
@@ -69,7 +69,8 @@ type LibraryItem struct {
 	Size          int64          `json:"size"`
 }
 
-// BookMedia contains the book-specific media information.
+// BookMedia contains the media information of an item.
+// Podcasts share this structure and additionally populate Episodes.
 type BookMedia struct {
 	Metadata    BookMetadata `json:"metadata"`
 	CoverPath   string       `json:"coverPath"`
@@ -79,6 +80,9 @@ type BookMedia struct {
 	Duration    float64      `json:"duration"`
 	Size        int64        `json:"size"`
 	EbookFile   *EbookFile   `json:"ebookFile"`
+
+	// Podcast-only fields (empty for books)
+	Episodes    []PodcastEpisode `json:"episodes"`
 }
 
 // BookMetadata contains metadata about a book.
@@ -97,6 +101,9 @@ type BookMetadata struct {
 	ASIN            string      `json:"asin"`
 	Language        string      `json:"language"`
 	Explicit        bool        `json:"explicit"`
+
+	// Podcast metadata uses a single author string instead of an authors list
+	Author          string      `json:"author"`
 }
 
 // SeriesList handles the ABS API returning series as either an array or single object.
@@ -187,6 +194,35 @@ type Chapter struct {
 	Title string  `json:"title"`
 }
 
+// PodcastEpisode represents a single episode of a podcast item.
+type PodcastEpisode struct {
+	ID            string    `json:"id"`
+	LibraryItemID string    `json:"libraryItemId"`
+	Index         int       `json:"index"`
+	Season        string    `json:"season"`
+	Episode       string    `json:"episode"`
+	EpisodeType   string    `json:"episodeType"`
+	Title         string    `json:"title"`
+	Subtitle      string    `json:"subtitle"`
+	Description   string    `json:"description"`
+	PubDate       string    `json:"pubDate"`
+	PublishedAt   int64     `json:"publishedAt"`
+	AddedAt       int64     `json:"addedAt"`
+	UpdatedAt     int64     `json:"updatedAt"`
+	AudioFile     AudioFile `json:"audioFile"`
+	Chapters      []Chapter `json:"chapters"`
+	Duration      float64   `json:"duration"`
+	Size          int64     `json:"size"`
+}
+
+// GetDuration returns the episode duration in seconds, falling back to the audio file.
+func (e *PodcastEpisode) GetDuration() float64 {
+	if e.Duration > 0 {
+		return e.Duration
+	}
+	return e.AudioFile.Duration
+}
+
 // EbookFile represents an ebook file.
 type EbookFile struct {
 	Ino         string       `json:"ino"`
@@ -214,6 +250,7 @@ type ItemsResponse struct {
 // SearchResponse represents the response from a library search.
 type SearchResponse struct {
 	Book      []SearchBookResult   `json:"book"`
+	Podcast   []SearchBookResult   `json:"podcast"`
 	Narrators []interface{}        `json:"narrators"`
 	Tags      []interface{}        `json:"tags"`
 	Genres    []interface{}        `json:"genres"`
@@ -269,8 +306,10 @@ type ItemsInProgressResponse struct {
 type ItemInProgress struct {
 	ID          string    `json:"id"`
 	LibraryID   string    `json:"libraryId"`
+	MediaType   string    `json:"mediaType"`
 	Media       BookMedia `json:"media"`
 	ProgressLastUpdate int64 `json:"progressLastUpdate"`
+	RecentEpisode *PodcastEpisode `json:"recentEpisode"` // Episode in progress, for podcasts
 }
 
 // Progress represents playback progress for an item.
@@ -288,6 +327,88 @@ type Progress struct {
 	FinishedAt          *int64  `json:"finishedAt"`
 }
 
+// MeResponse represents the response from /api/me.
+type MeResponse struct {
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	Type          string     `json:"type"`
+	MediaProgress []Progress `json:"mediaProgress"`
+	Bookmarks     []Bookmark `json:"bookmarks"`
+}
+
+// Bookmark represents a user bookmark in an item.
+// ABS identifies bookmarks by item and time.
+type Bookmark struct {
+	LibraryItemID string  `json:"libraryItemId"`
+	Title         string  `json:"title"`
+	Time          float64 `json:"time"` // Position in seconds
+	CreatedAt     int64   `json:"createdAt"`
+}
+
+// BookmarkRequest represents the request body for creating or updating a bookmark.
+type BookmarkRequest struct {
+	Title string  `json:"title"`
+	Time  float64 `json:"time"`
+}
+
+// Collection represents a library collection shared by all users.
+type Collection struct {
+	ID          string        `json:"id"`
+	LibraryID   string        `json:"libraryId"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Books       []LibraryItem `json:"books"`
+	LastUpdate  int64         `json:"lastUpdate"`
+	CreatedAt   int64         `json:"createdAt"`
+}
+
+// CollectionsResponse represents the response from /api/libraries/{id}/collections.
+type CollectionsResponse struct {
+	Results []Collection `json:"results"`
+	Total   int          `json:"total"`
+}
+
+// Playlist represents a user playlist of books and podcast episodes.
+type Playlist struct {
+	ID          string         `json:"id"`
+	LibraryID   string         `json:"libraryId"`
+	UserID      string         `json:"userId"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Items       []PlaylistItem `json:"items"`
+	LastUpdate  int64          `json:"lastUpdate"`
+	CreatedAt   int64          `json:"createdAt"`
+}
+
+// PlaylistItem represents an entry of a playlist.
+// EpisodeID is set for podcast episodes (empty for books).
+type PlaylistItem struct {
+	LibraryItemID string          `json:"libraryItemId"`
+	EpisodeID     string          `json:"episodeId"`
+	LibraryItem   *LibraryItem    `json:"libraryItem"`
+	Episode       *PodcastEpisode `json:"episode"`
+}
+
+// PlaylistsResponse represents the response from /api/libraries/{id}/playlists.
+type PlaylistsResponse struct {
+	Results []Playlist `json:"results"`
+	Total   int        `json:"total"`
+}
+
+// Next returns the entry following the given item in the playlist, or nil
+// if the item is the last entry or not part of the playlist.
+func (p *Playlist) Next(itemID, episodeID string) *PlaylistItem {
+	for i, entry := range p.Items {
+		if entry.LibraryItemID == itemID && entry.EpisodeID == episodeID {
+			if i+1 < len(p.Items) {
+				return &p.Items[i+1]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
 // ProgressUpdate represents the request body for updating progress.
 type ProgressUpdate struct {
 	Duration        float64 `json:"duration"`
@@ -296,6 +417,52 @@ type ProgressUpdate struct {
 	IsFinished      bool    `json:"isFinished"`
 }
 
+// DeviceInfo identifies the client that opens a playback session in ABS.
+type DeviceInfo struct {
+	DeviceID      string `json:"deviceId,omitempty"`
+	ClientName    string `json:"clientName"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Manufacturer  string `json:"manufacturer,omitempty"`
+	Model         string `json:"model,omitempty"`
+}
+
+// PlaybackSessionRequest represents the request body for starting a playback session.
+type PlaybackSessionRequest struct {
+	DeviceInfo         DeviceInfo `json:"deviceInfo"`
+	ForceDirectPlay    bool       `json:"forceDirectPlay"`
+	ForceTranscode     bool       `json:"forceTranscode"`
+	SupportedMimeTypes []string   `json:"supportedMimeTypes,omitempty"`
+	MediaPlayer        string     `json:"mediaPlayer"`
+}
+
+// PlaybackSession represents a listening session in Audiobookshelf.
+// Time listened in a session is counted towards the user's listening stats.
+type PlaybackSession struct {
+	ID            string  `json:"id"`
+	UserID        string  `json:"userId"`
+	LibraryItemID string  `json:"libraryItemId"`
+	EpisodeID     string  `json:"episodeId"`
+	MediaType     string  `json:"mediaType"`
+	DisplayTitle  string  `json:"displayTitle"`
+	DisplayAuthor string  `json:"displayAuthor"`
+	Duration      float64 `json:"duration"`
+	PlayMethod    int     `json:"playMethod"`
+	MediaPlayer   string  `json:"mediaPlayer"`
+	TimeListening float64 `json:"timeListening"`
+	StartTime     float64 `json:"startTime"`
+	CurrentTime   float64 `json:"currentTime"`
+	StartedAt     int64   `json:"startedAt"`
+	UpdatedAt     int64   `json:"updatedAt"`
+}
+
+// SessionSync represents the request body for syncing or closing a playback session.
+// TimeListened is the listening time in seconds since the previous sync.
+type SessionSync struct {
+	CurrentTime  float64 `json:"currentTime"`
+	TimeListened float64 `json:"timeListened"`
+	Duration     float64 `json:"duration"`
+}
+
 // SimplifiedItem is a simplified view of a library item for UI purposes.
 type SimplifiedItem struct {
 	ID          string
@@ -308,10 +475,7 @@ type SimplifiedItem struct {
 
 // ToSimplified converts a LibraryItem to SimplifiedItem.
 func (item *LibraryItem) ToSimplified(baseURL string) SimplifiedItem {
-	author := ""
-	if len(item.Media.Metadata.Authors) > 0 {
-		author = item.Media.Metadata.Authors[0].Name
-	}
+	author := item.GetAuthorName()
 
 	coverURL := ""
 	if item.Media.CoverPath != "" {
@@ -327,6 +491,29 @@ func (item *LibraryItem) ToSimplified(baseURL string) SimplifiedItem {
 	}
 }
 
+// IsPodcast returns true if the item is a podcast.
+func (item *LibraryItem) IsPodcast() bool {
+	return item.MediaType == "podcast"
+}
+
+// GetEpisode returns the episode with the given ID, or nil if not found.
+func (item *LibraryItem) GetEpisode(episodeID string) *PodcastEpisode {
+	for i := range item.Media.Episodes {
+		if item.Media.Episodes[i].ID == episodeID {
+			return &item.Media.Episodes[i]
+		}
+	}
+	return nil
+}
+
+// GetAuthorName returns the first book author, or the podcast author.
+func (item *LibraryItem) GetAuthorName() string {
+	if len(item.Media.Metadata.Authors) > 0 {
+		return item.Media.Metadata.Authors[0].Name
+	}
+	return item.Media.Metadata.Author
+}
+
 // GetPrimaryAudioFile returns the primary audio file for an item.
 func (item *LibraryItem) GetPrimaryAudioFile() *AudioFile {
 	if len(item.Media.AudioFiles) == 0 {
@@ -2,11 +2,19 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"audiobookshelf-sonos-bridge/internal/abs"
 	"audiobookshelf-sonos-bridge/internal/store"
 )
 
@@ -206,6 +214,95 @@ func TestIndex_CreateEntry(t *testing.T) {
 	}
 }
 
+func TestNewFingerprint(t *testing.T) {
+	files := []abs.AudioFile{
+		{Ino: "101", Metadata: abs.FileMetadata{Size: 1000, Mtimems: 1700000000000}},
+		{Ino: "102", Metadata: abs.FileMetadata{Size: 2000, Mtimems: 1700000500000}},
+	}
+
+	fp := NewFingerprint(1600000000000, files)
+	if fp.Size != 3000 {
+		t.Errorf("expected total size 3000, got %d", fp.Size)
+	}
+	if !fp.Mtime.Equal(time.UnixMilli(1700000500000)) {
+		t.Errorf("expected latest mtime, got %v", fp.Mtime)
+	}
+	if again := NewFingerprint(1600000000000, files); again != fp {
+		t.Errorf("expected stable fingerprint, got %+v and %+v", fp, again)
+	}
+
+	// A re-tagged second file keeps its size but changes its mtime
+	retagged := slices.Clone(files)
+	retagged[1].Metadata.Mtimems++
+	if NewFingerprint(1600000000000, retagged).Hash == fp.Hash {
+		t.Error("expected changed hash for modified file")
+	}
+
+	// A replaced file gets a new inode
+	replaced := slices.Clone(files)
+	replaced[0].Ino = "201"
+	if NewFingerprint(1600000000000, replaced).Hash == fp.Hash {
+		t.Error("expected changed hash for replaced file")
+	}
+
+	if NewFingerprint(1600000000001, files).Hash == fp.Hash {
+		t.Error("expected changed hash for changed item folder")
+	}
+}
+
+func TestIndex_InvalidateIfChanged(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tmpDir := t.TempDir()
+	idx := NewIndex(store.NewCacheStore(db), tmpDir)
+
+	files := []abs.AudioFile{{Ino: "101", Metadata: abs.FileMetadata{Size: 1000, Mtimems: 1700000000000}}}
+	job := Job{ItemID: "item-1", SourcePaths: []string{"/media/book.mp3"}, Fingerprint: NewFingerprint(0, files)}
+
+	if err := idx.CreateEntryForJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.MarkReadyWithFormat("item-1", 3600, "mp3"); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := idx.GetEntry("item-1")
+	if entry.SourceSize != 1000 || entry.Fingerprint != job.Fingerprint.Hash {
+		t.Errorf("expected recorded fingerprint, got size %d and %q", entry.SourceSize, entry.Fingerprint)
+	}
+
+	if removed, err := idx.InvalidateIfChanged(job); err != nil || removed {
+		t.Errorf("expected unchanged entry to be kept, got %v, %v", removed, err)
+	}
+
+	// Entries without a fingerprint adopt the current one
+	if err := idx.CreateEntry("item-2", "/media/old.mp3", 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	legacy := Job{ItemID: "item-2", Fingerprint: job.Fingerprint}
+	if removed, err := idx.InvalidateIfChanged(legacy); err != nil || removed {
+		t.Errorf("expected legacy entry to be kept, got %v, %v", removed, err)
+	}
+	if entry, _ := idx.GetEntry("item-2"); entry.Fingerprint != job.Fingerprint.Hash {
+		t.Errorf("expected legacy entry to adopt fingerprint, got %q", entry.Fingerprint)
+	}
+
+	// A changed source removes the entry and its files
+	os.MkdirAll(filepath.Join(tmpDir, "item-1"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "item-1", "audio.mp3"), []byte("old"), 0644)
+	files[0].Metadata.Size = 1200
+	job.Fingerprint = NewFingerprint(0, files)
+	if removed, err := idx.InvalidateIfChanged(job); err != nil || !removed {
+		t.Fatalf("expected changed entry to be removed, got %v, %v", removed, err)
+	}
+	if entry, _ := idx.GetEntry("item-1"); entry != nil {
+		t.Error("expected entry to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "item-1")); !os.IsNotExist(err) {
+		t.Error("expected cached files to be removed")
+	}
+}
+
 func TestIndex_MarkTransitions(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -272,6 +369,57 @@ func TestIndex_MarkFailed(t *testing.T) {
 	}
 }
 
+func TestIndex_Passthrough(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tmpDir := t.TempDir()
+	idx := NewIndex(store.NewCacheStore(db), filepath.Join(tmpDir, "cache"))
+
+	sourcePath := filepath.Join(tmpDir, "book.m4b")
+	if err := os.WriteFile(sourcePath, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.CreateEntry("item-1", sourcePath, 5, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.MarkPassthrough("item-1", 3600, "mp4", sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, _ := idx.GetEntry("item-1")
+	if !entry.Passthrough || entry.Status != store.CacheStatusReady || entry.CacheFormat != "mp4" {
+		t.Errorf("unexpected passthrough entry: %+v", entry)
+	}
+	if got := idx.GetCachePathFromEntry(entry); got != sourcePath {
+		t.Errorf("expected source path %s, got %s", sourcePath, got)
+	}
+	if cached, _ := idx.IsCached("item-1"); !cached {
+		t.Error("expected passthrough entry to be cached")
+	}
+
+	// Removing the entry leaves the source file alone
+	if err := idx.Remove("item-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sourcePath); err != nil {
+		t.Errorf("expected source file to remain: %v", err)
+	}
+
+	// A cached copy replaces the passthrough
+	if err := idx.CreateEntry("item-2", sourcePath, 5, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	idx.MarkPassthrough("item-2", 3600, "mp4", sourcePath)
+	if err := idx.MarkReadyWithFormat("item-2", 3600, "mp4"); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ = idx.GetEntry("item-2")
+	if entry.Passthrough || idx.GetCachePathFromEntry(entry) != idx.GetCachePathWithFormat("item-2", "mp4") {
+		t.Errorf("expected cached copy after transcoding, got %+v", entry)
+	}
+}
+
 func TestIndex_EnsureDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 	idx := NewIndex(nil, tmpDir)
@@ -301,6 +449,11 @@ func TestIndex_CleanupTempFiles(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	partialFile := filepath.Join(itemDir, "segment_001.mp3"+PartialSuffix)
+	if err := os.WriteFile(partialFile, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
 	// Create normal file that should not be deleted
 	normalFile := filepath.Join(itemDir, "audio.mp3")
 	if err := os.WriteFile(normalFile, []byte("mp3"), 0644); err != nil {
@@ -316,41 +469,928 @@ func TestIndex_CleanupTempFiles(t *testing.T) {
 		t.Error("expected temp file to be deleted")
 	}
 
+	if _, err := os.Stat(partialFile); !os.IsNotExist(err) {
+		t.Error("expected partial file to be deleted")
+	}
+
 	// Normal file should remain
 	if _, err := os.Stat(normalFile); os.IsNotExist(err) {
 		t.Error("expected normal file to remain")
 	}
 }
 
+func TestSegmentOrder(t *testing.T) {
+	tests := []struct {
+		segmentCount int
+		firstSegment int
+		expected     []int
+	}{
+		{1, 0, []int{0}},
+		{4, 0, []int{0, 1, 2, 3}},
+		{4, 2, []int{2, 3, 0, 1}},
+		{4, 3, []int{3, 0, 1, 2}},
+		{3, 5, []int{0, 1, 2}}, // Out of range starts at the beginning
+	}
+
+	for _, tt := range tests {
+		got := segmentOrder(tt.segmentCount, tt.firstSegment)
+		if !slices.Equal(got, tt.expected) {
+			t.Errorf("segmentOrder(%d, %d) = %v, expected %v", tt.segmentCount, tt.firstSegment, got, tt.expected)
+		}
+	}
+}
+
+func TestPlanSegments(t *testing.T) {
+	tests := []struct {
+		name          string
+		totalSec      int
+		chapterStarts []int
+		expected      []int
+	}{
+		{"short output", 5000, []int{1200, 2400}, []int{0}},
+		{"no chapters", 20000, nil, []int{0, 7200, 14400}},
+		{"cut at last chapter before limit", 20000, []int{3000, 6900, 7300, 13000, 14100}, []int{0, 6900, 14100}},
+		{"chapter on limit", 15000, []int{7200}, []int{0, 7200, 14400}},
+		{"chapters too early", 10000, []int{1000, 3000}, []int{0, 7200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planSegments(tt.totalSec, tt.chapterStarts)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("planSegments(%d, %v) = %v, expected %v", tt.totalSec, tt.chapterStarts, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTranscodeProfile_ChapterStarts(t *testing.T) {
+	chapters := []abs.Chapter{
+		{ID: 0, Start: 0},
+		{ID: 2, Start: 9000.5},
+		{ID: 1, Start: 3000},
+	}
+
+	if got := DefaultProfile.chapterStarts(chapters); !slices.Equal(got, []int{3000, 9000}) {
+		t.Errorf("expected [3000 9000], got %v", got)
+	}
+	fast := ApplySpeed(DefaultProfile, 1.5)
+	if got := fast.chapterStarts(chapters); !slices.Equal(got, []int{2000, 6000}) {
+		t.Errorf("expected output timeline [2000 6000] at 1.5x, got %v", got)
+	}
+}
+
+func TestProfileForDevice(t *testing.T) {
+	allFormats := []string{"audio/mpeg", "audio/mp4", "audio/flac", "audio/x-ms-wma"}
+
+	tests := []struct {
+		name     string
+		device   *store.SonosDevice
+		expected string
+	}{
+		{"unknown device", nil, ProfileDefault},
+		{"no sink formats", &store.SonosDevice{Model: "Sonos One"}, ProfileDefault},
+		{"all formats", &store.SonosDevice{Model: "Sonos One", SinkFormats: allFormats}, ProfileHighQuality},
+		{"aliases", &store.SonosDevice{Model: "Era 100", SinkFormats: []string{"audio/mp3", "audio/x-m4a", "audio/x-flac"}}, ProfileHighQuality},
+		{"missing flac", &store.SonosDevice{Model: "Sonos One", SinkFormats: []string{"audio/mpeg", "audio/mp4"}}, ProfileDefault},
+		{"legacy model", &store.SonosDevice{Model: "Sonos ZP100", SinkFormats: allFormats}, ProfileDefault},
+		{"connect", &store.SonosDevice{Model: "Sonos Connect", SinkFormats: allFormats}, ProfileDefault},
+	}
+
+	for _, tt := range tests {
+		if got := ProfileForDevice(tt.device).Name; got != tt.expected {
+			t.Errorf("%s: expected profile %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestSupportsHLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   *store.SonosDevice
+		expected bool
+	}{
+		{"unknown device", nil, false},
+		{"no sink formats", &store.SonosDevice{Model: "Sonos One"}, false},
+		{"vnd.apple.mpegurl", &store.SonosDevice{Model: "Sonos One", SinkFormats: []string{"audio/mpeg", "application/vnd.apple.mpegurl"}}, true},
+		{"alias", &store.SonosDevice{Model: "Era 100", SinkFormats: []string{"application/x-mpegURL"}}, true},
+		{"legacy model", &store.SonosDevice{Model: "Sonos ZP90", SinkFormats: []string{"application/vnd.apple.mpegurl"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := SupportsHLS(tt.device); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestHLSSegments(t *testing.T) {
+	duration := 45
+	single := &store.CacheEntry{ItemID: "item", DurationSec: &duration, SegmentCount: 1}
+	expected := []hlsSegment{
+		{Start: 0, Duration: 10, Source: 0, Offset: 0},
+		{Start: 10, Duration: 10, Source: 0, Offset: 10},
+		{Start: 20, Duration: 10, Source: 0, Offset: 20},
+		{Start: 30, Duration: 10, Source: 0, Offset: 30},
+		{Start: 40, Duration: 5, Source: 0, Offset: 40},
+	}
+	if got := hlsSegments(single); !slices.Equal(got, expected) {
+		t.Errorf("single file: expected %v, got %v", expected, got)
+	}
+
+	// HLS segments end at cache segment boundaries
+	segmented := &store.CacheEntry{ItemID: "item", DurationSec: &duration, SegmentCount: 2, SegmentStarts: []int{0, 25}}
+	expected = []hlsSegment{
+		{Start: 0, Duration: 10, Source: 0, Offset: 0},
+		{Start: 10, Duration: 10, Source: 0, Offset: 10},
+		{Start: 20, Duration: 5, Source: 0, Offset: 20},
+		{Start: 25, Duration: 10, Source: 1, Offset: 0},
+		{Start: 35, Duration: 10, Source: 1, Offset: 10},
+	}
+	if got := hlsSegments(segmented); !slices.Equal(got, expected) {
+		t.Errorf("segmented: expected %v, got %v", expected, got)
+	}
+
+	if got := hlsSegments(&store.CacheEntry{ItemID: "item"}); got != nil {
+		t.Errorf("unknown duration: expected no segments, got %v", got)
+	}
+}
+
+func TestLiveSourcesAvailable(t *testing.T) {
+	tmpDir := t.TempDir()
+	existing := filepath.Join(tmpDir, "01.mp3")
+	if err := os.WriteFile(existing, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if LiveSourcesAvailable(nil) {
+		t.Error("expected no live stream without source files")
+	}
+	if !LiveSourcesAvailable([]string{existing}) {
+		t.Error("expected live stream with local source files")
+	}
+	if LiveSourcesAvailable([]string{existing, filepath.Join(tmpDir, "02.mp3")}) {
+		t.Error("expected no live stream with a missing source file")
+	}
+	if got := LiveFileName(3600); got != "live_3600.mp3" {
+		t.Errorf("LiveFileName(3600) = %s, want live_3600.mp3", got)
+	}
+}
+
+func TestLive_Limits(t *testing.T) {
+	live := NewLive(1)
+
+	// A running stream takes the only slot
+	live.slots <- struct{}{}
+	entry := &store.CacheEntry{ItemID: "item-1", SourcePaths: []string{"/media/item-1.mp3"}}
+	if err := live.Stream(context.Background(), io.Discard, entry, 0); err != ErrLiveBusy {
+		t.Errorf("expected ErrLiveBusy with all slots taken, got %v", err)
+	}
+	<-live.slots
+
+	// Transcoders, and their loudness measurements, are kept per cache key
+	first := live.transcoder("item-1")
+	if live.transcoder("item-1") != first {
+		t.Error("expected the transcoder of a cache key to be reused")
+	}
+	if live.transcoder("item-2") == first {
+		t.Error("expected a transcoder per cache key")
+	}
+
+	live.Forget("item-1")
+	live.Forget("item-2")
+	if len(live.transcoders) != 0 {
+		t.Errorf("expected forgotten transcoders to be dropped, got %d", len(live.transcoders))
+	}
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	duration := 25
+	entry := &store.CacheEntry{ItemID: "item", DurationSec: &duration, SegmentCount: 1}
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-TARGETDURATION:10\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:10.000,\nhls_00000.ts\n" +
+		"#EXTINF:10.000,\nhls_00001.ts\n" +
+		"#EXTINF:5.000,\nhls_00002.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if got := HLSPlaylist(entry); got != expected {
+		t.Errorf("unexpected playlist:\n%s", got)
+	}
+}
+
+func TestProfileByName(t *testing.T) {
+	tests := []struct {
+		name      string
+		ok        bool
+		loudnorm  bool
+		compress  bool
+		channels  int
+		segmented bool
+	}{
+		{"", true, false, false, 2, true},
+		{"hq", true, false, false, 2, false},
+		{"loudnorm", true, true, false, 2, true},
+		{"night", true, true, true, 2, true},
+		{"hq+night", true, true, true, 2, false},
+		{"hq+speech", true, true, false, 1, false},
+		{"none", false, false, false, 0, false},
+		{"hq+none", false, false, false, 0, false},
+		{"hq+loud", false, false, false, 0, false},
+		{"unknown", false, false, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		profile, ok := ProfileByName(tt.name)
+		if ok != tt.ok {
+			t.Errorf("ProfileByName(%q): expected ok=%v, got %v", tt.name, tt.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if profile.Name != tt.name {
+			t.Errorf("ProfileByName(%q): expected name %q, got %q", tt.name, tt.name, profile.Name)
+		}
+		if profile.Loudnorm != tt.loudnorm || profile.Compress != tt.compress || profile.Channels != tt.channels || profile.Segmented != tt.segmented {
+			t.Errorf("ProfileByName(%q): unexpected settings %+v", tt.name, profile)
+		}
+	}
+}
+
+func TestProfileByName_Speed(t *testing.T) {
+	tests := []struct {
+		name  string
+		ok    bool
+		speed float64
+	}{
+		{"1.5x", true, 1.5},
+		{"2x", true, 2},
+		{"hq+1.25x", true, 1.25},
+		{"hq+night+1.75x", true, 1.75},
+		{"speech+2x", true, 2},
+		{"1x", false, 0},
+		{"3x", false, 0},
+		{"1.5x+night", false, 0},
+		{"hq+1.5x+1.5x", false, 0},
+	}
+
+	for _, tt := range tests {
+		profile, ok := ProfileByName(tt.name)
+		if ok != tt.ok {
+			t.Errorf("ProfileByName(%q): expected ok=%v, got %v", tt.name, tt.ok, ok)
+			continue
+		}
+		if ok && (profile.Speed != tt.speed || !profile.RequiresTranscode()) {
+			t.Errorf("ProfileByName(%q): unexpected settings %+v", tt.name, profile)
+		}
+	}
+}
+
+func TestTranscodeProfile_TimeMapping(t *testing.T) {
+	profile := ApplySpeed(DefaultProfile, 1.5)
+	if got := profile.OutputTime(10800); got != 7200 {
+		t.Errorf("OutputTime(10800) = %d, want 7200", got)
+	}
+	if got := profile.SourceTime(7200); got != 10800 {
+		t.Errorf("SourceTime(7200) = %d, want 10800", got)
+	}
+	if got := DefaultProfile.OutputTime(10800); got != 10800 {
+		t.Errorf("default OutputTime(10800) = %d, want 10800", got)
+	}
+	if f := profile.tempoFilter(); f != "atempo=1.5" {
+		t.Errorf("unexpected tempo filter %q", f)
+	}
+}
+
+func TestTranscodeProfile_Version(t *testing.T) {
+	if v := DefaultProfile.Version(); v != CurrentCacheVersion {
+		t.Errorf("expected default profile to keep version %s, got %s", CurrentCacheVersion, v)
+	}
+
+	seen := make(map[string]string)
+	for _, profile := range KnownProfiles() {
+		v := profile.Version()
+		if other, ok := seen[v]; ok {
+			t.Errorf("profiles %q and %q share version %s", other, profile.Name, v)
+		}
+		seen[v] = profile.Name
+	}
+
+	// Changing a setting changes the version
+	changed := HighQualityProfile
+	changed.Bitrate = "320k"
+	if changed.Version() == HighQualityProfile.Version() {
+		t.Error("expected a changed profile to get a new version")
+	}
+}
+
+func TestApplyPreset_RequiresTranscode(t *testing.T) {
+	if DefaultProfile.RequiresTranscode() || HighQualityProfile.RequiresTranscode() {
+		t.Error("expected base profiles to allow remuxing")
+	}
+	for _, preset := range []string{PresetLoudnorm, PresetNight, PresetSpeech} {
+		if !ApplyPreset(HighQualityProfile, preset).RequiresTranscode() {
+			t.Errorf("expected preset %s to require transcoding", preset)
+		}
+	}
+	if profile := ApplyPreset(HighQualityProfile, PresetNone); profile != HighQualityProfile {
+		t.Errorf("expected preset none to leave the profile unchanged, got %+v", profile)
+	}
+}
+
+func TestProfileForSource(t *testing.T) {
+	flac := &AudioFormat{Container: "flac", AudioCodec: "flac"}
+	wav := &AudioFormat{Container: "wav", AudioCodec: "pcm_s16le"}
+	opus := &AudioFormat{Container: "ogg", AudioCodec: "opus"}
+
+	tests := []struct {
+		name     string
+		profile  TranscodeProfile
+		format   *AudioFormat
+		expected string
+	}{
+		{"hq lossless", HighQualityProfile, flac, "flac"},
+		{"hq pcm", HighQualityProfile, wav, "flac"},
+		{"hq lossy", HighQualityProfile, opus, "mp4"},
+		{"hq unknown", HighQualityProfile, nil, "mp4"},
+		{"hq night lossless", ApplyPreset(HighQualityProfile, PresetNight), flac, "flac"},
+		{"hq speech lossless", ApplyPreset(HighQualityProfile, PresetSpeech), flac, "mp3"},
+		{"default lossless", DefaultProfile, flac, "mp3"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.profile.ForSource(tt.format).OutputFormat; got != tt.expected {
+			t.Errorf("%s: expected output format %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+
+	// FLAC output is encoded without a bitrate
+	profile := HighQualityProfile.ForSource(flac)
+	if profile.AudioCodec != "flac" || profile.Bitrate != "" {
+		t.Errorf("expected flac without bitrate, got codec %q bitrate %q", profile.AudioCodec, profile.Bitrate)
+	}
+	if args := NewTranscoder().WithProfile(profile).encodeArgs(); slices.Contains(args, "-b:a") {
+		t.Errorf("expected no bitrate for flac, got %v", args)
+	}
+	if profile.Name != HighQualityProfile.Name {
+		t.Errorf("expected the profile name to stay %q, got %q", HighQualityProfile.Name, profile.Name)
+	}
+}
+
+func TestParseLoudnessStats(t *testing.T) {
+	output := `Input #0, mp3, from 'book.mp3':
+  Duration: 10:02:13.41, start: 0.025057, bitrate: 64 kb/s
+[Parsed_loudnorm_0 @ 0x55d4c8a1e2c0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+	stats, err := parseLoudnessStats(output)
+	if err != nil {
+		t.Fatalf("parseLoudnessStats failed: %v", err)
+	}
+
+	expected := "loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true"
+	if got := stats.filter(); got != expected {
+		t.Errorf("expected filter %s, got %s", expected, got)
+	}
+
+	// Silent input can't be measured
+	silent := &loudnessStats{InputI: "-inf", InputTP: "-inf", InputLRA: "0.00", InputThresh: "-inf", TargetOffset: "inf"}
+	if got := silent.filter(); got != "loudnorm=I=-16:TP=-1.5:LRA=11" {
+		t.Errorf("expected single pass filter for silent input, got %s", got)
+	}
+
+	if _, err := parseLoudnessStats("Conversion failed!"); err == nil {
+		t.Error("expected error for output without measurement")
+	}
+}
+
+func TestIndex_PruneOutdatedProfiles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tmpDir := t.TempDir()
+	cacheStore := store.NewCacheStore(db)
+	idx := NewIndex(cacheStore, tmpDir)
+
+	night, _ := ProfileByName("hq+night")
+	entries := map[string]string{
+		"item-1":          CurrentCacheVersion,
+		"item-1@hq+night": night.Version(),
+		"item-2@hq+night": CurrentCacheVersion + "-00000000", // Created with older settings
+		"item-3":          "v2",
+	}
+	for itemID, version := range entries {
+		if err := cacheStore.Create(&store.CacheEntry{
+			ItemID:         itemID,
+			SourcePath:     "/media/book.mp3",
+			ProfileVersion: version,
+			CachePath:      idx.GetCachePath(itemID),
+			Status:         store.CacheStatusReady,
+		}); err != nil {
+			t.Fatalf("failed to create entry: %v", err)
+		}
+		if err := idx.EnsureDirectory(itemID); err != nil {
+			t.Fatalf("failed to create cache directory: %v", err)
+		}
+	}
+
+	pruned, err := idx.PruneOutdatedProfiles()
+	if err != nil {
+		t.Fatalf("PruneOutdatedProfiles failed: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("expected 2 pruned entries, got %d", pruned)
+	}
+
+	for itemID := range entries {
+		entry, _ := idx.GetEntry(itemID)
+		_, statErr := os.Stat(idx.GetCacheDir(itemID))
+		outdated := itemID == "item-2@hq+night" || itemID == "item-3"
+		if outdated && (entry != nil || statErr == nil) {
+			t.Errorf("expected %s to be pruned", itemID)
+		}
+		if !outdated && (entry == nil || statErr != nil) {
+			t.Errorf("expected %s to remain", itemID)
+		}
+	}
+
+	// A ready entry with another profile's version isn't cached
+	if err := idx.CreateEntry("item-4@night", "/media/book.mp3", 0, time.Now()); err != nil {
+		t.Fatalf("failed to create entry: %v", err)
+	}
+	entry, _ := idx.GetEntry("item-4@night")
+	loudNight, _ := ProfileByName("night")
+	if entry.ProfileVersion != loudNight.Version() {
+		t.Errorf("expected profile version %s, got %s", loudNight.Version(), entry.ProfileVersion)
+	}
+	cacheStore.MarkReadyWithFormat("item-4@night", 60, "mp3")
+	idx.EnsureDirectory("item-4@night")
+	os.WriteFile(idx.GetCachePathWithFormat("item-4@night", "mp3"), []byte("audio"), 0644)
+	if cached, _ := idx.IsCached("item-4@night"); !cached {
+		t.Error("expected entry with current profile version to be cached")
+	}
+	db.Conn().Exec(`UPDATE cache_index SET profile_version = 'v3-00000000' WHERE item_id = 'item-4@night'`)
+	if cached, _ := idx.IsCached("item-4@night"); cached {
+		t.Error("expected entry with outdated profile version not to be cached")
+	}
+}
+
 func TestWorker_Enqueue(t *testing.T) {
-	worker := NewWorker(nil, nil, 2)
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
 
-	job := Job{ItemID: "item-1", SourcePath: "/media/book.m4b"}
+	queue := store.NewJobStore(db)
+	worker := NewWorker(nil, nil, queue, 2)
 
-	if !worker.Enqueue(job) {
+	if !worker.Enqueue(Job{ItemID: "item-1", SourcePath: "/media/book.m4b", Profile: "night"}) {
+		t.Error("expected enqueue to succeed")
+	}
+	if !worker.Enqueue(Job{ItemID: "item-2", SourcePaths: []string{"/media/other.mp3"}, Priority: PriorityWarmup}) {
 		t.Error("expected enqueue to succeed")
 	}
 
-	if worker.QueueLength() != 1 {
-		t.Errorf("expected queue length 1, got %d", worker.QueueLength())
+	// Queueing an item again raises its priority instead of adding a job
+	if !worker.Enqueue(Job{ItemID: "item-1", SourcePath: "/media/book.m4b", Profile: "night", Priority: PriorityInteractive}) {
+		t.Error("expected enqueue of queued item to succeed")
+	}
+	if worker.QueueLength() != 2 {
+		t.Errorf("expected queue length 2, got %d", worker.QueueLength())
+	}
+
+	qj, err := queue.Claim(time.Now(), 0)
+	if err != nil || qj == nil {
+		t.Fatalf("expected a job, got %v, %v", qj, err)
+	}
+	job, err := decodeJob(qj)
+	if err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+	if job.ItemID != "item-1" || job.Priority != PriorityInteractive || job.Profile != "night" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if !slices.Equal(job.SourcePaths, []string{"/media/book.m4b"}) {
+		t.Errorf("expected source paths from SourcePath, got %v", job.SourcePaths)
+	}
+
+	// Running items can't be queued again
+	if worker.Enqueue(Job{ItemID: "item-1"}) {
+		t.Error("expected enqueue of running item to fail")
+	}
+}
+
+func TestWorker_FinishJob(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	queue := store.NewJobStore(db)
+	idx := NewIndex(store.NewCacheStore(db), t.TempDir())
+	worker := NewWorker(idx, nil, queue, 1)
+
+	idx.CreateEntryWithFormat("item-1", "/media/item-1", 0, time.Now(), "mp3")
+	worker.Enqueue(Job{ItemID: "item-1", SourcePaths: []string{"/media/item-1"}})
+
+	for attempt := 1; attempt <= maxJobAttempts; attempt++ {
+		// Make the retry due
+		db.Conn().Exec(`UPDATE transcode_jobs SET next_attempt_at = 0`)
+
+		qj, err := queue.Claim(time.Now(), 0)
+		if err != nil || qj == nil {
+			t.Fatalf("attempt %d: expected a job, got %v, %v", attempt, qj, err)
+		}
+		if qj.Attempts != attempt {
+			t.Errorf("expected attempt %d, got %d", attempt, qj.Attempts)
+		}
+		worker.finishJob(qj.ItemID, qj.Attempts, fmt.Errorf("ffmpeg failed"))
+
+		entry, _ := idx.GetEntry("item-1")
+		qj, _ = queue.Get("item-1")
+		if attempt < maxJobAttempts {
+			if entry.Status != store.CacheStatusPending || entry.ErrorText != "ffmpeg failed" {
+				t.Errorf("attempt %d: expected pending entry with error, got %s %q", attempt, entry.Status, entry.ErrorText)
+			}
+			if qj == nil || qj.Status != store.JobStatusQueued || !qj.NextAttemptAt.After(time.Now()) {
+				t.Errorf("attempt %d: expected job queued for a later retry, got %+v", attempt, qj)
+			}
+			continue
+		}
+
+		if entry.Status != store.CacheStatusFailed {
+			t.Errorf("expected failed entry after %d attempts, got %s", attempt, entry.Status)
+		}
+		if qj != nil {
+			t.Error("expected job to be removed after the last attempt")
+		}
+	}
+}
+
+func TestWorker_Cancel(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	queue := store.NewJobStore(db)
+	idx := NewIndex(store.NewCacheStore(db), t.TempDir())
+	worker := NewWorker(idx, nil, queue, 1)
+
+	idx.CreateEntryWithFormat("item-1", "/media/item-1", 0, time.Now(), "mp3")
+	worker.Enqueue(Job{ItemID: "item-1", SourcePaths: []string{"/media/item-1"}})
+
+	cancelled, err := worker.Cancel("item-1")
+	if err != nil || !cancelled {
+		t.Fatalf("expected job to be cancelled, got %v, %v", cancelled, err)
+	}
+	if worker.QueueLength() != 0 {
+		t.Errorf("expected empty queue, got %d", worker.QueueLength())
+	}
+	entry, _ := idx.GetEntry("item-1")
+	if entry.Status != store.CacheStatusFailed || entry.ErrorText != errJobCancelled {
+		t.Errorf("expected cancelled entry, got %s %q", entry.Status, entry.ErrorText)
+	}
+
+	if cancelled, _ := worker.Cancel("item-1"); cancelled {
+		t.Error("expected no job to cancel")
+	}
+}
+
+func TestWorker_BackgroundLimits(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	allowed := false
+	worker := NewWorker(nil, nil, store.NewJobStore(db), 2)
+	worker.SetBackground(BackgroundConfig{
+		Allowed: func(time.Time) bool { return allowed },
+		MaxJobs: 1,
+	})
+	now := time.Now()
+
+	worker.applySchedule(now)
+	if !worker.BackgroundPaused() {
+		t.Error("expected background jobs to be paused outside the allowed hours")
+	}
+	if p := worker.minClaimPriority(now); p != PriorityInteractive {
+		t.Errorf("expected only interactive jobs outside the allowed hours, got %d", p)
+	}
+
+	allowed = true
+	worker.applySchedule(now)
+	if worker.BackgroundPaused() {
+		t.Error("expected background jobs to be resumed in the allowed hours")
+	}
+	if p := worker.minClaimPriority(now); p != PriorityBulk {
+		t.Errorf("expected all jobs in the allowed hours, got %d", p)
+	}
+
+	// Only MaxJobs background jobs run at once
+	worker.backgroundJobs = 1
+	if p := worker.minClaimPriority(now); p != PriorityInteractive {
+		t.Errorf("expected only interactive jobs at the background limit, got %d", p)
 	}
 }
 
-func TestWorker_QueueFull(t *testing.T) {
-	// Create worker with small buffer
-	worker := &Worker{
-		jobs:    make(chan Job, 1),
-		workers: 1,
+func TestWorker_InteractiveWhileBackgroundPaused(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	queue := store.NewJobStore(db)
+	allowed := true
+	worker := NewWorker(nil, nil, queue, 3)
+	worker.SetBackground(BackgroundConfig{Allowed: func(time.Time) bool { return allowed }})
+	now := time.Now()
+
+	for _, id := range []string{"bulk-1", "bulk-2", "bulk-3"} {
+		queue.Enqueue(id, int(PriorityBulk), "{}")
 	}
 
-	// First job should succeed
-	if !worker.Enqueue(Job{ItemID: "item-1"}) {
-		t.Error("first enqueue should succeed")
+	// Background jobs leave one worker free even without MaxJobs
+	for i := 0; i < 3; i++ {
+		qj, _ := queue.Claim(now, int(worker.minClaimPriority(now)))
+		if qj == nil {
+			break
+		}
+		worker.backgroundJobs++
 	}
+	if worker.backgroundJobs != 2 {
+		t.Fatalf("expected 2 background jobs to start, got %d", worker.backgroundJobs)
+	}
+
+	allowed = false
+	worker.applySchedule(now)
 
-	// Second job should fail (queue full)
-	if worker.Enqueue(Job{ItemID: "item-2"}) {
-		t.Error("second enqueue should fail when queue is full")
+	queue.Enqueue("interactive", int(PriorityInteractive), "{}")
+	qj, err := queue.Claim(now, int(worker.minClaimPriority(now)))
+	if err != nil || qj == nil || qj.ItemID != "interactive" {
+		t.Fatalf("expected interactive job to be claimed, got %+v, %v", qj, err)
+	}
+}
+
+func TestWorker_YieldPaused(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	allowed := true
+	worker := NewWorker(nil, nil, store.NewJobStore(db), 1)
+	worker.SetBackground(BackgroundConfig{Allowed: func(time.Time) bool { return allowed }})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker.running["bulk-1"] = &runningJob{cancel: cancel, done: make(chan struct{}), background: true}
+	worker.backgroundJobs = 1
+
+	// With a single worker, the paused job gives it up
+	allowed = false
+	worker.applySchedule(time.Now())
+	if ctx.Err() == nil || !worker.running["bulk-1"].yielded {
+		t.Error("expected paused background job to be stopped and queued again")
+	}
+}
+
+func TestPauseGate(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true not available")
+	}
+
+	gate := newPauseGate()
+	gate.pause()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- gate.run(context.Background(), exec.Command("true"))
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected command to wait while the gate is paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	gate.resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected command to run after resume")
+	}
+
+	// Waiting commands give up with their context
+	gate.pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gate.run(ctx, exec.Command("true")); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.expected {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.expected)
+		}
+	}
+}
+
+func TestWorker_ResolveSources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/items/item-1/file/101":
+			w.Write([]byte("part one"))
+		case "/api/items/item-1/file/102":
+			w.Write([]byte("part two"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	localFile := filepath.Join(tmpDir, "book.mp3")
+	os.WriteFile(localFile, []byte("local"), 0644)
+	missingFile := filepath.Join(tmpDir, "missing.mp3")
+
+	worker := NewWorker(NewIndex(nil, tmpDir), NewTranscoder(), nil, 1)
+	client := abs.NewClient(server.URL).WithToken("test-token")
+	remote := []RemoteFile{{ItemID: "item-1", Ino: "101"}, {ItemID: "item-1", Ino: "102"}}
+
+	t.Run("local files", func(t *testing.T) {
+		job := Job{ItemID: "item-1", RemoteFiles: remote, ABSClient: client}
+		paths, sourceType, cleanup, err := worker.resolveSources(context.Background(), job, []string{localFile})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer cleanup()
+		if sourceType != store.SourceTypeLocal {
+			t.Errorf("expected source type 'local', got '%s'", sourceType)
+		}
+		if len(paths) != 1 || paths[0] != localFile {
+			t.Errorf("expected local path, got %v", paths)
+		}
+	})
+
+	t.Run("missing without fallback", func(t *testing.T) {
+		job := Job{ItemID: "item-1"}
+		if _, _, _, err := worker.resolveSources(context.Background(), job, []string{missingFile}); err == nil {
+			t.Error("expected error for missing source file")
+		}
+	})
+
+	t.Run("download from ABS", func(t *testing.T) {
+		os.MkdirAll(filepath.Join(tmpDir, "item-1"), 0755)
+		job := Job{ItemID: "item-1", RemoteFiles: remote, ABSClient: client}
+		paths, sourceType, cleanup, err := worker.resolveSources(context.Background(), job, []string{localFile, missingFile})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sourceType != store.SourceTypeABS {
+			t.Errorf("expected source type 'abs', got '%s'", sourceType)
+		}
+		if len(paths) != 2 {
+			t.Fatalf("expected 2 downloaded files, got %d", len(paths))
+		}
+		if data, _ := os.ReadFile(paths[1]); string(data) != "part two" {
+			t.Errorf("unexpected content of second file: %q", data)
+		}
+
+		cleanup()
+		if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+			t.Error("expected downloaded files to be removed by cleanup")
+		}
+	})
+
+	t.Run("download fails", func(t *testing.T) {
+		job := Job{ItemID: "item-1", RemoteFiles: []RemoteFile{{ItemID: "item-1", Ino: "999"}}, ABSClient: client}
+		if _, _, _, err := worker.resolveSources(context.Background(), job, []string{missingFile}); err == nil {
+			t.Error("expected error for failed download")
+		}
+		if matches, _ := filepath.Glob(filepath.Join(tmpDir, "item-1", "*.tmp")); len(matches) != 0 {
+			t.Errorf("expected partial downloads to be removed, found %v", matches)
+		}
+	})
+}
+
+func TestEvictor_Run(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	cacheDir := t.TempDir()
+	cacheStore := store.NewCacheStore(db)
+	idx := NewIndex(cacheStore, cacheDir)
+
+	// Four ready entries of 1000 bytes each; item-old is segmented
+	now := time.Now()
+	entries := []struct {
+		itemID     string
+		files      []string
+		accessedAt time.Time
+	}{
+		{"item-old", []string{"segment_000.m4a", "segment_001.m4a"}, now.Add(-3 * time.Hour)},
+		{"item-playing", []string{"audio.m4a"}, now.Add(-2 * time.Hour)},
+		{"item-recent", []string{"audio.m4a"}, now.Add(-1 * time.Hour)},
+		{"item-new", []string{"audio.m4a"}, now},
+	}
+	for _, e := range entries {
+		if err := idx.EnsureDirectory(e.itemID); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range e.files {
+			data := make([]byte, 1000/len(e.files))
+			if err := os.WriteFile(filepath.Join(idx.GetCacheDir(e.itemID), f), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := idx.CreateEntryWithFormat(e.itemID, "/media/"+e.itemID, 1000, now, "mp4"); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.MarkReadyWithSegments(e.itemID, 3600, "mp4", store.FixedSegmentStarts(len(e.files), 1800)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Conn().Exec(`UPDATE cache_index SET last_accessed_at = ? WHERE item_id = ?`, e.accessedAt.Unix(), e.itemID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// item-playing is paused on a speaker
+	store.NewSessionStore(db).Create(&store.Session{
+		ID:          "session-1",
+		ABSTokenEnc: []byte("token"),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+	})
+	store.NewDeviceStore(db).Upsert(&store.SonosDevice{
+		UUID:         "uuid:RINCON_123",
+		Name:         "Test",
+		DiscoveredAt: now,
+		LastSeenAt:   now,
+	})
+	playbackStore := store.NewPlaybackStore(db)
+	err := playbackStore.Create(&store.PlaybackSession{
+		ID:                 "playback-1",
+		SessionID:          "session-1",
+		ItemID:             "item-playing",
+		SonosUUID:          "uuid:RINCON_123",
+		StreamToken:        "token",
+		StartedAt:          now,
+		LastPositionUpdate: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Below the high watermark nothing is evicted
+	evictor := NewEvictor(idx, playbackStore, EvictorConfig{MaxBytes: 8000, HighWatermark: 90, LowWatermark: 50})
+	if freed := evictor.Run(); freed != 0 {
+		t.Errorf("expected nothing to be evicted, freed %d bytes", freed)
+	}
+
+	// 4000 bytes exceed 90% of 4000, so entries are evicted down to 2000
+	evictor = NewEvictor(idx, playbackStore, EvictorConfig{MaxBytes: 4000, HighWatermark: 90, LowWatermark: 50})
+	if freed := evictor.Run(); freed != 2000 {
+		t.Errorf("expected 2000 bytes to be freed, got %d", freed)
+	}
+
+	expected := map[string]bool{
+		"item-old":     false,
+		"item-playing": true,
+		"item-recent":  false,
+		"item-new":     true,
+	}
+	for itemID, kept := range expected {
+		entry, _ := idx.GetEntry(itemID)
+		if (entry != nil) != kept {
+			t.Errorf("%s: expected entry kept=%v", itemID, kept)
+		}
+		if _, err := os.Stat(idx.GetCacheDir(itemID)); (err == nil) != kept {
+			t.Errorf("%s: expected directory kept=%v", itemID, kept)
+		}
 	}
 }
 
@@ -393,6 +1433,189 @@ func TestExtractDurationFromFFmpegOutput(t *testing.T) {
 	}
 }
 
+func TestParseFFmpegExitCode(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		output   string
+		class    FailureClass
+	}{
+		{1, "/media/book.m4b: No such file or directory", FailureMissingFile},
+		{234, "av_interleaved_write_frame(): No space left on device", FailureDiskFull},
+		{1, "Decoder (codec ac4) not found for input stream #0:0", FailureUnsupportedCodec},
+		{1, "Could not find tag for codec opus in stream #0, codec not currently supported in container", FailureUnsupportedCodec},
+		{1, "Application provided invalid, non monotonically increasing dts to muxer in stream 0", FailureConcatMismatch},
+		{183, "[mp3 @ 0x1] Header missing\nError while decoding stream #0:0: Invalid data found when processing input", FailureCorruptInput},
+		{1, "something else", FailureUnknown},
+		{137, "", FailureUnknown},
+	}
+
+	for _, tc := range tests {
+		err := &TranscodeError{
+			ExitCode: tc.exitCode,
+			Output:   tc.output,
+			Err:      fmt.Errorf("segment 1: %w", ParseFFmpegExitCode(tc.exitCode, tc.output)),
+		}
+		if class := ClassifyFailure(err); class != tc.class {
+			t.Errorf("for %q: expected %s, got %s", tc.output, tc.class, class)
+		}
+	}
+
+	if class := ClassifyFailure(nil); class != "" {
+		t.Errorf("expected no class for nil, got %s", class)
+	}
+	if FailureCorruptInput.Retryable() || FailureUnsupportedCodec.Retryable() || !FailureDiskFull.Retryable() {
+		t.Error("unexpected retryable classes")
+	}
+}
+
+func TestTranscodeError_Error(t *testing.T) {
+	err := &TranscodeError{
+		ExitCode: 1,
+		Output:   "Input #0, mp3, from 'book.mp3':\n  Duration: 01:00:00.00\nbook.mp3: Invalid data found when processing input\n",
+		Err:      ErrInvalidInputFile,
+	}
+	expected := "ffmpeg exited with code 1: invalid input file: book.mp3: Invalid data found when processing input"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+// fakeFFmpeg installs an ffmpeg script as the only program on PATH. It writes
+// its last argument, the output file, and fails with the given message when
+// its arguments match a shell case pattern.
+func fakeFFmpeg(t *testing.T, failPattern, message string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := fmt.Sprintf(`#!/bin/sh
+case "$*" in
+%s) echo "%s" >&2; exit 1 ;;
+esac
+for arg; do out="$arg"; done
+echo "Duration: 00:01:00.00" >&2
+echo audio > "$out"
+`, failPattern, message)
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write ffmpeg script: %v", err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestTranscoder_Fallbacks(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh not available")
+	}
+
+	inputs := make([]string, 2)
+	for i := range inputs {
+		inputs[i] = filepath.Join(t.TempDir(), fmt.Sprintf("part%d.mp3", i))
+		os.WriteFile(inputs[i], []byte("audio"), 0644)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		failPattern string
+		message     string
+		run         func(tr *Transcoder, outputPath string) (*TranscodeResult, error)
+		note        string
+	}{
+		{
+			name:        "remux failure",
+			failPattern: "*copy*",
+			message:     "Could not find tag for codec opus in stream #0, codec not currently supported in container",
+			run: func(tr *Transcoder, outputPath string) (*TranscodeResult, error) {
+				return tr.remuxWithFallback(ctx, inputs[:1], outputPath, "mp4")
+			},
+			note: "remux failed, transcoding instead",
+		},
+		{
+			name:        "corrupt input",
+			failPattern: "-i*",
+			message:     "Error while decoding stream #0:0: Invalid data found when processing input",
+			run: func(tr *Transcoder, outputPath string) (*TranscodeResult, error) {
+				return tr.transcodeWithFallback(ctx, inputs[:1], outputPath)
+			},
+			note: "error-tolerant decoding",
+		},
+		{
+			name:        "concat mismatch",
+			failPattern: "*concat.txt*chapters?-1?-vn*", // Concatenation without stream copy
+			message:     "Application provided invalid, non monotonically increasing dts to muxer",
+			run: func(tr *Transcoder, outputPath string) (*TranscodeResult, error) {
+				return tr.transcodeWithFallback(ctx, inputs, outputPath)
+			},
+			note: "normalizing each file",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeFFmpeg(t, tc.failPattern, tc.message)
+			log := &transcodeLog{}
+			outputPath := filepath.Join(t.TempDir(), "audio.out")
+
+			result, err := tc.run(NewTranscoder().withLog(log), outputPath)
+			if err != nil {
+				t.Fatalf("expected fallback to succeed, got %v\n%s", err, log)
+			}
+			if result.Format != "mp3" {
+				t.Errorf("expected mp3 output, got %s", result.Format)
+			}
+			if _, err := os.Stat(outputPath); err != nil {
+				t.Errorf("expected output file: %v", err)
+			}
+			if !strings.Contains(log.String(), tc.note) || !strings.Contains(log.String(), tc.message) {
+				t.Errorf("expected log to contain the fallback and ffmpeg's error, got\n%s", log)
+			}
+
+			// Normalized parts are removed
+			if parts, _ := filepath.Glob(outputPath + ".norm*"); len(parts) > 0 {
+				t.Errorf("expected parts to be removed, got %v", parts)
+			}
+		})
+	}
+
+	// Missing files don't fall back
+	fakeFFmpeg(t, "*", "unused")
+	log := &transcodeLog{}
+	_, err := NewTranscoder().withLog(log).transcodeWithFallback(ctx, []string{"/nonexistent.mp3"}, filepath.Join(t.TempDir(), "audio.mp3"))
+	if ClassifyFailure(err) != FailureMissingFile || log.String() != "" {
+		t.Errorf("expected missing file without fallback, got %v\n%s", err, log)
+	}
+}
+
+func TestWorker_FinishJob_NotRetryable(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	queue := store.NewJobStore(db)
+	idx := NewIndex(store.NewCacheStore(db), t.TempDir())
+	worker := NewWorker(idx, nil, queue, 1)
+
+	idx.CreateEntryWithFormat("item-1", "/media/item-1", 0, time.Now(), "mp3")
+	worker.Enqueue(Job{ItemID: "item-1", SourcePaths: []string{"/media/item-1"}})
+	qj, _ := queue.Claim(time.Now(), 0)
+
+	// Corrupt input fails the same way on every attempt
+	err := &TranscodeError{ExitCode: 1, Output: "Invalid data found when processing input", Err: ErrInvalidInputFile}
+	worker.recordAttempt(qj.ItemID, qj.Attempts, time.Now(), &transcodeLog{}, err)
+	worker.finishJob(qj.ItemID, qj.Attempts, err)
+
+	entry, _ := idx.GetEntry("item-1")
+	if entry.Status != store.CacheStatusFailed {
+		t.Errorf("expected failed entry after the first attempt, got %s", entry.Status)
+	}
+	if qj, _ := queue.Get("item-1"); qj != nil {
+		t.Error("expected job to be removed")
+	}
+
+	attempts, _ := worker.Attempts("item-1")
+	if len(attempts) != 1 || attempts[0].ErrorClass != string(FailureCorruptInput) {
+		t.Errorf("expected one corrupt_input attempt, got %+v", attempts)
+	}
+}
+
 func TestIndex_WaitForReady(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -466,3 +1689,149 @@ func TestIndex_WaitForReady_Failed(t *testing.T) {
 		t.Error("expected error for failed transcoding")
 	}
 }
+
+func TestNextSeriesBook(t *testing.T) {
+	book := func(id, seriesID, sequence string) abs.LibraryItem {
+		item := abs.LibraryItem{ID: id}
+		item.Media.Metadata.Series = abs.SeriesList{{ID: seriesID, Sequence: sequence}}
+		return item
+	}
+	books := []abs.LibraryItem{
+		book("book-3", "series-1", "3"),
+		book("book-1", "series-1", "1"),
+		book("book-2", "series-1", "2"),
+		book("book-2.5", "series-1", "2.5"),
+		book("other", "series-2", "2"),
+		book("unnumbered", "series-1", ""),
+	}
+
+	tests := []struct {
+		name     string
+		current  float64
+		finished map[string]bool
+		expected string
+	}{
+		{"next in sequence", 1, nil, "book-2"},
+		{"fractional sequence", 2, nil, "book-2.5"},
+		{"skips finished", 1, map[string]bool{"book-2": true, "book-2.5": true}, "book-3"},
+		{"last book", 3, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextSeriesBook(books, "series-1", tt.current, tt.finished); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWarmupKey(t *testing.T) {
+	bookItem := &abs.LibraryItem{ID: "book-1", MediaType: "book"}
+	if key, ok := warmupKey(bookItem); !ok || key != "book-1" {
+		t.Errorf("expected book-1, got %q (%v)", key, ok)
+	}
+
+	podcast := &abs.LibraryItem{ID: "pod-1", MediaType: "podcast"}
+	if _, ok := warmupKey(podcast); ok {
+		t.Error("expected no key for podcast without episodes")
+	}
+
+	podcast.Media.Episodes = []abs.PodcastEpisode{
+		{ID: "ep-1", PublishedAt: 100},
+		{ID: "ep-2", PublishedAt: 300},
+		{ID: "ep-3", PublishedAt: 200},
+	}
+	if key, ok := warmupKey(podcast); !ok || key != store.CacheKey("pod-1", "ep-2") {
+		t.Errorf("expected latest episode, got %q (%v)", key, ok)
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	type update struct {
+		outSec, speed float64
+		end           bool
+	}
+	var updates []update
+	pw := &progressWriter{report: func(outSec, speed float64, end bool) {
+		updates = append(updates, update{outSec, speed, end})
+	}}
+
+	// Blocks may be split across writes
+	fmt.Fprint(pw, "bitrate=N/A\nout_time_us=N/A\nspeed=N/A\nprogress=continue\n")
+	fmt.Fprint(pw, "out_time_us=90500000\nout_ti")
+	fmt.Fprint(pw, "me=00:01:30.500000\nspeed=45.2x\nprogress=continue\n")
+	fmt.Fprint(pw, "out_time_ms=120000000\nspeed=40x\nprogress=end\n")
+
+	expected := []update{
+		{0, 0, false},
+		{90.5, 45.2, false},
+		{120, 40, true},
+	}
+	if !slices.Equal(updates, expected) {
+		t.Errorf("expected updates %v, got %v", expected, updates)
+	}
+}
+
+func TestProgressTracker(t *testing.T) {
+	pt := NewProgressTracker()
+
+	if _, ok := pt.Get("item-1"); ok {
+		t.Error("expected no progress before the job starts")
+	}
+
+	updates, cancel := pt.Subscribe("item-1")
+	defer cancel()
+
+	// Two segments of 100 and 50 seconds
+	p := pt.begin("item-1", 150)
+	p.startSegment(0, 2, 100)
+	p.update(50, 10, false)
+
+	progress, ok := pt.Get("item-1")
+	if !ok {
+		t.Fatal("expected progress of running job")
+	}
+	if progress.Percent != 33.3 {
+		t.Errorf("expected 33.3%%, got %v", progress.Percent)
+	}
+	if progress.SegmentPercent != 50 || progress.Segment != 0 || progress.SegmentCount != 2 {
+		t.Errorf("unexpected segment progress: %+v", progress)
+	}
+	if progress.Speed != 10 {
+		t.Errorf("expected speed 10, got %v", progress.Speed)
+	}
+
+	// Subscribers only keep the latest update
+	if got := <-updates; got != progress {
+		t.Errorf("expected latest update %+v, got %+v", progress, got)
+	}
+
+	p.update(100, 10, true)
+	p.startSegment(1, 2, 50)
+	p.update(25, 10, false)
+
+	progress, _ = pt.Get("item-1")
+	if progress.Percent != 83.3 || progress.SegmentPercent != 50 || progress.SegmentsDone != 1 {
+		t.Errorf("unexpected progress after first segment: %+v", progress)
+	}
+	if progress.ETASec < 0 {
+		t.Errorf("expected ETA, got %d", progress.ETASec)
+	}
+
+	pt.end(p)
+	if _, ok := pt.Get("item-1"); ok {
+		t.Error("expected no progress after the job ended")
+	}
+	for range updates {
+		// Drain until the channel is closed
+	}
+
+	// Updates of an ended job are ignored
+	p.update(50, 10, false)
+
+	// A nil progress ignores updates
+	var none *jobProgress
+	none.startSegment(0, 1, 10)
+	none.update(1, 1, true)
+}
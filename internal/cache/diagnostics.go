@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailureClass classifies why a transcode failed.
+type FailureClass string
+
+const (
+	FailureMissingFile      FailureClass = "missing_file"      // A source file doesn't exist
+	FailureCorruptInput     FailureClass = "corrupt_input"     // A source file can't be decoded
+	FailureUnsupportedCodec FailureClass = "unsupported_codec" // No decoder, or the codec can't be stored in the container
+	FailureDiskFull         FailureClass = "disk_full"         // Not enough space in the cache directory
+	FailureConcatMismatch   FailureClass = "concat_mismatch"   // Source files can't be joined without normalizing them
+	FailureUnknown          FailureClass = "unknown"
+)
+
+// ClassifyFailure returns the class of a transcoding error, or "" for nil.
+func ClassifyFailure(err error) FailureClass {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrInputFileNotFound):
+		return FailureMissingFile
+	case errors.Is(err, ErrInsufficientDiskSpace):
+		return FailureDiskFull
+	case errors.Is(err, ErrUnsupportedCodec):
+		return FailureUnsupportedCodec
+	case errors.Is(err, ErrConcatMismatch):
+		return FailureConcatMismatch
+	case errors.Is(err, ErrInvalidInputFile):
+		return FailureCorruptInput
+	default:
+		return FailureUnknown
+	}
+}
+
+// Retryable reports whether running the job again may succeed. Corrupt and
+// unsupported sources fail the same way once all fallbacks were tried.
+func (c FailureClass) Retryable() bool {
+	return c != FailureCorruptInput && c != FailureUnsupportedCodec
+}
+
+// canFallBack reports whether a failed encode may succeed in another way.
+// Missing files, a full disk and cancelled jobs fail regardless of how the
+// files are encoded.
+func canFallBack(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrFFmpegNotFound) {
+		return false
+	}
+	switch ClassifyFailure(err) {
+	case FailureMissingFile, FailureDiskFull:
+		return false
+	}
+	return true
+}
+
+const (
+	// maxLogSize bounds the log kept per attempt. Longer logs keep their end.
+	maxLogSize = 256 << 10
+
+	// maxRunOutput bounds the output kept per ffmpeg run. Longer output
+	// keeps its start, where ffmpeg describes the inputs, and its end,
+	// where it reports errors.
+	maxRunOutput = 64 << 10
+)
+
+// transcodeLog collects the commands and output of the ffmpeg runs of a job
+// attempt, and notes on the fallbacks taken. A nil *transcodeLog ignores all
+// writes.
+type transcodeLog struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+// notef adds a note to the log.
+func (l *transcodeLog) notef(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(&l.buf, "[%s] %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// run adds an ffmpeg run with its output and exit error to the log.
+func (l *transcodeLog) run(args []string, output []byte, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintf(&l.buf, "[%s] $ ffmpeg %s\n", time.Now().Format("15:04:05"), quoteArgs(args))
+	out := string(output)
+	if len(out) > maxRunOutput {
+		half := maxRunOutput / 2
+		out = out[:half] + "\n...[output truncated]...\n" + out[len(out)-half:]
+	}
+	l.buf.WriteString(strings.ReplaceAll(out, "\r", "\n"))
+	if len(out) > 0 && !strings.HasSuffix(out, "\n") {
+		l.buf.WriteByte('\n')
+	}
+	if err != nil {
+		fmt.Fprintf(&l.buf, "=> %v\n\n", err)
+	} else {
+		l.buf.WriteString("=> ok\n\n")
+	}
+}
+
+// String returns the log, truncated to its last maxLogSize bytes.
+func (l *transcodeLog) String() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.buf.String()
+	if len(s) > maxLogSize {
+		s = "...[log truncated]...\n" + s[len(s)-maxLogSize:]
+	}
+	return s
+}
+
+// quoteArgs joins command line arguments, quoting those that contain spaces
+// or quotes.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// EvictorConfig configures the cache evictor.
+type EvictorConfig struct {
+	MaxBytes      int64 // Cache quota in bytes (0 disables eviction)
+	HighWatermark int   // Usage in percent of MaxBytes that starts eviction
+	LowWatermark  int   // Usage in percent of MaxBytes that eviction frees down to
+	Interval      time.Duration
+}
+
+// DefaultEvictorInterval is how often the cache size is checked.
+const DefaultEvictorInterval = 5 * time.Minute
+
+// Evictor keeps the cache below its quota by removing the least recently
+// played entries. Items with a playback session are never evicted.
+type Evictor struct {
+	index         *Index
+	playbackStore *store.PlaybackStore
+	config        EvictorConfig
+	cancel        context.CancelFunc
+}
+
+// NewEvictor creates a new cache evictor.
+func NewEvictor(index *Index, playbackStore *store.PlaybackStore, config EvictorConfig) *Evictor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultEvictorInterval
+	}
+	return &Evictor{
+		index:         index,
+		playbackStore: playbackStore,
+		config:        config,
+	}
+}
+
+// Start begins checking the cache size periodically.
+// Does nothing if no quota is configured.
+func (e *Evictor) Start(ctx context.Context) {
+	if e.config.MaxBytes <= 0 {
+		slog.Info("cache quota not configured, eviction disabled")
+		return
+	}
+
+	ctx, e.cancel = context.WithCancel(ctx)
+
+	go func() {
+		e.Run()
+
+		ticker := time.NewTicker(e.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Run()
+			}
+		}
+	}()
+
+	slog.Info("cache evictor started",
+		"max_bytes", e.config.MaxBytes,
+		"high_watermark", e.config.HighWatermark,
+		"low_watermark", e.config.LowWatermark,
+		"interval", e.config.Interval,
+	)
+}
+
+// Stop stops the evictor.
+func (e *Evictor) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+// Run evicts entries if the cache exceeds the high watermark, until it is
+// below the low watermark. Returns the number of bytes freed.
+func (e *Evictor) Run() int64 {
+	if e.config.MaxBytes <= 0 {
+		return 0
+	}
+
+	usage := e.index.TotalDiskUsage()
+	high := e.config.MaxBytes * int64(e.config.HighWatermark) / 100
+	if usage <= high {
+		return 0
+	}
+	low := e.config.MaxBytes * int64(e.config.LowWatermark) / 100
+
+	entries, err := e.index.store.ListReadyByLastAccess()
+	if err != nil {
+		slog.Error("failed to list cache entries for eviction", "error", err)
+		return 0
+	}
+
+	// Paused sessions keep their playback session and may resume at any time
+	sessions, err := e.playbackStore.ListAll()
+	if err != nil {
+		slog.Error("failed to list playback sessions", "error", err)
+		return 0
+	}
+	inUse := make(map[string]bool, len(sessions))
+	for _, ps := range sessions {
+		inUse[ps.CacheKey()] = true
+	}
+
+	slog.Info("cache above high watermark, evicting",
+		"usage_bytes", usage,
+		"high_bytes", high,
+		"low_bytes", low,
+	)
+
+	var freed int64
+	var evicted int
+	for _, entry := range entries {
+		if usage-freed <= low {
+			break
+		}
+		if inUse[entry.ItemID] {
+			continue
+		}
+
+		size := e.index.DiskUsage(entry.ItemID)
+		if entry.Passthrough && size == 0 {
+			// Nothing to free, the source file is served directly
+			continue
+		}
+		if err := e.index.Remove(entry.ItemID); err != nil {
+			slog.Warn("failed to evict cache entry", "item_id", entry.ItemID, "error", err)
+			continue
+		}
+		freed += size
+		evicted++
+
+		slog.Debug("evicted cache entry",
+			"item_id", entry.ItemID,
+			"size", size,
+			"last_accessed", entry.LastAccessedAt,
+		)
+	}
+
+	if usage-freed > low {
+		slog.Warn("cache still above low watermark, remaining entries are in use",
+			"usage_bytes", usage-freed,
+			"low_bytes", low,
+		)
+	}
+	slog.Info("cache eviction finished", "evicted", evicted, "freed_bytes", freed)
+
+	return freed
+}
+
+// dirSize returns the total size of all files below path.
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// remuxWithFallback remuxes the concatenated inputPaths into outputPath. If
+// remuxing fails, e.g. because a stream can't be copied into the target
+// container, the files are transcoded instead. The result's Format is the
+// format actually written.
+func (t *Transcoder) remuxWithFallback(ctx context.Context, inputPaths []string, outputPath, outputFormat string) (*TranscodeResult, error) {
+	result, err := t.RemuxMultiple(ctx, inputPaths, outputPath, outputFormat)
+	if !canFallBack(ctx, err) {
+		return result, err
+	}
+
+	t.fallback("remux failed, transcoding instead", err)
+	return t.transcodeWithFallback(ctx, inputPaths, outputPath)
+}
+
+// transcodeWithFallback transcodes the concatenated inputPaths into
+// outputPath. Corrupt input is decoded again with error-tolerant flags, and
+// files that can't be concatenated are transcoded one by one and then joined.
+func (t *Transcoder) transcodeWithFallback(ctx context.Context, inputPaths []string, outputPath string) (*TranscodeResult, error) {
+	result, err := t.TranscodeMultiple(ctx, inputPaths, outputPath)
+	if !canFallBack(ctx, err) {
+		return result, err
+	}
+
+	class := ClassifyFailure(err)
+	if class == FailureCorruptInput && !t.tolerant {
+		t.fallback("corrupt input, transcoding with error-tolerant decoding", err)
+		return t.withTolerantDecoding().transcodeWithFallback(ctx, inputPaths, outputPath)
+	}
+	if len(inputPaths) > 1 && (class == FailureConcatMismatch || class == FailureCorruptInput) {
+		t.fallback("concatenation failed, normalizing each file", err)
+		return t.normalizeAndConcat(ctx, inputPaths, outputPath)
+	}
+	return nil, err
+}
+
+// normalizeAndConcat transcodes each input on its own, so all parts share
+// the profile's codec, sample rate and channel layout, and joins the parts
+// without encoding them again.
+func (t *Transcoder) normalizeAndConcat(ctx context.Context, inputPaths []string, outputPath string) (*TranscodeResult, error) {
+	parts := make([]string, 0, len(inputPaths))
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	for i, path := range inputPaths {
+		// The .tmp suffix lets CleanupTempFiles remove parts of interrupted jobs
+		part := fmt.Sprintf("%s.norm%03d.tmp", outputPath, i)
+		parts = append(parts, part)
+		if _, err := t.transcodeWithFallback(ctx, []string{path}, part); err != nil {
+			return nil, fmt.Errorf("normalizing file %d: %w", i, err)
+		}
+	}
+
+	return t.RemuxMultiple(ctx, parts, outputPath, t.profile.OutputFormat)
+}
+
+// remuxSegmentedWithFallback splits inputPath into segments without
+// re-encoding it, and transcodes the segments if that fails. Returns the
+// output format used.
+func (t *Transcoder) remuxSegmentedWithFallback(ctx context.Context, inputPath, outputDir, outputFormat string, chapterStarts []int) (*SegmentedResult, string, error) {
+	result, err := t.RemuxSegmented(ctx, inputPath, outputDir, outputFormat, chapterStarts)
+	if !canFallBack(ctx, err) {
+		return result, outputFormat, err
+	}
+
+	t.fallback("segmented remux failed, transcoding instead", err)
+	removeSegments(outputDir)
+	result, err = t.transcodeSegmentedWithFallback(ctx, inputPath, outputDir, chapterStarts)
+	return result, t.profile.OutputFormat, err
+}
+
+// transcodeSegmentedWithFallback transcodes inputPath into segments, and
+// again with error-tolerant decoding if the input is corrupt.
+func (t *Transcoder) transcodeSegmentedWithFallback(ctx context.Context, inputPath, outputDir string, chapterStarts []int) (*SegmentedResult, error) {
+	result, err := t.TranscodeSegmented(ctx, inputPath, outputDir, chapterStarts)
+	if !canFallBack(ctx, err) || t.tolerant || ClassifyFailure(err) != FailureCorruptInput {
+		return result, err
+	}
+
+	t.fallback("corrupt input, transcoding with error-tolerant decoding", err)
+	return t.withTolerantDecoding().TranscodeSegmented(ctx, inputPath, outputDir, chapterStarts)
+}
+
+// fallback logs that a failed encode is retried in another way.
+func (t *Transcoder) fallback(action string, err error) {
+	slog.Info("transcoding fallback", "action", action, "class", ClassifyFailure(err), "error", err)
+	t.log.notef("%s (%s: %v)", action, ClassifyFailure(err), err)
+}
+
+// removeSegments removes the segments written by a failed segmented encode.
+func removeSegments(outputDir string) {
+	matches, _ := filepath.Glob(filepath.Join(outputDir, "segment_*"))
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// Fingerprint identifies the version of an item's source files, as reported
+// by ABS. A replaced or re-tagged file changes its size, modification time or
+// inode, and adding or removing files changes the item's modification time.
+type Fingerprint struct {
+	Size  int64     // Total size of all source files
+	Mtime time.Time // Latest modification time of the source files or the item
+	Hash  string    // Digest of the item's mtime and each file's inode, size and mtime
+}
+
+// NewFingerprint returns the fingerprint of the given audio files in playback
+// order. itemMtimeMs is the modification time of the item's folder, or 0 for
+// podcast episodes, whose folder changes with every new episode.
+func NewFingerprint(itemMtimeMs int64, files []abs.AudioFile) Fingerprint {
+	h := sha256.New()
+	fmt.Fprintf(h, "item:%d\n", itemMtimeMs)
+
+	fp := Fingerprint{}
+	latest := itemMtimeMs
+	for _, af := range files {
+		fmt.Fprintf(h, "%s:%d:%d\n", af.Ino, af.Metadata.Size, af.Metadata.Mtimems)
+		fp.Size += af.Metadata.Size
+		if af.Metadata.Mtimems > latest {
+			latest = af.Metadata.Mtimems
+		}
+	}
+
+	fp.Mtime = time.UnixMilli(latest)
+	fp.Hash = hex.EncodeToString(h.Sum(nil))[:16]
+	return fp
+}
+
+// CreateEntryForJob creates a new pending cache entry for a job, recording
+// the fingerprint of its source files.
+func (idx *Index) CreateEntryForJob(job Job) error {
+	sourcePaths := job.SourcePaths
+	if len(sourcePaths) == 0 && job.SourcePath != "" {
+		sourcePaths = []string{job.SourcePath}
+	}
+	var sourcePath string
+	if len(sourcePaths) > 0 {
+		sourcePath = sourcePaths[0]
+	}
+
+	// Delete existing entry if any (handles version migration)
+	_ = idx.store.Delete(job.ItemID)
+
+	return idx.store.Create(&store.CacheEntry{
+		ItemID:         job.ItemID,
+		SourcePath:     sourcePath,
+		SourcePaths:    sourcePaths,
+		SourceSize:     job.Fingerprint.Size,
+		SourceMtime:    job.Fingerprint.Mtime,
+		Fingerprint:    job.Fingerprint.Hash,
+		ProfileVersion: ProfileVersionForKey(job.ItemID),
+		CachePath:      idx.GetCachePathWithFormat(job.ItemID, "mp3"),
+		CacheFormat:    "mp3",
+		Status:         store.CacheStatusPending,
+	})
+}
+
+// SourceChanged reports whether the source files of an entry changed since
+// it was transcoded. Entries created before fingerprints were recorded are
+// assumed to be current.
+func (idx *Index) SourceChanged(entry *store.CacheEntry, fp Fingerprint) bool {
+	return entry.Fingerprint != "" && fp.Hash != "" && entry.Fingerprint != fp.Hash
+}
+
+// InvalidateIfChanged removes the cached files and the entry of a job's item
+// if its source files changed, so that it is transcoded again. Entries without
+// a fingerprint adopt the job's. Entries that are being transcoded are left
+// alone. Returns true if the entry was removed.
+func (idx *Index) InvalidateIfChanged(job Job) (bool, error) {
+	entry, err := idx.store.Get(job.ItemID)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	if entry.Status == store.CacheStatusInProgress || job.Fingerprint.Hash == "" {
+		return false, nil
+	}
+
+	if entry.Fingerprint == "" {
+		return false, idx.store.UpdateSource(job.ItemID, job.Fingerprint.Size, job.Fingerprint.Mtime, job.Fingerprint.Hash)
+	}
+	if !idx.SourceChanged(entry, job.Fingerprint) {
+		return false, nil
+	}
+
+	slog.Info("source files changed, invalidating cache entry",
+		"item_id", job.ItemID,
+		"old_size", entry.SourceSize,
+		"new_size", job.Fingerprint.Size,
+		"old_mtime", entry.SourceMtime,
+		"new_mtime", job.Fingerprint.Mtime,
+	)
+	if err := idx.Remove(job.ItemID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
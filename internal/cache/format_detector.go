@@ -139,3 +139,21 @@ func (af *AudioFormat) String() string {
 	return fmt.Sprintf("container=%s codec=%s bitrate=%dkbps samplerate=%dHz channels=%d duration=%ds",
 		af.Container, af.AudioCodec, af.Bitrate, af.SampleRate, af.Channels, af.Duration)
 }
+
+// losslessCodecs are the ffprobe names of lossless audio codecs. PCM codecs
+// are matched by their "pcm_" prefix.
+var losslessCodecs = map[string]bool{
+	"flac":    true,
+	"alac":    true,
+	"wavpack": true,
+	"ape":     true,
+	"tta":     true,
+	"tak":     true,
+	"mlp":     true,
+	"truehd":  true,
+}
+
+// Lossless reports whether the audio is stored without lossy compression.
+func (af *AudioFormat) Lossless() bool {
+	return losslessCodecs[af.AudioCodec] || strings.HasPrefix(af.AudioCodec, "pcm_")
+}
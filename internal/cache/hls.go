@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// HLS output. Players that support HLS stream an item as one playlist of
+// short MPEG-TS segments instead of the cached files, so long books play as
+// one continuous item. Segments are cut from the cached output on first
+// request and kept next to it.
+const (
+	HLSSegmentDuration = 10                              // Length of an HLS segment in seconds
+	HLSPlaylistName    = "index.m3u8"                    // File name of the playlist in stream URLs
+	HLSContentType     = "application/vnd.apple.mpegurl" // MIME type of the playlist
+	HLSSegmentType     = "video/mp2t"                    // MIME type of the segments
+)
+
+// hlsDir is the directory of the cut segments in an item's cache directory.
+const hlsDir = "hls"
+
+// hlsCutTimeout is how long cutting a single segment may take.
+const hlsCutTimeout = 2 * time.Minute
+
+// hlsFormats are the playlist MIME types a player must accept for HLS, with
+// their aliases.
+var hlsFormats = []string{
+	HLSContentType,
+	"application/x-mpegurl",
+	"audio/mpegurl",
+	"audio/x-mpegurl",
+}
+
+// SupportsHLS reports whether a Sonos player can stream HLS playlists.
+// Legacy players get segmented files even if they advertise HLS.
+func SupportsHLS(device *store.SonosDevice) bool {
+	if device == nil || IsLegacyModel(device.Model) {
+		return false
+	}
+	for _, mimeType := range hlsFormats {
+		if device.AcceptsFormat(mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// HLSAvailable reports whether an entry can be streamed as HLS. Segments are
+// cut from the finished output, so the entry must be ready.
+func HLSAvailable(entry *store.CacheEntry) bool {
+	return entry != nil && entry.Status == store.CacheStatusReady && entry.DurationSec != nil && *entry.DurationSec > 0
+}
+
+// HLSSegmentName returns the file name of an HLS segment.
+func HLSSegmentName(index int) string {
+	return fmt.Sprintf("hls_%05d.ts", index)
+}
+
+// hlsSegment is a piece of the cached output served as one HLS segment.
+type hlsSegment struct {
+	Start    int // Start on the output's timeline in seconds
+	Duration int // Length in seconds
+	Source   int // Index of the cache segment it is cut from
+	Offset   int // Start within the cache segment in seconds
+}
+
+// hlsSegments splits the output of an entry into HLS segments. Segments
+// never span two cache segments, so each one is cut from a single file.
+func hlsSegments(entry *store.CacheEntry) []hlsSegment {
+	if entry.DurationSec == nil {
+		return nil
+	}
+	total := *entry.DurationSec
+
+	starts := entry.SegmentStarts
+	if len(starts) == 0 {
+		starts = []int{0}
+	}
+
+	var segments []hlsSegment
+	for source, sourceStart := range starts {
+		sourceEnd := total
+		if source+1 < len(starts) {
+			sourceEnd = min(starts[source+1], total)
+		}
+		for start := sourceStart; start < sourceEnd; start += HLSSegmentDuration {
+			segments = append(segments, hlsSegment{
+				Start:    start,
+				Duration: min(HLSSegmentDuration, sourceEnd-start),
+				Source:   source,
+				Offset:   start - sourceStart,
+			})
+		}
+	}
+	return segments
+}
+
+// HLSSegmentCount returns the number of HLS segments of an entry.
+func HLSSegmentCount(entry *store.CacheEntry) int {
+	return len(hlsSegments(entry))
+}
+
+// HLSPlaylist returns the VOD playlist of an entry. Segment URIs are relative
+// to the playlist, so they carry the playlist's stream token.
+func HLSPlaylist(entry *store.CacheEntry) string {
+	segments := hlsSegments(entry)
+
+	target := 1
+	for _, segment := range segments {
+		target = max(target, segment.Duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, segment := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%d.000,\n%s\n", segment.Duration, HLSSegmentName(i))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// HLS cuts the HLS segments of cached items on demand.
+type HLS struct {
+	index      *Index
+	transcoder *Transcoder
+
+	mu       sync.Mutex
+	inFlight map[string]chan struct{} // Segments being cut, by path
+}
+
+// NewHLS creates an HLS segmenter for the items in the cache index.
+func NewHLS(index *Index) *HLS {
+	return &HLS{
+		index:      index,
+		transcoder: NewTranscoder(),
+		inFlight:   make(map[string]chan struct{}),
+	}
+}
+
+// Segment returns the path of an HLS segment of an entry, cutting it from
+// the cached output if it doesn't exist yet. Concurrent requests for the
+// same segment wait for a single cut.
+func (h *HLS) Segment(ctx context.Context, entry *store.CacheEntry, index int) (string, error) {
+	segments := hlsSegments(entry)
+	if index < 0 || index >= len(segments) {
+		return "", fmt.Errorf("HLS segment %d out of range (%d segments)", index, len(segments))
+	}
+	segment := segments[index]
+
+	path := filepath.Join(h.index.GetCacheDir(entry.ItemID), hlsDir, HLSSegmentName(index))
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		h.mu.Lock()
+		done, cutting := h.inFlight[path]
+		if !cutting {
+			done = make(chan struct{})
+			h.inFlight[path] = done
+		}
+		h.mu.Unlock()
+
+		if cutting {
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		err := h.cut(entry, segment, path)
+
+		h.mu.Lock()
+		delete(h.inFlight, path)
+		close(done)
+		h.mu.Unlock()
+
+		if err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+}
+
+// cut cuts a segment from the cached output. The cut isn't tied to the
+// request, so a player that gives up early still leaves the segment for its
+// next attempt.
+func (h *HLS) cut(entry *store.CacheEntry, segment hlsSegment, path string) error {
+	source := h.index.GetCachePathFromEntry(entry)
+	if entry.IsSegmented() {
+		source = filepath.Join(h.index.GetCacheDir(entry.ItemID), entry.GetSegmentFileName(segment.Source))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hlsCutTimeout)
+	defer cancel()
+	return h.transcoder.CutHLSSegment(ctx, source, path, entry.CacheFormat, segment.Offset, segment.Duration, segment.Start)
+}
+
+// CutHLSSegment writes durationSec seconds of the input, starting at
+// startSec, as an MPEG-TS segment whose timestamps start at offsetSec on the
+// playlist's timeline. AAC and MP3 are copied; other formats are encoded to
+// AAC, which MPEG-TS can carry.
+func (t *Transcoder) CutHLSSegment(ctx context.Context, inputPath, outputPath string, format string, startSec, durationSec, offsetSec int) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return ErrFFmpegNotFound
+	}
+	if _, err := os.Stat(inputPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrInputFileNotFound
+		}
+		return fmt.Errorf("failed to stat input file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	codecArgs := []string{"-c:a", "copy"}
+	if format != "mp3" && format != "mp4" {
+		codecArgs = []string{"-c:a", "aac", "-b:a", "256k"}
+	}
+
+	tempPath := outputPath + ".tmp"
+	args := []string{"-y", "-ss", fmt.Sprint(startSec), "-i", inputPath, "-t", fmt.Sprint(durationSec), "-map", "0:a", "-vn"}
+	args = append(args, codecArgs...)
+	args = append(args, "-output_ts_offset", fmt.Sprint(offsetSec), "-f", "mpegts", tempPath)
+
+	output, err := t.runFFmpeg(ctx, args)
+	if err != nil {
+		os.Remove(tempPath)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return ParseFFmpegExitCode(exitErr.ExitCode(), string(output))
+		}
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	if err := os.Rename(tempPath, outputPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename HLS segment: %w", err)
+	}
+	return nil
+}
+
+// removeHLS deletes the cut HLS segments of an item, e.g. after its output
+// was replaced.
+func (idx *Index) removeHLS(itemID string) {
+	os.RemoveAll(filepath.Join(idx.GetCacheDir(itemID), hlsDir))
+}
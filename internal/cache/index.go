@@ -83,6 +83,11 @@ func (idx *Index) IsCached(itemID string) (bool, error) {
 		return false, nil
 	}
 
+	// Output of a changed profile has to be transcoded again
+	if entry.ProfileVersion != ProfileVersionForKey(itemID) {
+		return false, nil
+	}
+
 	// Check for segmented cache
 	if entry.IsSegmented() {
 		return idx.verifySegmentedCache(entry)
@@ -126,10 +131,14 @@ func (idx *Index) GetCachePathWithFormat(itemID, format string) string {
 }
 
 // GetCachePathFromEntry returns the path to the cached file based on the entry's format.
+// Passthrough entries are served from their source file.
 func (idx *Index) GetCachePathFromEntry(entry *store.CacheEntry) string {
 	if entry == nil {
 		return ""
 	}
+	if entry.Passthrough {
+		return entry.CachePath
+	}
 	format := entry.CacheFormat
 	if format == "" {
 		format = "mp3" // default for old entries
@@ -184,7 +193,7 @@ func (idx *Index) CreateEntryWithFormat(itemID, sourcePath string, sourceSize in
 		SourcePath:     sourcePath,
 		SourceSize:     sourceSize,
 		SourceMtime:    sourceMtime,
-		ProfileVersion: CurrentCacheVersion,
+		ProfileVersion: ProfileVersionForKey(itemID),
 		CachePath:      idx.GetCachePathWithFormat(itemID, format),
 		CacheFormat:    format,
 		Status:         store.CacheStatusPending,
@@ -201,17 +210,45 @@ func (idx *Index) MarkInProgress(itemID string) error {
 // MarkReady marks an entry as ready with the given duration.
 // Deprecated: Use MarkReadyWithFormat instead.
 func (idx *Index) MarkReady(itemID string, durationSec int) error {
+	idx.removeHLS(itemID)
 	return idx.store.MarkReady(itemID, durationSec)
 }
 
 // MarkReadyWithFormat marks an entry as ready with the given duration and format.
 func (idx *Index) MarkReadyWithFormat(itemID string, durationSec int, format string) error {
+	idx.removeHLS(itemID)
 	return idx.store.MarkReadyWithFormat(itemID, durationSec, format)
 }
 
 // MarkReadyWithSegments marks an entry as ready with segment information.
-func (idx *Index) MarkReadyWithSegments(itemID string, durationSec int, format string, segmentCount int, segmentDurationSec int) error {
-	return idx.store.MarkReadyWithSegments(itemID, durationSec, format, segmentCount, segmentDurationSec)
+func (idx *Index) MarkReadyWithSegments(itemID string, durationSec int, format string, segmentStarts []int) error {
+	idx.removeHLS(itemID)
+	return idx.store.MarkReadyWithSegments(itemID, durationSec, format, segmentStarts)
+}
+
+// MarkPassthrough marks an entry as ready to be served straight from its
+// source file, without a copy in the cache.
+func (idx *Index) MarkPassthrough(itemID string, durationSec int, format, sourcePath string) error {
+	idx.removeHLS(itemID)
+	return idx.store.MarkPassthrough(itemID, durationSec, format, sourcePath)
+}
+
+// SetLayout records the output format and segment layout of an item that is
+// still being transcoded. HLS segments cut from an earlier output are removed.
+func (idx *Index) SetLayout(itemID string, durationSec int, format string, segmentStarts []int) error {
+	idx.removeHLS(itemID)
+	return idx.store.UpdateLayout(itemID, durationSec, format, segmentStarts)
+}
+
+// SetSourcePaths records the source files of an entry in playback order.
+func (idx *Index) SetSourcePaths(itemID string, paths []string) error {
+	return idx.store.UpdateSourcePaths(itemID, paths)
+}
+
+// SetSourceType records whether an entry is transcoded from the local media
+// volume or from files downloaded through the ABS API.
+func (idx *Index) SetSourceType(itemID string, sourceType string) error {
+	return idx.store.UpdateSourceType(itemID, sourceType)
 }
 
 // GetCacheDir returns the cache directory path for an item.
@@ -224,11 +261,91 @@ func (idx *Index) MarkFailed(itemID string, errorText string) error {
 	return idx.store.MarkFailed(itemID, errorText)
 }
 
+// MarkPending marks an entry as waiting for another attempt, keeping the
+// error message of the last one.
+func (idx *Index) MarkPending(itemID string, errorText string) error {
+	return idx.store.UpdateStatus(itemID, store.CacheStatusPending, errorText)
+}
+
 // Delete removes a cache entry.
 func (idx *Index) Delete(itemID string) error {
 	return idx.store.Delete(itemID)
 }
 
+// accessResolution is the granularity of last-access tracking.
+const accessResolution = time.Minute
+
+// TouchAccess records that an item was streamed, for least-recently-played eviction.
+func (idx *Index) TouchAccess(itemID string) error {
+	return idx.store.TouchAccess(itemID, accessResolution)
+}
+
+// DiskUsage returns the size of all cached files of an item, in bytes.
+func (idx *Index) DiskUsage(itemID string) int64 {
+	return dirSize(idx.GetCacheDir(itemID))
+}
+
+// TotalDiskUsage returns the size of the whole cache directory, in bytes.
+func (idx *Index) TotalDiskUsage() int64 {
+	return dirSize(idx.cacheDir)
+}
+
+// Remove deletes the cached files of an item, including all segments, and its index entry.
+func (idx *Index) Remove(itemID string) error {
+	if err := os.RemoveAll(idx.GetCacheDir(itemID)); err != nil {
+		return fmt.Errorf("failed to remove cache directory: %w", err)
+	}
+	return idx.store.Delete(itemID)
+}
+
+// ProfileVersionForKey returns the profile version of the transcode profile
+// a cache key is created with. Unknown variants are transcoded with the
+// default profile.
+func ProfileVersionForKey(itemID string) string {
+	_, variant := store.SplitVariantKey(itemID)
+	profile, ok := ProfileByName(variant)
+	if !ok {
+		profile = DefaultProfile
+	}
+	return profile.Version()
+}
+
+// PruneOutdatedProfiles deletes the cache entries and files of profile
+// versions that no current profile produces, e.g. after a profile's settings
+// changed. Returns the number of deleted entries.
+func (idx *Index) PruneOutdatedProfiles() (int64, error) {
+	current := make(map[string]bool)
+	for _, profile := range KnownProfiles() {
+		current[profile.Version()] = true
+	}
+
+	entries, err := idx.store.ListAll()
+	if err != nil {
+		return 0, err
+	}
+
+	outdated := make(map[string]bool)
+	for _, entry := range entries {
+		if current[entry.ProfileVersion] {
+			continue
+		}
+		if err := os.RemoveAll(idx.GetCacheDir(entry.ItemID)); err != nil {
+			return 0, fmt.Errorf("failed to remove cache directory: %w", err)
+		}
+		outdated[entry.ProfileVersion] = true
+	}
+
+	var deleted int64
+	for version := range outdated {
+		n, err := idx.store.DeleteByProfile(version)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
 // IsStale checks if a cache entry is stale (source changed).
 func (idx *Index) IsStale(entry *store.CacheEntry, currentSize int64, currentMtime time.Time) bool {
 	if entry.SourceSize != currentSize {
@@ -322,16 +439,23 @@ func (idx *Index) GetPendingItems() ([]*store.CacheEntry, error) {
 	return idx.store.ListByStatus(store.CacheStatusPending)
 }
 
-// CleanupTempFiles removes any leftover temporary files.
+// CleanupTempFiles removes any leftover temporary files, including HLS
+// segments whose cut was interrupted.
 func (idx *Index) CleanupTempFiles() error {
-	pattern := filepath.Join(idx.cacheDir, "*", "*.tmp")
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return err
+	patterns := []string{
+		filepath.Join(idx.cacheDir, "*", "*.tmp"),
+		filepath.Join(idx.cacheDir, "*", "*"+PartialSuffix),
+		filepath.Join(idx.cacheDir, "*", hlsDir, "*.tmp"),
 	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
 
-	for _, match := range matches {
-		os.Remove(match)
+		for _, match := range matches {
+			os.Remove(match)
+		}
 	}
 
 	return nil
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// LiveContentType is the MIME type of live streams.
+const LiveContentType = "audio/mpeg"
+
+// ErrLiveBusy is returned when the maximum number of live streams is running.
+var ErrLiveBusy = errors.New("too many live streams")
+
+// LiveFileName returns the file name of a live stream starting at offsetSec
+// on the output's timeline.
+func LiveFileName(offsetSec int) string {
+	return fmt.Sprintf("live_%d.mp3", offsetSec)
+}
+
+// LiveSourcesAvailable reports whether an item can be streamed live, which
+// requires all its source files on the local media volume.
+func LiveSourcesAvailable(paths []string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Live transcodes items that aren't cached yet from their source files while
+// they are streamed, starting at an offset, so playback doesn't wait for the
+// cache. The cache job keeps running and takes over once the item is ready.
+type Live struct {
+	slots       chan struct{} // One per running stream
+	mu          sync.Mutex
+	transcoders map[string]*Transcoder // By cache key, so loudness is measured once per item
+}
+
+// NewLive creates a live transcoder running up to maxStreams streams at once.
+func NewLive(maxStreams int) *Live {
+	return &Live{
+		slots:       make(chan struct{}, max(maxStreams, 1)),
+		transcoders: make(map[string]*Transcoder),
+	}
+}
+
+// transcoder returns the transcoder of a cache key, using the key's profile.
+func (l *Live) transcoder(itemID string) *Transcoder {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := l.transcoders[itemID]
+	if t == nil {
+		_, variant := store.SplitVariantKey(itemID)
+		profile, ok := ProfileByName(variant)
+		if !ok {
+			profile = DefaultProfile
+		}
+		t = NewTranscoder().WithProfile(profile)
+		l.transcoders[itemID] = t
+	}
+	return t
+}
+
+// Forget drops the loudness measurements of a cache key, once the item is
+// cached or no longer played live. Running streams aren't affected.
+func (l *Live) Forget(itemID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.transcoders, itemID)
+}
+
+// SetLive sets the live transcoder streaming queued items while their jobs
+// run. An item's measurements are dropped when its job ends. It must be
+// called before Start.
+func (w *Worker) SetLive(live *Live) {
+	w.live = live
+}
+
+// Stream writes the output of an entry, starting at offsetSec on the output's
+// timeline, to w as it is encoded. Returns once the output is complete or ctx
+// is done, or ErrLiveBusy without writing anything if the maximum number of
+// streams is running.
+func (l *Live) Stream(ctx context.Context, w io.Writer, entry *store.CacheEntry, offsetSec int) error {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		return ErrLiveBusy
+	}
+	defer func() { <-l.slots }()
+
+	return l.transcoder(entry.ItemID).EncodeLive(ctx, entry.SourcePaths, offsetSec, w)
+}
+
+// EncodeLive transcodes the concatenated inputPaths with the transcoder's
+// profile from startSec on the output's timeline and writes MP3 to w.
+func (t *Transcoder) EncodeLive(ctx context.Context, inputPaths []string, startSec int, w io.Writer) error {
+	if len(inputPaths) == 0 {
+		return ErrInputFileNotFound
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return ErrFFmpegNotFound
+	}
+
+	// Progress lines would pile up over a whole book
+	args := []string{"-nostats"}
+	if sourceSec := t.profile.SourceTime(startSec); sourceSec > 0 {
+		// -ss before -i for fast seeking
+		args = append(args, "-ss", strconv.Itoa(sourceSec))
+	}
+
+	if len(inputPaths) == 1 {
+		args = append(args, "-i", inputPaths[0])
+	} else {
+		concatFile, err := os.CreateTemp("", "live-*.concat.txt")
+		if err != nil {
+			return fmt.Errorf("failed to create concat list: %w", err)
+		}
+		for _, path := range inputPaths {
+			// Escape single quotes in path for ffmpeg concat format
+			escapedPath := strings.ReplaceAll(path, "'", "'\\''")
+			fmt.Fprintf(concatFile, "file '%s'\n", escapedPath)
+		}
+		concatFile.Close()
+		defer os.Remove(concatFile.Name())
+
+		args = append(args, "-f", "concat", "-safe", "0", "-i", concatFile.Name())
+	}
+
+	// Loudness is measured like for progressive encodes, so the live stream
+	// matches the cached output
+	filterArgs, err := t.filterArgs(ctx, inputPaths, progressiveMeasureSec)
+	if err != nil {
+		return err
+	}
+
+	args = append(args,
+		"-map", "0:a", // Select only audio streams
+		"-map_chapters", "-1", // Remove chapter metadata
+		"-vn", // No video
+		"-c:a", "libmp3lame",
+		"-ar", strconv.Itoa(t.profile.SampleRate),
+		"-ac", strconv.Itoa(t.profile.Channels),
+		"-b:a", t.profile.Bitrate,
+	)
+	args = append(args, filterArgs...)
+	args = append(args, "-f", "mp3", "pipe:1")
+
+	var stderr strings.Builder
+	cmd := t.command(ctx, args)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	t.log.run(args, []byte(stderr.String()), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return ParseFFmpegExitCode(exitErr.ExitCode(), stderr.String())
+		}
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// loudnormTargets are the EBU R128 targets for spoken word: integrated
+// loudness, true peak and loudness range.
+const loudnormTargets = "I=-16:TP=-1.5:LRA=11"
+
+// progressiveMeasureSec limits the loudnorm measurement of progressive
+// encodes, so playback doesn't wait for a pass over the whole item.
+const progressiveMeasureSec = 20 * 60
+
+// compressorFilter evens out quiet and loud passages for night listening.
+// Runs before loudnorm, which then brings the result to the target loudness.
+const compressorFilter = "acompressor=threshold=-24dB:ratio=4:attack=20:release=250:makeup=2"
+
+// tempoFilter returns the filter changing the speed without changing the
+// pitch. atempo accepts 0.5 to 2.0 in older ffmpeg releases, which covers
+// all PlaybackSpeeds.
+func (p TranscodeProfile) tempoFilter() string {
+	return "atempo=" + strconv.FormatFloat(p.Tempo(), 'f', -1, 64)
+}
+
+// loudnessStats are the measurements of the first loudnorm pass.
+type loudnessStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// filter returns the second pass loudnorm filter using the measurements.
+// Silent input can't be measured and is normalized in a single pass.
+func (s *loudnessStats) filter() string {
+	if strings.Contains(s.InputI, "inf") || strings.Contains(s.InputThresh, "inf") {
+		return "loudnorm=" + loudnormTargets
+	}
+	return fmt.Sprintf("loudnorm=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		loudnormTargets, s.InputI, s.InputTP, s.InputLRA, s.InputThresh, s.TargetOffset)
+}
+
+// parseLoudnessStats extracts the JSON block loudnorm prints at the end of
+// the first pass.
+func parseLoudnessStats(output string) (*loudnessStats, error) {
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("no loudnorm measurement in ffmpeg output")
+	}
+
+	var stats loudnessStats
+	if err := json.Unmarshal([]byte(output[start:end+1]), &stats); err != nil {
+		return nil, fmt.Errorf("invalid loudnorm measurement: %w", err)
+	}
+	if stats.InputI == "" {
+		return nil, errors.New("incomplete loudnorm measurement")
+	}
+	return &stats, nil
+}
+
+// filterArgs returns the ffmpeg arguments for the profile's audio filters,
+// or nil if the profile doesn't filter. inputPaths are all files of the item,
+// so segments of the same item are normalized alike. measureSec limits the
+// loudness measurement to the start of the input (0 = whole input).
+func (t *Transcoder) filterArgs(ctx context.Context, inputPaths []string, measureSec int) ([]string, error) {
+	var filters []string
+	if t.profile.Compress {
+		filters = append(filters, compressorFilter)
+	}
+	if t.profile.Loudnorm {
+		stats, err := t.measureLoudness(ctx, inputPaths, filters, measureSec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, stats.filter())
+	}
+	if t.profile.Tempo() != 1 {
+		filters = append(filters, t.profile.tempoFilter())
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return []string{"-af", strings.Join(filters, ",")}, nil
+}
+
+// measureLoudness runs the first loudnorm pass over the input, after the
+// given filters. Measurements are kept for the transcoder's lifetime.
+func (t *Transcoder) measureLoudness(ctx context.Context, inputPaths []string, filters []string, measureSec int) (*loudnessStats, error) {
+	key := strings.Join(inputPaths, "\x00")
+
+	t.mu.Lock()
+	stats := t.loudness[key]
+	t.mu.Unlock()
+	if stats != nil {
+		return stats, nil
+	}
+
+	args := []string{"-hide_banner", "-nostats"}
+	if len(inputPaths) == 1 {
+		args = append(args, "-i", inputPaths[0])
+	} else {
+		concatFile, err := os.CreateTemp("", "loudnorm-*.concat.txt")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create concat list: %w", err)
+		}
+		for _, path := range inputPaths {
+			// Escape single quotes in path for ffmpeg concat format
+			escapedPath := strings.ReplaceAll(path, "'", "'\\''")
+			fmt.Fprintf(concatFile, "file '%s'\n", escapedPath)
+		}
+		concatFile.Close()
+		defer os.Remove(concatFile.Name())
+
+		args = append(args, "-f", "concat", "-safe", "0", "-i", concatFile.Name())
+	}
+
+	if measureSec > 0 {
+		args = append(args, "-t", strconv.Itoa(measureSec))
+	}
+
+	filter := "loudnorm=" + loudnormTargets + ":print_format=json"
+	if len(filters) > 0 {
+		filter = strings.Join(filters, ",") + "," + filter
+	}
+	args = append(args,
+		"-map", "0:a",
+		"-vn",
+		"-af", filter,
+		"-f", "null",
+		"-",
+	)
+
+	var buf bytes.Buffer
+	cmd := t.command(ctx, args)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := t.run(ctx, cmd)
+	output := buf.Bytes()
+	t.log.run(args, output, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			outputStr := string(output)
+			return nil, &TranscodeError{
+				ExitCode: exitErr.ExitCode(),
+				Output:   truncateOutput(outputStr, 500),
+				Err:      fmt.Errorf("loudness measurement: %w", ParseFFmpegExitCode(exitErr.ExitCode(), outputStr)),
+			}
+		}
+		return nil, &TranscodeError{
+			Err: fmt.Errorf("ffmpeg loudness measurement failed: %w", err),
+		}
+	}
+
+	stats, err = parseLoudnessStats(string(output))
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.loudness == nil {
+		t.loudness = make(map[string]*loudnessStats)
+	}
+	t.loudness[key] = stats
+	t.mu.Unlock()
+
+	return stats, nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// SetPassthrough enables serving compatible single-file items straight from
+// the media volume instead of copying them into the cache.
+func (w *Worker) SetPassthrough(enabled bool) {
+	w.passthrough = enabled
+}
+
+// ServeDirectly marks a job's item as ready to be served from its source file
+// if it qualifies for passthrough, without running the job. Returns true if
+// the item is served directly.
+func (w *Worker) ServeDirectly(ctx context.Context, job Job) (bool, error) {
+	sourcePaths := job.SourcePaths
+	if len(sourcePaths) == 0 && job.SourcePath != "" {
+		sourcePaths = []string{job.SourcePath}
+	}
+	if !w.passthrough || len(sourcePaths) != 1 {
+		return false, nil
+	}
+	if _, err := os.Stat(sourcePaths[0]); err != nil {
+		return false, nil
+	}
+
+	t := w.transcoderFor(job)
+	duration, err := t.GetDuration(ctx, sourcePaths[0])
+	if err != nil {
+		return false, nil
+	}
+	return w.servePassthrough(ctx, t, job.ItemID, sourcePaths, store.SourceTypeLocal, duration)
+}
+
+// servePassthrough marks an item as ready to be served from its source file
+// if the file can be played by Sonos as it is. Only single local files whose
+// output isn't processed or segmented qualify; downloaded files are removed
+// after the job. Returns true if the item is served directly.
+func (w *Worker) servePassthrough(ctx context.Context, t *Transcoder, itemID string, sourcePaths []string, sourceType string, durationSec int) (bool, error) {
+	if !w.passthrough || len(sourcePaths) != 1 || sourceType != store.SourceTypeLocal {
+		return false, nil
+	}
+	if t.profile.RequiresTranscode() || (t.profile.Segmented && durationSec > SegmentDuration) {
+		return false, nil
+	}
+
+	format, err := NewFormatDetector().Detect(ctx, sourcePaths[0])
+	if err != nil {
+		slog.Debug("format detection failed, caching", "path", sourcePaths[0], "error", err)
+		return false, nil
+	}
+	checker := NewCompatibilityChecker()
+	if checker.Check(format) != Compatible {
+		return false, nil
+	}
+
+	targetFormat := checker.GetTargetFormat(format)
+	if err := w.index.MarkPassthrough(itemID, durationSec, targetFormat, sourcePaths[0]); err != nil {
+		return false, err
+	}
+
+	slog.Info("serving source file without caching",
+		"item_id", itemID,
+		"path", sourcePaths[0],
+		"format", targetFormat,
+		"duration_sec", durationSec,
+	)
+	return true, nil
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// Profile names. The name is used as the cache variant, so an item can be
+// cached once per profile.
+const (
+	ProfileDefault     = ""   // Segmented output for players with little memory
+	ProfileHighQuality = "hq" // Single file, higher bitrate for current players
+)
+
+// Audio presets process the audio on top of a player's profile. They are
+// selected globally (BRIDGE_AUDIO_PRESET) or per item.
+const (
+	PresetNone     = "none"     // No processing
+	PresetLoudnorm = "loudnorm" // EBU R128 loudness normalization
+	PresetNight    = "night"    // Dynamic range compression, then normalization
+	PresetSpeech   = "speech"   // Normalized mono at a low bitrate
+)
+
+// AudioPresets lists all audio presets.
+var AudioPresets = []string{PresetNone, PresetLoudnorm, PresetNight, PresetSpeech}
+
+// PlaybackSpeeds are the speeds offered besides normal speed. Sonos players
+// can't change the playback rate, so each speed is a separate cache variant
+// with the tempo changed by the transcoder.
+var PlaybackSpeeds = []float64{1.25, 1.5, 1.75, 2.0}
+
+// presetSeparator joins a profile, audio preset and speed name, e.g.
+// "hq+night" or "hq+night+1.5x".
+const presetSeparator = "+"
+
+// speedSuffix ends the name of a speed, e.g. "1.5x".
+const speedSuffix = "x"
+
+// HighQualityProfile is used for players that can buffer long single files.
+// Compatible sources (FLAC, AAC, MP3) are remuxed as before; everything else
+// is transcoded to FLAC if the source is lossless, or to AAC otherwise. Both
+// are among the formats a player must advertise to get this profile.
+var HighQualityProfile = TranscodeProfile{
+	Name:         ProfileHighQuality,
+	AudioCodec:   "aac",
+	Bitrate:      "256k",
+	SampleRate:   44100,
+	Channels:     2,
+	OutputFormat: "mp4",
+	Segmented:    false,
+	KeepLossless: true,
+}
+
+// legacyModels are players with ~128MB RAM that fail on long single files.
+// Matched case-insensitively against the model name.
+var legacyModels = []string{"ZP80", "ZP90", "ZP100", "ZP120", "CONNECT"}
+
+// highQualityFormats are the MIME types a player must accept for the
+// high quality profile, with their aliases.
+var highQualityFormats = [][]string{
+	{"audio/mpeg", "audio/mp3"},
+	{"audio/mp4", "audio/x-m4a", "audio/aac"},
+	{"audio/flac", "audio/x-flac"},
+}
+
+// ProfileByName returns the profile with the given name, including profiles
+// with an audio preset or speed applied.
+func ProfileByName(name string) (TranscodeProfile, bool) {
+	if name == ProfileDefault {
+		return DefaultProfile, true
+	}
+
+	// Presets and speeds on the default profile are named without the base
+	parts := strings.Split(name, presetSeparator)
+	profile := DefaultProfile
+	if parts[0] == ProfileHighQuality {
+		profile = HighQualityProfile
+		parts = parts[1:]
+	}
+	if len(parts) > 0 && parts[0] != PresetNone && IsAudioPreset(parts[0]) {
+		profile = ApplyPreset(profile, parts[0])
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		speed, ok := ParseSpeed(parts[0])
+		if !ok || speed == 1 {
+			return TranscodeProfile{}, false
+		}
+		profile = ApplySpeed(profile, speed)
+		parts = parts[1:]
+	}
+
+	// Only names built by ApplyPreset and ApplySpeed are valid
+	if len(parts) > 0 || profile.Name != name {
+		return TranscodeProfile{}, false
+	}
+	return profile, true
+}
+
+// SpeedName returns the name of a playback speed, e.g. "1.5x".
+func SpeedName(speed float64) string {
+	return strconv.FormatFloat(speed, 'f', -1, 64) + speedSuffix
+}
+
+// ParseSpeed parses a speed, given as a number ("1.5") or by name ("1.5x").
+// Only normal speed and PlaybackSpeeds are accepted.
+func ParseSpeed(s string) (float64, bool) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, speedSuffix), 64)
+	if err != nil {
+		return 0, false
+	}
+	if speed == 1 {
+		return 1, true
+	}
+	for _, known := range PlaybackSpeeds {
+		if speed == known {
+			return speed, true
+		}
+	}
+	return 0, false
+}
+
+// IsAudioPreset reports whether name is a known audio preset.
+func IsAudioPreset(name string) bool {
+	for _, preset := range AudioPresets {
+		if name == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyPreset returns the profile with the audio preset applied. The preset
+// becomes part of the profile name, so processed output is cached separately.
+// PresetNone and unknown presets return the profile unchanged.
+func ApplyPreset(profile TranscodeProfile, preset string) TranscodeProfile {
+	switch preset {
+	case PresetLoudnorm:
+		profile.Loudnorm = true
+	case PresetNight:
+		profile.Compress = true
+		profile.Loudnorm = true
+	case PresetSpeech:
+		profile.Loudnorm = true
+		profile.AudioCodec = "libmp3lame"
+		profile.Bitrate = "64k"
+		profile.Channels = 1
+		profile.OutputFormat = "mp3"
+		profile.KeepLossless = false
+	default:
+		return profile
+	}
+
+	if profile.Name == ProfileDefault {
+		profile.Name = preset
+	} else {
+		profile.Name += presetSeparator + preset
+	}
+	return profile
+}
+
+// ApplySpeed returns the profile with the playback speed applied. The speed
+// becomes part of the profile name, so each speed is cached separately.
+// Normal speed returns the profile unchanged.
+func ApplySpeed(profile TranscodeProfile, speed float64) TranscodeProfile {
+	if speed <= 0 || speed == 1 {
+		return profile
+	}
+
+	profile.Speed = speed
+	if profile.Name == ProfileDefault {
+		profile.Name = SpeedName(speed)
+	} else {
+		profile.Name += presetSeparator + SpeedName(speed)
+	}
+	return profile
+}
+
+// KnownProfiles returns every profile a cache entry can currently be created
+// with: each base profile, alone and with each audio preset, at each speed.
+func KnownProfiles() []TranscodeProfile {
+	var profiles []TranscodeProfile
+	for _, base := range []TranscodeProfile{DefaultProfile, HighQualityProfile} {
+		for _, preset := range AudioPresets {
+			profile := ApplyPreset(base, preset)
+			profiles = append(profiles, profile)
+			for _, speed := range PlaybackSpeeds {
+				profiles = append(profiles, ApplySpeed(profile, speed))
+			}
+		}
+	}
+	return profiles
+}
+
+// ProfileForDevice picks the transcode profile for a Sonos player.
+// Legacy players and players whose sink formats are unknown get the default
+// profile; players advertising MP3, AAC and FLAC get the high quality profile.
+func ProfileForDevice(device *store.SonosDevice) TranscodeProfile {
+	if device == nil || IsLegacyModel(device.Model) {
+		return DefaultProfile
+	}
+
+	for _, aliases := range highQualityFormats {
+		accepted := false
+		for _, mimeType := range aliases {
+			if device.AcceptsFormat(mimeType) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return DefaultProfile
+		}
+	}
+
+	return HighQualityProfile
+}
+
+// ForSource returns the profile used to transcode a source of the given
+// format. Profiles keeping lossless audio transcode lossless sources to FLAC.
+func (p TranscodeProfile) ForSource(format *AudioFormat) TranscodeProfile {
+	if !p.KeepLossless || format == nil || !format.Lossless() {
+		return p
+	}
+	p.AudioCodec = "flac"
+	p.Bitrate = ""
+	p.OutputFormat = "flac"
+	return p
+}
+
+// IsLegacyModel reports whether a model name belongs to a player that needs
+// segmented output.
+func IsLegacyModel(model string) bool {
+	model = strings.ToUpper(model)
+	for _, legacy := range legacyModels {
+		if strings.Contains(model, legacy) {
+			return true
+		}
+	}
+	return false
+}
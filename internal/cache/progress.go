@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minETAFraction is the share of a job that must be done before an ETA is
+// estimated from the elapsed time.
+const minETAFraction = 0.01
+
+// Progress is the progress of a running transcoding job.
+type Progress struct {
+	ItemID         string  `json:"itemId"`
+	Percent        float64 `json:"percent"`        // Overall progress, 0-100
+	ETASec         int     `json:"etaSec"`         // Estimated seconds until done, -1 if unknown
+	Speed          float64 `json:"speed"`          // Encoding speed as a multiple of real time
+	Segment        int     `json:"segment"`        // Index of the segment being encoded
+	SegmentCount   int     `json:"segmentCount"`   // Number of segments, 1 for single-file output
+	SegmentPercent float64 `json:"segmentPercent"` // Progress of the current segment, 0-100
+	SegmentsDone   int     `json:"segmentsDone"`   // Number of finished segments
+}
+
+// progressWriter parses the output of ffmpeg's -progress option, a block of
+// key=value lines per update that ends with a progress=continue|end line.
+type progressWriter struct {
+	buf    []byte
+	outSec float64
+	speed  float64
+	report func(outSec, speed float64, end bool)
+}
+
+// Write implements io.Writer.
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		pw.parseLine(string(pw.buf[:i]))
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (pw *progressWriter) parseLine(line string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return
+	}
+
+	switch key {
+	case "out_time_us", "out_time_ms": // Both are in microseconds; N/A before the first frame
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			pw.outSec = float64(us) / 1e6
+		}
+	case "speed":
+		if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			pw.speed = speed
+		}
+	case "progress":
+		pw.report(pw.outSec, pw.speed, value == "end")
+	}
+}
+
+// jobProgress accumulates the progress of a job over its ffmpeg runs. A nil
+// *jobProgress ignores all updates.
+type jobProgress struct {
+	tracker *ProgressTracker
+	itemID  string
+
+	// Guarded by tracker.mu
+	totalSec     float64   // Output seconds to encode over all passes
+	doneSec      float64   // Output seconds encoded by finished runs
+	runSec       float64   // Output seconds encoded by the current run
+	speed        float64   // Speed reported by the current run
+	firstUpdate  time.Time // Time of the first update, the base for the ETA
+	segment      int
+	segmentCount int
+	segmentSec   float64 // Length of the current segment
+	inSegment    bool    // Whether the current run encodes a segment
+	segmentsDone int
+}
+
+// startSegment records that the next ffmpeg run encodes segment i of count,
+// lengthSec seconds long.
+func (p *jobProgress) startSegment(i, count, lengthSec int) {
+	if p == nil {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+
+	p.segment, p.segmentCount = i, count
+	p.segmentSec = float64(lengthSec)
+	p.inSegment = true
+	p.runSec = 0
+	p.tracker.publish(p)
+}
+
+// update records the output position of the current ffmpeg run.
+func (p *jobProgress) update(outSec, speed float64, end bool) {
+	if p == nil {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+
+	if p.firstUpdate.IsZero() {
+		p.firstUpdate = time.Now()
+	}
+	p.runSec, p.speed = outSec, speed
+	if end {
+		p.doneSec += p.runSec
+		p.runSec = 0
+		if p.inSegment {
+			p.segmentsDone++
+			p.inSegment = false
+		}
+	}
+	p.tracker.publish(p)
+}
+
+// snapshot returns the current progress. Must be called with tracker.mu held.
+func (p *jobProgress) snapshot() Progress {
+	progress := Progress{
+		ItemID:       p.itemID,
+		ETASec:       -1,
+		Speed:        p.speed,
+		Segment:      p.segment,
+		SegmentCount: max(p.segmentCount, 1),
+		SegmentsDone: p.segmentsDone,
+	}
+
+	fraction := 0.0
+	if p.totalSec > 0 {
+		fraction = math.Min((p.doneSec+p.runSec)/p.totalSec, 1)
+	}
+	progress.Percent = math.Round(fraction*1000) / 10
+
+	if p.inSegment && p.segmentSec > 0 {
+		progress.SegmentPercent = math.Round(math.Min(p.runSec/p.segmentSec, 1)*1000) / 10
+	} else if p.segmentCount <= 1 {
+		progress.SegmentPercent = progress.Percent
+	}
+
+	if fraction >= minETAFraction && !p.firstUpdate.IsZero() {
+		elapsed := time.Since(p.firstUpdate).Seconds()
+		progress.ETASec = int(math.Ceil(elapsed * (1 - fraction) / fraction))
+	}
+	return progress
+}
+
+// ProgressTracker tracks the progress of running transcoding jobs by item ID
+// and notifies subscribers about updates.
+type ProgressTracker struct {
+	mu   sync.Mutex
+	jobs map[string]*jobProgress
+	subs map[string]map[chan Progress]struct{}
+}
+
+// NewProgressTracker creates a new progress tracker.
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		jobs: make(map[string]*jobProgress),
+		subs: make(map[string]map[chan Progress]struct{}),
+	}
+}
+
+// begin starts tracking a job that encodes totalSec seconds of output over
+// all of its passes.
+func (pt *ProgressTracker) begin(itemID string, totalSec int) *jobProgress {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	p := &jobProgress{tracker: pt, itemID: itemID, totalSec: float64(totalSec)}
+	pt.jobs[itemID] = p
+	pt.publish(p)
+	return p
+}
+
+// end stops tracking a job and closes the channels of its subscribers.
+func (pt *ProgressTracker) end(p *jobProgress) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if pt.jobs[p.itemID] != p {
+		return
+	}
+	delete(pt.jobs, p.itemID)
+	for ch := range pt.subs[p.itemID] {
+		close(ch)
+	}
+	delete(pt.subs, p.itemID)
+}
+
+// Get returns the progress of a running job.
+func (pt *ProgressTracker) Get(itemID string) (Progress, bool) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	p, ok := pt.jobs[itemID]
+	if !ok {
+		return Progress{}, false
+	}
+	return p.snapshot(), true
+}
+
+// Subscribe returns a channel that receives the latest progress of an item's
+// job. Slow subscribers only get the most recent update. The channel is
+// closed when the job ends; a subscription made before the job starts stays
+// open until the job has ended. The returned function cancels the
+// subscription.
+func (pt *ProgressTracker) Subscribe(itemID string) (<-chan Progress, func()) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	ch := make(chan Progress, 1)
+	if pt.subs[itemID] == nil {
+		pt.subs[itemID] = make(map[chan Progress]struct{})
+	}
+	pt.subs[itemID][ch] = struct{}{}
+
+	cancel := func() {
+		pt.mu.Lock()
+		defer pt.mu.Unlock()
+
+		if _, ok := pt.subs[itemID][ch]; !ok {
+			return // Closed by end
+		}
+		delete(pt.subs[itemID], ch)
+		if len(pt.subs[itemID]) == 0 {
+			delete(pt.subs, itemID)
+		}
+		close(ch)
+	}
+	return ch, cancel
+}
+
+// publish sends the progress of a job to its subscribers. Must be called with
+// mu held.
+func (pt *ProgressTracker) publish(p *jobProgress) {
+	if pt.jobs[p.itemID] != p {
+		return
+	}
+
+	progress := p.snapshot()
+	for ch := range pt.subs[p.itemID] {
+		// Replace an update the subscriber hasn't received yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- progress
+	}
+}
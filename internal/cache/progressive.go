@@ -0,0 +1,504 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// PartialSuffix is appended to output files of a progressive encode while they
+// are being written. Unlike ".tmp" files, partial files are streamable and are
+// served by the stream handler while they grow.
+const PartialSuffix = ".part"
+
+// progressivePollInterval is how often a progressive encode checks whether
+// ffmpeg has started writing its output.
+const progressivePollInterval = 100 * time.Millisecond
+
+// EncodeStreamable encodes durationSec seconds of output (0 = until the end),
+// starting at startSec in the source, from the concatenated inputPaths into
+// outputPath. With remux the audio stream is copied, otherwise it is
+// transcoded with the transcoder's profile.
+//
+// The output is written to outputPath+PartialSuffix in a form that can be
+// played while it is still being written (fragmented M4A for mp4) and renamed
+// to outputPath when complete. started, if not nil, is called once ffmpeg has
+// written the first data.
+func (t *Transcoder) EncodeStreamable(ctx context.Context, inputPaths []string, outputPath string, startSec, durationSec int, outputFormat string, remux bool, started func()) (int64, error) {
+	if len(inputPaths) == 0 {
+		return 0, ErrInputFileNotFound
+	}
+
+	// Verify ffmpeg is available
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return 0, ErrFFmpegNotFound
+	}
+
+	// Ensure output directory exists
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var args []string
+	if startSec > 0 {
+		// -ss before -i for fast seeking
+		args = append(args, "-ss", strconv.Itoa(startSec))
+	}
+
+	if len(inputPaths) == 1 {
+		args = append(args, "-i", inputPaths[0])
+	} else {
+		// Create concat list file for ffmpeg
+		concatListPath := outputPath + ".concat.txt"
+		concatFile, err := os.Create(concatListPath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create concat list: %w", err)
+		}
+		for _, path := range inputPaths {
+			// Escape single quotes in path for ffmpeg concat format
+			escapedPath := strings.ReplaceAll(path, "'", "'\\''")
+			fmt.Fprintf(concatFile, "file '%s'\n", escapedPath)
+		}
+		concatFile.Close()
+		defer os.Remove(concatListPath)
+
+		args = append(args, "-f", "concat", "-safe", "0", "-i", concatListPath)
+	}
+
+	if durationSec > 0 {
+		args = append(args, "-t", strconv.Itoa(durationSec))
+	}
+
+	args = append(args,
+		"-map", "0:a", // Select only audio streams
+		"-map_chapters", "-1", // Remove chapter metadata
+		"-vn", // No video
+	)
+
+	if remux {
+		args = append(args, "-c:a", "copy")
+	} else {
+		// Loudness is measured once per item, so all segments match
+		filterArgs, err := t.filterArgs(ctx, inputPaths, progressiveMeasureSec)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, t.encodeArgs()...)
+		args = append(args, filterArgs...)
+		outputFormat = t.profile.OutputFormat
+	}
+
+	// +faststart rewrites the file after encoding, so M4A output is fragmented
+	// instead: the moov atom comes first and fragments are appended as they
+	// are encoded. The ipod muxer keeps the M4A brand for older Sonos devices.
+	if outputFormat == "mp4" {
+		args = append(args,
+			"-movflags", "+empty_moov+default_base_moof",
+			"-frag_duration", "10000000", // 10 second fragments
+			"-brand", "M4A",
+			"-f", "ipod",
+		)
+	} else {
+		args = append(args, "-f", outputFormat)
+	}
+
+	partialPath := outputPath + PartialSuffix
+	args = append(args, "-y", partialPath)
+
+	cmd, output := t.ffmpegCommand(ctx, args)
+
+	if err := cmd.Start(); err != nil {
+		return 0, &TranscodeError{
+			Err: fmt.Errorf("ffmpeg failed to start: %w", err),
+		}
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// Report the start once the first data has been written
+	var err error
+	if started != nil {
+		err = waitForOutput(partialPath, exited, started)
+	} else {
+		err = <-exited
+	}
+	t.log.run(args, output.Bytes(), err)
+
+	if err != nil {
+		os.Remove(partialPath)
+
+		if exitErr, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+			exitCode := exitErr.ExitCode()
+			outputStr := output.String()
+			parsedErr := ParseFFmpegExitCode(exitCode, outputStr)
+
+			return 0, &TranscodeError{
+				ExitCode: exitCode,
+				Output:   truncateOutput(outputStr, 500),
+				Err:      parsedErr,
+			}
+		}
+
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		return 0, &TranscodeError{
+			Err: fmt.Errorf("ffmpeg failed: %w", err),
+		}
+	}
+
+	// Atomic rename - readers of the partial file keep their file handle
+	if err := os.Rename(partialPath, outputPath); err != nil {
+		os.Remove(partialPath)
+		return 0, fmt.Errorf("failed to rename partial file: %w", err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat output file: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// waitForOutput calls started once path contains data, then waits for ffmpeg
+// to exit. Returns the exit error.
+func waitForOutput(path string, exited <-chan error, started func()) error {
+	ticker := time.NewTicker(progressivePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				started()
+			}
+			return err
+		case <-ticker.C:
+			if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+				started()
+				return <-exited
+			}
+		}
+	}
+}
+
+// segmentOrder returns the order in which segments are encoded so that the
+// segment containing the resume position is available first.
+func segmentOrder(segmentCount, firstSegment int) []int {
+	if firstSegment < 0 || firstSegment >= segmentCount {
+		firstSegment = 0
+	}
+
+	order := make([]int, 0, segmentCount)
+	for i := firstSegment; i < segmentCount; i++ {
+		order = append(order, i)
+	}
+	for i := 0; i < firstSegment; i++ {
+		order = append(order, i)
+	}
+	return order
+}
+
+// progressiveJob tracks a running progressive encode.
+type progressiveJob struct {
+	started     chan struct{} // Closed once the first output is being written
+	startedOnce sync.Once
+	done        chan struct{} // Closed when the encode has finished
+	err         error         // Set before done is closed
+}
+
+func (pj *progressiveJob) markStarted() {
+	pj.startedOnce.Do(func() { close(pj.started) })
+}
+
+// StartProgressive transcodes a job in the background so it can be streamed
+// while it is still being encoded. Segmented output starts with the segment
+// containing startSec, a position in the source. Returns once the first output is being written, or
+// with the error if the encode fails before that.
+//
+// If the item is already being encoded progressively, it waits for that
+// encode instead of starting another one. A queued job of the item is taken
+// over, and stopped first if it is running.
+func (w *Worker) StartProgressive(ctx context.Context, job Job, startSec int) error {
+	w.mu.Lock()
+	pj, running := w.progressive[job.ItemID]
+	if !running {
+		pj = &progressiveJob{
+			started: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		w.progressive[job.ItemID] = pj
+		queued := w.running[job.ItemID]
+		if job.ABSClient != nil {
+			w.absClients[job.ItemID] = job.ABSClient
+		}
+
+		w.wg.Add(1)
+		go w.runProgressive(pj, job, startSec, queued)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-pj.started:
+		return nil
+	case <-pj.done:
+		return pj.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runProgressive runs a progressive encode. It isn't bound to the request that
+// started it, only to the worker's lifetime. The encode is recorded as a
+// running job, so it is queued again if the bridge stops before it completes,
+// and a failed encode is retried in the background. queued is the item's
+// running job of the worker pool, if any.
+func (w *Worker) runProgressive(pj *progressiveJob, job Job, startSec int, queued *runningJob) {
+	defer w.wg.Done()
+	ctx := w.context()
+
+	if queued != nil {
+		slog.Debug("taking over running job for playback", "item_id", job.ItemID)
+		queued.cancel()
+		<-queued.done
+	}
+
+	job.Priority = PriorityInteractive
+	log := &transcodeLog{}
+	started := time.Now()
+	err := w.startJob(job)
+	if err == nil {
+		err = w.processProgressive(ctx, pj, job, startSec, log)
+		if err != nil {
+			slog.Error("progressive transcoding failed", "item_id", job.ItemID, "error", err)
+		}
+	}
+
+	w.mu.Lock()
+	delete(w.progressive, job.ItemID)
+	w.mu.Unlock()
+
+	if ctx.Err() == nil {
+		w.recordAttempt(job.ItemID, 1, started, log, err)
+		w.finishJob(job.ItemID, 1, err)
+	}
+
+	pj.err = err
+	close(pj.done)
+}
+
+// startJob records a job that is run immediately as running in the queue.
+func (w *Worker) startJob(job Job) error {
+	payload, err := encodeJob(job)
+	if err != nil {
+		return err
+	}
+	return w.queue.Start(job.ItemID, int(job.Priority), payload)
+}
+
+// processProgressive encodes a job into streamable output. The commands and
+// output of its ffmpeg runs are written to log.
+func (w *Worker) processProgressive(ctx context.Context, pj *progressiveJob, job Job, startSec int, log *transcodeLog) error {
+	startTime := time.Now()
+	itemID := job.ItemID
+
+	sourcePaths := job.SourcePaths
+	if len(sourcePaths) == 0 && job.SourcePath != "" {
+		sourcePaths = []string{job.SourcePath}
+	}
+
+	slog.Debug("starting progressive transcoding", "item_id", itemID, "source_count", len(sourcePaths), "start_sec", startSec)
+
+	if err := w.index.MarkInProgress(itemID); err != nil {
+		return err
+	}
+
+	if err := w.index.EnsureDirectory(itemID); err != nil {
+		return err
+	}
+
+	// Fall back to downloading through ABS if the media path isn't mounted
+	sourcePaths, sourceType, cleanup, err := w.resolveSources(ctx, job, sourcePaths)
+	if err != nil {
+		log.notef("failed to get source files: %v", err)
+		return err
+	}
+	defer cleanup()
+
+	if err := w.index.SetSourceType(itemID, sourceType); err != nil {
+		slog.Warn("failed to record source type", "item_id", itemID, "error", err)
+	}
+
+	t := w.transcoderFor(job).withLog(log).forSources(ctx, sourcePaths)
+	// Durations and segments are on the output's timeline, which is shorter
+	// for speed variants
+	sourceDuration := w.totalDuration(ctx, sourcePaths)
+	totalDuration := t.profile.OutputTime(sourceDuration)
+
+	// Compatible single files are served without a copy in the cache
+	if ok, err := w.servePassthrough(ctx, t, itemID, sourcePaths, sourceType, totalDuration); ok || err != nil {
+		return err
+	}
+	outputFormat, remux := t.profile.OutputFormat, false
+	if !t.profile.RequiresTranscode() {
+		if format, ok := w.streamableFormat(ctx, sourcePaths); ok {
+			outputFormat, remux = format, true
+		}
+	}
+
+	var segmentStarts []int
+	segmentCount := 1
+	if t.profile.Segmented && needsSegmentation(totalDuration) {
+		segmentStarts = planSegments(totalDuration, t.profile.chapterStarts(job.Chapters))
+		segmentCount = len(segmentStarts)
+	}
+
+	// The stream handler needs the layout before the entry is ready
+	if err := w.index.SetLayout(itemID, totalDuration, outputFormat, segmentStarts); err != nil {
+		return err
+	}
+
+	t, endProgress := w.trackProgress(t, itemID, len(sourcePaths), sourceDuration, false)
+	defer endProgress()
+
+	layout := &store.CacheEntry{CacheFormat: outputFormat, SegmentCount: segmentCount}
+	outputDir := w.index.GetCacheDir(itemID)
+
+	if segmentCount == 1 {
+		outputPath := w.index.GetCachePathWithFormat(itemID, outputFormat)
+		size, err := t.EncodeStreamable(ctx, sourcePaths, outputPath, 0, 0, outputFormat, remux, pj.markStarted)
+		if err != nil {
+			return err
+		}
+
+		// The probed source duration is only an estimate for the output
+		if duration, err := t.GetDuration(ctx, outputPath); err == nil {
+			totalDuration = duration
+		}
+
+		slog.Info("progressive transcoding complete",
+			"item_id", itemID,
+			"duration_sec", totalDuration,
+			"output_size", size,
+			"output_format", outputFormat,
+			"remux", remux,
+			"profile", t.profile.Name,
+			"transcode_time", time.Since(startTime),
+			"source_files", len(sourcePaths),
+		)
+
+		return w.index.MarkReadyWithFormat(itemID, totalDuration, outputFormat)
+	}
+
+	firstSegment, _ := store.GlobalToSegment(t.profile.OutputTime(startSec), segmentStarts)
+	var totalSize int64
+
+	for _, i := range segmentOrder(segmentCount, firstSegment) {
+		segmentPath := filepath.Join(outputDir, layout.GetSegmentFileName(i))
+
+		// Only the first encoded segment reports the start
+		var started func()
+		if i == firstSegment {
+			started = pj.markStarted
+		}
+
+		segmentLength := store.SegmentLength(i, segmentStarts)
+		if segmentLength == 0 {
+			t.progress.startSegment(i, segmentCount, totalDuration-segmentStarts[i])
+		} else {
+			t.progress.startSegment(i, segmentCount, segmentLength)
+		}
+
+		// The last segment is encoded until the end of the source
+		size, err := t.EncodeStreamable(ctx, sourcePaths, segmentPath, t.profile.SourceTime(segmentStarts[i]), segmentLength, outputFormat, remux, started)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", i, err)
+		}
+		totalSize += size
+
+		slog.Debug("progressive segment complete",
+			"item_id", itemID,
+			"segment_index", i,
+			"size_bytes", size)
+	}
+
+	slog.Info("progressive segmented transcoding complete",
+		"item_id", itemID,
+		"duration_sec", totalDuration,
+		"segment_count", segmentCount,
+		"first_segment", firstSegment,
+		"total_size", totalSize,
+		"output_format", outputFormat,
+		"remux", remux,
+		"profile", t.profile.Name,
+		"transcode_time", time.Since(startTime),
+		"source_files", len(sourcePaths),
+	)
+
+	return w.index.MarkReadyWithSegments(itemID, totalDuration, outputFormat, segmentStarts)
+}
+
+// totalDuration returns the summed duration of all source files in seconds.
+// Files whose duration can't be probed are skipped.
+func (w *Worker) totalDuration(ctx context.Context, sourcePaths []string) int {
+	var total int
+	for _, path := range sourcePaths {
+		duration, err := w.transcoder.GetDuration(ctx, path)
+		if err != nil {
+			slog.Debug("failed to get duration", "path", path, "error", err)
+			continue
+		}
+		total += duration
+	}
+	return total
+}
+
+// streamableFormat returns the output format for the source files and whether
+// they can be remuxed. Remuxing requires all files to share a Sonos-compatible codec.
+func (w *Worker) streamableFormat(ctx context.Context, sourcePaths []string) (string, bool) {
+	detector := NewFormatDetector()
+	checker := NewCompatibilityChecker()
+
+	var first *AudioFormat
+	for _, path := range sourcePaths {
+		format, err := detector.Detect(ctx, path)
+		if err != nil {
+			slog.Debug("format detection failed, transcoding", "path", path, "error", err)
+			return "", false
+		}
+		if checker.Check(format) == NeedsTranscode {
+			return "", false
+		}
+		if first == nil {
+			first = format
+		} else if format.AudioCodec != first.AudioCodec {
+			return "", false
+		}
+	}
+
+	if first == nil {
+		return "", false
+	}
+
+	// Other containers aren't reliably playable while they are being written
+	switch target := checker.GetTargetFormat(first); target {
+	case "mp3", "mp4", "flac":
+		return target, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,405 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// Priority orders jobs in the transcode queue. Jobs with a higher priority
+// are run first.
+type Priority int
+
+const (
+	PriorityBulk        Priority = 0  // Libraries and series queued by an admin, changed sources
+	PriorityWarmup      Priority = 10 // Items pinned or in progress
+	PriorityInteractive Priority = 20 // Playback and admin actions on single items
+)
+
+const (
+	// maxJobAttempts is how often a failing job is run before its cache
+	// entry is marked as failed.
+	maxJobAttempts = 4
+
+	// retryBaseDelay is the delay before the first retry. It doubles with
+	// each further attempt, up to retryMaxDelay.
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+
+	// queuePollInterval is how often idle workers check for jobs whose
+	// retry delay has passed.
+	queuePollInterval = 10 * time.Second
+)
+
+// errJobCancelled is recorded for cache entries whose job was cancelled.
+const errJobCancelled = "cancelled"
+
+// ClientProvider returns an ABS client with a user token. It is used to
+// download the remote files of jobs that were queued before a restart.
+type ClientProvider func() (*abs.Client, error)
+
+// SessionClientProvider returns a ClientProvider using the token of the most
+// recently used active session.
+func SessionClientProvider(absClient *abs.Client, sessionStore *store.SessionStore, tokenDecrypt TokenDecrypter) ClientProvider {
+	return func() (*abs.Client, error) {
+		sessions, err := sessionStore.ListActive()
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) == 0 {
+			return nil, fmt.Errorf("no active sessions")
+		}
+
+		token, err := tokenDecrypt.DecryptToken(sessions[0].ABSTokenEnc)
+		if err != nil {
+			return nil, err
+		}
+		return absClient.WithToken(token), nil
+	}
+}
+
+// jobPayload is the persisted form of a Job. The ABS client isn't persisted.
+type jobPayload struct {
+	SourcePaths []string      `json:"sourcePaths"`
+	RemoteFiles []RemoteFile  `json:"remoteFiles,omitempty"`
+	Profile     string        `json:"profile,omitempty"`
+	Chapters    []abs.Chapter `json:"chapters,omitempty"`
+	Fingerprint Fingerprint   `json:"fingerprint"`
+}
+
+// encodeJob serializes a job for the queue.
+func encodeJob(job Job) (string, error) {
+	sourcePaths := job.SourcePaths
+	if len(sourcePaths) == 0 && job.SourcePath != "" {
+		sourcePaths = []string{job.SourcePath}
+	}
+
+	data, err := json.Marshal(jobPayload{
+		SourcePaths: sourcePaths,
+		RemoteFiles: job.RemoteFiles,
+		Profile:     job.Profile,
+		Chapters:    job.Chapters,
+		Fingerprint: job.Fingerprint,
+	})
+	return string(data), err
+}
+
+// decodeJob restores a job from the queue.
+func decodeJob(qj *store.TranscodeJob) (Job, error) {
+	var p jobPayload
+	if err := json.Unmarshal([]byte(qj.Payload), &p); err != nil {
+		return Job{}, fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	return Job{
+		ItemID:      qj.ItemID,
+		SourcePaths: p.SourcePaths,
+		RemoteFiles: p.RemoteFiles,
+		Profile:     p.Profile,
+		Chapters:    p.Chapters,
+		Fingerprint: p.Fingerprint,
+		Priority:    Priority(qj.Priority),
+	}, nil
+}
+
+// retryDelay returns the delay before the next run of a job that failed
+// after the given number of attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// runningJob is a queued job being run by the worker pool.
+type runningJob struct {
+	cancel     context.CancelFunc
+	done       chan struct{} // Closed once the job has stopped
+	background bool
+	yielded    bool // Stopped to free its worker while background jobs are paused
+}
+
+// SetClientProvider sets the provider of ABS clients for jobs that were
+// queued without one, such as jobs restored after a restart.
+func (w *Worker) SetClientProvider(clients ClientProvider) {
+	w.clients = clients
+}
+
+// notify wakes an idle worker.
+func (w *Worker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runNext claims the next due job from the queue and runs it.
+// Returns false if there was none.
+func (w *Worker) runNext(ctx context.Context) bool {
+	now := time.Now()
+
+	w.claimMu.Lock()
+	qj, err := w.queue.Claim(now, int(w.minClaimPriority(now)))
+	background := qj != nil && Priority(qj.Priority) < PriorityInteractive
+	if background {
+		w.mu.Lock()
+		w.backgroundJobs++
+		w.mu.Unlock()
+	}
+	w.claimMu.Unlock()
+
+	if err != nil {
+		slog.Error("failed to claim transcoding job", "error", err)
+		return false
+	}
+	if qj == nil {
+		return false
+	}
+
+	w.runQueued(ctx, qj)
+
+	if background {
+		w.mu.Lock()
+		w.backgroundJobs--
+		w.mu.Unlock()
+		// Another background job may start now
+		w.notify()
+	}
+	return true
+}
+
+// runQueued runs a claimed job and records its outcome in the queue.
+func (w *Worker) runQueued(ctx context.Context, qj *store.TranscodeJob) {
+	job, err := decodeJob(qj)
+	if err != nil {
+		slog.Error("dropping transcoding job", "item_id", qj.ItemID, "error", err)
+		w.finishJob(qj.ItemID, maxJobAttempts, err)
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rj := &runningJob{
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		background: Priority(qj.Priority) < PriorityInteractive,
+	}
+	defer close(rj.done)
+
+	w.mu.Lock()
+	if _, ok := w.progressive[job.ItemID]; ok {
+		// A progressive encode has taken over the job
+		w.mu.Unlock()
+		return
+	}
+	w.running[job.ItemID] = rj
+	job.ABSClient = w.absClients[job.ItemID]
+	w.mu.Unlock()
+
+	if job.ABSClient == nil && w.clients != nil && len(job.RemoteFiles) > 0 {
+		if job.ABSClient, err = w.clients(); err != nil {
+			slog.Debug("no ABS client for queued job", "item_id", job.ItemID, "error", err)
+		}
+	}
+
+	slog.Debug("running queued job", "item_id", job.ItemID, "priority", job.Priority, "attempt", qj.Attempts)
+	log := &transcodeLog{}
+	started := time.Now()
+	err = w.processJob(jobCtx, job, log)
+
+	w.mu.Lock()
+	delete(w.running, job.ItemID)
+	_, superseded := w.progressive[job.ItemID]
+	yielded := rj.yielded
+	w.mu.Unlock()
+
+	if w.live != nil {
+		w.live.Forget(job.ItemID)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		// Shutdown: the job is queued again on the next start
+		return
+	case superseded:
+		return
+	case yielded:
+		slog.Info("background job queued again until the allowed hours", "item_id", job.ItemID)
+		if err := w.queue.Retry(job.ItemID, time.Now(), ""); err != nil {
+			slog.Error("failed to requeue transcoding job", "item_id", job.ItemID, "error", err)
+		}
+		if err := w.index.MarkPending(job.ItemID, ""); err != nil {
+			slog.Warn("failed to reset cache entry", "item_id", job.ItemID, "error", err)
+		}
+		return
+	case err != nil && jobCtx.Err() != nil:
+		slog.Info("transcoding job cancelled", "item_id", job.ItemID)
+		w.recordAttempt(job.ItemID, qj.Attempts, started, log, errors.New(errJobCancelled))
+		if err := w.index.MarkFailed(job.ItemID, errJobCancelled); err != nil {
+			slog.Warn("failed to mark cancelled job", "item_id", job.ItemID, "error", err)
+		}
+		return
+	}
+
+	w.recordAttempt(job.ItemID, qj.Attempts, started, log, err)
+	w.finishJob(job.ItemID, qj.Attempts, err)
+}
+
+// recordAttempt stores the outcome and ffmpeg log of a run of a job.
+func (w *Worker) recordAttempt(itemID string, attempt int, started time.Time, log *transcodeLog, err error) {
+	a := &store.TranscodeAttempt{
+		ItemID:     itemID,
+		Attempt:    attempt,
+		Log:        log.String(),
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+	}
+	if err != nil {
+		a.ErrorClass = string(ClassifyFailure(err))
+		a.Error = err.Error()
+	}
+	if err := w.queue.AddAttempt(a); err != nil {
+		slog.Warn("failed to record transcoding attempt", "item_id", itemID, "error", err)
+	}
+}
+
+// finishJob removes a completed job from the queue. A failed job is retried
+// with an increasing delay until it has been run maxJobAttempts times; then
+// its cache entry is marked as failed. Corrupt and unsupported sources are
+// marked as failed right away.
+func (w *Worker) finishJob(itemID string, attempts int, err error) {
+	if err != nil && attempts < maxJobAttempts && ClassifyFailure(err).Retryable() {
+		next := time.Now().Add(retryDelay(attempts))
+		if qerr := w.queue.Retry(itemID, next, err.Error()); qerr != nil {
+			slog.Error("failed to requeue transcoding job", "item_id", itemID, "error", qerr)
+		}
+		if merr := w.index.MarkPending(itemID, err.Error()); merr != nil {
+			slog.Warn("failed to reset cache entry", "item_id", itemID, "error", merr)
+		}
+		slog.Warn("transcoding failed, retrying", "item_id", itemID, "attempt", attempts, "next_attempt", next, "error", err)
+		return
+	}
+
+	if _, qerr := w.queue.Delete(itemID); qerr != nil {
+		slog.Error("failed to remove transcoding job", "item_id", itemID, "error", qerr)
+	}
+	w.mu.Lock()
+	delete(w.absClients, itemID)
+	w.mu.Unlock()
+
+	if err != nil {
+		if merr := w.index.MarkFailed(itemID, err.Error()); merr != nil {
+			slog.Warn("failed to mark cache entry failed", "item_id", itemID, "error", merr)
+		}
+		slog.Error("transcoding failed, giving up", "item_id", itemID, "attempts", attempts, "class", ClassifyFailure(err), "error", err)
+	}
+}
+
+// Cancel removes an item's job from the queue and stops it if it is running.
+// The cache entry is marked as failed, so the job can be retried. Returns
+// false if the item has no job. Progressive encodes of playing items can't be
+// cancelled.
+func (w *Worker) Cancel(itemID string) (bool, error) {
+	w.mu.Lock()
+	if _, ok := w.progressive[itemID]; ok {
+		w.mu.Unlock()
+		return false, nil
+	}
+	rj := w.running[itemID]
+	delete(w.absClients, itemID)
+	w.mu.Unlock()
+
+	deleted, err := w.queue.Delete(itemID)
+	if err != nil {
+		return false, err
+	}
+
+	if rj != nil {
+		// The worker marks the entry once ffmpeg has stopped
+		rj.cancel()
+		return true, nil
+	}
+	if !deleted {
+		return false, nil
+	}
+
+	slog.Info("transcoding job cancelled", "item_id", itemID)
+	return true, w.index.MarkFailed(itemID, errJobCancelled)
+}
+
+// Jobs returns all jobs in the queue, running jobs first.
+func (w *Worker) Jobs() ([]store.TranscodeJob, error) {
+	return w.queue.List()
+}
+
+// Attempts returns the recorded attempts of an item without their logs,
+// newest first.
+func (w *Worker) Attempts(itemID string) ([]store.TranscodeAttempt, error) {
+	return w.queue.ListAttempts(itemID)
+}
+
+// LatestAttempts returns the newest recorded attempt of each item without
+// its log, by item ID.
+func (w *Worker) LatestAttempts() (map[string]store.TranscodeAttempt, error) {
+	return w.queue.LatestAttempts()
+}
+
+// Attempt returns a recorded attempt with its ffmpeg log, or nil if there is none.
+func (w *Worker) Attempt(id int64) (*store.TranscodeAttempt, error) {
+	return w.queue.GetAttempt(id)
+}
+
+// Replay queues the pending cache entries that have no job, such as entries
+// queued before the queue was persisted. Jobs are built with resolve and
+// queued with bulk priority. Returns the number of queued jobs.
+func (w *Worker) Replay(ctx context.Context, resolve JobResolver) int {
+	entries, err := w.index.GetPendingItems()
+	if err != nil {
+		slog.Error("failed to list pending cache entries", "error", err)
+		return 0
+	}
+
+	var client *abs.Client
+	queued := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if qj, err := w.queue.Get(entry.ItemID); err != nil || qj != nil {
+			continue
+		}
+
+		if client == nil {
+			if w.clients == nil {
+				slog.Debug("no ABS client to replay pending cache entries")
+				return queued
+			}
+			if client, err = w.clients(); err != nil {
+				slog.Info("skipping replay of pending cache entries", "reason", err)
+				return queued
+			}
+		}
+
+		job, err := resolve(ctx, client, entry.ItemID)
+		if err != nil {
+			slog.Warn("failed to build job for pending cache entry", "item_id", entry.ItemID, "error", err)
+			continue
+		}
+		job.Priority = PriorityBulk
+		if w.Enqueue(job) {
+			queued++
+		}
+	}
+
+	if queued > 0 {
+		slog.Info("replayed pending cache entries", "count", queued)
+	}
+	return queued
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// DefaultScanInterval is how often cached items are checked for changed
+// source files.
+const DefaultScanInterval = 6 * time.Hour
+
+// JobResolver builds the transcoding job for a cache key from the current
+// ABS item, including the fingerprint of its source files. Returns an error
+// wrapping abs.ErrNotFound if the item no longer exists.
+type JobResolver func(ctx context.Context, client *abs.Client, cacheKey string) (Job, error)
+
+// SourceScanner periodically compares the fingerprints of cached items with
+// their current source files in ABS and transcodes changed items again.
+// Items with a playback session are skipped until playback ends.
+type SourceScanner struct {
+	index         *Index
+	worker        *Worker
+	absClient     *abs.Client
+	sessionStore  *store.SessionStore
+	playbackStore *store.PlaybackStore
+	tokenDecrypt  TokenDecrypter
+	resolve       JobResolver
+	interval      time.Duration
+	cancel        context.CancelFunc
+}
+
+// NewSourceScanner creates a new source scanner.
+func NewSourceScanner(
+	index *Index,
+	worker *Worker,
+	absClient *abs.Client,
+	sessionStore *store.SessionStore,
+	playbackStore *store.PlaybackStore,
+	tokenDecrypt TokenDecrypter,
+	resolve JobResolver,
+	interval time.Duration,
+) *SourceScanner {
+	if interval <= 0 {
+		interval = DefaultScanInterval
+	}
+	return &SourceScanner{
+		index:         index,
+		worker:        worker,
+		absClient:     absClient,
+		sessionStore:  sessionStore,
+		playbackStore: playbackStore,
+		tokenDecrypt:  tokenDecrypt,
+		resolve:       resolve,
+		interval:      interval,
+	}
+}
+
+// Start begins scanning periodically.
+func (s *SourceScanner) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Run(ctx)
+			}
+		}
+	}()
+
+	slog.Info("cache source scanner started", "interval", s.interval)
+}
+
+// Stop stops the scanner.
+func (s *SourceScanner) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// Run checks all ready cache entries once and queues the changed ones.
+// Returns the number of queued entries.
+func (s *SourceScanner) Run(ctx context.Context) int {
+	// Get a session with an active ABS token
+	sessions, err := s.sessionStore.ListActive()
+	if err != nil {
+		slog.Error("failed to get active sessions", "error", err)
+		return 0
+	}
+	if len(sessions) == 0 {
+		slog.Debug("no active sessions, skipping source scan")
+		return 0
+	}
+
+	token, err := s.tokenDecrypt.DecryptToken(sessions[0].ABSTokenEnc)
+	if err != nil {
+		slog.Error("failed to decrypt token", "error", err)
+		return 0
+	}
+	client := s.absClient.WithToken(token)
+
+	entries, err := s.index.store.ListByStatus(store.CacheStatusReady)
+	if err != nil {
+		slog.Error("failed to list cache entries for source scan", "error", err)
+		return 0
+	}
+
+	inUse, err := s.inUse()
+	if err != nil {
+		slog.Error("failed to list playback sessions", "error", err)
+		return 0
+	}
+
+	queued := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if inUse[entry.ItemID] {
+			continue
+		}
+
+		job, err := s.resolve(ctx, client, entry.ItemID)
+		if err != nil {
+			if !errors.Is(err, abs.ErrNotFound) {
+				slog.Warn("failed to get item for source scan", "item_id", entry.ItemID, "error", err)
+			}
+			continue
+		}
+
+		removed, err := s.index.InvalidateIfChanged(job)
+		if err != nil {
+			slog.Warn("failed to check source files", "item_id", entry.ItemID, "error", err)
+			continue
+		}
+		if !removed {
+			continue
+		}
+
+		if err := s.index.CreateEntryForJob(job); err != nil {
+			slog.Warn("failed to create cache entry", "item_id", entry.ItemID, "error", err)
+			continue
+		}
+		job.Priority = PriorityBulk
+		if !s.worker.Enqueue(job) {
+			continue
+		}
+		queued++
+	}
+
+	slog.Info("cache source scan complete", "checked", len(entries), "queued", queued)
+	return queued
+}
+
+// inUse returns the cache keys of all playback sessions.
+func (s *SourceScanner) inUse() (map[string]bool, error) {
+	sessions, err := s.playbackStore.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool, len(sessions))
+	for _, ps := range sessions {
+		inUse[ps.CacheKey()] = true
+	}
+	return inUse, nil
+}
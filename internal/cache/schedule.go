@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// scheduleInterval is how often the worker checks whether background jobs
+// may run.
+const scheduleInterval = time.Minute
+
+// BackgroundConfig limits background transcoding: all queued jobs below
+// PriorityInteractive, such as warmup and bulk jobs.
+type BackgroundConfig struct {
+	Allowed func(time.Time) bool // Reports whether background jobs may run at a time (nil = always)
+	MaxJobs int                  // Maximum concurrent background jobs (0 = pool size less one)
+	Nice    int                  // Niceness of background ffmpeg processes (0 = unchanged)
+	IOClass string               // IO priority of background ffmpeg processes: "idle", "best-effort" or "" (unchanged)
+}
+
+// processLimits applies the background limits to ffmpeg processes.
+type processLimits struct {
+	prefix []string // Command that runs ffmpeg with lower CPU and IO priority
+	gate   *pauseGate
+}
+
+// priorityPrefix returns the command prefix that runs a program with the
+// given niceness and IO class. Tools that aren't installed are skipped.
+func priorityPrefix(nice int, ioClass string) []string {
+	var prefix []string
+	if nice > 0 {
+		if path, err := exec.LookPath("nice"); err == nil {
+			prefix = append(prefix, path, "-n", strconv.Itoa(nice))
+		} else {
+			slog.Warn("nice not found, background transcoding runs with normal priority")
+		}
+	}
+
+	var classArgs []string
+	switch ioClass {
+	case "idle":
+		classArgs = []string{"-c", "3"}
+	case "best-effort":
+		classArgs = []string{"-c", "2", "-n", "7"}
+	}
+	if classArgs != nil {
+		if path, err := exec.LookPath("ionice"); err == nil {
+			prefix = append(append(prefix, path), classArgs...)
+		} else {
+			slog.Warn("ionice not found, background transcoding runs with normal IO priority")
+		}
+	}
+	return prefix
+}
+
+// pauseGate pauses ffmpeg processes while background jobs may not run.
+// Running processes are stopped with SIGSTOP and continued with SIGCONT, so
+// paused jobs resume where they stopped; new processes wait for the resume.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // Closed when the gate is resumed
+	procs   map[*os.Process]bool
+}
+
+func newPauseGate() *pauseGate {
+	return &pauseGate{procs: make(map[*os.Process]bool)}
+}
+
+// run starts cmd once the gate is open and waits for it to exit.
+func (g *pauseGate) run(ctx context.Context, cmd *exec.Cmd) error {
+	for {
+		g.mu.Lock()
+		if !g.paused {
+			break
+		}
+		resumed := g.resumed
+		g.mu.Unlock()
+
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Started with the lock held, so a pause can't miss the process
+	err := cmd.Start()
+	if err == nil {
+		g.procs[cmd.Process] = true
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Wait()
+
+	g.mu.Lock()
+	delete(g.procs, cmd.Process)
+	g.mu.Unlock()
+	return err
+}
+
+// pause stops all running processes. Returns false if already paused.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	for p := range g.procs {
+		if err := p.Signal(syscall.SIGSTOP); err != nil {
+			slog.Debug("failed to stop ffmpeg", "pid", p.Pid, "error", err)
+		}
+	}
+	return true
+}
+
+// resume continues all stopped processes. Returns false if not paused.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	for p := range g.procs {
+		if err := p.Signal(syscall.SIGCONT); err != nil {
+			slog.Debug("failed to continue ffmpeg", "pid", p.Pid, "error", err)
+		}
+	}
+	return true
+}
+
+// isPaused reports whether the gate is paused.
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// SetBackground sets the limits for background jobs. It must be called
+// before Start.
+func (w *Worker) SetBackground(cfg BackgroundConfig) {
+	w.background = cfg
+	w.limits = &processLimits{
+		prefix: priorityPrefix(cfg.Nice, cfg.IOClass),
+		gate:   w.limits.gate,
+	}
+}
+
+// BackgroundPaused reports whether background jobs are paused because they
+// may not run at this time.
+func (w *Worker) BackgroundPaused() bool {
+	return w.limits.gate.isPaused()
+}
+
+// maxBackgroundJobs returns how many background jobs may run at once. One
+// worker is kept free for interactive jobs, since paused background jobs
+// hold their worker until the allowed hours begin again.
+func (w *Worker) maxBackgroundJobs() int {
+	limit := w.workers - 1
+	if w.background.MaxJobs > 0 && w.background.MaxJobs < limit {
+		limit = w.background.MaxJobs
+	}
+	return max(limit, 1)
+}
+
+// minClaimPriority returns the lowest priority of a job a worker may start
+// now: background jobs only run in the allowed hours and up to
+// maxBackgroundJobs at once.
+func (w *Worker) minClaimPriority(now time.Time) Priority {
+	if w.limits.gate.isPaused() || (w.background.Allowed != nil && !w.background.Allowed(now)) {
+		return PriorityInteractive
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.backgroundJobs >= w.maxBackgroundJobs() {
+		return PriorityInteractive
+	}
+	return PriorityBulk
+}
+
+// yieldPaused stops paused background jobs and queues them again if they
+// hold every worker, which happens with a pool of one. Otherwise interactive
+// jobs would wait for the allowed hours.
+func (w *Worker) yieldPaused() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.backgroundJobs < w.workers {
+		return
+	}
+	for itemID, rj := range w.running {
+		if rj.background && !rj.yielded {
+			slog.Debug("stopping paused background job", "item_id", itemID)
+			rj.yielded = true
+			rj.cancel()
+		}
+	}
+}
+
+// watchSchedule pauses background jobs when the allowed hours end and
+// resumes them when they begin again.
+func (w *Worker) watchSchedule(ctx context.Context) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		w.applySchedule(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applySchedule pauses or resumes background jobs for the given time.
+func (w *Worker) applySchedule(now time.Time) {
+	if w.background.Allowed(now) {
+		if w.limits.gate.resume() {
+			slog.Info("background transcoding resumed")
+			for i := 0; i < w.workers; i++ {
+				w.notify()
+			}
+		}
+		return
+	}
+
+	if w.limits.gate.pause() {
+		slog.Info("background transcoding paused until the allowed hours")
+		w.yieldPaused()
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"sort"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+)
+
+// minSegmentFraction is the shortest a segment cut at a chapter boundary may
+// be, as a fraction of SegmentDuration. Without a chapter start between this
+// and SegmentDuration the segment is cut at SegmentDuration.
+const minSegmentFraction = 0.5
+
+// planSegments returns the start offsets of the segments to split totalSec
+// seconds of output into. No segment is longer than SegmentDuration; each
+// one ends at the last chapter start before that limit, so the handover
+// between segments falls between chapters instead of mid-sentence.
+// chapterStarts are on the output's timeline. Output that fits into one
+// segment returns a single start.
+func planSegments(totalSec int, chapterStarts []int) []int {
+	starts := []int{0}
+	minLength := int(SegmentDuration * minSegmentFraction)
+
+	for start := 0; totalSec-start > SegmentDuration; {
+		limit := start + SegmentDuration
+		end := limit
+		for _, chapter := range chapterStarts {
+			if chapter > limit {
+				break
+			}
+			if chapter >= start+minLength {
+				end = chapter
+			}
+		}
+		starts = append(starts, end)
+		start = end
+	}
+	return starts
+}
+
+// chapterStarts returns the start offsets of chapters on the output's
+// timeline of the profile, in ascending order.
+func (p TranscodeProfile) chapterStarts(chapters []abs.Chapter) []int {
+	starts := make([]int, 0, len(chapters))
+	for _, chapter := range chapters {
+		if chapter.Start > 0 {
+			starts = append(starts, p.OutputTime(int(chapter.Start)))
+		}
+	}
+	sort.Ints(starts)
+	return starts
+}
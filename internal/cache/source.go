@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// RemoteFile identifies an audio file that can be fetched through the ABS file API.
+type RemoteFile struct {
+	ItemID string
+	Ino    string
+}
+
+// resolveSources returns the files to transcode for a job and where they come from.
+// The mapped local paths are used if all of them exist. Otherwise, e.g. when the
+// bridge runs on a different host than ABS, the audio files are downloaded through
+// the ABS API into the item's cache directory. The returned cleanup function
+// removes downloaded files and must be called once transcoding is done.
+func (w *Worker) resolveSources(ctx context.Context, job Job, sourcePaths []string) ([]string, string, func(), error) {
+	missing := firstMissing(sourcePaths)
+	if missing == "" {
+		return sourcePaths, store.SourceTypeLocal, func() {}, nil
+	}
+
+	if job.ABSClient == nil || len(job.RemoteFiles) == 0 {
+		return nil, "", nil, fmt.Errorf("source file not found: %s", missing)
+	}
+
+	slog.Info("source file not found locally, downloading from ABS",
+		"item_id", job.ItemID,
+		"path", missing,
+		"file_count", len(job.RemoteFiles),
+	)
+
+	// Downloads use the .tmp suffix so CleanupTempFiles removes leftovers after a crash
+	dir := w.index.GetCacheDir(job.ItemID)
+	paths := make([]string, 0, len(job.RemoteFiles))
+	cleanup := func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}
+
+	for i, file := range job.RemoteFiles {
+		path := filepath.Join(dir, fmt.Sprintf("source_%03d.tmp", i))
+		paths = append(paths, path)
+
+		if err := w.download(ctx, job, file, path); err != nil {
+			cleanup()
+			return nil, "", nil, err
+		}
+	}
+
+	return paths, store.SourceTypeABS, cleanup, nil
+}
+
+// download copies a single remote audio file to path.
+func (w *Worker) download(ctx context.Context, job Job, file RemoteFile, path string) error {
+	body, size, err := job.ABSClient.GetLibraryFile(ctx, file.ItemID, file.Ino)
+	if err != nil {
+		return fmt.Errorf("failed to download source file %s: %w", file.Ino, err)
+	}
+	defer body.Close()
+
+	if size > 0 {
+		if err := w.transcoder.CheckDiskSpace(path, size); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+
+	written, err := io.Copy(out, body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download source file %s: %w", file.Ino, err)
+	}
+
+	slog.Debug("downloaded source file from ABS", "item_id", job.ItemID, "ino", file.Ino, "size", written)
+	return nil
+}
+
+// firstMissing returns the first path that does not exist, or "" if all exist.
+func firstMissing(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return path
+		}
+	}
+	return ""
+}
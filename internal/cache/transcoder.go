@@ -1,35 +1,100 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+
+	"audiobookshelf-sonos-bridge/internal/store"
 )
 
 // TranscodeProfile defines the output format settings.
 type TranscodeProfile struct {
+	Name         string // Cache variant name (empty for the default profile)
 	AudioCodec   string
 	Bitrate      string
 	SampleRate   int
 	Channels     int
 	OutputFormat string
+	Segmented    bool    // Split output longer than SegmentDuration into segments
+	Loudnorm     bool    // EBU R128 loudness normalization (two-pass loudnorm)
+	Compress     bool    // Dynamic range compression for night listening
+	Speed        float64 // Playback speed baked into the output (0 or 1 = normal)
+	KeepLossless bool    // Transcode lossless sources to FLAC (see ForSource)
+}
+
+// RequiresTranscode reports whether the profile changes the audio itself.
+// Filters and downmixing need re-encoding, so compatible sources can't be remuxed.
+func (p TranscodeProfile) RequiresTranscode() bool {
+	return p.Loudnorm || p.Compress || p.Channels == 1 || p.Tempo() != 1
+}
+
+// Tempo returns the speed factor of the output (1 = normal speed).
+func (p TranscodeProfile) Tempo() float64 {
+	if p.Speed <= 0 {
+		return 1
+	}
+	return p.Speed
+}
+
+// OutputTime converts a time in the source to the output's timeline.
+func (p TranscodeProfile) OutputTime(sourceSec int) int {
+	return int(math.Round(float64(sourceSec) / p.Tempo()))
+}
+
+// SourceTime converts a time in the output to the source's timeline.
+func (p TranscodeProfile) SourceTime(outputSec int) int {
+	return int(math.Round(float64(outputSec) * p.Tempo()))
+}
+
+// Version identifies the profile's output settings. It is stored as the
+// ProfileVersion of cache entries, so outputs of a changed profile are
+// detected and can be deleted with DeleteByProfile. The default profile
+// keeps the plain cache version, so caches from before per-profile versions
+// stay valid; bump CurrentCacheVersion when changing it.
+func (p TranscodeProfile) Version() string {
+	if p.Name == ProfileDefault {
+		return CurrentCacheVersion
+	}
+
+	settings := fmt.Sprintf("%s|%s|%s|%d|%d|%s|%t|%t",
+		p.Name, p.AudioCodec, p.Bitrate, p.SampleRate, p.Channels, p.OutputFormat, p.Segmented, p.KeepLossless)
+	if p.Compress {
+		settings += "|" + compressorFilter
+	}
+	if p.Loudnorm {
+		settings += "|" + loudnormTargets
+	}
+	if p.Tempo() != 1 {
+		settings += "|" + p.tempoFilter()
+	}
+
+	sum := sha256.Sum256([]byte(settings))
+	return CurrentCacheVersion + "-" + hex.EncodeToString(sum[:4])
 }
 
 // DefaultProfile is the Sonos-compatible MP3 profile.
+// It works on every Sonos player, including the ZP90/Connect.
 var DefaultProfile = TranscodeProfile{
 	AudioCodec:   "libmp3lame",
 	Bitrate:      "128k",
 	SampleRate:   44100,
 	Channels:     2,
 	OutputFormat: "mp3",
+	Segmented:    true,
 }
 
 // Common errors
@@ -39,6 +104,8 @@ var (
 	ErrFFprobeNotFound       = errors.New("ffprobe not found")
 	ErrInputFileNotFound     = errors.New("input file not found")
 	ErrInvalidInputFile      = errors.New("invalid input file")
+	ErrUnsupportedCodec      = errors.New("unsupported codec")
+	ErrConcatMismatch        = errors.New("input files don't match")
 )
 
 // TranscodeError provides detailed error information for transcoding failures.
@@ -50,27 +117,62 @@ type TranscodeError struct {
 
 func (e *TranscodeError) Error() string {
 	if e.ExitCode != 0 {
-		return fmt.Sprintf("ffmpeg exited with code %d: %s", e.ExitCode, e.Output)
+		return fmt.Sprintf("ffmpeg exited with code %d: %v: %s", e.ExitCode, e.Err, lastLine(e.Output))
 	}
 	return e.Err.Error()
 }
 
+// lastLine returns the last non-empty line of ffmpeg's output, which usually
+// names the error. The full output is kept in the attempt's log.
+func lastLine(output string) string {
+	lines := strings.FieldsFunc(output, func(r rune) bool { return r == '\n' || r == '\r' })
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// ffmpegErrors maps messages in ffmpeg's output to the errors they indicate.
+// The first match wins, so causes come before the errors they lead to.
+var ffmpegErrors = []struct {
+	message string
+	err     error
+}{
+	{"no space left on device", ErrInsufficientDiskSpace},
+	{"no such file", ErrInputFileNotFound},
+	{"not found for input stream", ErrUnsupportedCodec},
+	{"unknown decoder", ErrUnsupportedCodec},
+	{"unsupported codec", ErrUnsupportedCodec},
+	{"codec not currently supported in container", ErrUnsupportedCodec},
+	{"could not find tag for codec", ErrUnsupportedCodec},
+	{"non monotonically increasing dts", ErrConcatMismatch},
+	{"error reinitializing filters", ErrConcatMismatch},
+	{"invalid data", ErrInvalidInputFile},
+	{"invalid argument", ErrInvalidInputFile},
+	{"error while decoding", ErrInvalidInputFile},
+	{"header missing", ErrInvalidInputFile},
+}
+
 func (e *TranscodeError) Unwrap() error {
 	return e.Err
 }
 
-// ParseFFmpegExitCode returns a human-readable error for common ffmpeg exit codes.
+// ParseFFmpegExitCode returns a human-readable error for common ffmpeg exit
+// codes. Known causes in the output, such as a missing file or a corrupt
+// stream, are returned as the matching error, whatever the exit code, as
+// ffmpeg versions exit with different codes for them.
 func ParseFFmpegExitCode(exitCode int, output string) error {
+	outputLower := strings.ToLower(output)
+	for _, e := range ffmpegErrors {
+		if strings.Contains(outputLower, e.message) {
+			return e.err
+		}
+	}
+
 	switch exitCode {
 	case 1:
-		// Check output for specific errors
-		outputLower := strings.ToLower(output)
-		if strings.Contains(outputLower, "no such file") {
-			return ErrInputFileNotFound
-		}
-		if strings.Contains(outputLower, "invalid data") || strings.Contains(outputLower, "invalid argument") {
-			return ErrInvalidInputFile
-		}
 		return fmt.Errorf("ffmpeg general error")
 	case 2:
 		return fmt.Errorf("ffmpeg output error")
@@ -85,7 +187,14 @@ func ParseFFmpegExitCode(exitCode int, output string) error {
 
 // Transcoder handles audio transcoding using ffmpeg.
 type Transcoder struct {
-	profile TranscodeProfile
+	profile  TranscodeProfile
+	progress *jobProgress   // Receives the progress of ffmpeg runs, may be nil
+	limits   *processLimits // Limits of background jobs, nil for interactive ones
+	log      *transcodeLog  // Receives the commands and output of ffmpeg runs, may be nil
+	tolerant bool           // Decode damaged input instead of failing
+
+	mu       sync.Mutex
+	loudness map[string]*loudnessStats // Loudnorm measurements by input
 }
 
 // NewTranscoder creates a new transcoder with the default profile.
@@ -95,10 +204,176 @@ func NewTranscoder() *Transcoder {
 	}
 }
 
+// WithProfile returns a transcoder that uses the given profile.
+func (t *Transcoder) WithProfile(profile TranscodeProfile) *Transcoder {
+	return &Transcoder{
+		profile: profile,
+	}
+}
+
+// Profile returns the transcoder's profile.
+func (t *Transcoder) Profile() TranscodeProfile {
+	return t.profile
+}
+
+// clone returns a copy of the transcoder without its loudness measurements.
+func (t *Transcoder) clone() *Transcoder {
+	return &Transcoder{
+		profile:  t.profile,
+		progress: t.progress,
+		limits:   t.limits,
+		log:      t.log,
+		tolerant: t.tolerant,
+	}
+}
+
+// withProgress returns a transcoder with the same profile that reports the
+// progress of its encodes to p.
+func (t *Transcoder) withProgress(p *jobProgress) *Transcoder {
+	c := t.clone()
+	c.progress = p
+	return c
+}
+
+// withLimits returns a transcoder with the same profile whose ffmpeg
+// processes run with the given background limits.
+func (t *Transcoder) withLimits(limits *processLimits) *Transcoder {
+	c := t.clone()
+	c.limits = limits
+	return c
+}
+
+// withLog returns a transcoder with the same profile that writes the
+// commands and output of its ffmpeg runs to l.
+func (t *Transcoder) withLog(l *transcodeLog) *Transcoder {
+	c := t.clone()
+	c.log = l
+	return c
+}
+
+// withTolerantDecoding returns a transcoder with the same profile that skips
+// damaged parts of its input instead of failing.
+func (t *Transcoder) withTolerantDecoding() *Transcoder {
+	c := t.clone()
+	c.tolerant = true
+	return c
+}
+
+// forSources returns a transcoder with the profile for the given sources
+// (see ForSource). Sources are only treated as lossless if all of them are.
+func (t *Transcoder) forSources(ctx context.Context, sourcePaths []string) *Transcoder {
+	if !t.profile.KeepLossless || len(sourcePaths) == 0 {
+		return t
+	}
+
+	detector := NewFormatDetector()
+	var format *AudioFormat
+	for _, path := range sourcePaths {
+		f, err := detector.Detect(ctx, path)
+		if err != nil {
+			slog.Debug("format detection failed, keeping profile output format", "path", path, "error", err)
+			return t
+		}
+		if !f.Lossless() {
+			return t
+		}
+		if format == nil {
+			format = f
+		}
+	}
+
+	c := t.clone()
+	c.profile = t.profile.ForSource(format)
+	return c
+}
+
+// encodeArgs returns the output options that encode audio with the profile.
+func (t *Transcoder) encodeArgs() []string {
+	args := []string{
+		"-c:a", t.profile.AudioCodec,
+		"-ar", strconv.Itoa(t.profile.SampleRate),
+		"-ac", strconv.Itoa(t.profile.Channels),
+	}
+	// Lossless codecs have no bitrate
+	if t.profile.Bitrate != "" {
+		args = append(args, "-b:a", t.profile.Bitrate)
+	}
+	return args
+}
+
+// muxerArgs returns the options that write a file in the given format. M4A
+// output is written with the ipod muxer and the moov atom first, like remuxes.
+func muxerArgs(outputFormat string) []string {
+	if outputFormat == "mp4" {
+		return []string{"-movflags", "+faststart", "-brand", "M4A", "-f", "ipod"}
+	}
+	return []string{"-f", outputFormat}
+}
+
+// decodeArgs returns the input options for transcoding. Tolerant decoding
+// ignores decoding errors and drops corrupt packets.
+func (t *Transcoder) decodeArgs() []string {
+	if !t.tolerant {
+		return nil
+	}
+	return []string{"-err_detect", "ignore_err", "-fflags", "+discardcorrupt"}
+}
+
+// command returns an ffmpeg command with the given arguments. Background
+// jobs run ffmpeg with lower CPU and IO priority.
+func (t *Transcoder) command(ctx context.Context, args []string) *exec.Cmd {
+	if t.limits == nil || len(t.limits.prefix) == 0 {
+		return exec.CommandContext(ctx, "ffmpeg", args...)
+	}
+
+	prefix := t.limits.prefix
+	cmdArgs := make([]string, 0, len(prefix)+len(args))
+	cmdArgs = append(cmdArgs, prefix[1:]...)
+	cmdArgs = append(cmdArgs, "ffmpeg")
+	cmdArgs = append(cmdArgs, args...)
+	return exec.CommandContext(ctx, prefix[0], cmdArgs...)
+}
+
+// run runs an ffmpeg command. Commands of background jobs are paused while
+// background transcoding may not run.
+func (t *Transcoder) run(ctx context.Context, cmd *exec.Cmd) error {
+	if t.limits == nil {
+		return cmd.Run()
+	}
+	return t.limits.gate.run(ctx, cmd)
+}
+
+// ffmpegCommand returns an ffmpeg command with the given arguments and the
+// buffer that collects its output. With a progress receiver, ffmpeg writes
+// machine-readable progress to stdout, and only stderr is collected.
+func (t *Transcoder) ffmpegCommand(ctx context.Context, args []string) (*exec.Cmd, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+	if t.progress != nil {
+		args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	}
+
+	cmd := t.command(ctx, args)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if t.progress != nil {
+		cmd.Stdout = &progressWriter{report: t.progress.update}
+	}
+	return cmd, output
+}
+
+// runFFmpeg runs ffmpeg with the given arguments and returns its output.
+func (t *Transcoder) runFFmpeg(ctx context.Context, args []string) ([]byte, error) {
+	cmd, output := t.ffmpegCommand(ctx, args)
+	err := t.run(ctx, cmd)
+	t.log.run(args, output.Bytes(), err)
+	return output.Bytes(), err
+}
+
 // TranscodeResult contains the result of a transcoding operation.
 type TranscodeResult struct {
 	DurationSec int
 	OutputSize  int64
+	Format      string // Output format; differs from the planned one if a remux fell back to transcoding
 }
 
 // TranscodeMultiple concatenates multiple audio files and transcodes to the target format.
@@ -161,25 +436,26 @@ func (t *Transcoder) TranscodeMultiple(ctx context.Context, inputPaths []string,
 
 	// Build ffmpeg command using concat demuxer
 	// -map 0:a selects only audio streams, excluding data streams (like chapter markers)
-	args := []string{
+	args := append(t.decodeArgs(),
 		"-f", "concat",
 		"-safe", "0",
 		"-i", concatListPath,
 		"-map", "0:a",          // Select only audio streams (excludes data/subtitle streams)
 		"-map_chapters", "-1",  // Remove chapter metadata (prevents bin_data stream that Sonos can't handle)
 		"-vn",                  // No video
-		"-ar", strconv.Itoa(t.profile.SampleRate),
-		"-ac", strconv.Itoa(t.profile.Channels),
-		"-b:a", t.profile.Bitrate,
-		"-f", t.profile.OutputFormat,
-		"-y", // Overwrite output
-		tempPath,
-	}
+	)
+	args = append(args, t.encodeArgs()...)
+	args = append(args, muxerArgs(t.profile.OutputFormat)...)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	filterArgs, err := t.filterArgs(ctx, inputPaths, 0)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, filterArgs...)
+	args = append(args, "-y", tempPath) // Overwrite output
 
 	// Capture stderr for error messages
-	output, err := cmd.CombinedOutput()
+	output, err := t.runFFmpeg(ctx, args)
 	if err != nil {
 		os.Remove(tempPath)
 
@@ -225,6 +501,7 @@ func (t *Transcoder) TranscodeMultiple(ctx context.Context, inputPaths []string,
 	return &TranscodeResult{
 		DurationSec: duration,
 		OutputSize:  info.Size(),
+		Format:      t.profile.OutputFormat,
 	}, nil
 }
 
@@ -261,23 +538,24 @@ func (t *Transcoder) Transcode(ctx context.Context, inputPath, outputPath string
 
 	// Build ffmpeg command
 	// -map 0:a selects only audio streams, excluding data streams (like chapter markers)
-	args := []string{
+	args := append(t.decodeArgs(),
 		"-i", inputPath,
 		"-map", "0:a",          // Select only audio streams (excludes data/subtitle streams)
 		"-map_chapters", "-1",  // Remove chapter metadata (prevents bin_data stream that Sonos can't handle)
 		"-vn",                  // No video
-		"-ar", strconv.Itoa(t.profile.SampleRate),
-		"-ac", strconv.Itoa(t.profile.Channels),
-		"-b:a", t.profile.Bitrate,
-		"-f", t.profile.OutputFormat,
-		"-y", // Overwrite output
-		tempPath,
-	}
+	)
+	args = append(args, t.encodeArgs()...)
+	args = append(args, muxerArgs(t.profile.OutputFormat)...)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	filterArgs, err := t.filterArgs(ctx, []string{inputPath}, 0)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, filterArgs...)
+	args = append(args, "-y", tempPath) // Overwrite output
 
 	// Capture stderr for error messages
-	output, err := cmd.CombinedOutput()
+	output, err := t.runFFmpeg(ctx, args)
 	if err != nil {
 		// Clean up temp file on error
 		os.Remove(tempPath)
@@ -328,15 +606,17 @@ func (t *Transcoder) Transcode(ctx context.Context, inputPath, outputPath string
 	return &TranscodeResult{
 		DurationSec: duration,
 		OutputSize:  info.Size(),
+		Format:      t.profile.OutputFormat,
 	}, nil
 }
 
-// truncateOutput truncates a string to maxLen characters.
+// truncateOutput truncates ffmpeg output to its last maxLen characters,
+// where ffmpeg reports errors.
 func truncateOutput(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
 	}
-	return s[:maxLen] + "...[truncated]"
+	return "[truncated]..." + s[len(s)-maxLen:]
 }
 
 // GetDuration returns the duration of an audio file in seconds.
@@ -473,10 +753,8 @@ func (t *Transcoder) Remux(ctx context.Context, inputPath, outputPath string, ou
 		tempPath,
 	)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-
 	// Capture stderr for error messages
-	output, err := cmd.CombinedOutput()
+	output, err := t.runFFmpeg(ctx, args)
 	if err != nil {
 		// Clean up temp file on error
 		os.Remove(tempPath)
@@ -527,6 +805,7 @@ func (t *Transcoder) Remux(ctx context.Context, inputPath, outputPath string, ou
 	return &TranscodeResult{
 		DurationSec: duration,
 		OutputSize:  info.Size(),
+		Format:      outputFormat,
 	}, nil
 }
 
@@ -617,10 +896,8 @@ func (t *Transcoder) RemuxMultiple(ctx context.Context, inputPaths []string, out
 		tempPath,
 	)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-
 	// Capture stderr for error messages
-	output, err := cmd.CombinedOutput()
+	output, err := t.runFFmpeg(ctx, args)
 	if err != nil {
 		os.Remove(tempPath)
 
@@ -666,18 +943,26 @@ func (t *Transcoder) RemuxMultiple(ctx context.Context, inputPaths []string, out
 	return &TranscodeResult{
 		DurationSec: duration,
 		OutputSize:  info.Size(),
+		Format:      outputFormat,
 	}, nil
 }
 
 // SmartTranscode intelligently chooses between copy, remux, or transcode based on format.
+// Failed encodes fall back to transcoding, and to error-tolerant decoding for
+// corrupt input.
 func (t *Transcoder) SmartTranscode(ctx context.Context, inputPath, outputPath string) (*TranscodeResult, error) {
+	inputPaths := []string{inputPath}
+	if t.profile.RequiresTranscode() {
+		return t.transcodeWithFallback(ctx, inputPaths, outputPath)
+	}
+
 	// Detect input format
 	detector := NewFormatDetector()
 	format, err := detector.Detect(ctx, inputPath)
 	if err != nil {
 		// If format detection fails, fall back to regular transcode
 		slog.Debug("format detection failed, using transcode fallback", "path", inputPath, "error", err)
-		return t.Transcode(ctx, inputPath, outputPath)
+		return t.transcodeWithFallback(ctx, inputPaths, outputPath)
 	}
 
 	slog.Debug("audio format detected", "format", format.String())
@@ -688,14 +973,14 @@ func (t *Transcoder) SmartTranscode(ctx context.Context, inputPath, outputPath s
 
 	switch compatibility {
 	case Compatible:
-		// File is already compatible, just copy it
-		// Note: We still need to ensure it's in the right location
-		// For now, we'll use remux with the same format to ensure consistency
+		// File is already compatible; with passthrough enabled it is served
+		// from the source (see servePassthrough) unless it has to be cached,
+		// e.g. when it was downloaded. Remux with the same format for a clean copy
 		slog.Debug("using fast-path: remux (already compatible)",
 			"container", format.Container,
 			"codec", format.AudioCodec)
 		targetFormat := checker.GetTargetFormat(format)
-		return t.Remux(ctx, inputPath, outputPath, targetFormat)
+		return t.remuxWithFallback(ctx, inputPaths, outputPath, targetFormat)
 
 	case NeedsRemux:
 		// Codec is compatible but container needs changing
@@ -704,7 +989,7 @@ func (t *Transcoder) SmartTranscode(ctx context.Context, inputPath, outputPath s
 			"codec", format.AudioCodec,
 			"target_format", checker.GetTargetFormat(format))
 		targetFormat := checker.GetTargetFormat(format)
-		return t.Remux(ctx, inputPath, outputPath, targetFormat)
+		return t.remuxWithFallback(ctx, inputPaths, outputPath, targetFormat)
 
 	case NeedsTranscode:
 		// Full transcoding required
@@ -712,33 +997,35 @@ func (t *Transcoder) SmartTranscode(ctx context.Context, inputPath, outputPath s
 			"container", format.Container,
 			"codec", format.AudioCodec,
 			"reason", "codec not compatible")
-		return t.Transcode(ctx, inputPath, outputPath)
+		return t.transcodeWithFallback(ctx, inputPaths, outputPath)
 
 	default:
 		// Unknown compatibility, fall back to transcode
 		slog.Debug("using slow-path: transcode (unknown compatibility)",
 			"container", format.Container,
 			"codec", format.AudioCodec)
-		return t.Transcode(ctx, inputPath, outputPath)
+		return t.transcodeWithFallback(ctx, inputPaths, outputPath)
 	}
 }
 
-// SegmentDuration is the default segment duration for ZP90-compatible streaming.
-// 2 hours = 7200 seconds, producing ~55MB segments at 63kbps AAC.
+// SegmentDuration is the maximum segment duration for ZP90-compatible streaming.
+// 2 hours = 7200 seconds, producing ~55MB segments at 63kbps AAC. Segments
+// are cut at the last chapter boundary before this limit.
 const SegmentDuration = 7200
 
 // SegmentedResult contains the result of a segmented transcoding operation.
 type SegmentedResult struct {
 	DurationSec        int   // Total duration of the audio
 	SegmentCount       int   // Number of segments created
-	SegmentDurationSec int   // Duration of each segment (last may be shorter)
+	SegmentStarts      []int // Start offset of each segment
 	TotalSize          int64 // Total size of all segments
 }
 
-// RemuxSegmented splits a single input file into 2-hour segments without re-encoding.
+// RemuxSegmented splits a single input file into segments of up to 2 hours without re-encoding.
 // This is required for ZP90/Sonos Connect devices which have a ~128MB RAM limit.
+// Segments are cut at the chapter starts closest to the limit (see planSegments).
 // Each segment is named segment_000.ext, segment_001.ext, etc.
-func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir string, outputFormat string) (*SegmentedResult, error) {
+func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir string, outputFormat string, chapterStarts []int) (*SegmentedResult, error) {
 	// Verify ffmpeg is available
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return nil, ErrFFmpegNotFound
@@ -770,17 +1057,15 @@ func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir st
 		return nil, fmt.Errorf("failed to get input duration: %w", err)
 	}
 
-	// Calculate number of segments
-	segmentCount := (totalDuration + SegmentDuration - 1) / SegmentDuration // ceiling division
-	if segmentCount < 1 {
-		segmentCount = 1
-	}
+	// Plan segment boundaries
+	segmentStarts := planSegments(totalDuration, chapterStarts)
+	segmentCount := len(segmentStarts)
 
 	slog.Debug("creating segmented remux",
 		"input_path", inputPath,
 		"total_duration", totalDuration,
 		"segment_count", segmentCount,
-		"segment_duration", SegmentDuration)
+		"segment_starts", segmentStarts)
 
 	// Determine file extension
 	ext := ".m4a"
@@ -795,13 +1080,13 @@ func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir st
 
 	// Create each segment
 	for i := 0; i < segmentCount; i++ {
-		startTime := i * SegmentDuration
+		startTime := segmentStarts[i]
 		segmentPath := filepath.Join(outputDir, fmt.Sprintf("segment_%03d%s", i, ext))
 		tempPath := segmentPath + ".tmp"
 
-		// Calculate actual segment duration (last segment may be shorter)
-		segmentDuration := SegmentDuration
-		if startTime+segmentDuration > totalDuration {
+		// The last segment runs to the end of the input
+		segmentDuration := store.SegmentLength(i, segmentStarts)
+		if segmentDuration == 0 {
 			segmentDuration = totalDuration - startTime
 		}
 
@@ -833,8 +1118,8 @@ func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir st
 
 		args = append(args, "-y", tempPath)
 
-		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-		output, err := cmd.CombinedOutput()
+		t.progress.startSegment(i, segmentCount, segmentDuration)
+		output, err := t.runFFmpeg(ctx, args)
 		if err != nil {
 			os.Remove(tempPath)
 
@@ -885,14 +1170,14 @@ func (t *Transcoder) RemuxSegmented(ctx context.Context, inputPath, outputDir st
 	return &SegmentedResult{
 		DurationSec:        totalDuration,
 		SegmentCount:       segmentCount,
-		SegmentDurationSec: SegmentDuration,
+		SegmentStarts:      segmentStarts,
 		TotalSize:          totalSize,
 	}, nil
 }
 
-// TranscodeSegmented splits a single input file into 2-hour segments with full transcoding.
-// Used when the audio codec is not Sonos-compatible.
-func (t *Transcoder) TranscodeSegmented(ctx context.Context, inputPath, outputDir string) (*SegmentedResult, error) {
+// TranscodeSegmented splits a single input file into segments of up to 2 hours with full transcoding.
+// Used when the audio codec is not Sonos-compatible. chapterStarts are on the output's timeline.
+func (t *Transcoder) TranscodeSegmented(ctx context.Context, inputPath, outputDir string, chapterStarts []int) (*SegmentedResult, error) {
 	// Verify ffmpeg is available
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return nil, ErrFFmpegNotFound
@@ -924,29 +1209,37 @@ func (t *Transcoder) TranscodeSegmented(ctx context.Context, inputPath, outputDi
 		return nil, fmt.Errorf("failed to get input duration: %w", err)
 	}
 
-	// Calculate number of segments
-	segmentCount := (totalDuration + SegmentDuration - 1) / SegmentDuration
-	if segmentCount < 1 {
-		segmentCount = 1
-	}
+	// Segments are cut on the output's timeline, which is shorter for
+	// speed variants
+	totalDuration = t.profile.OutputTime(totalDuration)
+
+	// Plan segment boundaries
+	segmentStarts := planSegments(totalDuration, chapterStarts)
+	segmentCount := len(segmentStarts)
 
 	slog.Debug("creating segmented transcode",
 		"input_path", inputPath,
 		"total_duration", totalDuration,
 		"segment_count", segmentCount,
-		"segment_duration", SegmentDuration)
+		"segment_starts", segmentStarts)
+
+	// Filters are measured over the whole input, so all segments match
+	filterArgs, err := t.filterArgs(ctx, []string{inputPath}, 0)
+	if err != nil {
+		return nil, err
+	}
 
 	var totalSize int64
 
 	// Create each segment
 	for i := 0; i < segmentCount; i++ {
-		startTime := i * SegmentDuration
+		startTime := segmentStarts[i]
 		segmentPath := filepath.Join(outputDir, fmt.Sprintf("segment_%03d.mp3", i))
 		tempPath := segmentPath + ".tmp"
 
-		// Calculate actual segment duration (last segment may be shorter)
-		segmentDuration := SegmentDuration
-		if startTime+segmentDuration > totalDuration {
+		// The last segment runs to the end of the input
+		segmentDuration := store.SegmentLength(i, segmentStarts)
+		if segmentDuration == 0 {
 			segmentDuration = totalDuration - startTime
 		}
 
@@ -957,22 +1250,21 @@ func (t *Transcoder) TranscodeSegmented(ctx context.Context, inputPath, outputDi
 			"output_path", segmentPath)
 
 		// Build ffmpeg command for this segment (full transcode)
-		args := []string{
-			"-ss", strconv.Itoa(startTime),
+		args := append(t.decodeArgs(),
+			"-ss", strconv.Itoa(t.profile.SourceTime(startTime)),
 			"-i", inputPath,
 			"-t", strconv.Itoa(segmentDuration),
 			"-map", "0:a",
 			"-map_chapters", "-1",
 			"-vn",
-			"-ar", strconv.Itoa(t.profile.SampleRate),
-			"-ac", strconv.Itoa(t.profile.Channels),
-			"-b:a", t.profile.Bitrate,
-			"-f", t.profile.OutputFormat,
-			"-y", tempPath,
-		}
+		)
+		args = append(args, t.encodeArgs()...)
+		args = append(args, "-f", t.profile.OutputFormat)
+		args = append(args, filterArgs...)
+		args = append(args, "-y", tempPath)
 
-		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-		output, err := cmd.CombinedOutput()
+		t.progress.startSegment(i, segmentCount, segmentDuration)
+		output, err := t.runFFmpeg(ctx, args)
 		if err != nil {
 			os.Remove(tempPath)
 
@@ -1023,21 +1315,27 @@ func (t *Transcoder) TranscodeSegmented(ctx context.Context, inputPath, outputDi
 	return &SegmentedResult{
 		DurationSec:        totalDuration,
 		SegmentCount:       segmentCount,
-		SegmentDurationSec: SegmentDuration,
+		SegmentStarts:      segmentStarts,
 		TotalSize:          totalSize,
 	}, nil
 }
 
 // SmartTranscodeSegmented intelligently chooses between remux or transcode for segmented output.
-// Returns the output format used ("mp4" for M4A, "mp3" for MP3).
-func (t *Transcoder) SmartTranscodeSegmented(ctx context.Context, inputPath, outputDir string) (*SegmentedResult, string, error) {
+// Returns the output format used ("mp4" for M4A, "mp3" for MP3). Like
+// SmartTranscode, failed encodes fall back to transcoding.
+func (t *Transcoder) SmartTranscodeSegmented(ctx context.Context, inputPath, outputDir string, chapterStarts []int) (*SegmentedResult, string, error) {
+	if t.profile.RequiresTranscode() {
+		result, err := t.transcodeSegmentedWithFallback(ctx, inputPath, outputDir, chapterStarts)
+		return result, t.profile.OutputFormat, err
+	}
+
 	// Detect input format
 	detector := NewFormatDetector()
 	format, err := detector.Detect(ctx, inputPath)
 	if err != nil {
 		// If format detection fails, fall back to transcode
 		slog.Debug("format detection failed, using transcode fallback", "path", inputPath, "error", err)
-		result, err := t.TranscodeSegmented(ctx, inputPath, outputDir)
+		result, err := t.transcodeSegmentedWithFallback(ctx, inputPath, outputDir, chapterStarts)
 		return result, "mp3", err
 	}
 
@@ -1054,8 +1352,7 @@ func (t *Transcoder) SmartTranscodeSegmented(ctx context.Context, inputPath, out
 			"container", format.Container,
 			"codec", format.AudioCodec)
 		targetFormat := checker.GetTargetFormat(format)
-		result, err := t.RemuxSegmented(ctx, inputPath, outputDir, targetFormat)
-		return result, targetFormat, err
+		return t.remuxSegmentedWithFallback(ctx, inputPath, outputDir, targetFormat, chapterStarts)
 
 	case NeedsTranscode:
 		// Full transcoding required
@@ -1063,7 +1360,7 @@ func (t *Transcoder) SmartTranscodeSegmented(ctx context.Context, inputPath, out
 			"container", format.Container,
 			"codec", format.AudioCodec,
 			"reason", "codec not compatible")
-		result, err := t.TranscodeSegmented(ctx, inputPath, outputDir)
+		result, err := t.transcodeSegmentedWithFallback(ctx, inputPath, outputDir, chapterStarts)
 		return result, "mp3", err
 
 	default:
@@ -1071,12 +1368,15 @@ func (t *Transcoder) SmartTranscodeSegmented(ctx context.Context, inputPath, out
 		slog.Debug("using slow-path: segmented transcode (unknown compatibility)",
 			"container", format.Container,
 			"codec", format.AudioCodec)
-		result, err := t.TranscodeSegmented(ctx, inputPath, outputDir)
+		result, err := t.transcodeSegmentedWithFallback(ctx, inputPath, outputDir, chapterStarts)
 		return result, "mp3", err
 	}
 }
 
-// SmartTranscodeMultiple intelligently handles multiple files.
+// SmartTranscodeMultiple intelligently handles multiple files. Failed
+// encodes fall back to transcoding, to error-tolerant decoding for corrupt
+// input, and to transcoding each file on its own when the files can't be
+// concatenated.
 func (t *Transcoder) SmartTranscodeMultiple(ctx context.Context, inputPaths []string, outputPath string) (*TranscodeResult, error) {
 	if len(inputPaths) == 0 {
 		return nil, ErrInputFileNotFound
@@ -1087,6 +1387,10 @@ func (t *Transcoder) SmartTranscodeMultiple(ctx context.Context, inputPaths []st
 		return t.SmartTranscode(ctx, inputPaths[0], outputPath)
 	}
 
+	if t.profile.RequiresTranscode() {
+		return t.transcodeWithFallback(ctx, inputPaths, outputPath)
+	}
+
 	slog.Debug("analyzing multiple files for smart transcode", "file_count", len(inputPaths))
 
 	// Detect formats for all input files
@@ -1106,7 +1410,7 @@ func (t *Transcoder) SmartTranscodeMultiple(ctx context.Context, inputPaths []st
 				"file_index", i,
 				"path", path,
 				"error", err)
-			return t.TranscodeMultiple(ctx, inputPaths, outputPath)
+			return t.transcodeWithFallback(ctx, inputPaths, outputPath)
 		}
 		formats = append(formats, format)
 
@@ -1138,7 +1442,7 @@ func (t *Transcoder) SmartTranscodeMultiple(ctx context.Context, inputPaths []st
 			"codec", firstCodec,
 			"target_format", checker.GetTargetFormat(formats[0]))
 		targetFormat := checker.GetTargetFormat(formats[0])
-		return t.RemuxMultiple(ctx, inputPaths, outputPath, targetFormat)
+		return t.remuxWithFallback(ctx, inputPaths, outputPath, targetFormat)
 	}
 
 	// Otherwise, fall back to transcode (which re-encodes everything to a common format)
@@ -1146,5 +1450,5 @@ func (t *Transcoder) SmartTranscodeMultiple(ctx context.Context, inputPaths []st
 		"file_count", len(inputPaths),
 		"same_codec", allSameCodec,
 		"can_remux", canRemux)
-	return t.TranscodeMultiple(ctx, inputPaths, outputPath)
+	return t.transcodeWithFallback(ctx, inputPaths, outputPath)
 }
@@ -2,7 +2,10 @@ package cache
 
 import (
 	"context"
+	"encoding/base64"
 	"log/slog"
+	"math"
+	"strconv"
 	"time"
 
 	"audiobookshelf-sonos-bridge/internal/abs"
@@ -12,7 +15,8 @@ import (
 // WarmupConfig configures the cache warmup job.
 type WarmupConfig struct {
 	Interval       time.Duration
-	BatchSize      int
+	BatchSize      int // Maximum items queued per run (0 disables warmup)
+	UserBudget     int // Maximum items queued per user and run (0 = no per-user limit)
 	MaxConcurrent  int
 }
 
@@ -20,21 +24,30 @@ type WarmupConfig struct {
 var DefaultWarmupConfig = WarmupConfig{
 	Interval:      1 * time.Hour,
 	BatchSize:     10,
+	UserBudget:    5,
 	MaxConcurrent: 2,
 }
 
+// warmupProgressLimit is the number of items in progress considered per user.
+const warmupProgressLimit = 20
+
 // TokenDecrypter decrypts ABS tokens from session storage.
 type TokenDecrypter interface {
 	DecryptToken(encrypted []byte) (string, error)
 }
 
-// WarmupJob handles background cache warming.
+// WarmupJob handles background cache warming. Each run transcodes, for each
+// user with an active session and with that user's own token, the items the
+// user pinned, the items in progress and the next book of each series in
+// progress.
 type WarmupJob struct {
 	index         *Index
 	worker        *Worker
 	absClient     *abs.Client
 	sessionStore  *store.SessionStore
+	pinStore      *store.PinStore
 	tokenDecrypt  TokenDecrypter
+	resolve       JobResolver
 	config        WarmupConfig
 	cancel        context.CancelFunc
 }
@@ -45,7 +58,9 @@ func NewWarmupJob(
 	worker *Worker,
 	absClient *abs.Client,
 	sessionStore *store.SessionStore,
+	pinStore *store.PinStore,
 	tokenDecrypt TokenDecrypter,
+	resolve JobResolver,
 	config WarmupConfig,
 ) *WarmupJob {
 	return &WarmupJob{
@@ -53,13 +68,20 @@ func NewWarmupJob(
 		worker:       worker,
 		absClient:    absClient,
 		sessionStore: sessionStore,
+		pinStore:     pinStore,
 		tokenDecrypt: tokenDecrypt,
+		resolve:      resolve,
 		config:       config,
 	}
 }
 
 // Start begins the warmup job.
 func (j *WarmupJob) Start(ctx context.Context) {
+	if j.config.BatchSize <= 0 {
+		slog.Info("cache warmup budget is 0, warmup disabled")
+		return
+	}
+
 	ctx, j.cancel = context.WithCancel(ctx)
 
 	// Run initial warmup after a short delay
@@ -87,7 +109,11 @@ func (j *WarmupJob) Start(ctx context.Context) {
 		}
 	}()
 
-	slog.Info("cache warmup job started", "interval", j.config.Interval)
+	slog.Info("cache warmup job started",
+		"interval", j.config.Interval,
+		"budget", j.config.BatchSize,
+		"user_budget", j.config.UserBudget,
+	)
 }
 
 // Stop stops the warmup job.
@@ -99,123 +125,248 @@ func (j *WarmupJob) Stop() {
 }
 
 // run performs a single warmup pass.
-func (j *WarmupJob) run(ctx context.Context) {
+func (j *WarmupJob) run(ctx context.Context) int {
 	slog.Info("starting cache warmup run")
 
-	// Get a session with an active ABS token
 	sessions, err := j.sessionStore.ListActive()
 	if err != nil {
 		slog.Error("failed to get active sessions", "error", err)
-		return
+		return 0
 	}
 
 	if len(sessions) == 0 {
 		slog.Debug("no active sessions, skipping warmup")
-		return
+		return 0
 	}
 
-	// Use the first active session's token
-	session := sessions[0]
+	// Items wanted by several users are only considered once per run
+	seen := make(map[string]bool)
+	users := make(map[string]bool)
+	queued := 0
 
-	// Decrypt the token
-	token, err := j.tokenDecrypt.DecryptToken(session.ABSTokenEnc)
-	if err != nil {
-		slog.Error("failed to decrypt token", "error", err)
-		return
-	}
+	// Sessions are ordered by last use, so each user's most recent one is used
+	for _, session := range sessions {
+		if users[session.UserID] {
+			continue
+		}
+		users[session.UserID] = true
 
-	client := j.absClient.WithToken(token)
+		budget := j.config.BatchSize - queued
+		if budget <= 0 || ctx.Err() != nil {
+			break
+		}
+		if j.config.UserBudget > 0 && j.config.UserBudget < budget {
+			budget = j.config.UserBudget
+		}
 
-	// Get libraries
-	libraries, err := client.GetLibraries(ctx)
-	if err != nil {
-		slog.Error("failed to get libraries", "error", err)
-		return
+		token, err := j.tokenDecrypt.DecryptToken(session.ABSTokenEnc)
+		if err != nil {
+			slog.Error("failed to decrypt token", "user", session.ABSUsername, "error", err)
+			continue
+		}
+
+		n := j.warmUser(ctx, j.absClient.WithToken(token), session.UserID, budget, seen)
+		slog.Debug("cache warmup for user complete", "user", session.ABSUsername, "queued", n)
+		queued += n
 	}
 
-	queued := 0
-	for _, lib := range libraries {
-		if lib.MediaType != "book" {
-			continue // Only warm audiobooks
-		}
+	slog.Info("cache warmup run complete", "users", len(users), "queued", queued)
+	return queued
+}
 
-		queued += j.warmLibrary(ctx, client, lib.ID)
+// warmupCandidate is an item to warm and the priority of its job.
+type warmupCandidate struct {
+	key      string
+	priority Priority
+}
 
-		if queued >= j.config.BatchSize {
+// warmUser queues up to budget of a user's warmup candidates.
+func (j *WarmupJob) warmUser(ctx context.Context, client *abs.Client, userID string, budget int, seen map[string]bool) int {
+	queued := 0
+	for _, c := range j.candidates(ctx, client, userID) {
+		if queued >= budget || ctx.Err() != nil {
 			break
 		}
-	}
+		if seen[c.key] {
+			continue
+		}
+		seen[c.key] = true
 
-	slog.Info("cache warmup run complete", "queued", queued)
+		if j.queueItem(ctx, client, c.key, c.priority) {
+			queued++
+		}
+	}
+	return queued
 }
 
-// warmLibrary queues items from a library for transcoding.
-func (j *WarmupJob) warmLibrary(ctx context.Context, client *abs.Client, libraryID string) int {
-	// Get library items
-	items, err := client.GetLibraryItems(ctx, libraryID, abs.ItemsOptions{
-		Limit: j.config.BatchSize,
-		Sort:  "addedAt",
-		Desc:  true, // Newest first
-	})
+// candidates returns the cache keys to warm for a user, in priority order:
+// pinned items, items in progress, then the next unfinished book of each
+// series in progress. The next books are queued with bulk priority.
+func (j *WarmupJob) candidates(ctx context.Context, client *abs.Client, userID string) []warmupCandidate {
+	var keys []warmupCandidate
+
+	pinned, err := j.pinStore.ListByUser(userID)
 	if err != nil {
-		slog.Error("failed to get library items", "library_id", libraryID, "error", err)
-		return 0
+		slog.Warn("failed to list pinned items", "user_id", userID, "error", err)
 	}
-
-	queued := 0
-	for _, item := range items.Results {
-		// Check if already cached
-		cached, err := j.index.IsCached(item.ID)
+	for _, itemID := range pinned {
+		item, err := client.GetItem(ctx, itemID)
 		if err != nil {
+			slog.Debug("failed to get pinned item", "item_id", itemID, "error", err)
 			continue
 		}
-		if cached {
-			continue
+		if key, ok := warmupKey(item); ok {
+			keys = append(keys, warmupCandidate{key, PriorityWarmup})
 		}
+	}
 
-		// Check current status
-		status, _ := j.index.GetStatus(item.ID)
-		if status == store.CacheStatusInProgress {
-			continue // Already being processed
+	inProgress, err := client.GetItemsInProgress(ctx, warmupProgressLimit)
+	if err != nil {
+		slog.Warn("failed to get items in progress", "user_id", userID, "error", err)
+		return keys
+	}
+	for _, item := range inProgress {
+		if item.RecentEpisode != nil {
+			keys = append(keys, warmupCandidate{store.CacheKey(item.ID, item.RecentEpisode.ID), PriorityWarmup})
+		} else if item.MediaType != "podcast" {
+			keys = append(keys, warmupCandidate{item.ID, PriorityWarmup})
 		}
+	}
 
-		// Get source path
-		audioFile := item.GetPrimaryAudioFile()
-		if audioFile == nil {
-			continue
+	for _, key := range j.nextInSeries(ctx, client, inProgress) {
+		keys = append(keys, warmupCandidate{key, PriorityBulk})
+	}
+	return keys
+}
+
+// warmupKey returns the cache key to warm for an item: the item itself for
+// books, the latest episode for podcasts.
+func warmupKey(item *abs.LibraryItem) (string, bool) {
+	if !item.IsPodcast() {
+		return item.ID, true
+	}
+
+	var latest *abs.PodcastEpisode
+	for i := range item.Media.Episodes {
+		ep := &item.Media.Episodes[i]
+		if latest == nil || ep.PublishedAt > latest.PublishedAt {
+			latest = ep
 		}
+	}
+	if latest == nil {
+		return "", false
+	}
+	return store.CacheKey(item.ID, latest.ID), true
+}
 
-		sourcePath := audioFile.Metadata.Path
-		if sourcePath == "" {
-			continue
+// nextInSeries returns, for each series of the books in progress, the first
+// book after the one in progress that the user hasn't finished.
+func (j *WarmupJob) nextInSeries(ctx context.Context, client *abs.Client, inProgress []abs.ItemInProgress) []string {
+	progress, err := client.GetMediaProgress(ctx)
+	if err != nil {
+		slog.Warn("failed to get media progress", "error", err)
+		return nil
+	}
+	finished := make(map[string]bool)
+	for _, p := range progress {
+		if p.EpisodeID == "" && p.IsFinished {
+			finished[p.LibraryItemID] = true
 		}
+	}
 
-		// Create cache entry if needed
-		entry, _ := j.index.GetEntry(item.ID)
-		if entry == nil {
-			if err := j.index.CreateEntry(item.ID, sourcePath, 0, time.Now()); err != nil {
-				slog.Warn("failed to create cache entry", "item_id", item.ID, "error", err)
+	var keys []string
+	for _, item := range inProgress {
+		for _, series := range item.Media.Metadata.Series {
+			current, err := strconv.ParseFloat(series.Sequence, 64)
+			if err != nil {
 				continue
 			}
-		}
 
-		// Queue for transcoding
-		job := Job{
-			ItemID:     item.ID,
-			SourcePath: sourcePath,
+			// ABS uses URL-safe base64 encoding for filter values
+			books, err := client.GetLibraryItems(ctx, item.LibraryID, abs.ItemsOptions{
+				Filter: "series." + base64.URLEncoding.EncodeToString([]byte(series.ID)),
+			})
+			if err != nil {
+				slog.Debug("failed to get series", "series_id", series.ID, "error", err)
+				continue
+			}
+
+			if next := nextSeriesBook(books.Results, series.ID, current, finished); next != "" {
+				keys = append(keys, next)
+			}
 		}
+	}
+	return keys
+}
 
-		if j.worker.Enqueue(job) {
-			queued++
-			slog.Debug("queued item for cache warmup", "item_id", item.ID)
+// nextSeriesBook returns the ID of the book with the lowest sequence number
+// above current in a series that isn't finished, or "" if there is none.
+func nextSeriesBook(books []abs.LibraryItem, seriesID string, current float64, finished map[string]bool) string {
+	next := ""
+	nextSequence := math.Inf(1)
+	for _, book := range books {
+		if finished[book.ID] {
+			continue
+		}
+		for _, series := range book.Media.Metadata.Series {
+			if series.ID != seriesID {
+				continue
+			}
+			sequence, err := strconv.ParseFloat(series.Sequence, 64)
+			if err != nil || sequence <= current || sequence >= nextSequence {
+				continue
+			}
+			next, nextSequence = book.ID, sequence
 		}
+	}
+	return next
+}
 
-		if queued >= j.config.BatchSize {
-			break
+// queueItem creates a cache entry and queues a transcoding job with all audio
+// files of an item unless it is already cached or being processed. Returns
+// true if a job was queued. The job can download the audio files from ABS if
+// their paths don't exist locally. Cached items whose source files changed
+// are transcoded again.
+func (j *WarmupJob) queueItem(ctx context.Context, client *abs.Client, cacheKey string, priority Priority) bool {
+	job, err := j.resolve(ctx, client, cacheKey)
+	if err != nil {
+		slog.Debug("failed to build warmup job", "item_id", cacheKey, "error", err)
+		return false
+	}
+	job.Priority = priority
+
+	if _, err := j.index.InvalidateIfChanged(job); err != nil {
+		slog.Warn("failed to check source files", "item_id", cacheKey, "error", err)
+	}
+
+	// Check if already cached
+	cached, err := j.index.IsCached(cacheKey)
+	if err != nil || cached {
+		return false
+	}
+
+	// Check current status
+	status, _ := j.index.GetStatus(cacheKey)
+	if status == store.CacheStatusInProgress {
+		return false // Already being processed
+	}
+
+	// Create cache entry if needed
+	entry, _ := j.index.GetEntry(cacheKey)
+	if entry == nil {
+		if err := j.index.CreateEntryForJob(job); err != nil {
+			slog.Warn("failed to create cache entry", "item_id", cacheKey, "error", err)
+			return false
 		}
 	}
 
-	return queued
+	// Queue for transcoding
+	if !j.worker.Enqueue(job) {
+		return false
+	}
+
+	slog.Debug("queued item for cache warmup", "item_id", cacheKey, "priority", priority)
+	return true
 }
 
 // CleanupStale cleans up stale in_progress entries on startup.
@@ -2,48 +2,86 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"sync"
 	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
 )
 
 // Job represents a transcoding job.
 type Job struct {
 	ItemID      string
-	SourcePath  string   // Deprecated: use SourcePaths
-	SourcePaths []string // Multiple source files to concatenate
+	SourcePath  string        // Deprecated: use SourcePaths
+	SourcePaths []string      // Multiple source files to concatenate
+	RemoteFiles []RemoteFile  // Same files in ABS, downloaded if a local path doesn't exist
+	ABSClient   *abs.Client   // Client with a user token for downloading RemoteFiles
+	Profile     string        // Transcode profile name; ItemID is the profile's variant key
+	Chapters    []abs.Chapter // Chapters of the item or episode; segments are cut at their starts
+	Fingerprint Fingerprint   // Version of the source files, recorded in the cache entry
+	Priority    Priority      // Position in the transcode queue
 }
 
-// Worker manages transcoding jobs with a configurable worker pool.
+// Worker manages transcoding jobs with a configurable worker pool. Jobs are
+// persisted in a queue, so they survive restarts, and are run by priority.
 type Worker struct {
-	index      *Index
-	transcoder *Transcoder
-	jobs       chan Job
-	workers    int
-	wg         sync.WaitGroup
-	cancel     context.CancelFunc
+	index       *Index
+	transcoder  *Transcoder
+	queue       *store.JobStore
+	wake        chan struct{} // Signals idle workers that a job was queued
+	clients     ClientProvider
+	background  BackgroundConfig
+	limits      *processLimits
+	passthrough bool  // Serve compatible single files without caching them
+	live        *Live // Streams queued items until their jobs end
+	workers     int
+	wg          sync.WaitGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	claimMu        sync.Mutex // Serializes claiming jobs, so MaxJobs isn't exceeded
+	mu             sync.Mutex
+	progressive    map[string]*progressiveJob // Running progressive encodes by item ID
+	running        map[string]*runningJob     // Running queued jobs by item ID
+	absClients     map[string]*abs.Client     // Clients of queued jobs by item ID
+	backgroundJobs int                        // Number of running background jobs
+	progress       *ProgressTracker
 }
 
-// NewWorker creates a new worker pool.
-func NewWorker(index *Index, transcoder *Transcoder, workers int) *Worker {
+// NewWorker creates a new worker pool running the jobs in queue.
+func NewWorker(index *Index, transcoder *Transcoder, queue *store.JobStore, workers int) *Worker {
 	return &Worker{
-		index:      index,
-		transcoder: transcoder,
-		jobs:       make(chan Job, 100), // Buffer for 100 jobs
-		workers:    workers,
+		index:       index,
+		transcoder:  transcoder,
+		queue:       queue,
+		wake:        make(chan struct{}, max(workers, 1)),
+		limits:      &processLimits{gate: newPauseGate()},
+		workers:     workers,
+		progressive: make(map[string]*progressiveJob),
+		running:     make(map[string]*runningJob),
+		absClients:  make(map[string]*abs.Client),
+		progress:    NewProgressTracker(),
 	}
 }
 
 // Start starts the worker pool.
 func (w *Worker) Start(ctx context.Context) {
-	ctx, w.cancel = context.WithCancel(ctx)
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	ctx = w.ctx
 
 	for i := 0; i < w.workers; i++ {
 		w.wg.Add(1)
 		go w.worker(ctx, i)
 	}
 
+	if w.background.Allowed != nil {
+		w.wg.Add(1)
+		go w.watchSchedule(ctx)
+	}
+
 	slog.Info("cache worker pool started", "workers", w.workers)
 }
 
@@ -52,46 +90,120 @@ func (w *Worker) Stop() {
 	if w.cancel != nil {
 		w.cancel()
 	}
-	close(w.jobs)
 	w.wg.Wait()
 	slog.Info("cache worker pool stopped")
 }
 
-// Enqueue adds a job to the queue.
+// context returns the context of the running worker pool, so background
+// encodes are cancelled on shutdown.
+func (w *Worker) context() context.Context {
+	if w.ctx == nil {
+		return context.Background()
+	}
+	return w.ctx
+}
+
+// Enqueue adds a job to the queue with the job's priority. If the item is
+// already queued, its job takes the higher priority. Returns false if the
+// item's job is already running or the job couldn't be stored.
 func (w *Worker) Enqueue(job Job) bool {
-	select {
-	case w.jobs <- job:
-		return true
-	default:
-		slog.Warn("job queue full, dropping job", "item_id", job.ItemID)
+	payload, err := encodeJob(job)
+	if err != nil {
+		slog.Error("failed to encode transcoding job", "item_id", job.ItemID, "error", err)
+		return false
+	}
+
+	queued, err := w.queue.Enqueue(job.ItemID, int(job.Priority), payload)
+	if err != nil {
+		slog.Error("failed to queue transcoding job", "item_id", job.ItemID, "error", err)
+		return false
+	}
+	if !queued {
+		slog.Debug("transcoding job already running", "item_id", job.ItemID)
 		return false
 	}
+
+	if job.ABSClient != nil {
+		w.mu.Lock()
+		w.absClients[job.ItemID] = job.ABSClient
+		w.mu.Unlock()
+	}
+
+	w.notify()
+	return true
 }
 
-// QueueLength returns the current number of jobs in the queue.
+// QueueLength returns the number of jobs waiting in the queue.
 func (w *Worker) QueueLength() int {
-	return len(w.jobs)
+	count, err := w.queue.CountQueued()
+	if err != nil {
+		slog.Warn("failed to count queued jobs", "error", err)
+	}
+	return count
+}
+
+// Progress returns the progress of a running job by item ID.
+func (w *Worker) Progress(itemID string) (Progress, bool) {
+	return w.progress.Get(itemID)
+}
+
+// SubscribeProgress returns a channel with the progress updates of an item's
+// job, which is closed when the job ends. See ProgressTracker.Subscribe.
+func (w *Worker) SubscribeProgress(itemID string) (<-chan Progress, func()) {
+	return w.progress.Subscribe(itemID)
+}
+
+// trackProgress starts tracking the progress of a job and returns a transcoder
+// reporting to it, and the function that ends tracking. Segmented output of
+// multiple files takes two passes: concatenation and splitting.
+func (w *Worker) trackProgress(t *Transcoder, itemID string, sourceCount, totalDuration int, segmented bool) (*Transcoder, func()) {
+	passes := 1
+	if segmented && sourceCount > 1 {
+		passes = 2
+	}
+
+	p := w.progress.begin(itemID, passes*t.profile.OutputTime(totalDuration))
+	return t.withProgress(p), func() { w.progress.end(p) }
 }
 
-// worker is the worker goroutine.
+// transcoderFor returns a transcoder using the job's profile.
+// Unknown profiles fall back to the default profile.
+func (w *Worker) transcoderFor(job Job) *Transcoder {
+	profile, ok := ProfileByName(job.Profile)
+	if !ok {
+		slog.Warn("unknown transcode profile, using default", "item_id", job.ItemID, "profile", job.Profile)
+		profile = DefaultProfile
+	}
+	return w.transcoder.WithProfile(profile)
+}
+
+// worker is the worker goroutine. It runs due jobs from the queue until
+// there are none, then waits for a job to be queued or a retry to be due.
 func (w *Worker) worker(ctx context.Context, id int) {
 	defer w.wg.Done()
 
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
 	for {
+		for w.runNext(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case job, ok := <-w.jobs:
-			if !ok {
-				return
-			}
-			w.processJob(ctx, job)
+		case <-w.wake:
+		case <-ticker.C:
 		}
 	}
 }
 
-// processJob processes a single transcoding job.
-func (w *Worker) processJob(ctx context.Context, job Job) {
+// processJob processes a single transcoding job. The commands and output of
+// its ffmpeg runs are written to log.
+func (w *Worker) processJob(ctx context.Context, job Job, log *transcodeLog) error {
 	startTime := time.Now()
 
 	// Get source paths (support both old single path and new multiple paths)
@@ -105,44 +217,72 @@ func (w *Worker) processJob(ctx context.Context, job Job) {
 	// Mark as in progress
 	if err := w.index.MarkInProgress(job.ItemID); err != nil {
 		slog.Error("failed to mark job in progress", "item_id", job.ItemID, "error", err)
-		return
+		return err
 	}
 
 	// Create output directory
 	if err := w.index.EnsureDirectory(job.ItemID); err != nil {
-		w.index.MarkFailed(job.ItemID, err.Error())
 		slog.Error("failed to create cache directory", "item_id", job.ItemID, "error", err)
-		return
+		return err
+	}
+
+	// Fall back to downloading through ABS if the media path isn't mounted
+	sourcePaths, sourceType, cleanup, err := w.resolveSources(ctx, job, sourcePaths)
+	if err != nil {
+		slog.Error("failed to get source files", "item_id", job.ItemID, "error", err)
+		log.notef("failed to get source files: %v", err)
+		return err
+	}
+	defer cleanup()
+
+	if err := w.index.SetSourceType(job.ItemID, sourceType); err != nil {
+		slog.Warn("failed to record source type", "item_id", job.ItemID, "error", err)
+	}
+
+	t := w.transcoderFor(job).withLog(log).forSources(ctx, sourcePaths)
+	if job.Priority < PriorityInteractive {
+		t = t.withLimits(w.limits)
 	}
 
+	// Durations and segments are on the output's timeline, which is shorter
+	// for speed variants, so the layout matches progressive encodes
+	sourceDuration := w.totalDuration(ctx, sourcePaths)
+	totalDuration := t.profile.OutputTime(sourceDuration)
+
 	// Check if we need segmented processing (for files > 2 hours)
 	// This is required for ZP90/Sonos Connect which has a ~128MB RAM limit
-	if w.needsSegmentation(ctx, sourcePaths) {
-		w.processJobSegmented(ctx, job, sourcePaths, startTime)
-		return
+	segmented := t.profile.Segmented && needsSegmentation(totalDuration)
+	t, endProgress := w.trackProgress(t, job.ItemID, len(sourcePaths), sourceDuration, segmented)
+	defer endProgress()
+
+	if segmented {
+		return w.processJobSegmented(ctx, t, job, sourcePaths, startTime)
+	}
+
+	// Compatible single files are served without a copy in the cache
+	if ok, err := w.servePassthrough(ctx, t, job.ItemID, sourcePaths, sourceType, totalDuration); ok || err != nil {
+		return err
 	}
 
 	// Standard processing for shorter files
-	w.processJobStandard(ctx, job, sourcePaths, startTime)
+	return w.processJobStandard(ctx, t, job, sourcePaths, startTime)
 }
 
-// needsSegmentation checks if the source files require segmented processing.
-// Returns true if total duration exceeds 2 hours (SegmentDuration).
-func (w *Worker) needsSegmentation(ctx context.Context, sourcePaths []string) bool {
-	if len(sourcePaths) == 0 {
-		return false
-	}
-
-	var totalDuration int
-	for _, path := range sourcePaths {
-		duration, err := w.transcoder.GetDuration(ctx, path)
-		if err != nil {
-			slog.Debug("failed to get duration, assuming no segmentation needed", "path", path, "error", err)
-			continue
-		}
-		totalDuration += duration
+// segmentConcatenated splits the concatenated output of multiple files into
+// segments. Output of a processing profile already has its filters applied,
+// so it is split without encoding it again.
+func segmentConcatenated(ctx context.Context, t *Transcoder, inputPath, outputDir string, chapterStarts []int) (*SegmentedResult, string, error) {
+	if t.profile.RequiresTranscode() {
+		result, err := t.RemuxSegmented(ctx, inputPath, outputDir, t.profile.OutputFormat, chapterStarts)
+		return result, t.profile.OutputFormat, err
 	}
+	return t.SmartTranscodeSegmented(ctx, inputPath, outputDir, chapterStarts)
+}
 
+// needsSegmentation checks if source files of the given total duration
+// require segmented processing.
+// Returns true if total duration exceeds 2 hours (SegmentDuration).
+func needsSegmentation(totalDuration int) bool {
 	needsSegment := totalDuration > SegmentDuration
 	if needsSegment {
 		slog.Debug("file requires segmentation",
@@ -155,26 +295,28 @@ func (w *Worker) needsSegmentation(ctx context.Context, sourcePaths []string) bo
 }
 
 // processJobStandard handles standard (non-segmented) transcoding.
-func (w *Worker) processJobStandard(ctx context.Context, job Job, sourcePaths []string, startTime time.Time) {
+func (w *Worker) processJobStandard(ctx context.Context, t *Transcoder, job Job, sourcePaths []string, startTime time.Time) error {
 	// Determine target format based on input files
-	targetFormat := w.determineTargetFormat(ctx, sourcePaths)
+	targetFormat := w.determineTargetFormat(ctx, t, sourcePaths)
 	slog.Debug("determined target format", "item_id", job.ItemID, "format", targetFormat)
 
 	// Get output path with correct format
 	outputPath := w.index.GetCachePathWithFormat(job.ItemID, targetFormat)
 
 	// SmartTranscode: intelligently chooses between remux and transcode
-	result, err := w.transcoder.SmartTranscodeMultiple(ctx, sourcePaths, outputPath)
+	result, err := t.SmartTranscodeMultiple(ctx, sourcePaths, outputPath)
 	if err != nil {
-		w.index.MarkFailed(job.ItemID, err.Error())
 		slog.Error("transcoding failed", "item_id", job.ItemID, "error", err)
-		return
+		return err
+	}
+	if targetFormat, err = w.placeOutput(job.ItemID, outputPath, targetFormat, result); err != nil {
+		return err
 	}
 
 	// Mark as ready with the target format
 	if err := w.index.MarkReadyWithFormat(job.ItemID, result.DurationSec, targetFormat); err != nil {
 		slog.Error("failed to mark job ready", "item_id", job.ItemID, "error", err)
-		return
+		return err
 	}
 
 	duration := time.Since(startTime)
@@ -186,16 +328,32 @@ func (w *Worker) processJobStandard(ctx context.Context, job Job, sourcePaths []
 		"transcode_time", duration,
 		"source_files", len(sourcePaths),
 	)
+	return nil
+}
+
+// placeOutput returns the format of a finished encode. If a fallback wrote
+// another format than targetFormat, the output is moved to that format's path.
+func (w *Worker) placeOutput(itemID, outputPath, targetFormat string, result *TranscodeResult) (string, error) {
+	if result.Format == "" || result.Format == targetFormat {
+		return targetFormat, nil
+	}
+
+	slog.Debug("output format changed by fallback", "item_id", itemID, "planned", targetFormat, "actual", result.Format)
+	if err := os.Rename(outputPath, w.index.GetCachePathWithFormat(itemID, result.Format)); err != nil {
+		return "", fmt.Errorf("failed to move output: %w", err)
+	}
+	return result.Format, nil
 }
 
 // processJobSegmented handles segmented transcoding for long files.
-func (w *Worker) processJobSegmented(ctx context.Context, job Job, sourcePaths []string, startTime time.Time) {
+func (w *Worker) processJobSegmented(ctx context.Context, t *Transcoder, job Job, sourcePaths []string, startTime time.Time) error {
 	slog.Info("using segmented processing for long file",
 		"item_id", job.ItemID,
 		"source_count", len(sourcePaths))
 
 	// Get output directory
 	outputDir := w.index.GetCacheDir(job.ItemID)
+	chapterStarts := t.profile.chapterStarts(job.Chapters)
 
 	// For multiple files, we need to first concatenate, then segment
 	// For a single file, we can segment directly
@@ -205,20 +363,19 @@ func (w *Worker) processJobSegmented(ctx context.Context, job Job, sourcePaths [
 
 	if len(sourcePaths) == 1 {
 		// Single file - segment directly
-		result, outputFormat, err = w.transcoder.SmartTranscodeSegmented(ctx, sourcePaths[0], outputDir)
+		result, outputFormat, err = t.SmartTranscodeSegmented(ctx, sourcePaths[0], outputDir, chapterStarts)
 	} else {
 		// Multiple files - first concatenate to temp file, then segment
 		// For now, concatenate and transcode (this handles multi-file to segments)
 		tempPath := outputDir + "/concat_temp.tmp"
-		concatResult, concatErr := w.transcoder.SmartTranscodeMultiple(ctx, sourcePaths, tempPath)
+		concatResult, concatErr := t.SmartTranscodeMultiple(ctx, sourcePaths, tempPath)
 		if concatErr != nil {
-			w.index.MarkFailed(job.ItemID, concatErr.Error())
 			slog.Error("concatenation failed", "item_id", job.ItemID, "error", concatErr)
-			return
+			return concatErr
 		}
 
 		// Now segment the concatenated file
-		result, outputFormat, err = w.transcoder.SmartTranscodeSegmented(ctx, tempPath, outputDir)
+		result, outputFormat, err = segmentConcatenated(ctx, t, tempPath, outputDir, chapterStarts)
 
 		// Clean up temp file
 		os.Remove(tempPath)
@@ -230,15 +387,14 @@ func (w *Worker) processJobSegmented(ctx context.Context, job Job, sourcePaths [
 	}
 
 	if err != nil {
-		w.index.MarkFailed(job.ItemID, err.Error())
 		slog.Error("segmented transcoding failed", "item_id", job.ItemID, "error", err)
-		return
+		return err
 	}
 
 	// Mark as ready with segment information
-	if err := w.index.MarkReadyWithSegments(job.ItemID, result.DurationSec, outputFormat, result.SegmentCount, result.SegmentDurationSec); err != nil {
+	if err := w.index.MarkReadyWithSegments(job.ItemID, result.DurationSec, outputFormat, result.SegmentStarts); err != nil {
 		slog.Error("failed to mark segmented job ready", "item_id", job.ItemID, "error", err)
-		return
+		return err
 	}
 
 	duration := time.Since(startTime)
@@ -246,118 +402,24 @@ func (w *Worker) processJobSegmented(ctx context.Context, job Job, sourcePaths [
 		"item_id", job.ItemID,
 		"duration_sec", result.DurationSec,
 		"segment_count", result.SegmentCount,
-		"segment_duration", result.SegmentDurationSec,
+		"segment_starts", result.SegmentStarts,
 		"total_size", result.TotalSize,
 		"output_format", outputFormat,
 		"transcode_time", duration,
 		"source_files", len(sourcePaths),
 	)
-}
-
-// TranscodeSync performs synchronous transcoding (for on-demand).
-// Deprecated: Use TranscodeSyncMultiple for new code.
-func (w *Worker) TranscodeSync(ctx context.Context, itemID, sourcePath string) error {
-	return w.TranscodeSyncMultiple(ctx, itemID, []string{sourcePath})
-}
-
-// TranscodeSyncMultiple performs synchronous transcoding of multiple files.
-func (w *Worker) TranscodeSyncMultiple(ctx context.Context, itemID string, sourcePaths []string) error {
-	slog.Debug("starting sync transcoding", "item_id", itemID, "source_count", len(sourcePaths))
-
-	// Mark as in progress
-	if err := w.index.MarkInProgress(itemID); err != nil {
-		return err
-	}
-
-	// Create output directory
-	if err := w.index.EnsureDirectory(itemID); err != nil {
-		w.index.MarkFailed(itemID, err.Error())
-		return err
-	}
-
-	// Check if we need segmented processing (for files > 2 hours)
-	if w.needsSegmentation(ctx, sourcePaths) {
-		return w.transcodeSyncSegmented(ctx, itemID, sourcePaths)
-	}
-
-	// Standard processing for shorter files
-	targetFormat := w.determineTargetFormat(ctx, sourcePaths)
-	slog.Debug("determined target format", "item_id", itemID, "format", targetFormat)
-
-	// Get output path with correct format
-	outputPath := w.index.GetCachePathWithFormat(itemID, targetFormat)
-
-	// SmartTranscode: intelligently chooses between remux and transcode
-	result, err := w.transcoder.SmartTranscodeMultiple(ctx, sourcePaths, outputPath)
-	if err != nil {
-		w.index.MarkFailed(itemID, err.Error())
-		return err
-	}
-
-	slog.Info("sync transcoding complete",
-		"item_id", itemID,
-		"duration_sec", result.DurationSec,
-		"output_size", result.OutputSize,
-		"output_format", targetFormat,
-		"source_files", len(sourcePaths),
-	)
-
-	// Mark as ready with format
-	return w.index.MarkReadyWithFormat(itemID, result.DurationSec, targetFormat)
-}
-
-// transcodeSyncSegmented performs synchronous segmented transcoding.
-func (w *Worker) transcodeSyncSegmented(ctx context.Context, itemID string, sourcePaths []string) error {
-	slog.Info("using segmented sync processing for long file",
-		"item_id", itemID,
-		"source_count", len(sourcePaths))
-
-	outputDir := w.index.GetCacheDir(itemID)
-
-	var result *SegmentedResult
-	var outputFormat string
-	var err error
-
-	if len(sourcePaths) == 1 {
-		result, outputFormat, err = w.transcoder.SmartTranscodeSegmented(ctx, sourcePaths[0], outputDir)
-	} else {
-		// Multiple files - first concatenate to temp file, then segment
-		tempPath := outputDir + "/concat_temp.tmp"
-		concatResult, concatErr := w.transcoder.SmartTranscodeMultiple(ctx, sourcePaths, tempPath)
-		if concatErr != nil {
-			w.index.MarkFailed(itemID, concatErr.Error())
-			return concatErr
-		}
-
-		result, outputFormat, err = w.transcoder.SmartTranscodeSegmented(ctx, tempPath, outputDir)
-		os.Remove(tempPath)
-
-		if result != nil {
-			result.DurationSec = concatResult.DurationSec
-		}
-	}
-
-	if err != nil {
-		w.index.MarkFailed(itemID, err.Error())
-		return err
-	}
-
-	slog.Info("segmented sync transcoding complete",
-		"item_id", itemID,
-		"duration_sec", result.DurationSec,
-		"segment_count", result.SegmentCount,
-		"total_size", result.TotalSize,
-		"output_format", outputFormat,
-	)
-
-	return w.index.MarkReadyWithSegments(itemID, result.DurationSec, outputFormat, result.SegmentCount, result.SegmentDurationSec)
+	return nil
 }
 
 // determineTargetFormat analyzes input files and returns the optimal output format.
-func (w *Worker) determineTargetFormat(ctx context.Context, sourcePaths []string) string {
+// Profiles that process the audio always use their own output format.
+func (w *Worker) determineTargetFormat(ctx context.Context, t *Transcoder, sourcePaths []string) string {
 	if len(sourcePaths) == 0 {
 		return "mp3" // default
 	}
+	if t.profile.RequiresTranscode() {
+		return t.profile.OutputFormat
+	}
 
 	detector := NewFormatDetector()
 	checker := NewCompatibilityChecker()
@@ -365,8 +427,8 @@ func (w *Worker) determineTargetFormat(ctx context.Context, sourcePaths []string
 	// Check first file to determine format
 	format, err := detector.Detect(ctx, sourcePaths[0])
 	if err != nil {
-		slog.Debug("format detection failed, using mp3 default", "error", err)
-		return "mp3"
+		slog.Debug("format detection failed, using profile output format", "error", err)
+		return t.profile.OutputFormat
 	}
 
 	// If codec is compatible (can be remuxed), use the optimal target format
@@ -380,11 +442,11 @@ func (w *Worker) determineTargetFormat(ctx context.Context, sourcePaths []string
 		return targetFormat
 	}
 
-	// Needs full transcode - output will be mp3
-	slog.Debug("needs transcoding, using mp3",
+	// Needs full transcode - output is the profile's format
+	slog.Debug("needs transcoding, using profile output format",
 		"input_codec", format.AudioCodec,
 		"input_container", format.Container,
+		"target_format", t.profile.OutputFormat,
 	)
-	return "mp3"
+	return t.profile.OutputFormat
 }
-
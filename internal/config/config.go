@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -20,16 +21,31 @@ type Config struct {
 	SessionSecret   string // Secret for session encryption (min 32 chars)
 
 	// Optional with defaults
-	Port              string        // Server port (default: 8080)
-	CacheDir          string        // Cache directory (default: /cache)
-	ConfigDir         string        // Config directory (default: /config)
-	MediaDir          string        // Media directory (default: /media)
-	ABSMediaPrefix    string        // Path prefix ABS uses for media (default: /audiobooks)
-	PathMappings      []PathMapping // Additional path mappings (format: abs_prefix:local_path,...)
-	TranscodeWorkers  int           // Number of parallel transcoding workers (default: 2)
-	StreamTokenTTL    time.Duration // Streaming token validity (default: 24h)
-	AllowedNetworks   []string      // Allowed networks for streaming (default: all)
-	LogLevel          string        // Log level: debug, info, warn, error (default: info)
+	Port               string        // Server port (default: 8080)
+	CacheDir           string        // Cache directory (default: /cache)
+	ConfigDir          string        // Config directory (default: /config)
+	MediaDir           string        // Media directory (default: /media)
+	ABSMediaPrefix     string        // Path prefix ABS uses for media (default: /audiobooks)
+	PathMappings       []PathMapping // Additional path mappings (format: abs_prefix:local_path,...)
+	TranscodeWorkers   int           // Number of parallel transcoding workers (default: 2)
+	CacheMaxSize       int64         // Cache quota in bytes (default: 0 = unlimited)
+	CacheHighWatermark int           // Usage in percent of the quota that starts eviction (default: 90)
+	CacheLowWatermark  int           // Usage in percent of the quota that eviction frees down to (default: 80)
+	AudioPreset        string        // Audio preset for all items: none, loudnorm, night, speech (default: none)
+	WarmupBudget       int           // Maximum items queued per warmup run (default: 10)
+	WarmupUserBudget   int           // Maximum items queued per user and warmup run (default: 5)
+	BackgroundHours    []TimeWindow  // Daily windows in which background jobs may run (default: always)
+	BackgroundWorkers  int           // Maximum concurrent background transcoding jobs (default: TranscodeWorkers-1, at least 1)
+	BackgroundNice     int           // Niceness of background ffmpeg processes, 0-19 (default: 10)
+	BackgroundIOClass  string        // IO priority of background ffmpeg processes: idle, best-effort, none (default: best-effort)
+	StreamTokenTTL     time.Duration // Streaming token validity (default: 24h)
+	AllowedNetworks    []string      // Networks (CIDR) or addresses allowed to stream (default: all)
+	TrustedProxies     []string      // Reverse proxies whose X-Forwarded-For is trusted (default: none)
+	StreamBindSpeaker  bool          // Bind stream tokens to the speaker they were issued for (default: false)
+	HLS                bool          // Stream HLS playlists to players that support them (default: true)
+	LiveStreaming      bool          // Stream uncached items transcoded on the fly (default: true)
+	Passthrough        bool          // Serve compatible single-file items from the media volume without caching (default: true)
+	LogLevel           string        // Log level: debug, info, warn, error (default: info)
 }
 
 // Load reads configuration from environment variables.
@@ -101,22 +117,147 @@ func Load() (*Config, error) {
 		cfg.TranscodeWorkers = workers
 	}
 
+	// Cache quota (optional, e.g. "50G" or "500M")
+	cacheMaxSizeStr := os.Getenv("BRIDGE_CACHE_MAX_SIZE")
+	if cacheMaxSizeStr != "" {
+		size, err := parseByteSize(cacheMaxSizeStr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("BRIDGE_CACHE_MAX_SIZE must be a size like 50G or 500M (got: %s)", cacheMaxSizeStr))
+		} else {
+			cfg.CacheMaxSize = size
+		}
+	}
+
+	// Cache watermarks in percent of the quota
+	highStr := getEnvOrDefault("BRIDGE_CACHE_HIGH_WATERMARK", "90")
+	high, err := strconv.Atoi(highStr)
+	if err != nil || high < 1 || high > 100 {
+		errs = append(errs, "BRIDGE_CACHE_HIGH_WATERMARK must be a percentage between 1 and 100")
+	} else {
+		cfg.CacheHighWatermark = high
+	}
+
+	lowStr := getEnvOrDefault("BRIDGE_CACHE_LOW_WATERMARK", "80")
+	low, err := strconv.Atoi(lowStr)
+	if err != nil || low < 0 || low > 100 {
+		errs = append(errs, "BRIDGE_CACHE_LOW_WATERMARK must be a percentage between 0 and 100")
+	} else {
+		cfg.CacheLowWatermark = low
+	}
+
+	if cfg.CacheHighWatermark > 0 && cfg.CacheLowWatermark > cfg.CacheHighWatermark {
+		errs = append(errs, "BRIDGE_CACHE_LOW_WATERMARK must not be greater than BRIDGE_CACHE_HIGH_WATERMARK")
+	}
+
+	// Audio preset applied to items without their own preset
+	cfg.AudioPreset = strings.ToLower(getEnvOrDefault("BRIDGE_AUDIO_PRESET", "none"))
+	validAudioPresets := map[string]bool{"none": true, "loudnorm": true, "night": true, "speech": true}
+	if !validAudioPresets[cfg.AudioPreset] {
+		errs = append(errs, fmt.Sprintf("BRIDGE_AUDIO_PRESET must be one of: none, loudnorm, night, speech (got: %s)", cfg.AudioPreset))
+	}
+
+	// Warmup budgets: items queued per run, and per user within a run
+	warmupBudgetStr := getEnvOrDefault("BRIDGE_WARMUP_BUDGET", "10")
+	warmupBudget, err := strconv.Atoi(warmupBudgetStr)
+	if err != nil || warmupBudget < 0 {
+		errs = append(errs, "BRIDGE_WARMUP_BUDGET must be a non-negative integer")
+	} else {
+		cfg.WarmupBudget = warmupBudget
+	}
+
+	warmupUserBudgetStr := getEnvOrDefault("BRIDGE_WARMUP_USER_BUDGET", "5")
+	warmupUserBudget, err := strconv.Atoi(warmupUserBudgetStr)
+	if err != nil || warmupUserBudget < 0 {
+		errs = append(errs, "BRIDGE_WARMUP_USER_BUDGET must be a non-negative integer")
+	} else {
+		cfg.WarmupUserBudget = warmupUserBudget
+	}
+
+	// Background transcoding: allowed hours (e.g. "22:00-06:00,13:00-15:00"),
+	// concurrency and process priority
+	if hoursStr := os.Getenv("BRIDGE_BACKGROUND_HOURS"); hoursStr != "" {
+		windows, err := parseTimeWindows(hoursStr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("BRIDGE_BACKGROUND_HOURS must be time ranges like 22:00-06:00 (got: %s)", hoursStr))
+		} else {
+			cfg.BackgroundHours = windows
+		}
+	}
+
+	// One worker stays free for interactive jobs while background jobs are paused
+	cfg.BackgroundWorkers = max(cfg.TranscodeWorkers-1, 1)
+	if backgroundWorkersStr := os.Getenv("BRIDGE_BACKGROUND_WORKERS"); backgroundWorkersStr != "" {
+		backgroundWorkers, err := strconv.Atoi(backgroundWorkersStr)
+		if err != nil || backgroundWorkers < 1 {
+			errs = append(errs, "BRIDGE_BACKGROUND_WORKERS must be a positive integer")
+		} else {
+			cfg.BackgroundWorkers = backgroundWorkers
+		}
+	}
+
+	niceStr := getEnvOrDefault("BRIDGE_BACKGROUND_NICE", "10")
+	nice, err := strconv.Atoi(niceStr)
+	if err != nil || nice < 0 || nice > 19 {
+		errs = append(errs, "BRIDGE_BACKGROUND_NICE must be an integer between 0 and 19")
+	} else {
+		cfg.BackgroundNice = nice
+	}
+
+	cfg.BackgroundIOClass = strings.ToLower(getEnvOrDefault("BRIDGE_BACKGROUND_IO_PRIORITY", "best-effort"))
+	validIOClasses := map[string]bool{"idle": true, "best-effort": true, "none": true}
+	if !validIOClasses[cfg.BackgroundIOClass] {
+		errs = append(errs, fmt.Sprintf("BRIDGE_BACKGROUND_IO_PRIORITY must be one of: idle, best-effort, none (got: %s)", cfg.BackgroundIOClass))
+	}
+
 	// Stream token TTL
 	ttlStr := getEnvOrDefault("BRIDGE_STREAM_TOKEN_TTL", "24h")
 	ttl, err := time.ParseDuration(ttlStr)
-	if err != nil {
-		errs = append(errs, fmt.Sprintf("BRIDGE_STREAM_TOKEN_TTL must be a valid duration (got: %s)", ttlStr))
+	if err != nil || ttl <= 0 {
+		errs = append(errs, fmt.Sprintf("BRIDGE_STREAM_TOKEN_TTL must be a positive duration (got: %s)", ttlStr))
 	} else {
 		cfg.StreamTokenTTL = ttl
 	}
 
-	// Allowed networks (optional, comma-separated)
-	networksStr := os.Getenv("BRIDGE_ALLOWED_NETWORKS")
-	if networksStr != "" {
-		cfg.AllowedNetworks = strings.Split(networksStr, ",")
-		for i, n := range cfg.AllowedNetworks {
-			cfg.AllowedNetworks[i] = strings.TrimSpace(n)
-		}
+	bindStr := getEnvOrDefault("BRIDGE_STREAM_BIND_SPEAKER", "false")
+	bind, err := strconv.ParseBool(bindStr)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("BRIDGE_STREAM_BIND_SPEAKER must be true or false (got: %s)", bindStr))
+	} else {
+		cfg.StreamBindSpeaker = bind
+	}
+
+	hlsStr := getEnvOrDefault("BRIDGE_HLS", "true")
+	hls, err := strconv.ParseBool(hlsStr)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("BRIDGE_HLS must be true or false (got: %s)", hlsStr))
+	} else {
+		cfg.HLS = hls
+	}
+
+	liveStr := getEnvOrDefault("BRIDGE_LIVE_STREAMING", "true")
+	live, err := strconv.ParseBool(liveStr)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("BRIDGE_LIVE_STREAMING must be true or false (got: %s)", liveStr))
+	} else {
+		cfg.LiveStreaming = live
+	}
+
+	passthroughStr := getEnvOrDefault("BRIDGE_PASSTHROUGH", "true")
+	passthrough, err := strconv.ParseBool(passthroughStr)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("BRIDGE_PASSTHROUGH must be true or false (got: %s)", passthroughStr))
+	} else {
+		cfg.Passthrough = passthrough
+	}
+
+	// Allowed networks and trusted proxies (optional, comma-separated)
+	cfg.AllowedNetworks, err = parseNetworks(os.Getenv("BRIDGE_ALLOWED_NETWORKS"))
+	if err != nil {
+		errs = append(errs, "BRIDGE_ALLOWED_NETWORKS: "+err.Error())
+	}
+	cfg.TrustedProxies, err = parseNetworks(os.Getenv("BRIDGE_TRUSTED_PROXIES"))
+	if err != nil {
+		errs = append(errs, "BRIDGE_TRUSTED_PROXIES: "+err.Error())
 	}
 
 	if len(errs) > 0 {
@@ -131,6 +272,94 @@ func (c *Config) DatabasePath() string {
 	return c.ConfigDir + "/bridge.db"
 }
 
+// TimeWindow is a daily time range in local time. A window whose end is not
+// after its start ends on the next day.
+type TimeWindow struct {
+	Start time.Duration // Offset from midnight
+	End   time.Duration // Offset from midnight
+}
+
+// Contains reports whether t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// BackgroundAllowed reports whether background transcoding may run at t.
+// Without configured hours it may always run.
+func (c *Config) BackgroundAllowed(t time.Time) bool {
+	if len(c.BackgroundHours) == 0 {
+		return true
+	}
+	for _, w := range c.BackgroundHours {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeWindows parses comma-separated time ranges like "22:00-06:00".
+func parseTimeWindows(s string) ([]TimeWindow, error) {
+	var windows []TimeWindow
+	for _, part := range strings.Split(s, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range: %q", part)
+		}
+
+		var w TimeWindow
+		var err error
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses a time like "22:00" (or "24:00") as the offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hourStr, minuteStr, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	minute, err := strconv.Atoi(minuteStr)
+	if err != nil || hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute > 0) {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute, nil
+}
+
+// parseNetworks parses a comma-separated list of CIDR ranges and single IP
+// addresses, e.g. "192.168.0.0/16, 10.0.0.5". Returns nil for an empty list.
+func parseNetworks(s string) ([]string, error) {
+	var networks []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(part); err != nil {
+			if _, err := netip.ParseAddr(part); err != nil {
+				return nil, fmt.Errorf("invalid network or address: %q", part)
+			}
+		}
+		networks = append(networks, part)
+	}
+	return networks, nil
+}
+
 // PathMapping represents a single ABS path to local path mapping.
 type PathMapping struct {
 	ABSPrefix string
@@ -154,6 +383,39 @@ func (c *Config) MapABSPathToLocal(absPath string) string {
 	return absPath
 }
 
+// parseByteSize parses a size in bytes with an optional binary unit suffix
+// (K, M, G, T, optionally followed by "B" or "iB"), e.g. "500M" or "50GB".
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("size must not be negative")
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func clearEnv() {
@@ -18,7 +19,22 @@ func clearEnv() {
 	os.Unsetenv("BRIDGE_TRANSCODE_WORKERS")
 	os.Unsetenv("BRIDGE_STREAM_TOKEN_TTL")
 	os.Unsetenv("BRIDGE_ALLOWED_NETWORKS")
+	os.Unsetenv("BRIDGE_TRUSTED_PROXIES")
+	os.Unsetenv("BRIDGE_STREAM_BIND_SPEAKER")
+	os.Unsetenv("BRIDGE_HLS")
+	os.Unsetenv("BRIDGE_LIVE_STREAMING")
+	os.Unsetenv("BRIDGE_PASSTHROUGH")
 	os.Unsetenv("BRIDGE_LOG_LEVEL")
+	os.Unsetenv("BRIDGE_CACHE_MAX_SIZE")
+	os.Unsetenv("BRIDGE_CACHE_HIGH_WATERMARK")
+	os.Unsetenv("BRIDGE_CACHE_LOW_WATERMARK")
+	os.Unsetenv("BRIDGE_AUDIO_PRESET")
+	os.Unsetenv("BRIDGE_WARMUP_BUDGET")
+	os.Unsetenv("BRIDGE_WARMUP_USER_BUDGET")
+	os.Unsetenv("BRIDGE_BACKGROUND_HOURS")
+	os.Unsetenv("BRIDGE_BACKGROUND_WORKERS")
+	os.Unsetenv("BRIDGE_BACKGROUND_NICE")
+	os.Unsetenv("BRIDGE_BACKGROUND_IO_PRIORITY")
 }
 
 func setRequiredEnv() {
@@ -125,6 +141,18 @@ func TestLoad_Defaults(t *testing.T) {
 	if len(cfg.AllowedNetworks) != 0 {
 		t.Errorf("expected no allowed networks by default, got: %v", cfg.AllowedNetworks)
 	}
+	if cfg.AudioPreset != "none" {
+		t.Errorf("expected default audio preset none, got: %s", cfg.AudioPreset)
+	}
+	if !cfg.HLS {
+		t.Error("expected HLS to be enabled by default")
+	}
+	if !cfg.LiveStreaming {
+		t.Error("expected live streaming to be enabled by default")
+	}
+	if !cfg.Passthrough {
+		t.Error("expected passthrough to be enabled by default")
+	}
 }
 
 func TestLoad_CustomValues(t *testing.T) {
@@ -201,6 +229,101 @@ func TestLoad_InvalidTokenTTL(t *testing.T) {
 	if !strings.Contains(err.Error(), "BRIDGE_STREAM_TOKEN_TTL") {
 		t.Errorf("expected error about token TTL, got: %v", err)
 	}
+
+	os.Setenv("BRIDGE_STREAM_TOKEN_TTL", "0s")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_STREAM_TOKEN_TTL") {
+		t.Errorf("expected error about zero token TTL, got: %v", err)
+	}
+}
+
+func TestLoad_Networks(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+	os.Setenv("BRIDGE_ALLOWED_NETWORKS", "192.168.0.0/16,10.0.0.5, ,fd00::/8")
+	os.Setenv("BRIDGE_TRUSTED_PROXIES", "172.17.0.1")
+	os.Setenv("BRIDGE_STREAM_BIND_SPEAKER", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.AllowedNetworks) != 3 || cfg.AllowedNetworks[1] != "10.0.0.5" {
+		t.Errorf("unexpected allowed networks: %v", cfg.AllowedNetworks)
+	}
+	if len(cfg.TrustedProxies) != 1 || cfg.TrustedProxies[0] != "172.17.0.1" {
+		t.Errorf("unexpected trusted proxies: %v", cfg.TrustedProxies)
+	}
+	if !cfg.StreamBindSpeaker {
+		t.Error("expected stream tokens to be bound to speakers")
+	}
+
+	os.Setenv("BRIDGE_ALLOWED_NETWORKS", "192.168.0.0/33")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_ALLOWED_NETWORKS") {
+		t.Errorf("expected error about allowed networks, got: %v", err)
+	}
+
+	os.Setenv("BRIDGE_ALLOWED_NETWORKS", "")
+	os.Setenv("BRIDGE_TRUSTED_PROXIES", "proxy.local")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_TRUSTED_PROXIES") {
+		t.Errorf("expected error about trusted proxies, got: %v", err)
+	}
+}
+
+func TestLoad_HLS(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+	os.Setenv("BRIDGE_HLS", "false")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.HLS {
+		t.Error("expected HLS to be disabled")
+	}
+
+	os.Setenv("BRIDGE_HLS", "sometimes")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_HLS") {
+		t.Errorf("expected error about HLS, got: %v", err)
+	}
+}
+
+func TestLoad_LiveStreaming(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+	os.Setenv("BRIDGE_LIVE_STREAMING", "false")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LiveStreaming {
+		t.Error("expected live streaming to be disabled")
+	}
+
+	os.Setenv("BRIDGE_LIVE_STREAMING", "maybe")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_LIVE_STREAMING") {
+		t.Errorf("expected error about live streaming, got: %v", err)
+	}
+}
+
+func TestLoad_Passthrough(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+	os.Setenv("BRIDGE_PASSTHROUGH", "false")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Passthrough {
+		t.Error("expected passthrough to be disabled")
+	}
+
+	os.Setenv("BRIDGE_PASSTHROUGH", "copy")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_PASSTHROUGH") {
+		t.Errorf("expected error about passthrough, got: %v", err)
+	}
 }
 
 func TestLoad_InvalidLogLevel(t *testing.T) {
@@ -218,6 +341,183 @@ func TestLoad_InvalidLogLevel(t *testing.T) {
 	}
 }
 
+func TestLoad_AudioPreset(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+	os.Setenv("BRIDGE_AUDIO_PRESET", "Night")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AudioPreset != "night" {
+		t.Errorf("expected audio preset night, got: %s", cfg.AudioPreset)
+	}
+
+	os.Setenv("BRIDGE_AUDIO_PRESET", "loud")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_AUDIO_PRESET") {
+		t.Errorf("expected error about audio preset, got: %v", err)
+	}
+}
+
+func TestLoad_CacheQuota(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.CacheMaxSize != 0 || cfg.CacheHighWatermark != 90 || cfg.CacheLowWatermark != 80 {
+		t.Errorf("unexpected defaults: size=%d high=%d low=%d", cfg.CacheMaxSize, cfg.CacheHighWatermark, cfg.CacheLowWatermark)
+	}
+
+	os.Setenv("BRIDGE_CACHE_MAX_SIZE", "50G")
+	os.Setenv("BRIDGE_CACHE_HIGH_WATERMARK", "95")
+	os.Setenv("BRIDGE_CACHE_LOW_WATERMARK", "70")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.CacheMaxSize != 50<<30 {
+		t.Errorf("expected 50 GiB, got %d", cfg.CacheMaxSize)
+	}
+	if cfg.CacheHighWatermark != 95 || cfg.CacheLowWatermark != 70 {
+		t.Errorf("unexpected watermarks: high=%d low=%d", cfg.CacheHighWatermark, cfg.CacheLowWatermark)
+	}
+
+	// Low watermark above high watermark
+	os.Setenv("BRIDGE_CACHE_LOW_WATERMARK", "96")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_CACHE_LOW_WATERMARK") {
+		t.Errorf("expected error about low watermark, got: %v", err)
+	}
+}
+
+func TestLoad_WarmupBudget(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.WarmupBudget != 10 || cfg.WarmupUserBudget != 5 {
+		t.Errorf("unexpected defaults: budget=%d user budget=%d", cfg.WarmupBudget, cfg.WarmupUserBudget)
+	}
+
+	os.Setenv("BRIDGE_WARMUP_BUDGET", "0")
+	os.Setenv("BRIDGE_WARMUP_USER_BUDGET", "3")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.WarmupBudget != 0 || cfg.WarmupUserBudget != 3 {
+		t.Errorf("unexpected budgets: budget=%d user budget=%d", cfg.WarmupBudget, cfg.WarmupUserBudget)
+	}
+
+	os.Setenv("BRIDGE_WARMUP_USER_BUDGET", "-1")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_WARMUP_USER_BUDGET") {
+		t.Errorf("expected error about warmup user budget, got: %v", err)
+	}
+}
+
+func TestLoad_Background(t *testing.T) {
+	clearEnv()
+	setRequiredEnv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.BackgroundHours) != 0 || cfg.BackgroundWorkers != 1 || cfg.BackgroundNice != 10 || cfg.BackgroundIOClass != "best-effort" {
+		t.Errorf("unexpected defaults: %+v %d %d %s", cfg.BackgroundHours, cfg.BackgroundWorkers, cfg.BackgroundNice, cfg.BackgroundIOClass)
+	}
+	if !cfg.BackgroundAllowed(time.Date(2024, 1, 1, 18, 0, 0, 0, time.Local)) {
+		t.Error("expected background jobs to be allowed without hours")
+	}
+
+	// One worker is kept free for interactive jobs
+	os.Setenv("BRIDGE_TRANSCODE_WORKERS", "4")
+	if cfg, _ := Load(); cfg == nil || cfg.BackgroundWorkers != 3 {
+		t.Errorf("expected 3 background workers, got %+v", cfg)
+	}
+	os.Unsetenv("BRIDGE_TRANSCODE_WORKERS")
+
+	os.Setenv("BRIDGE_BACKGROUND_HOURS", "22:00-06:00, 13:00-15:30")
+	os.Setenv("BRIDGE_BACKGROUND_WORKERS", "1")
+	os.Setenv("BRIDGE_BACKGROUND_NICE", "0")
+	os.Setenv("BRIDGE_BACKGROUND_IO_PRIORITY", "IDLE")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.BackgroundWorkers != 1 || cfg.BackgroundNice != 0 || cfg.BackgroundIOClass != "idle" {
+		t.Errorf("unexpected values: %d %d %s", cfg.BackgroundWorkers, cfg.BackgroundNice, cfg.BackgroundIOClass)
+	}
+
+	tests := []struct {
+		hour, minute int
+		allowed      bool
+	}{
+		{23, 0, true},
+		{2, 30, true},
+		{6, 0, false},
+		{12, 59, false},
+		{13, 0, true},
+		{15, 29, true},
+		{15, 30, false},
+		{21, 59, false},
+	}
+	for _, tt := range tests {
+		at := time.Date(2024, 1, 1, tt.hour, tt.minute, 0, 0, time.Local)
+		if got := cfg.BackgroundAllowed(at); got != tt.allowed {
+			t.Errorf("BackgroundAllowed(%02d:%02d) = %v, expected %v", tt.hour, tt.minute, got, tt.allowed)
+		}
+	}
+
+	for _, invalid := range []string{"22:00", "25:00-06:00", "22:00-06:60", "evening"} {
+		os.Setenv("BRIDGE_BACKGROUND_HOURS", invalid)
+		if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_BACKGROUND_HOURS") {
+			t.Errorf("expected error about background hours for %q, got: %v", invalid, err)
+		}
+	}
+	os.Setenv("BRIDGE_BACKGROUND_HOURS", "")
+
+	os.Setenv("BRIDGE_BACKGROUND_NICE", "20")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BRIDGE_BACKGROUND_NICE") {
+		t.Errorf("expected error about niceness, got: %v", err)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"1024", 1024, false},
+		{"500M", 500 << 20, false},
+		{"500MB", 500 << 20, false},
+		{"1.5G", 3 << 29, false},
+		{"2GiB", 2 << 30, false},
+		{"1t", 1 << 40, false},
+		{"abc", 0, true},
+		{"-1G", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("parseByteSize(%q) = %d, expected %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
 func TestDatabasePath(t *testing.T) {
 	clearEnv()
 	setRequiredEnv()
@@ -0,0 +1,323 @@
+// Package pathmap derives local paths for Audiobookshelf library folders.
+//
+// ABS reports media paths as seen inside its own container (e.g. /audiobooks),
+// while the bridge usually mounts the same files somewhere below MediaDir.
+// Instead of requiring hand-written prefix mappings, the resolver takes a
+// sample file of each library folder and probes candidate local roots until
+// the sample is found.
+package pathmap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// sampleItemLimit is the number of library items checked for a sample file.
+const sampleItemLimit = 50
+
+// resolveTimeout bounds a single resolve run.
+const resolveTimeout = 2 * time.Minute
+
+// TokenDecrypter decrypts ABS tokens from session storage.
+type TokenDecrypter interface {
+	DecryptToken(encrypted []byte) (string, error)
+}
+
+// Resolver derives and persists path mappings for ABS library folders.
+// Verified mappings take precedence over the configured mapping.
+type Resolver struct {
+	mappingStore *store.PathMappingStore
+	sessionStore *store.SessionStore
+	absClient    *abs.Client
+	tokenDecrypt TokenDecrypter
+	mediaDir     string
+	fallback     func(absPath string) string
+	cancel       context.CancelFunc
+
+	resolving sync.Mutex // held while a resolve run is in progress
+
+	mu       sync.RWMutex
+	mappings []*store.PathMapping // verified mappings, longest ABS path first
+}
+
+// NewResolver creates a new path mapping resolver and loads the persisted mappings.
+// fallback maps paths that are not covered by a verified mapping, usually
+// Config.MapABSPathToLocal.
+func NewResolver(
+	mappingStore *store.PathMappingStore,
+	sessionStore *store.SessionStore,
+	absClient *abs.Client,
+	tokenDecrypt TokenDecrypter,
+	mediaDir string,
+	fallback func(absPath string) string,
+) *Resolver {
+	r := &Resolver{
+		mappingStore: mappingStore,
+		sessionStore: sessionStore,
+		absClient:    absClient,
+		tokenDecrypt: tokenDecrypt,
+		mediaDir:     mediaDir,
+		fallback:     fallback,
+	}
+
+	if err := r.load(); err != nil {
+		slog.Warn("failed to load path mappings", "error", err)
+	}
+	return r
+}
+
+// Start resolves the mappings in the background with the token of an active session.
+// Without an active session, mappings are resolved at the next login.
+func (r *Resolver) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	go func() {
+		sessions, err := r.sessionStore.ListActive()
+		if err != nil {
+			slog.Error("failed to get active sessions", "error", err)
+			return
+		}
+		if len(sessions) == 0 {
+			slog.Debug("no active sessions, resolving path mappings at next login")
+			return
+		}
+
+		token, err := r.tokenDecrypt.DecryptToken(sessions[0].ABSTokenEnc)
+		if err != nil {
+			slog.Error("failed to decrypt token", "error", err)
+			return
+		}
+
+		r.OnLogin(ctx, r.absClient.WithToken(token))
+	}()
+}
+
+// Stop cancels a running resolve.
+func (r *Resolver) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Map converts an ABS media path to a local filesystem path.
+func (r *Resolver) Map(absPath string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, m := range r.mappings {
+		if rel, ok := relativeTo(absPath, m.ABSPath); ok {
+			return m.LocalPath + rel
+		}
+	}
+	return r.fallback(absPath)
+}
+
+// List returns the persisted mappings of all library folders.
+func (r *Resolver) List() ([]*store.PathMapping, error) {
+	return r.mappingStore.List()
+}
+
+// OnLogin resolves the mappings with the client of a user who just logged in.
+// Failures are only logged, as playback falls back to the configured mapping.
+func (r *Resolver) OnLogin(ctx context.Context, client *abs.Client) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	if err := r.Resolve(ctx, client); err != nil {
+		slog.Warn("failed to resolve path mappings", "error", err)
+	}
+}
+
+// Resolve fetches the library folders from ABS, probes a local path for each
+// folder and persists the results. Runs are not repeated concurrently.
+func (r *Resolver) Resolve(ctx context.Context, client *abs.Client) error {
+	if !r.resolving.TryLock() {
+		slog.Debug("path mappings are already being resolved")
+		return nil
+	}
+	defer r.resolving.Unlock()
+
+	libraries, err := client.GetLibraries(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get libraries: %w", err)
+	}
+
+	var libraryIDs, folderIDs []string
+	for _, lib := range libraries {
+		libraryIDs = append(libraryIDs, lib.ID)
+		for _, folder := range lib.Folders {
+			m := r.resolveFolder(ctx, client, lib, folder)
+			if err := r.mappingStore.Upsert(m); err != nil {
+				return fmt.Errorf("failed to save path mapping: %w", err)
+			}
+			folderIDs = append(folderIDs, folder.ID)
+
+			slog.Info("resolved path mapping",
+				"library", lib.Name,
+				"abs_path", m.ABSPath,
+				"local_path", m.LocalPath,
+				"sample_found", m.SampleFound,
+			)
+		}
+	}
+
+	if err := r.mappingStore.DeleteExcept(libraryIDs, folderIDs); err != nil {
+		return fmt.Errorf("failed to remove old path mappings: %w", err)
+	}
+
+	return r.load()
+}
+
+// resolveFolder finds the local path of a single library folder.
+func (r *Resolver) resolveFolder(ctx context.Context, client *abs.Client, lib abs.Library, folder abs.Folder) *store.PathMapping {
+	m := &store.PathMapping{
+		FolderID:    folder.ID,
+		LibraryID:   lib.ID,
+		LibraryName: lib.Name,
+		ABSPath:     strings.TrimRight(folder.FullPath, "/"),
+		ResolvedAt:  time.Now(),
+	}
+
+	m.SamplePath = r.findSample(ctx, client, lib.ID, folder.ID, m.ABSPath)
+	if m.SamplePath == "" {
+		// Nothing to verify against, so only report where the folder probably is
+		m.LocalPath = r.firstExistingDir(r.candidates(m.ABSPath))
+		return m
+	}
+
+	rel, _ := relativeTo(m.SamplePath, m.ABSPath)
+	for _, root := range r.candidates(m.ABSPath) {
+		if _, err := os.Stat(root + rel); err == nil {
+			m.LocalPath = root
+			m.SampleFound = true
+			return m
+		}
+	}
+
+	// Show the configured mapping as the path that was expected
+	m.LocalPath = r.fallback(m.ABSPath)
+	return m
+}
+
+// findSample returns the ABS path of an item stored in the given folder.
+func (r *Resolver) findSample(ctx context.Context, client *abs.Client, libraryID, folderID, folderPath string) string {
+	items, err := client.GetLibraryItems(ctx, libraryID, abs.ItemsOptions{Limit: sampleItemLimit})
+	if err != nil {
+		slog.Warn("failed to get sample items", "library_id", libraryID, "error", err)
+		return ""
+	}
+
+	for _, item := range items.Results {
+		if item.FolderID != folderID || item.IsMissing {
+			continue
+		}
+		if _, ok := relativeTo(item.Path, folderPath); ok && item.Path != folderPath {
+			return item.Path
+		}
+	}
+	return ""
+}
+
+// candidates returns the local roots that may contain an ABS folder, in the
+// order they are probed: the configured mapping, MediaDir itself, MediaDir
+// joined with trailing parts of the folder path (e.g. /media/audiobooks for
+// /data/audiobooks) and finally all directories up to two levels below MediaDir.
+func (r *Resolver) candidates(folderPath string) []string {
+	seen := make(map[string]bool)
+	var roots []string
+	add := func(root string) {
+		root = filepath.Clean(root)
+		if !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+
+	add(r.fallback(folderPath))
+	add(r.mediaDir)
+
+	parts := strings.Split(strings.Trim(folderPath, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		add(filepath.Join(r.mediaDir, filepath.Join(parts[i:]...)))
+	}
+
+	for _, dir := range subdirs(r.mediaDir) {
+		add(dir)
+		for _, sub := range subdirs(dir) {
+			add(sub)
+		}
+	}
+
+	return roots
+}
+
+// firstExistingDir returns the first path that is an existing directory.
+func (r *Resolver) firstExistingDir(paths []string) string {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// load reads the verified mappings from the store.
+func (r *Resolver) load() error {
+	all, err := r.mappingStore.List()
+	if err != nil {
+		return err
+	}
+
+	var verified []*store.PathMapping
+	for _, m := range all {
+		if m.SampleFound && m.LocalPath != "" {
+			verified = append(verified, m)
+		}
+	}
+	// Nested folders must match before their parents
+	sort.Slice(verified, func(i, j int) bool {
+		return len(verified[i].ABSPath) > len(verified[j].ABSPath)
+	})
+
+	r.mu.Lock()
+	r.mappings = verified
+	r.mu.Unlock()
+	return nil
+}
+
+// relativeTo returns the part of path below dir, including the leading slash.
+func relativeTo(path, dir string) (string, bool) {
+	if path == dir {
+		return "", true
+	}
+	if strings.HasPrefix(path, dir+"/") {
+		return strings.TrimPrefix(path, dir), true
+	}
+	return "", false
+}
+
+// subdirs returns the directories directly below dir.
+func subdirs(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			dirs = append(dirs, filepath.Join(dir, e.Name()))
+		}
+	}
+	return dirs
+}
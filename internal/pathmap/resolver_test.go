@@ -0,0 +1,191 @@
+package pathmap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func setupResolver(t *testing.T, mediaDir string) (*Resolver, *store.PathMappingStore) {
+	t.Helper()
+
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mappingStore := store.NewPathMappingStore(db)
+
+	// Mimics the default configuration: /audiobooks maps to MediaDir
+	fallback := func(absPath string) string {
+		if strings.HasPrefix(absPath, "/audiobooks") {
+			return mediaDir + strings.TrimPrefix(absPath, "/audiobooks")
+		}
+		return absPath
+	}
+
+	return NewResolver(mappingStore, nil, nil, nil, mediaDir, fallback), mappingStore
+}
+
+func newTestABS(t *testing.T) *abs.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/libraries":
+			w.Write([]byte(`{"libraries": [
+				{"id": "lib-1", "name": "Hörbücher", "mediaType": "book", "folders": [
+					{"id": "fol-1", "fullPath": "/audiobooks"},
+					{"id": "fol-2", "fullPath": "/data/kids"}
+				]},
+				{"id": "lib-2", "name": "Leer", "mediaType": "book", "folders": [
+					{"id": "fol-3", "fullPath": "/empty"}
+				]}
+			]}`))
+		case "/api/libraries/lib-1/items":
+			w.Write([]byte(`{"results": [
+				{"id": "item-1", "folderId": "fol-1", "path": "/audiobooks/Author/Book"},
+				{"id": "item-2", "folderId": "fol-2", "path": "/data/kids/Story.mp3"}
+			], "total": 2}`))
+		case "/api/libraries/lib-2/items":
+			w.Write([]byte(`{"results": [], "total": 0}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return abs.NewClient(server.URL).WithToken("test-token")
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	mediaDir := t.TempDir()
+	os.MkdirAll(filepath.Join(mediaDir, "books", "Author", "Book"), 0755)
+	os.MkdirAll(filepath.Join(mediaDir, "kids"), 0755)
+	os.WriteFile(filepath.Join(mediaDir, "kids", "Story.mp3"), []byte("audio"), 0644)
+
+	resolver, mappingStore := setupResolver(t, mediaDir)
+
+	// Before resolving, the configured mapping is used
+	if got := resolver.Map("/audiobooks/Author/Book/01.mp3"); got != mediaDir+"/Author/Book/01.mp3" {
+		t.Errorf("expected configured mapping, got %s", got)
+	}
+
+	if err := resolver.Resolve(context.Background(), newTestABS(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		absPath  string
+		expected string
+	}{
+		{"/audiobooks/Author/Book/01.mp3", filepath.Join(mediaDir, "books", "Author", "Book", "01.mp3")},
+		{"/data/kids/Story.mp3", filepath.Join(mediaDir, "kids", "Story.mp3")},
+		{"/other/Book.mp3", "/other/Book.mp3"},
+		{"/empty/Book.mp3", "/empty/Book.mp3"},
+	}
+	for _, tt := range tests {
+		if got := resolver.Map(tt.absPath); got != tt.expected {
+			t.Errorf("Map(%s) = %s, expected %s", tt.absPath, got, tt.expected)
+		}
+	}
+
+	mappings, err := mappingStore.List()
+	if err != nil {
+		t.Fatalf("failed to list mappings: %v", err)
+	}
+	if len(mappings) != 3 {
+		t.Fatalf("expected 3 persisted mappings, got %d", len(mappings))
+	}
+	for _, m := range mappings {
+		if m.FolderID == "fol-3" && (m.SampleFound || m.SamplePath != "") {
+			t.Errorf("expected empty folder to be unverified, got %+v", m)
+		}
+	}
+
+	// A new resolver picks up the persisted mappings
+	reloaded := NewResolver(mappingStore, nil, nil, nil, mediaDir, func(p string) string { return p })
+	if got := reloaded.Map("/data/kids/Story.mp3"); got != filepath.Join(mediaDir, "kids", "Story.mp3") {
+		t.Errorf("expected persisted mapping, got %s", got)
+	}
+}
+
+func TestResolver_KeepsUnlistedLibraries(t *testing.T) {
+	mediaDir := t.TempDir()
+	resolver, mappingStore := setupResolver(t, mediaDir)
+
+	// A library the resolving user can't see, resolved earlier by someone else
+	hidden := &store.PathMapping{FolderID: "fol-9", LibraryID: "lib-9", ABSPath: "/private", LocalPath: mediaDir, ResolvedAt: time.Now()}
+	if err := mappingStore.Upsert(hidden); err != nil {
+		t.Fatalf("failed to save mapping: %v", err)
+	}
+
+	if err := resolver.Resolve(context.Background(), newTestABS(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mappings, err := mappingStore.List()
+	if err != nil {
+		t.Fatalf("failed to list mappings: %v", err)
+	}
+	found := false
+	for _, m := range mappings {
+		if m.FolderID == "fol-9" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected mapping of unlisted library to be kept, got %d mappings", len(mappings))
+	}
+}
+
+func TestResolver_SampleNotFound(t *testing.T) {
+	mediaDir := t.TempDir()
+	resolver, mappingStore := setupResolver(t, mediaDir)
+
+	if err := resolver.Resolve(context.Background(), newTestABS(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mappings, _ := mappingStore.List()
+	for _, m := range mappings {
+		if m.SampleFound {
+			t.Errorf("expected no verified mapping, got %+v", m)
+		}
+	}
+
+	// Unverified mappings are not used for playback
+	if got := resolver.Map("/audiobooks/Author/Book/01.mp3"); got != mediaDir+"/Author/Book/01.mp3" {
+		t.Errorf("expected configured mapping, got %s", got)
+	}
+}
+
+func TestRelativeTo(t *testing.T) {
+	tests := []struct {
+		path, dir string
+		rel       string
+		ok        bool
+	}{
+		{"/audiobooks/Book", "/audiobooks", "/Book", true},
+		{"/audiobooks", "/audiobooks", "", true},
+		{"/audiobooks2/Book", "/audiobooks", "", false},
+		{"/other", "/audiobooks", "", false},
+	}
+
+	for _, tt := range tests {
+		rel, ok := relativeTo(tt.path, tt.dir)
+		if rel != tt.rel || ok != tt.ok {
+			t.Errorf("relativeTo(%q, %q) = %q, %v; expected %q, %v", tt.path, tt.dir, rel, ok, tt.rel, tt.ok)
+		}
+	}
+}
@@ -51,6 +51,11 @@ func NewAVTransport(deviceIP string) *AVTransport {
 	}
 }
 
+// DeviceIP returns the IP address of the device the commands are sent to.
+func (t *AVTransport) DeviceIP() string {
+	return t.deviceIP
+}
+
 // SetAVTransportURI sets the URI to play.
 func (t *AVTransport) SetAVTransportURI(ctx context.Context, uri string, metadata string) error {
 	action := "SetAVTransportURI"
@@ -0,0 +1,125 @@
+package sonos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConnectionManager provides access to the UPnP ConnectionManager service,
+// which reports the audio formats a player accepts.
+type ConnectionManager struct {
+	ip         string
+	httpClient *http.Client
+}
+
+// ConnectionManager service constants.
+const (
+	ConnectionManagerServicePath = "/MediaRenderer/ConnectionManager/Control"
+	ConnectionManagerNamespace   = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// NewConnectionManager creates a new ConnectionManager client.
+func NewConnectionManager(ip string) *ConnectionManager {
+	return &ConnectionManager{
+		ip: ip,
+		httpClient: &http.Client{
+			Timeout: DefaultTimeout,
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   5 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				ResponseHeaderTimeout: 5 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+	}
+}
+
+// GetSinkFormats returns the MIME types the player accepts over HTTP,
+// as advertised in the Sink list of GetProtocolInfo.
+func (c *ConnectionManager) GetSinkFormats(ctx context.Context) ([]string, error) {
+	action := "GetProtocolInfo"
+	body := fmt.Sprintf(`
+		<u:GetProtocolInfo xmlns:u="%s">
+		</u:GetProtocolInfo>`,
+		ConnectionManagerNamespace,
+	)
+
+	resp, err := c.sendCommand(ctx, action, body)
+	if err != nil {
+		return nil, fmt.Errorf("GetProtocolInfo failed: %w", err)
+	}
+
+	return parseSinkFormats(extractString(resp, "Sink")), nil
+}
+
+// parseSinkFormats extracts the MIME types of http-get entries from a
+// protocol info list like "http-get:*:audio/mpeg:*,x-rincon:*:*:*".
+func parseSinkFormats(sink string) []string {
+	var formats []string
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(sink, ",") {
+		// protocol:network:contentFormat:additionalInfo
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 4)
+		if len(parts) < 3 || parts[0] != "http-get" || parts[2] == "*" {
+			continue
+		}
+
+		mimeType := strings.ToLower(parts[2])
+		if !seen[mimeType] {
+			seen[mimeType] = true
+			formats = append(formats, mimeType)
+		}
+	}
+
+	return formats
+}
+
+// sendCommand sends a SOAP command to the ConnectionManager service.
+func (c *ConnectionManager) sendCommand(ctx context.Context, action string, body string) (string, error) {
+	soapBody := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+		<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+			<s:Body>%s</s:Body>
+		</s:Envelope>`, body)
+
+	url := fmt.Sprintf("http://%s:1400%s", c.ip, ConnectionManagerServicePath)
+	slog.Debug("sending ConnectionManager command", "action", action, "ip", c.ip)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString(soapBody))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, ConnectionManagerNamespace, action))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("SOAP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("ConnectionManager SOAP error",
+			"status", resp.StatusCode,
+			"action", action,
+			"response", string(responseBody))
+		return "", fmt.Errorf("SOAP error: %d - %s", resp.StatusCode, string(responseBody))
+	}
+
+	return string(responseBody), nil
+}
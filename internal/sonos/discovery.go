@@ -107,6 +107,7 @@ func (d *Discovery) Discover(ctx context.Context, timeout time.Duration) ([]Devi
 			IsReachable:  true,
 			IsHidden:     isHidden,
 			GroupSize:    groupSize,
+			SinkFormats:  d.getSinkFormats(ctx, device),
 			DiscoveredAt: time.Now(),
 			LastSeenAt:   time.Now(),
 		}
@@ -171,6 +172,21 @@ func (d *Discovery) getZoneInfo(ctx context.Context, devices []Device) (invisibl
 	return
 }
 
+// getSinkFormats queries the audio formats a device accepts.
+// Returns nil on failure, which keeps the previously stored formats.
+func (d *Discovery) getSinkFormats(ctx context.Context, device Device) []string {
+	formats, err := NewConnectionManager(device.IPAddress).GetSinkFormats(ctx)
+	if err != nil {
+		slog.Warn("failed to get sink formats",
+			"device", device.Name,
+			"error", err)
+		return nil
+	}
+
+	slog.Debug("device sink formats", "device", device.Name, "formats", formats)
+	return formats
+}
+
 // ssdpSearch performs an SSDP M-SEARCH and returns discovered device locations.
 func (d *Discovery) ssdpSearch(ctx context.Context, timeout time.Duration) ([]string, error) {
 	// Create UDP socket
@@ -137,6 +137,25 @@ func TestExtractInt(t *testing.T) {
 	}
 }
 
+func TestParseSinkFormats(t *testing.T) {
+	sink := "http-get:*:audio/mpeg:*,http-get:*:audio/MP4:*,x-rincon:*:*:*,http-get:*:*:*,http-get:*:audio/mpeg:DLNA.ORG_PN=MP3,http-get:*:audio/flac:*"
+
+	formats := parseSinkFormats(sink)
+	expected := []string{"audio/mpeg", "audio/mp4", "audio/flac"}
+	if len(formats) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, formats)
+	}
+	for i, f := range expected {
+		if formats[i] != f {
+			t.Errorf("expected format %d to be %s, got %s", i, f, formats[i])
+		}
+	}
+
+	if got := parseSinkFormats(""); len(got) != 0 {
+		t.Errorf("expected no formats for empty sink, got %v", got)
+	}
+}
+
 func TestAVTransport_MockServer(t *testing.T) {
 	// Create a mock Sonos SOAP server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,22 +19,33 @@ const (
 	CacheStatusFailed     CacheStatus = "failed"
 )
 
+// Source types record where the audio of a cache entry was read from.
+const (
+	SourceTypeLocal = "local" // Mapped path on the local media volume
+	SourceTypeABS   = "abs"   // Downloaded through the ABS file API
+)
+
 // CacheEntry represents an entry in the cache index.
 type CacheEntry struct {
-	ItemID             string
-	SourcePath         string
-	SourceSize         int64
-	SourceMtime        time.Time
-	ProfileVersion     string
-	CachePath          string
-	CacheFormat        string // "mp3", "mp4", "flac", "ogg" - the actual output format
-	DurationSec        *int   // nil if not yet known
-	SegmentCount       int    // Number of segments (1 for single-file, >1 for segmented)
-	SegmentDurationSec int    // Duration of each segment in seconds (e.g., 7200 for 2h)
-	Status             CacheStatus
-	ErrorText          string
-	CreatedAt          time.Time
-	UpdatedAt          time.Time
+	ItemID         string
+	SourcePath     string
+	SourcePaths    []string // All source files in playback order (nil for older entries)
+	SourceSize     int64
+	SourceMtime    time.Time
+	Fingerprint    string // Digest of the source files' inodes, sizes and mtimes ("" for older entries)
+	ProfileVersion string
+	CachePath      string
+	CacheFormat    string // "mp3", "mp4", "flac", "ogg" - the actual output format
+	DurationSec    *int   // nil if not yet known
+	SegmentCount   int    // Number of segments (1 for single-file, >1 for segmented)
+	SegmentStarts  []int  // Start offset of each segment in seconds (nil for single-file)
+	SourceType     string // SourceTypeLocal or SourceTypeABS
+	Status         CacheStatus
+	ErrorText      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	LastAccessedAt time.Time // Last time the entry was streamed (zero if never)
+	Passthrough    bool      // Served from the source file at CachePath instead of a cached copy
 }
 
 // IsSegmented returns true if the cache entry uses multiple segments.
@@ -66,19 +79,127 @@ func (e *CacheEntry) GetSegmentFileName(segmentIndex int) string {
 	return fmt.Sprintf("segment_%03d%s", segmentIndex, ext)
 }
 
+// episodeSeparator joins item and episode ID in a cache key. ABS IDs only
+// contain letters, digits, "-" and "_", so the key can be split again.
+const episodeSeparator = "~"
+
+// CacheKey returns the cache index key for an item.
+// Podcast episodes are cached individually, so their key combines item and episode ID.
+func CacheKey(itemID, episodeID string) string {
+	if episodeID == "" {
+		return itemID
+	}
+	return itemID + episodeSeparator + episodeID
+}
+
+// SplitCacheKey splits a cache key (without variant) into the item and
+// episode ID. The episode ID is empty for items that aren't episodes.
+func SplitCacheKey(cacheKey string) (itemID, episodeID string) {
+	itemID, episodeID, _ = strings.Cut(cacheKey, episodeSeparator)
+	return itemID, episodeID
+}
+
+// VariantKey returns the cache index key for a variant of an item, e.g. the
+// same book transcoded with another profile. The default variant uses the
+// plain cache key.
+func VariantKey(cacheKey, variant string) string {
+	if variant == "" {
+		return cacheKey
+	}
+	return cacheKey + "@" + variant
+}
+
+// SplitVariantKey splits a cache index key into the item's cache key and
+// the variant.
+func SplitVariantKey(key string) (cacheKey, variant string) {
+	cacheKey, variant, _ = strings.Cut(key, "@")
+	return cacheKey, variant
+}
+
 // GlobalToSegment converts a global position to segment index and local position.
-func GlobalToSegment(globalPosSec, segmentDurationSec int) (segmentIndex int, localPosSec int) {
-	if segmentDurationSec <= 0 {
+// Segments start at the given offsets; without offsets the output is a single file.
+func GlobalToSegment(globalPosSec int, segmentStarts []int) (segmentIndex int, localPosSec int) {
+	for i := len(segmentStarts) - 1; i > 0; i-- {
+		if globalPosSec >= segmentStarts[i] {
+			return i, globalPosSec - segmentStarts[i]
+		}
+	}
+	if len(segmentStarts) == 0 {
 		return 0, globalPosSec
 	}
-	segmentIndex = globalPosSec / segmentDurationSec
-	localPosSec = globalPosSec % segmentDurationSec
-	return
+	return 0, globalPosSec - segmentStarts[0]
 }
 
 // SegmentToGlobal converts segment index and local position to global position.
-func SegmentToGlobal(segmentIndex, localPosSec, segmentDurationSec int) int {
-	return segmentIndex*segmentDurationSec + localPosSec
+func SegmentToGlobal(segmentIndex, localPosSec int, segmentStarts []int) int {
+	if segmentIndex < 0 || segmentIndex >= len(segmentStarts) {
+		return localPosSec
+	}
+	return segmentStarts[segmentIndex] + localPosSec
+}
+
+// SegmentLength returns the length of a segment in seconds, or 0 for the last
+// segment, which ends with the output.
+func SegmentLength(segmentIndex int, segmentStarts []int) int {
+	if segmentIndex < 0 || segmentIndex+1 >= len(segmentStarts) {
+		return 0
+	}
+	return segmentStarts[segmentIndex+1] - segmentStarts[segmentIndex]
+}
+
+// FixedSegmentStarts returns the offsets of segments of a fixed duration.
+// Entries created before segments were cut at chapters use this layout.
+func FixedSegmentStarts(segmentCount, segmentDurationSec int) []int {
+	if segmentCount <= 1 || segmentDurationSec <= 0 {
+		return nil
+	}
+	starts := make([]int, segmentCount)
+	for i := range starts {
+		starts[i] = i * segmentDurationSec
+	}
+	return starts
+}
+
+// joinSegmentStarts formats segment offsets for the segment_starts column.
+func joinSegmentStarts(starts []int) string {
+	parts := make([]string, len(starts))
+	for i, start := range starts {
+		parts[i] = strconv.Itoa(start)
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitSegmentStarts parses the segment_starts column.
+func splitSegmentStarts(s string) []int {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	starts := make([]int, 0, len(parts))
+	for _, part := range parts {
+		start, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// splitSourcePaths parses the source_paths column, which holds one path per line.
+func splitSourcePaths(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// segmentCountFor returns the segment_count to store for a layout.
+func segmentCountFor(starts []int) int {
+	if len(starts) > 1 {
+		return len(starts)
+	}
+	return 1
 }
 
 // CacheStore provides CRUD operations for the cache index.
@@ -94,8 +215,8 @@ func NewCacheStore(db *DB) *CacheStore {
 // Create inserts a new cache entry.
 func (s *CacheStore) Create(entry *CacheEntry) error {
 	query := `
-		INSERT INTO cache_index (item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, status, error_text, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO cache_index (item_id, source_path, source_size, source_mtime, source_fingerprint, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_starts, source_type, status, error_text, created_at, updated_at, source_paths)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	now := time.Now().Unix()
 	cacheFormat := entry.CacheFormat
@@ -106,21 +227,28 @@ func (s *CacheStore) Create(entry *CacheEntry) error {
 	if segmentCount == 0 {
 		segmentCount = 1 // default for single-file cache
 	}
+	sourceType := entry.SourceType
+	if sourceType == "" {
+		sourceType = SourceTypeLocal
+	}
 	_, err := s.db.Exec(query,
 		entry.ItemID,
 		entry.SourcePath,
 		entry.SourceSize,
 		entry.SourceMtime.Unix(),
+		entry.Fingerprint,
 		entry.ProfileVersion,
 		entry.CachePath,
 		cacheFormat,
 		entry.DurationSec,
 		segmentCount,
-		entry.SegmentDurationSec,
+		joinSegmentStarts(entry.SegmentStarts),
+		sourceType,
 		string(entry.Status),
 		entry.ErrorText,
 		now,
 		now,
+		strings.Join(entry.SourcePaths, "\n"),
 	)
 	return err
 }
@@ -128,16 +256,19 @@ func (s *CacheStore) Create(entry *CacheEntry) error {
 // Get retrieves a cache entry by item ID.
 func (s *CacheStore) Get(itemID string) (*CacheEntry, error) {
 	query := `
-		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, status, error_text, created_at, updated_at
+		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, COALESCE(segment_starts, ''), COALESCE(source_fingerprint, ''), source_type, status, error_text, created_at, updated_at, last_accessed_at, COALESCE(source_paths, ''), COALESCE(passthrough, 0)
 		FROM cache_index WHERE item_id = ?
 	`
 	row := s.db.QueryRow(query, itemID)
 
 	var entry CacheEntry
 	var sourceMtime, createdAt, updatedAt int64
+	var lastAccessedAt sql.NullInt64
 	var durationSec sql.NullInt64
 	var segmentCount sql.NullInt64
 	var segmentDurationSec sql.NullInt64
+	var segmentStarts, sourcePaths string
+	var sourceType sql.NullString
 	var cacheFormat sql.NullString
 	var errorText sql.NullString
 	var status string
@@ -153,10 +284,16 @@ func (s *CacheStore) Get(itemID string) (*CacheEntry, error) {
 		&durationSec,
 		&segmentCount,
 		&segmentDurationSec,
+		&segmentStarts,
+		&entry.Fingerprint,
+		&sourceType,
 		&status,
 		&errorText,
 		&createdAt,
 		&updatedAt,
+		&lastAccessedAt,
+		&sourcePaths,
+		&entry.Passthrough,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -186,8 +323,18 @@ func (s *CacheStore) Get(itemID string) (*CacheEntry, error) {
 	} else {
 		entry.SegmentCount = 1 // default for old entries
 	}
-	if segmentDurationSec.Valid {
-		entry.SegmentDurationSec = int(segmentDurationSec.Int64)
+	entry.SegmentStarts = splitSegmentStarts(segmentStarts)
+	entry.SourcePaths = splitSourcePaths(sourcePaths)
+	if entry.SegmentStarts == nil && segmentDurationSec.Valid {
+		entry.SegmentStarts = FixedSegmentStarts(entry.SegmentCount, int(segmentDurationSec.Int64))
+	}
+	if sourceType.Valid && sourceType.String != "" {
+		entry.SourceType = sourceType.String
+	} else {
+		entry.SourceType = SourceTypeLocal // default for old entries
+	}
+	if lastAccessedAt.Valid && lastAccessedAt.Int64 > 0 {
+		entry.LastAccessedAt = time.Unix(lastAccessedAt.Int64, 0)
 	}
 
 	return &entry, nil
@@ -202,22 +349,62 @@ func (s *CacheStore) UpdateStatus(itemID string, status CacheStatus, errorText s
 
 // MarkReady marks a cache entry as ready and sets the duration.
 func (s *CacheStore) MarkReady(itemID string, durationSec int) error {
-	query := `UPDATE cache_index SET status = ?, duration_sec = ?, error_text = '', updated_at = ? WHERE item_id = ?`
+	query := `UPDATE cache_index SET status = ?, duration_sec = ?, passthrough = 0, error_text = '', updated_at = ? WHERE item_id = ?`
 	_, err := s.db.Exec(query, string(CacheStatusReady), durationSec, time.Now().Unix(), itemID)
 	return err
 }
 
 // MarkReadyWithFormat marks a cache entry as ready and sets the duration and format.
 func (s *CacheStore) MarkReadyWithFormat(itemID string, durationSec int, cacheFormat string) error {
-	query := `UPDATE cache_index SET status = ?, duration_sec = ?, cache_format = ?, error_text = '', updated_at = ? WHERE item_id = ?`
+	query := `UPDATE cache_index SET status = ?, duration_sec = ?, cache_format = ?, passthrough = 0, error_text = '', updated_at = ? WHERE item_id = ?`
 	_, err := s.db.Exec(query, string(CacheStatusReady), durationSec, cacheFormat, time.Now().Unix(), itemID)
 	return err
 }
 
 // MarkReadyWithSegments marks a cache entry as ready with segment information.
-func (s *CacheStore) MarkReadyWithSegments(itemID string, durationSec int, cacheFormat string, segmentCount int, segmentDurationSec int) error {
-	query := `UPDATE cache_index SET status = ?, duration_sec = ?, cache_format = ?, segment_count = ?, segment_duration_sec = ?, error_text = '', updated_at = ? WHERE item_id = ?`
-	_, err := s.db.Exec(query, string(CacheStatusReady), durationSec, cacheFormat, segmentCount, segmentDurationSec, time.Now().Unix(), itemID)
+// segmentStarts are the start offsets of the segments (nil for a single file).
+func (s *CacheStore) MarkReadyWithSegments(itemID string, durationSec int, cacheFormat string, segmentStarts []int) error {
+	query := `UPDATE cache_index SET status = ?, duration_sec = ?, cache_format = ?, segment_count = ?, segment_starts = ?, segment_duration_sec = 0, passthrough = 0, error_text = '', updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, string(CacheStatusReady), durationSec, cacheFormat, segmentCountFor(segmentStarts), joinSegmentStarts(segmentStarts), time.Now().Unix(), itemID)
+	return err
+}
+
+// UpdateLayout records the output format and segment layout of an entry
+// without changing its status. Used by progressive encodes, which stream the
+// output before the entry is ready.
+func (s *CacheStore) UpdateLayout(itemID string, durationSec int, cacheFormat string, segmentStarts []int) error {
+	query := `UPDATE cache_index SET duration_sec = ?, cache_format = ?, segment_count = ?, segment_starts = ?, segment_duration_sec = 0, passthrough = 0, updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, durationSec, cacheFormat, segmentCountFor(segmentStarts), joinSegmentStarts(segmentStarts), time.Now().Unix(), itemID)
+	return err
+}
+
+// MarkPassthrough marks a cache entry as ready to be served from the source
+// file at sourcePath, which isn't copied into the cache.
+func (s *CacheStore) MarkPassthrough(itemID string, durationSec int, cacheFormat, sourcePath string) error {
+	query := `UPDATE cache_index SET status = ?, duration_sec = ?, cache_format = ?, cache_path = ?, segment_count = 1, segment_starts = '', segment_duration_sec = 0, passthrough = 1, error_text = '', updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, string(CacheStatusReady), durationSec, cacheFormat, sourcePath, time.Now().Unix(), itemID)
+	return err
+}
+
+// UpdateSourceType records where the audio of a cache entry is read from.
+func (s *CacheStore) UpdateSourceType(itemID string, sourceType string) error {
+	query := `UPDATE cache_index SET source_type = ?, updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, sourceType, time.Now().Unix(), itemID)
+	return err
+}
+
+// UpdateSource records the size, modification time and fingerprint of the
+// source files of an entry.
+func (s *CacheStore) UpdateSource(itemID string, sourceSize int64, sourceMtime time.Time, fingerprint string) error {
+	query := `UPDATE cache_index SET source_size = ?, source_mtime = ?, source_fingerprint = ?, updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, sourceSize, sourceMtime.Unix(), fingerprint, time.Now().Unix(), itemID)
+	return err
+}
+
+// UpdateSourcePaths records the source files of an entry.
+func (s *CacheStore) UpdateSourcePaths(itemID string, sourcePaths []string) error {
+	query := `UPDATE cache_index SET source_paths = ?, updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, strings.Join(sourcePaths, "\n"), time.Now().Unix(), itemID)
 	return err
 }
 
@@ -248,7 +435,7 @@ func (s *CacheStore) Delete(itemID string) error {
 // ListByStatus returns all cache entries with the given status.
 func (s *CacheStore) ListByStatus(status CacheStatus) ([]*CacheEntry, error) {
 	query := `
-		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, status, error_text, created_at, updated_at
+		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, COALESCE(segment_starts, ''), COALESCE(source_fingerprint, ''), source_type, status, error_text, created_at, updated_at, last_accessed_at, COALESCE(source_paths, ''), COALESCE(passthrough, 0)
 		FROM cache_index WHERE status = ? ORDER BY created_at
 	`
 	rows, err := s.db.Query(query, string(status))
@@ -263,7 +450,7 @@ func (s *CacheStore) ListByStatus(status CacheStatus) ([]*CacheEntry, error) {
 // ListAll returns all cache entries.
 func (s *CacheStore) ListAll() ([]*CacheEntry, error) {
 	query := `
-		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, status, error_text, created_at, updated_at
+		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, COALESCE(segment_starts, ''), COALESCE(source_fingerprint, ''), source_type, status, error_text, created_at, updated_at, last_accessed_at, COALESCE(source_paths, ''), COALESCE(passthrough, 0)
 		FROM cache_index ORDER BY created_at
 	`
 	rows, err := s.db.Query(query)
@@ -275,6 +462,33 @@ func (s *CacheStore) ListAll() ([]*CacheEntry, error) {
 	return s.scanEntries(rows)
 }
 
+// ListReadyByLastAccess returns all ready cache entries, least recently played first.
+// Entries that were never streamed are ordered by the time they became ready.
+func (s *CacheStore) ListReadyByLastAccess() ([]*CacheEntry, error) {
+	query := `
+		SELECT item_id, source_path, source_size, source_mtime, profile_version, cache_path, cache_format, duration_sec, segment_count, segment_duration_sec, COALESCE(segment_starts, ''), COALESCE(source_fingerprint, ''), source_type, status, error_text, created_at, updated_at, last_accessed_at, COALESCE(source_paths, ''), COALESCE(passthrough, 0)
+		FROM cache_index WHERE status = ?
+		ORDER BY CASE WHEN COALESCE(last_accessed_at, 0) > 0 THEN last_accessed_at ELSE updated_at END
+	`
+	rows, err := s.db.Query(query, string(CacheStatusReady))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanEntries(rows)
+}
+
+// TouchAccess records that a cache entry was streamed.
+// The timestamp is only written if it is older than resolution, so range
+// requests during playback do not cause a write each.
+func (s *CacheStore) TouchAccess(itemID string, resolution time.Duration) error {
+	now := time.Now()
+	query := `UPDATE cache_index SET last_accessed_at = ? WHERE item_id = ? AND COALESCE(last_accessed_at, 0) <= ?`
+	_, err := s.db.Exec(query, now.Unix(), itemID, now.Add(-resolution).Unix())
+	return err
+}
+
 // ResetInProgressToPending resets all in_progress entries to pending (used on startup).
 func (s *CacheStore) ResetInProgressToPending() (int64, error) {
 	query := `UPDATE cache_index SET status = ? WHERE status = ?`
@@ -300,9 +514,12 @@ func (s *CacheStore) scanEntries(rows *sql.Rows) ([]*CacheEntry, error) {
 	for rows.Next() {
 		var entry CacheEntry
 		var sourceMtime, createdAt, updatedAt int64
+		var lastAccessedAt sql.NullInt64
 		var durationSec sql.NullInt64
 		var segmentCount sql.NullInt64
 		var segmentDurationSec sql.NullInt64
+		var segmentStarts, sourcePaths string
+		var sourceType sql.NullString
 		var cacheFormat sql.NullString
 		var errorText sql.NullString
 		var status string
@@ -318,10 +535,16 @@ func (s *CacheStore) scanEntries(rows *sql.Rows) ([]*CacheEntry, error) {
 			&durationSec,
 			&segmentCount,
 			&segmentDurationSec,
+			&segmentStarts,
+			&entry.Fingerprint,
+			&sourceType,
 			&status,
 			&errorText,
 			&createdAt,
 			&updatedAt,
+			&lastAccessedAt,
+			&sourcePaths,
+			&entry.Passthrough,
 		)
 		if err != nil {
 			return nil, err
@@ -348,8 +571,18 @@ func (s *CacheStore) scanEntries(rows *sql.Rows) ([]*CacheEntry, error) {
 		} else {
 			entry.SegmentCount = 1 // default for old entries
 		}
-		if segmentDurationSec.Valid {
-			entry.SegmentDurationSec = int(segmentDurationSec.Int64)
+		entry.SegmentStarts = splitSegmentStarts(segmentStarts)
+		entry.SourcePaths = splitSourcePaths(sourcePaths)
+		if entry.SegmentStarts == nil && segmentDurationSec.Valid {
+			entry.SegmentStarts = FixedSegmentStarts(entry.SegmentCount, int(segmentDurationSec.Int64))
+		}
+		if sourceType.Valid && sourceType.String != "" {
+			entry.SourceType = sourceType.String
+		} else {
+			entry.SourceType = SourceTypeLocal // default for old entries
+		}
+		if lastAccessedAt.Valid && lastAccessedAt.Int64 > 0 {
+			entry.LastAccessedAt = time.Unix(lastAccessedAt.Int64, 0)
 		}
 		entries = append(entries, &entry)
 	}
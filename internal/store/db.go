@@ -51,6 +51,26 @@ func (db *DB) Conn() *sql.DB {
 	return db.conn
 }
 
+// deleteReturningIDs runs a DELETE ... RETURNING id statement and returns
+// the IDs of the deleted rows.
+func deleteReturningIDs(db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // migrate runs all database migrations.
 func (db *DB) migrate() error {
 	migrations := []string{
@@ -58,6 +78,13 @@ func (db *DB) migrate() error {
 		migrationSonosDevices,
 		migrationCacheIndex,
 		migrationPlaybackSessions,
+		migrationListeningSessions,
+		migrationPathMappings,
+		migrationItemSettings,
+		migrationPinnedItems,
+		migrationTranscodeJobs,
+		migrationTranscodeAttempts,
+		migrationStreamTokenRevocations,
 	}
 
 	for i, m := range migrations {
@@ -126,6 +153,123 @@ func (db *DB) runIncrementalMigrations() error {
 		}
 	}
 
+	// Add segment_starts column to cache_index if not exists
+	// Start offsets of segments cut at chapter boundaries; replaces the fixed
+	// segment_duration_sec, which is only read for older entries
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'segment_starts'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check segment_starts column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding segment_starts column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN segment_starts TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add segment_starts column: %w", err)
+		}
+	}
+
+	// Add source_type column to cache_index if not exists
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'source_type'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check source_type column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding source_type column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN source_type TEXT DEFAULT 'local'`)
+		if err != nil {
+			return fmt.Errorf("failed to add source_type column: %w", err)
+		}
+	}
+
+	// Add last_accessed_at column to cache_index if not exists
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'last_accessed_at'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check last_accessed_at column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding last_accessed_at column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN last_accessed_at INTEGER DEFAULT 0`)
+		if err != nil {
+			return fmt.Errorf("failed to add last_accessed_at column: %w", err)
+		}
+	}
+
+	// Add source_fingerprint column to cache_index if not exists
+	// Identifies the version of the source files an entry was transcoded from
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'source_fingerprint'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check source_fingerprint column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding source_fingerprint column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN source_fingerprint TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add source_fingerprint column: %w", err)
+		}
+	}
+
+	// Add source_paths column to cache_index if not exists
+	// All source files of an entry, for streaming them before they are cached
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'source_paths'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check source_paths column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding source_paths column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN source_paths TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add source_paths column: %w", err)
+		}
+	}
+
+	// Add passthrough column to cache_index if not exists
+	// Entries served from their source file instead of a cached copy
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cache_index') WHERE name = 'passthrough'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check passthrough column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating cache_index: adding passthrough column")
+		_, err := db.conn.Exec(`ALTER TABLE cache_index ADD COLUMN passthrough INTEGER DEFAULT 0`)
+		if err != nil {
+			return fmt.Errorf("failed to add passthrough column: %w", err)
+		}
+	}
+
+	// Add abs_user_type column to sessions if not exists
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = 'abs_user_type'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check abs_user_type column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating sessions: adding abs_user_type column")
+		_, err := db.conn.Exec(`ALTER TABLE sessions ADD COLUMN abs_user_type TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add abs_user_type column: %w", err)
+		}
+	}
+
 	// Add current_segment column to playback_sessions if not exists
 	err = db.conn.QueryRow(`
 		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'current_segment'
@@ -209,6 +353,164 @@ func (db *DB) runIncrementalMigrations() error {
 		}
 	}
 
+	// Add episode_id column to playback_sessions if not exists
+	// Set for podcast episodes (empty for audiobooks)
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'episode_id'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check episode_id column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding episode_id column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN episode_id TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add episode_id column: %w", err)
+		}
+	}
+
+	// Add playlist_id column to playback_sessions if not exists
+	// Set when the item is played as part of an ABS playlist
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'playlist_id'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check playlist_id column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding playlist_id column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN playlist_id TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add playlist_id column: %w", err)
+		}
+	}
+
+	// Add sink_formats column to sonos_devices if not exists
+	// Comma-separated MIME types from the ConnectionManager's GetProtocolInfo
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('sonos_devices') WHERE name = 'sink_formats'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check sink_formats column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating sonos_devices: adding sink_formats column")
+		_, err := db.conn.Exec(`ALTER TABLE sonos_devices ADD COLUMN sink_formats TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add sink_formats column: %w", err)
+		}
+	}
+
+	// Add cache_profile column to playback_sessions if not exists
+	// Transcode profile of the cache variant being streamed
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'cache_profile'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check cache_profile column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding cache_profile column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN cache_profile TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add cache_profile column: %w", err)
+		}
+	}
+
+	// Add playback_speed column to playback_sessions if not exists
+	// Speed of the cache variant being streamed
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'playback_speed'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check playback_speed column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding playback_speed column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN playback_speed REAL DEFAULT 1`)
+		if err != nil {
+			return fmt.Errorf("failed to add playback_speed column: %w", err)
+		}
+	}
+
+	// Add segment_starts column to playback_sessions if not exists
+	// Segment layout of the cache variant being streamed
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'segment_starts'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check playback segment_starts column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding segment_starts column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN segment_starts TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add playback segment_starts column: %w", err)
+		}
+	}
+
+	// Add stream_mode column to playback_sessions if not exists
+	// How the cache variant is streamed: files or an HLS playlist
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'stream_mode'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check stream_mode column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding stream_mode column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN stream_mode TEXT DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add stream_mode column: %w", err)
+		}
+	}
+
+	// Add stream_offset column to playback_sessions if not exists
+	// Start of a live stream on the cache variant's timeline
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('playback_sessions') WHERE name = 'stream_offset'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check stream_offset column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating playback_sessions: adding stream_offset column")
+		_, err := db.conn.Exec(`ALTER TABLE playback_sessions ADD COLUMN stream_offset INTEGER DEFAULT 0`)
+		if err != nil {
+			return fmt.Errorf("failed to add stream_offset column: %w", err)
+		}
+	}
+
+	// Replace revoked_at with revoked_at_ms in stream_token_revocations
+	// Tokens issued in the same second as a revocation must be told apart
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('stream_token_revocations') WHERE name = 'revoked_at_ms'
+	`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check revoked_at_ms column: %w", err)
+	}
+
+	if count == 0 {
+		slog.Info("migrating stream_token_revocations: storing revocation times in milliseconds")
+		// Revocations keep covering all tokens issued within their second
+		_, err := db.conn.Exec(`
+			ALTER TABLE stream_token_revocations ADD COLUMN revoked_at_ms INTEGER NOT NULL DEFAULT 0;
+			UPDATE stream_token_revocations SET revoked_at_ms = revoked_at * 1000 + 999;
+			ALTER TABLE stream_token_revocations DROP COLUMN revoked_at;
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to add revoked_at_ms column: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -277,3 +579,102 @@ CREATE TABLE IF NOT EXISTS playback_sessions (
 CREATE INDEX IF NOT EXISTS idx_playback_session ON playback_sessions(session_id);
 CREATE INDEX IF NOT EXISTS idx_playback_playing ON playback_sessions(is_playing);
 `
+
+// Listening sessions table schema
+// Open Audiobookshelf playback sessions; kept after the playback session is
+// deleted until the ABS session has been closed.
+const migrationListeningSessions = `
+CREATE TABLE IF NOT EXISTS listening_sessions (
+    id TEXT PRIMARY KEY,
+    playback_id TEXT NOT NULL,
+    session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    item_id TEXT NOT NULL,
+    episode_id TEXT NOT NULL DEFAULT '',
+    position_sec INTEGER NOT NULL DEFAULT 0,
+    duration_sec INTEGER NOT NULL DEFAULT 0,
+    unsynced_sec INTEGER NOT NULL DEFAULT 0,
+    started_at INTEGER NOT NULL,
+    last_tick_at INTEGER NOT NULL,
+    last_synced_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_listening_playback ON listening_sessions(playback_id);
+CREATE INDEX IF NOT EXISTS idx_listening_session ON listening_sessions(session_id);
+`
+
+// Path mappings table schema
+// Local paths derived for ABS library folders by probing the media directory.
+const migrationPathMappings = `
+CREATE TABLE IF NOT EXISTS path_mappings (
+    folder_id TEXT PRIMARY KEY,
+    library_id TEXT NOT NULL,
+    library_name TEXT NOT NULL DEFAULT '',
+    abs_path TEXT NOT NULL,
+    local_path TEXT NOT NULL DEFAULT '',
+    sample_path TEXT NOT NULL DEFAULT '',
+    sample_found INTEGER NOT NULL DEFAULT 0,
+    resolved_at INTEGER NOT NULL
+);
+`
+
+// Item settings table schema
+// Per-item overrides of global playback settings.
+const migrationItemSettings = `
+CREATE TABLE IF NOT EXISTS item_settings (
+    item_id TEXT PRIMARY KEY,
+    audio_preset TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL
+);
+`
+
+// Pinned items table schema
+// Items users want cached ahead of playback, keyed by ABS user ID.
+const migrationPinnedItems = `
+CREATE TABLE IF NOT EXISTS pinned_items (
+    user_id TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, item_id)
+);
+`
+
+// Transcode jobs table schema
+// Durable queue of the cache worker, one job per cache key.
+const migrationTranscodeJobs = `
+CREATE TABLE IF NOT EXISTS transcode_jobs (
+    item_id TEXT PRIMARY KEY,
+    priority INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    payload TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_transcode_jobs_next ON transcode_jobs(status, priority, next_attempt_at);
+`
+
+const migrationTranscodeAttempts = `
+CREATE TABLE IF NOT EXISTS transcode_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    error_class TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    log TEXT NOT NULL DEFAULT '',
+    started_at INTEGER NOT NULL,
+    finished_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_transcode_attempts_item ON transcode_attempts(item_id, id);
+`
+
+// Stream token revocations table schema
+// Revoked stream tokens by token ID, playback session or web session.
+const migrationStreamTokenRevocations = `
+CREATE TABLE IF NOT EXISTS stream_token_revocations (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    revoked_at_ms INTEGER NOT NULL,
+    PRIMARY KEY (scope, subject)
+);
+`
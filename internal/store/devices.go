@@ -3,6 +3,7 @@ package store
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 )
 
@@ -14,12 +15,32 @@ type SonosDevice struct {
 	LocationURL  string
 	Model        string
 	IsReachable  bool
-	IsHidden     bool // Hidden devices (stereo pair slaves, non-coordinator group members) are not shown in UI
-	GroupSize    int  // Number of players in this device's group (1 = standalone, >1 = group coordinator)
+	IsHidden     bool     // Hidden devices (stereo pair slaves, non-coordinator group members) are not shown in UI
+	GroupSize    int      // Number of players in this device's group (1 = standalone, >1 = group coordinator)
+	SinkFormats  []string // MIME types the player accepts over HTTP (empty if unknown)
 	DiscoveredAt time.Time
 	LastSeenAt   time.Time
 }
 
+// AcceptsFormat reports whether the device advertised the MIME type as a
+// sink format. Returns false if the sink formats are unknown.
+func (d *SonosDevice) AcceptsFormat(mimeType string) bool {
+	for _, f := range d.SinkFormats {
+		if strings.EqualFold(f, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSinkFormats parses the comma-separated sink_formats column.
+func splitSinkFormats(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
 // DeviceStore provides CRUD operations for Sonos devices.
 type DeviceStore struct {
 	db *sql.DB
@@ -33,8 +54,8 @@ func NewDeviceStore(db *DB) *DeviceStore {
 // Upsert inserts or updates a Sonos device.
 func (s *DeviceStore) Upsert(device *SonosDevice) error {
 	query := `
-		INSERT INTO sonos_devices (uuid, name, ip_address, location_url, model, is_reachable, is_hidden, group_size, discovered_at, last_seen_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO sonos_devices (uuid, name, ip_address, location_url, model, is_reachable, is_hidden, group_size, sink_formats, discovered_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(uuid) DO UPDATE SET
 			name = excluded.name,
 			ip_address = excluded.ip_address,
@@ -43,6 +64,7 @@ func (s *DeviceStore) Upsert(device *SonosDevice) error {
 			is_reachable = excluded.is_reachable,
 			is_hidden = excluded.is_hidden,
 			group_size = excluded.group_size,
+			sink_formats = CASE WHEN excluded.sink_formats = '' THEN sonos_devices.sink_formats ELSE excluded.sink_formats END,
 			last_seen_at = excluded.last_seen_at
 	`
 	isReachable := 0
@@ -67,6 +89,7 @@ func (s *DeviceStore) Upsert(device *SonosDevice) error {
 		isReachable,
 		isHidden,
 		groupSize,
+		strings.Join(device.SinkFormats, ","),
 		device.DiscoveredAt.Unix(),
 		device.LastSeenAt.Unix(),
 	)
@@ -76,13 +99,14 @@ func (s *DeviceStore) Upsert(device *SonosDevice) error {
 // Get retrieves a device by UUID.
 func (s *DeviceStore) Get(uuid string) (*SonosDevice, error) {
 	query := `
-		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), discovered_at, last_seen_at
+		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), COALESCE(sink_formats, ''), discovered_at, last_seen_at
 		FROM sonos_devices WHERE uuid = ?
 	`
 	row := s.db.QueryRow(query, uuid)
 
 	var device SonosDevice
 	var isReachable, isHidden, groupSize int
+	var sinkFormats string
 	var discoveredAt, lastSeenAt int64
 
 	err := row.Scan(
@@ -94,6 +118,7 @@ func (s *DeviceStore) Get(uuid string) (*SonosDevice, error) {
 		&isReachable,
 		&isHidden,
 		&groupSize,
+		&sinkFormats,
 		&discoveredAt,
 		&lastSeenAt,
 	)
@@ -107,6 +132,7 @@ func (s *DeviceStore) Get(uuid string) (*SonosDevice, error) {
 	device.IsReachable = isReachable == 1
 	device.IsHidden = isHidden == 1
 	device.GroupSize = groupSize
+	device.SinkFormats = splitSinkFormats(sinkFormats)
 	device.DiscoveredAt = time.Unix(discoveredAt, 0)
 	device.LastSeenAt = time.Unix(lastSeenAt, 0)
 
@@ -116,7 +142,7 @@ func (s *DeviceStore) Get(uuid string) (*SonosDevice, error) {
 // List returns all visible Sonos devices (excludes hidden devices like stereo pair slaves).
 func (s *DeviceStore) List() ([]*SonosDevice, error) {
 	query := `
-		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), discovered_at, last_seen_at
+		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), COALESCE(sink_formats, ''), discovered_at, last_seen_at
 		FROM sonos_devices WHERE COALESCE(is_hidden, 0) = 0 ORDER BY name
 	`
 	rows, err := s.db.Query(query)
@@ -129,6 +155,7 @@ func (s *DeviceStore) List() ([]*SonosDevice, error) {
 	for rows.Next() {
 		var device SonosDevice
 		var isReachable, isHidden, groupSize int
+		var sinkFormats string
 		var discoveredAt, lastSeenAt int64
 
 		err := rows.Scan(
@@ -140,6 +167,7 @@ func (s *DeviceStore) List() ([]*SonosDevice, error) {
 			&isReachable,
 			&isHidden,
 			&groupSize,
+			&sinkFormats,
 			&discoveredAt,
 			&lastSeenAt,
 		)
@@ -150,6 +178,7 @@ func (s *DeviceStore) List() ([]*SonosDevice, error) {
 		device.IsReachable = isReachable == 1
 		device.IsHidden = isHidden == 1
 		device.GroupSize = groupSize
+		device.SinkFormats = splitSinkFormats(sinkFormats)
 		device.DiscoveredAt = time.Unix(discoveredAt, 0)
 		device.LastSeenAt = time.Unix(lastSeenAt, 0)
 		devices = append(devices, &device)
@@ -161,7 +190,7 @@ func (s *DeviceStore) List() ([]*SonosDevice, error) {
 // ListReachable returns only reachable and visible Sonos devices.
 func (s *DeviceStore) ListReachable() ([]*SonosDevice, error) {
 	query := `
-		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), discovered_at, last_seen_at
+		SELECT uuid, name, ip_address, location_url, model, is_reachable, COALESCE(is_hidden, 0), COALESCE(group_size, 1), COALESCE(sink_formats, ''), discovered_at, last_seen_at
 		FROM sonos_devices WHERE is_reachable = 1 AND COALESCE(is_hidden, 0) = 0 ORDER BY name
 	`
 	rows, err := s.db.Query(query)
@@ -174,6 +203,7 @@ func (s *DeviceStore) ListReachable() ([]*SonosDevice, error) {
 	for rows.Next() {
 		var device SonosDevice
 		var isReachable, isHidden, groupSize int
+		var sinkFormats string
 		var discoveredAt, lastSeenAt int64
 
 		err := rows.Scan(
@@ -185,6 +215,7 @@ func (s *DeviceStore) ListReachable() ([]*SonosDevice, error) {
 			&isReachable,
 			&isHidden,
 			&groupSize,
+			&sinkFormats,
 			&discoveredAt,
 			&lastSeenAt,
 		)
@@ -195,6 +226,7 @@ func (s *DeviceStore) ListReachable() ([]*SonosDevice, error) {
 		device.IsReachable = isReachable == 1
 		device.IsHidden = isHidden == 1
 		device.GroupSize = groupSize
+		device.SinkFormats = splitSinkFormats(sinkFormats)
 		device.DiscoveredAt = time.Unix(discoveredAt, 0)
 		device.LastSeenAt = time.Unix(lastSeenAt, 0)
 		devices = append(devices, &device)
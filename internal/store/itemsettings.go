@@ -0,0 +1,48 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ItemSettingsStore persists per-item overrides of global playback settings.
+type ItemSettingsStore struct {
+	db *sql.DB
+}
+
+// NewItemSettingsStore creates a new item settings store.
+func NewItemSettingsStore(db *DB) *ItemSettingsStore {
+	return &ItemSettingsStore{db: db.Conn()}
+}
+
+// GetAudioPreset returns the audio preset chosen for an item.
+// Returns "" if the item uses the global preset.
+func (s *ItemSettingsStore) GetAudioPreset(itemID string) (string, error) {
+	query := `SELECT audio_preset FROM item_settings WHERE item_id = ?`
+	var preset string
+	err := s.db.QueryRow(query, itemID).Scan(&preset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return preset, err
+}
+
+// SetAudioPreset sets the audio preset of an item.
+// An empty preset removes the override.
+func (s *ItemSettingsStore) SetAudioPreset(itemID string, preset string) error {
+	if preset == "" {
+		_, err := s.db.Exec(`DELETE FROM item_settings WHERE item_id = ?`, itemID)
+		return err
+	}
+
+	query := `
+		INSERT INTO item_settings (item_id, audio_preset, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(item_id) DO UPDATE SET
+			audio_preset = excluded.audio_preset,
+			updated_at = excluded.updated_at
+	`
+	_, err := s.db.Exec(query, itemID, preset, time.Now().Unix())
+	return err
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// JobStatus represents the status of a queued transcode job.
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+)
+
+// TranscodeJob represents a job in the durable transcode queue.
+// There is at most one job per cache key.
+type TranscodeJob struct {
+	ItemID        string // Cache key of the job
+	Priority      int    // Higher priorities are run first
+	Status        JobStatus
+	Attempts      int       // Number of times the job was started
+	NextAttemptAt time.Time // The job isn't run before this time
+	LastError     string    // Error of the last failed attempt
+	Payload       string    // Serialized job
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// JobStore persists the transcode queue.
+type JobStore struct {
+	db *sql.DB
+}
+
+// NewJobStore creates a new job store.
+func NewJobStore(db *DB) *JobStore {
+	return &JobStore{db: db.Conn()}
+}
+
+const jobColumns = `item_id, priority, status, attempts, next_attempt_at, last_error, payload, created_at, updated_at`
+
+// Enqueue adds a job to the queue. If the item is already queued, the job
+// keeps its place and takes the higher of both priorities and the new
+// payload; a higher priority also ends the wait for a retry. Returns false if
+// the item's job is running.
+func (s *JobStore) Enqueue(itemID string, priority int, payload string) (bool, error) {
+	now := time.Now().Unix()
+	query := `
+		INSERT INTO transcode_jobs (item_id, priority, status, attempts, next_attempt_at, payload, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, ?, ?, ?)
+		ON CONFLICT(item_id) DO UPDATE SET
+			priority = MAX(priority, excluded.priority),
+			next_attempt_at = CASE WHEN excluded.priority > priority THEN excluded.next_attempt_at ELSE next_attempt_at END,
+			payload = excluded.payload,
+			updated_at = excluded.updated_at
+		WHERE status = ?
+	`
+	result, err := s.db.Exec(query, itemID, priority, string(JobStatusQueued), now, payload, now, now, string(JobStatusQueued))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Start records a job that is run immediately instead of being queued,
+// replacing a queued job of the item.
+func (s *JobStore) Start(itemID string, priority int, payload string) error {
+	now := time.Now().Unix()
+	query := `
+		INSERT INTO transcode_jobs (item_id, priority, status, attempts, next_attempt_at, payload, created_at, updated_at)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+		ON CONFLICT(item_id) DO UPDATE SET
+			priority = excluded.priority,
+			status = excluded.status,
+			attempts = excluded.attempts,
+			next_attempt_at = excluded.next_attempt_at,
+			last_error = '',
+			payload = excluded.payload,
+			updated_at = excluded.updated_at
+	`
+	_, err := s.db.Exec(query, itemID, priority, string(JobStatusRunning), now, payload, now, now)
+	return err
+}
+
+// Claim marks the queued job with the highest priority of at least
+// minPriority that is due at now as running and returns it. Jobs of equal
+// priority are run in the order they were queued. Returns nil if no job is due.
+func (s *JobStore) Claim(now time.Time, minPriority int) (*TranscodeJob, error) {
+	query := `
+		UPDATE transcode_jobs
+		SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE item_id = (
+			SELECT item_id FROM transcode_jobs
+			WHERE status = ? AND next_attempt_at <= ? AND priority >= ?
+			ORDER BY priority DESC, created_at, rowid
+			LIMIT 1
+		)
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(s.db.QueryRow(query, string(JobStatusRunning), now.Unix(), string(JobStatusQueued), now.Unix(), minPriority))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Get retrieves the job of an item. Returns nil if there is none.
+func (s *JobStore) Get(itemID string) (*TranscodeJob, error) {
+	job, err := scanJob(s.db.QueryRow(`SELECT `+jobColumns+` FROM transcode_jobs WHERE item_id = ?`, itemID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Retry queues a failed job again for nextAttempt.
+func (s *JobStore) Retry(itemID string, nextAttempt time.Time, errorText string) error {
+	query := `UPDATE transcode_jobs SET status = ?, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE item_id = ?`
+	_, err := s.db.Exec(query, string(JobStatusQueued), nextAttempt.Unix(), errorText, time.Now().Unix(), itemID)
+	return err
+}
+
+// Delete removes the job of an item. Returns false if there was none.
+func (s *JobStore) Delete(itemID string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM transcode_jobs WHERE item_id = ?`, itemID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ResetRunning queues all running jobs again (used on startup).
+func (s *JobStore) ResetRunning() (int64, error) {
+	query := `UPDATE transcode_jobs SET status = ?, updated_at = ? WHERE status = ?`
+	result, err := s.db.Exec(query, string(JobStatusQueued), time.Now().Unix(), string(JobStatusRunning))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// List returns all jobs, running jobs first, then in the order they will run.
+func (s *JobStore) List() ([]TranscodeJob, error) {
+	query := `SELECT ` + jobColumns + ` FROM transcode_jobs
+		ORDER BY status = ? DESC, priority DESC, next_attempt_at, created_at, rowid`
+	rows, err := s.db.Query(query, string(JobStatusRunning))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []TranscodeJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// CountQueued returns the number of jobs waiting to run.
+func (s *JobStore) CountQueued() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM transcode_jobs WHERE status = ?`, string(JobStatusQueued)).Scan(&count)
+	return count, err
+}
+
+func scanJob(row rowScanner) (*TranscodeJob, error) {
+	var job TranscodeJob
+	var status string
+	var nextAttemptAt, createdAt, updatedAt int64
+	err := row.Scan(&job.ItemID, &job.Priority, &status, &job.Attempts, &nextAttemptAt, &job.LastError, &job.Payload, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = JobStatus(status)
+	job.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+	job.CreatedAt = time.Unix(createdAt, 0)
+	job.UpdatedAt = time.Unix(updatedAt, 0)
+	return &job, nil
+}
+
+// attemptsPerItem is the number of attempts kept per item; older ones are
+// removed when an attempt is added.
+const attemptsPerItem = 10
+
+// TranscodeAttempt is a finished run of a transcode job with its ffmpeg log.
+type TranscodeAttempt struct {
+	ID         int64
+	ItemID     string
+	Attempt    int    // Number of the run within the job, starting at 1
+	ErrorClass string // Classification of the error, empty if the attempt succeeded
+	Error      string
+	Log        string // Commands and output of all ffmpeg runs; not set by ListAttempts
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Failed reports whether the attempt failed.
+func (a TranscodeAttempt) Failed() bool {
+	return a.Error != ""
+}
+
+// AddAttempt records a finished attempt and removes the oldest attempts of
+// the item beyond the last attemptsPerItem.
+func (s *JobStore) AddAttempt(a *TranscodeAttempt) error {
+	query := `
+		INSERT INTO transcode_attempts (item_id, attempt, error_class, error, log, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := s.db.Exec(query, a.ItemID, a.Attempt, a.ErrorClass, a.Error, a.Log, a.StartedAt.Unix(), a.FinishedAt.Unix())
+	if err != nil {
+		return err
+	}
+	if a.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM transcode_attempts WHERE item_id = ? AND id NOT IN (
+			SELECT id FROM transcode_attempts WHERE item_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`
+	_, err = s.db.Exec(query, a.ItemID, a.ItemID, attemptsPerItem)
+	return err
+}
+
+// GetAttempt retrieves an attempt with its log. Returns nil if there is none.
+func (s *JobStore) GetAttempt(id int64) (*TranscodeAttempt, error) {
+	query := `
+		SELECT id, item_id, attempt, error_class, error, log, started_at, finished_at
+		FROM transcode_attempts WHERE id = ?
+	`
+	var a TranscodeAttempt
+	var startedAt, finishedAt int64
+	err := s.db.QueryRow(query, id).Scan(&a.ID, &a.ItemID, &a.Attempt, &a.ErrorClass, &a.Error, &a.Log, &startedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.StartedAt = time.Unix(startedAt, 0)
+	a.FinishedAt = time.Unix(finishedAt, 0)
+	return &a, nil
+}
+
+// ListAttempts returns the attempts of an item without their logs, newest first.
+func (s *JobStore) ListAttempts(itemID string) ([]TranscodeAttempt, error) {
+	return s.queryAttempts(`WHERE item_id = ? ORDER BY id DESC`, itemID)
+}
+
+// LatestAttempts returns the newest attempt of each item without its log,
+// by item ID.
+func (s *JobStore) LatestAttempts() (map[string]TranscodeAttempt, error) {
+	attempts, err := s.queryAttempts(`WHERE id IN (SELECT MAX(id) FROM transcode_attempts GROUP BY item_id)`)
+	if err != nil {
+		return nil, err
+	}
+
+	byItem := make(map[string]TranscodeAttempt, len(attempts))
+	for _, a := range attempts {
+		byItem[a.ItemID] = a
+	}
+	return byItem, nil
+}
+
+func (s *JobStore) queryAttempts(where string, args ...any) ([]TranscodeAttempt, error) {
+	query := `SELECT id, item_id, attempt, error_class, error, started_at, finished_at FROM transcode_attempts ` + where
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []TranscodeAttempt
+	for rows.Next() {
+		var a TranscodeAttempt
+		var startedAt, finishedAt int64
+		if err := rows.Scan(&a.ID, &a.ItemID, &a.Attempt, &a.ErrorClass, &a.Error, &startedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		a.StartedAt = time.Unix(startedAt, 0)
+		a.FinishedAt = time.Unix(finishedAt, 0)
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
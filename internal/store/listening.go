@@ -0,0 +1,172 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ListeningSession links a bridge playback session to an open Audiobookshelf
+// playback session. Listening time is accumulated locally and reported to ABS
+// on every sync, so it is persisted to survive restarts.
+type ListeningSession struct {
+	ID           string // ABS playback session ID
+	PlaybackID   string // Reference to playback_sessions.id
+	SessionID    string // Reference to sessions.id
+	ItemID       string
+	EpisodeID    string
+	PositionSec  int // Last observed position
+	DurationSec  int // Total duration in seconds
+	UnsyncedSec  int // Listening time not yet reported to ABS
+	StartedAt    time.Time
+	LastTickAt   time.Time
+	LastSyncedAt time.Time
+}
+
+// ListeningStore provides CRUD operations for ABS listening sessions.
+type ListeningStore struct {
+	db *sql.DB
+}
+
+// NewListeningStore creates a new listening session store.
+func NewListeningStore(db *DB) *ListeningStore {
+	return &ListeningStore{db: db.Conn()}
+}
+
+// Create inserts a new listening session.
+func (s *ListeningStore) Create(ls *ListeningSession) error {
+	query := `
+		INSERT INTO listening_sessions (id, playback_id, session_id, item_id, episode_id, position_sec, duration_sec, unsynced_sec, started_at, last_tick_at, last_synced_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query,
+		ls.ID,
+		ls.PlaybackID,
+		ls.SessionID,
+		ls.ItemID,
+		ls.EpisodeID,
+		ls.PositionSec,
+		ls.DurationSec,
+		ls.UnsyncedSec,
+		ls.StartedAt.Unix(),
+		ls.LastTickAt.Unix(),
+		ls.LastSyncedAt.Unix(),
+	)
+	return err
+}
+
+// GetByPlaybackID retrieves the listening session of a playback session.
+func (s *ListeningStore) GetByPlaybackID(playbackID string) (*ListeningSession, error) {
+	query := `
+		SELECT id, playback_id, session_id, item_id, episode_id, position_sec, duration_sec, unsynced_sec, started_at, last_tick_at, last_synced_at
+		FROM listening_sessions WHERE playback_id = ? ORDER BY started_at DESC LIMIT 1
+	`
+	row := s.db.QueryRow(query, playbackID)
+
+	ls, err := scanListeningSession(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ls, nil
+}
+
+// ListBySessionID returns all listening sessions of a web session.
+func (s *ListeningStore) ListBySessionID(sessionID string) ([]*ListeningSession, error) {
+	query := `
+		SELECT id, playback_id, session_id, item_id, episode_id, position_sec, duration_sec, unsynced_sec, started_at, last_tick_at, last_synced_at
+		FROM listening_sessions WHERE session_id = ? ORDER BY started_at DESC
+	`
+	rows, err := s.db.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListeningSessions(rows)
+}
+
+// ListOrphaned returns listening sessions whose playback session no longer exists.
+func (s *ListeningStore) ListOrphaned() ([]*ListeningSession, error) {
+	query := `
+		SELECT id, playback_id, session_id, item_id, episode_id, position_sec, duration_sec, unsynced_sec, started_at, last_tick_at, last_synced_at
+		FROM listening_sessions
+		WHERE playback_id NOT IN (SELECT id FROM playback_sessions)
+		ORDER BY started_at ASC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListeningSessions(rows)
+}
+
+// AddListened adds listening time and records the observed position.
+func (s *ListeningStore) AddListened(id string, seconds int, positionSec int) error {
+	query := `UPDATE listening_sessions SET unsynced_sec = unsynced_sec + ?, position_sec = ?, last_tick_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, seconds, positionSec, time.Now().Unix(), id)
+	return err
+}
+
+// MarkSynced subtracts the listening time reported to ABS and updates the sync timestamp.
+// Time listened while the sync request was in flight is kept for the next sync.
+func (s *ListeningStore) MarkSynced(id string, syncedSec int) error {
+	query := `UPDATE listening_sessions SET unsynced_sec = MAX(unsynced_sec - ?, 0), last_synced_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, syncedSec, time.Now().Unix(), id)
+	return err
+}
+
+// Delete removes a listening session by ID.
+func (s *ListeningStore) Delete(id string) error {
+	query := `DELETE FROM listening_sessions WHERE id = ?`
+	_, err := s.db.Exec(query, id)
+	return err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanListeningSession(row rowScanner) (*ListeningSession, error) {
+	var ls ListeningSession
+	var startedAt, lastTickAt, lastSyncedAt int64
+
+	err := row.Scan(
+		&ls.ID,
+		&ls.PlaybackID,
+		&ls.SessionID,
+		&ls.ItemID,
+		&ls.EpisodeID,
+		&ls.PositionSec,
+		&ls.DurationSec,
+		&ls.UnsyncedSec,
+		&startedAt,
+		&lastTickAt,
+		&lastSyncedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ls.StartedAt = time.Unix(startedAt, 0)
+	ls.LastTickAt = time.Unix(lastTickAt, 0)
+	ls.LastSyncedAt = time.Unix(lastSyncedAt, 0)
+	return &ls, nil
+}
+
+func scanListeningSessions(rows *sql.Rows) ([]*ListeningSession, error) {
+	var sessions []*ListeningSession
+	for rows.Next() {
+		ls, err := scanListeningSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ls)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PathMapping maps an ABS library folder to the local path it was found at.
+type PathMapping struct {
+	FolderID    string // ABS library folder ID
+	LibraryID   string
+	LibraryName string
+	ABSPath     string // Folder path as seen by ABS
+	LocalPath   string // Local path the folder was found at (empty if not found)
+	SamplePath  string // ABS path of the file used to verify the mapping
+	SampleFound bool   // Whether the sample exists below LocalPath
+	ResolvedAt  time.Time
+}
+
+// PathMappingStore provides persistence for derived path mappings.
+type PathMappingStore struct {
+	db *sql.DB
+}
+
+// NewPathMappingStore creates a new path mapping store.
+func NewPathMappingStore(db *DB) *PathMappingStore {
+	return &PathMappingStore{db: db.Conn()}
+}
+
+// Upsert creates or replaces the mapping of a library folder.
+func (s *PathMappingStore) Upsert(m *PathMapping) error {
+	query := `
+		INSERT INTO path_mappings (folder_id, library_id, library_name, abs_path, local_path, sample_path, sample_found, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(folder_id) DO UPDATE SET
+			library_id = excluded.library_id,
+			library_name = excluded.library_name,
+			abs_path = excluded.abs_path,
+			local_path = excluded.local_path,
+			sample_path = excluded.sample_path,
+			sample_found = excluded.sample_found,
+			resolved_at = excluded.resolved_at
+	`
+	sampleFound := 0
+	if m.SampleFound {
+		sampleFound = 1
+	}
+	_, err := s.db.Exec(query,
+		m.FolderID,
+		m.LibraryID,
+		m.LibraryName,
+		m.ABSPath,
+		m.LocalPath,
+		m.SamplePath,
+		sampleFound,
+		m.ResolvedAt.Unix(),
+	)
+	return err
+}
+
+// List returns all path mappings ordered by library and folder path.
+func (s *PathMappingStore) List() ([]*PathMapping, error) {
+	query := `
+		SELECT folder_id, library_id, library_name, abs_path, local_path, sample_path, sample_found, resolved_at
+		FROM path_mappings ORDER BY library_name, abs_path
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []*PathMapping
+	for rows.Next() {
+		var m PathMapping
+		var sampleFound int
+		var resolvedAt int64
+		if err := rows.Scan(
+			&m.FolderID,
+			&m.LibraryID,
+			&m.LibraryName,
+			&m.ABSPath,
+			&m.LocalPath,
+			&m.SamplePath,
+			&sampleFound,
+			&resolvedAt,
+		); err != nil {
+			return nil, err
+		}
+		m.SampleFound = sampleFound == 1
+		m.ResolvedAt = time.Unix(resolvedAt, 0)
+		mappings = append(mappings, &m)
+	}
+	return mappings, rows.Err()
+}
+
+// DeleteExcept removes mappings of folders that no longer exist in the given
+// libraries. Mappings of other libraries are kept, since the user whose
+// token listed the libraries may not have access to all of them.
+func (s *PathMappingStore) DeleteExcept(libraryIDs, folderIDs []string) error {
+	existing, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(libraryIDs))
+	for _, id := range libraryIDs {
+		listed[id] = true
+	}
+	keep := make(map[string]bool, len(folderIDs))
+	for _, id := range folderIDs {
+		keep[id] = true
+	}
+
+	for _, m := range existing {
+		if !listed[m.LibraryID] || keep[m.FolderID] {
+			continue
+		}
+		if _, err := s.db.Exec(`DELETE FROM path_mappings WHERE folder_id = ?`, m.FolderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PinStore persists the items users pinned to keep cached.
+type PinStore struct {
+	db *sql.DB
+}
+
+// NewPinStore creates a new pin store.
+func NewPinStore(db *DB) *PinStore {
+	return &PinStore{db: db.Conn()}
+}
+
+// Pin marks an item as pinned by a user.
+func (s *PinStore) Pin(userID, itemID string) error {
+	query := `
+		INSERT INTO pinned_items (user_id, item_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(user_id, item_id) DO NOTHING
+	`
+	_, err := s.db.Exec(query, userID, itemID, time.Now().Unix())
+	return err
+}
+
+// Unpin removes a user's pin of an item.
+func (s *PinStore) Unpin(userID, itemID string) error {
+	_, err := s.db.Exec(`DELETE FROM pinned_items WHERE user_id = ? AND item_id = ?`, userID, itemID)
+	return err
+}
+
+// IsPinned reports whether a user pinned an item.
+func (s *PinStore) IsPinned(userID, itemID string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pinned_items WHERE user_id = ? AND item_id = ?`, userID, itemID).Scan(&count)
+	return count > 0, err
+}
+
+// ListByUser returns the IDs of the items a user pinned, most recently pinned first.
+func (s *PinStore) ListByUser(userID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT item_id FROM pinned_items WHERE user_id = ? ORDER BY created_at DESC, item_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var itemIDs []string
+	for rows.Next() {
+		var itemID string
+		if err := rows.Scan(&itemID); err != nil {
+			return nil, err
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+	return itemIDs, rows.Err()
+}
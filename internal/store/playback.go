@@ -3,6 +3,7 @@ package store
 import (
 	"database/sql"
 	"errors"
+	"math"
 	"time"
 )
 
@@ -11,17 +12,100 @@ type PlaybackSession struct {
 	ID                  string
 	SessionID           string // Reference to sessions.id
 	ItemID              string
+	EpisodeID           string // Podcast episode ID (empty for audiobooks)
 	SonosUUID           string
 	StreamToken         string
 	PositionSec         int        // Global position in seconds (across all segments)
 	DurationSec         int        // Total duration in seconds
 	CurrentSegment      int        // Current segment index (0-based, for segmented playback)
-	SegmentDurationSec  int        // Duration of each segment (e.g., 7200 for 2h)
+	SegmentStarts       []int      // Start offset of each segment (nil for single-file playback)
 	IsPlaying           bool
 	StartedAt           time.Time
 	LastPositionUpdate  time.Time
 	ABSProgressSyncedAt time.Time
 	SleepAt             *time.Time // Unix timestamp when sleep timer should trigger (nil = no timer)
+	PlaylistID          string     // ABS playlist the item is played from (empty if played individually)
+	Profile             string     // Transcode profile of the streamed cache variant (empty for the default)
+	Speed               float64    // Playback speed of the streamed cache variant (1 = normal)
+	StreamMode          string     // StreamModeFile, StreamModeHLS or StreamModeLive
+	StreamOffset        int        // Start of a live stream on the variant's timeline in seconds
+}
+
+// Stream modes of a playback session.
+const (
+	StreamModeFile = ""     // The cached files, one URI per segment
+	StreamModeHLS  = "hls"  // An HLS playlist over the whole item
+	StreamModeLive = "live" // Transcoded from the source files while streaming, from StreamOffset
+)
+
+// IsHLS reports whether the session streams an HLS playlist.
+func (ps *PlaybackSession) IsHLS() bool {
+	return ps.StreamMode == StreamModeHLS
+}
+
+// IsLive reports whether the session streams a live transcode.
+func (ps *PlaybackSession) IsLive() bool {
+	return ps.StreamMode == StreamModeLive
+}
+
+// CacheKey returns the cache index key of the variant being played.
+func (ps *PlaybackSession) CacheKey() string {
+	return VariantKey(CacheKey(ps.ItemID, ps.EpisodeID), ps.Profile)
+}
+
+// RealPosition converts a position on the timeline of the streamed variant
+// to the item's timeline. Speed variants are shorter than the item.
+func (ps *PlaybackSession) RealPosition(variantSec int) int {
+	if ps.Speed <= 0 || ps.Speed == 1 {
+		return variantSec
+	}
+	return int(math.Round(float64(variantSec) * ps.Speed))
+}
+
+// VariantPosition converts a position on the item's timeline to the timeline
+// of the streamed variant.
+func (ps *PlaybackSession) VariantPosition(realSec int) int {
+	if ps.Speed <= 0 || ps.Speed == 1 {
+		return realSec
+	}
+	return int(math.Round(float64(realSec) / ps.Speed))
+}
+
+// IsSegmented reports whether the streamed variant is split into segments.
+func (ps *PlaybackSession) IsSegmented() bool {
+	return len(ps.SegmentStarts) > 1
+}
+
+// GlobalPosition converts the position reported by the player, which is
+// local to the current segment or live stream, to the position in the item.
+func (ps *PlaybackSession) GlobalPosition(localSec int) int {
+	if ps.IsLive() {
+		return ps.RealPosition(ps.StreamOffset + localSec)
+	}
+	return ps.RealPosition(SegmentToGlobal(ps.CurrentSegment, localSec, ps.SegmentStarts))
+}
+
+// SegmentPosition converts a position in the item to the segment and the
+// position within it to seek the player to.
+func (ps *PlaybackSession) SegmentPosition(realSec int) (segmentIndex int, localSec int) {
+	return GlobalToSegment(ps.VariantPosition(realSec), ps.SegmentStarts)
+}
+
+// speed returns the playback speed to store, defaulting to normal speed.
+func (ps *PlaybackSession) speed() float64 {
+	if ps.Speed <= 0 {
+		return 1
+	}
+	return ps.Speed
+}
+
+// legacySegmentStarts returns the fixed segment layout of sessions stored
+// before segment offsets, which only recorded the segment duration.
+func legacySegmentStarts(durationSec, segmentDurationSec int) []int {
+	if segmentDurationSec <= 0 {
+		return nil
+	}
+	return FixedSegmentStarts(durationSec/segmentDurationSec+1, segmentDurationSec)
 }
 
 // PlaybackStore provides CRUD operations for playback sessions.
@@ -37,8 +121,8 @@ func NewPlaybackStore(db *DB) *PlaybackStore {
 // Create inserts a new playback session.
 func (s *PlaybackStore) Create(ps *PlaybackSession) error {
 	query := `
-		INSERT INTO playback_sessions (id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO playback_sessions (id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_starts, is_playing, started_at, last_position_update, abs_progress_synced_at, episode_id, playlist_id, cache_profile, playback_speed, stream_mode, stream_offset)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	isPlaying := 0
 	if ps.IsPlaying {
@@ -54,11 +138,17 @@ func (s *PlaybackStore) Create(ps *PlaybackSession) error {
 		ps.PositionSec,
 		ps.DurationSec,
 		ps.CurrentSegment,
-		ps.SegmentDurationSec,
+		joinSegmentStarts(ps.SegmentStarts),
 		isPlaying,
 		ps.StartedAt.Unix(),
 		ps.LastPositionUpdate.Unix(),
 		ps.ABSProgressSyncedAt.Unix(),
+		ps.EpisodeID,
+		ps.PlaylistID,
+		ps.Profile,
+		ps.speed(),
+		ps.StreamMode,
+		ps.StreamOffset,
 	)
 	return err
 }
@@ -66,7 +156,7 @@ func (s *PlaybackStore) Create(ps *PlaybackSession) error {
 // Get retrieves a playback session by ID.
 func (s *PlaybackStore) Get(id string) (*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions WHERE id = ?
 	`
 	row := s.db.QueryRow(query, id)
@@ -76,7 +166,7 @@ func (s *PlaybackStore) Get(id string) (*PlaybackSession, error) {
 // GetBySessionID retrieves the active playback session for a web session.
 func (s *PlaybackStore) GetBySessionID(sessionID string) (*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions WHERE session_id = ? ORDER BY started_at DESC LIMIT 1
 	`
 	row := s.db.QueryRow(query, sessionID)
@@ -86,7 +176,7 @@ func (s *PlaybackStore) GetBySessionID(sessionID string) (*PlaybackSession, erro
 // GetByToken retrieves a playback session by stream token.
 func (s *PlaybackStore) GetByToken(token string) (*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions WHERE stream_token = ?
 	`
 	row := s.db.QueryRow(query, token)
@@ -160,10 +250,37 @@ func (s *PlaybackStore) UpdatePositionAndSegment(id string, positionSec int, seg
 	return err
 }
 
+// UpdateProfile switches the session to another cache variant, e.g. after
+// moving playback to a speaker with a different transcode profile or
+// changing the playback speed.
+func (s *PlaybackStore) UpdateProfile(id string, profile string, speed float64, streamMode string, segment int, segmentStarts []int) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	query := `UPDATE playback_sessions SET cache_profile = ?, playback_speed = ?, stream_mode = ?, current_segment = ?, segment_starts = ?, segment_duration_sec = 0, last_position_update = ? WHERE id = ?`
+	_, err := s.db.Exec(query, profile, speed, streamMode, segment, joinSegmentStarts(segmentStarts), time.Now().Unix(), id)
+	return err
+}
+
+// UpdateStreamLayout switches how the session's cache variant is streamed,
+// e.g. after moving playback to a speaker that plays HLS.
+func (s *PlaybackStore) UpdateStreamLayout(id string, streamMode string, segment int, segmentStarts []int) error {
+	query := `UPDATE playback_sessions SET stream_mode = ?, current_segment = ?, segment_starts = ?, segment_duration_sec = 0, last_position_update = ? WHERE id = ?`
+	_, err := s.db.Exec(query, streamMode, segment, joinSegmentStarts(segmentStarts), time.Now().Unix(), id)
+	return err
+}
+
+// UpdateStreamOffset records where the live stream of a session starts.
+func (s *PlaybackStore) UpdateStreamOffset(id string, offsetSec int) error {
+	query := `UPDATE playback_sessions SET stream_offset = ?, last_position_update = ? WHERE id = ?`
+	_, err := s.db.Exec(query, offsetSec, time.Now().Unix(), id)
+	return err
+}
+
 // ListActive returns all currently playing sessions.
 func (s *PlaybackStore) ListActive() ([]*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions WHERE is_playing = 1 ORDER BY started_at DESC
 	`
 	rows, err := s.db.Query(query)
@@ -178,7 +295,7 @@ func (s *PlaybackStore) ListActive() ([]*PlaybackSession, error) {
 // ListAll returns all playback sessions.
 func (s *PlaybackStore) ListAll() ([]*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions ORDER BY started_at DESC
 	`
 	rows, err := s.db.Query(query)
@@ -190,15 +307,11 @@ func (s *PlaybackStore) ListAll() ([]*PlaybackSession, error) {
 	return s.scanRows(rows)
 }
 
-// DeleteStale removes playback sessions older than the given duration.
-func (s *PlaybackStore) DeleteStale(maxAge time.Duration) (int64, error) {
+// DeleteStale removes playback sessions older than the given duration and
+// returns their IDs.
+func (s *PlaybackStore) DeleteStale(maxAge time.Duration) ([]string, error) {
 	cutoff := time.Now().Add(-maxAge).Unix()
-	query := `DELETE FROM playback_sessions WHERE last_position_update < ?`
-	result, err := s.db.Exec(query, cutoff)
-	if err != nil {
-		return 0, err
-	}
-	return result.RowsAffected()
+	return deleteReturningIDs(s.db, `DELETE FROM playback_sessions WHERE last_position_update < ? RETURNING id`, cutoff)
 }
 
 // StopAllPlaying marks all playing sessions as stopped.
@@ -229,7 +342,7 @@ func (s *PlaybackStore) ClearSleepTimer(id string) error {
 // GetSessionsWithActiveTimer returns all sessions that have an active sleep timer.
 func (s *PlaybackStore) GetSessionsWithActiveTimer() ([]*PlaybackSession, error) {
 	query := `
-		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at
+		SELECT id, session_id, item_id, sonos_uuid, stream_token, position_sec, duration_sec, current_segment, segment_duration_sec, is_playing, started_at, last_position_update, abs_progress_synced_at, sleep_at, episode_id, playlist_id, cache_profile, playback_speed, COALESCE(segment_starts, ''), COALESCE(stream_mode, ''), COALESCE(stream_offset, 0)
 		FROM playback_sessions WHERE sleep_at IS NOT NULL ORDER BY sleep_at ASC
 	`
 	rows, err := s.db.Query(query)
@@ -246,6 +359,9 @@ func (s *PlaybackStore) scanRow(row *sql.Row) (*PlaybackSession, error) {
 	var isPlaying int
 	var currentSegment, segmentDurationSec, sleepAt sql.NullInt64
 	var startedAt, lastPositionUpdate, absSyncedAt int64
+	var episodeID, playlistID, profile sql.NullString
+	var speed sql.NullFloat64
+	var segmentStarts, streamMode string
 
 	err := row.Scan(
 		&ps.ID,
@@ -262,6 +378,13 @@ func (s *PlaybackStore) scanRow(row *sql.Row) (*PlaybackSession, error) {
 		&lastPositionUpdate,
 		&absSyncedAt,
 		&sleepAt,
+		&episodeID,
+		&playlistID,
+		&profile,
+		&speed,
+		&segmentStarts,
+		&streamMode,
+		&ps.StreamOffset,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -277,13 +400,22 @@ func (s *PlaybackStore) scanRow(row *sql.Row) (*PlaybackSession, error) {
 	if currentSegment.Valid {
 		ps.CurrentSegment = int(currentSegment.Int64)
 	}
-	if segmentDurationSec.Valid {
-		ps.SegmentDurationSec = int(segmentDurationSec.Int64)
+	ps.SegmentStarts = splitSegmentStarts(segmentStarts)
+	if ps.SegmentStarts == nil && segmentDurationSec.Valid {
+		ps.SegmentStarts = legacySegmentStarts(ps.DurationSec, int(segmentDurationSec.Int64))
 	}
 	if sleepAt.Valid {
 		t := time.Unix(sleepAt.Int64, 0)
 		ps.SleepAt = &t
 	}
+	ps.EpisodeID = episodeID.String
+	ps.PlaylistID = playlistID.String
+	ps.Profile = profile.String
+	ps.Speed = 1
+	if speed.Valid && speed.Float64 > 0 {
+		ps.Speed = speed.Float64
+	}
+	ps.StreamMode = streamMode
 
 	return &ps, nil
 }
@@ -295,6 +427,9 @@ func (s *PlaybackStore) scanRows(rows *sql.Rows) ([]*PlaybackSession, error) {
 		var isPlaying int
 		var currentSegment, segmentDurationSec, sleepAt sql.NullInt64
 		var startedAt, lastPositionUpdate, absSyncedAt int64
+		var episodeID, playlistID, profile sql.NullString
+		var speed sql.NullFloat64
+	var segmentStarts, streamMode string
 
 		err := rows.Scan(
 			&ps.ID,
@@ -311,6 +446,13 @@ func (s *PlaybackStore) scanRows(rows *sql.Rows) ([]*PlaybackSession, error) {
 			&lastPositionUpdate,
 			&absSyncedAt,
 			&sleepAt,
+			&episodeID,
+			&playlistID,
+			&profile,
+			&speed,
+			&segmentStarts,
+			&streamMode,
+			&ps.StreamOffset,
 		)
 		if err != nil {
 			return nil, err
@@ -323,13 +465,22 @@ func (s *PlaybackStore) scanRows(rows *sql.Rows) ([]*PlaybackSession, error) {
 		if currentSegment.Valid {
 			ps.CurrentSegment = int(currentSegment.Int64)
 		}
-		if segmentDurationSec.Valid {
-			ps.SegmentDurationSec = int(segmentDurationSec.Int64)
+		ps.SegmentStarts = splitSegmentStarts(segmentStarts)
+		if ps.SegmentStarts == nil && segmentDurationSec.Valid {
+			ps.SegmentStarts = legacySegmentStarts(ps.DurationSec, int(segmentDurationSec.Int64))
 		}
 		if sleepAt.Valid {
 			t := time.Unix(sleepAt.Int64, 0)
 			ps.SleepAt = &t
 		}
+		ps.EpisodeID = episodeID.String
+		ps.PlaylistID = playlistID.String
+		ps.Profile = profile.String
+		ps.Speed = 1
+		if speed.Valid && speed.Float64 > 0 {
+			ps.Speed = speed.Float64
+		}
+		ps.StreamMode = streamMode
 		sessions = append(sessions, &ps)
 	}
 
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RevocationScope is what a stream token revocation applies to.
+type RevocationScope string
+
+const (
+	RevokeToken    RevocationScope = "token"    // A single token, by token ID
+	RevokePlayback RevocationScope = "playback" // All tokens of a playback session
+	RevokeSession  RevocationScope = "session"  // All tokens of a web session
+)
+
+// RevocationStore persists revoked stream tokens. A revocation applies to
+// the tokens of its subject that were issued up to the revocation; tokens
+// issued later, e.g. for a new playback of the same web session, stay valid.
+type RevocationStore struct {
+	db *sql.DB
+}
+
+// NewRevocationStore creates a new revocation store.
+func NewRevocationStore(db *DB) *RevocationStore {
+	return &RevocationStore{db: db.Conn()}
+}
+
+// Revoke revokes the tokens of a subject issued up to now.
+func (s *RevocationStore) Revoke(scope RevocationScope, subject string) error {
+	query := `
+		INSERT INTO stream_token_revocations (scope, subject, revoked_at_ms)
+		VALUES (?, ?, ?)
+		ON CONFLICT(scope, subject) DO UPDATE SET revoked_at_ms = excluded.revoked_at_ms
+	`
+	_, err := s.db.Exec(query, string(scope), subject, time.Now().UnixMilli())
+	return err
+}
+
+// IsRevoked reports whether a token issued at issuedAt was revoked by its
+// token ID, its playback session or its web session. Empty IDs are ignored.
+func (s *RevocationStore) IsRevoked(tokenID, playbackID, sessionID string, issuedAt time.Time) (bool, error) {
+	query := `
+		SELECT COUNT(*) FROM stream_token_revocations
+		WHERE revoked_at_ms >= ? AND (
+			(scope = ? AND subject = ? AND subject != '') OR
+			(scope = ? AND subject = ? AND subject != '') OR
+			(scope = ? AND subject = ? AND subject != '')
+		)
+	`
+	var count int
+	err := s.db.QueryRow(query, issuedAt.UnixMilli(),
+		string(RevokeToken), tokenID,
+		string(RevokePlayback), playbackID,
+		string(RevokeSession), sessionID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// DeleteBefore removes revocations older than the given time. Once all
+// tokens issued before a revocation have expired, it is no longer needed.
+func (s *RevocationStore) DeleteBefore(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM stream_token_revocations WHERE revoked_at_ms < ?`, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -14,10 +14,16 @@ type Session struct {
 	ABSUserID   string
 	UserID      string // Alias for ABSUserID
 	ABSUsername string
+	ABSUserType string // ABS user type ("root", "admin", "user", "guest"); empty for sessions from older versions
 	CreatedAt   time.Time
 	LastUsedAt  time.Time
 }
 
+// IsAdmin reports whether the session belongs to an ABS admin or root user.
+func (s *Session) IsAdmin() bool {
+	return s.ABSUserType == "admin" || s.ABSUserType == "root"
+}
+
 // SessionStore provides CRUD operations for sessions.
 type SessionStore struct {
 	db *sql.DB
@@ -31,14 +37,15 @@ func NewSessionStore(db *DB) *SessionStore {
 // Create inserts a new session.
 func (s *SessionStore) Create(session *Session) error {
 	query := `
-		INSERT INTO sessions (id, abs_token_enc, abs_user_id, abs_username, created_at, last_used_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO sessions (id, abs_token_enc, abs_user_id, abs_username, abs_user_type, created_at, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 	_, err := s.db.Exec(query,
 		session.ID,
 		session.ABSTokenEnc,
 		session.ABSUserID,
 		session.ABSUsername,
+		session.ABSUserType,
 		session.CreatedAt.Unix(),
 		session.LastUsedAt.Unix(),
 	)
@@ -48,7 +55,7 @@ func (s *SessionStore) Create(session *Session) error {
 // Get retrieves a session by ID.
 func (s *SessionStore) Get(id string) (*Session, error) {
 	query := `
-		SELECT id, abs_token_enc, abs_user_id, abs_username, created_at, last_used_at
+		SELECT id, abs_token_enc, abs_user_id, abs_username, abs_user_type, created_at, last_used_at
 		FROM sessions WHERE id = ?
 	`
 	row := s.db.QueryRow(query, id)
@@ -61,6 +68,7 @@ func (s *SessionStore) Get(id string) (*Session, error) {
 		&session.ABSTokenEnc,
 		&session.ABSUserID,
 		&session.ABSUsername,
+		&session.ABSUserType,
 		&createdAt,
 		&lastUsedAt,
 	)
@@ -78,6 +86,13 @@ func (s *SessionStore) Get(id string) (*Session, error) {
 	return &session, nil
 }
 
+// UpdateUserType stores the ABS user type of a session.
+func (s *SessionStore) UpdateUserType(id string, userType string) error {
+	query := `UPDATE sessions SET abs_user_type = ? WHERE id = ?`
+	_, err := s.db.Exec(query, userType, id)
+	return err
+}
+
 // UpdateLastUsed updates the last_used_at timestamp.
 func (s *SessionStore) UpdateLastUsed(id string) error {
 	query := `UPDATE sessions SET last_used_at = ? WHERE id = ?`
@@ -92,20 +107,16 @@ func (s *SessionStore) Delete(id string) error {
 	return err
 }
 
-// DeleteOlderThan removes sessions not used since the given time.
-func (s *SessionStore) DeleteOlderThan(since time.Time) (int64, error) {
-	query := `DELETE FROM sessions WHERE last_used_at < ?`
-	result, err := s.db.Exec(query, since.Unix())
-	if err != nil {
-		return 0, err
-	}
-	return result.RowsAffected()
+// DeleteOlderThan removes sessions not used since the given time and returns
+// their IDs.
+func (s *SessionStore) DeleteOlderThan(since time.Time) ([]string, error) {
+	return deleteReturningIDs(s.db, `DELETE FROM sessions WHERE last_used_at < ? RETURNING id`, since.Unix())
 }
 
 // List returns all sessions.
 func (s *SessionStore) List() ([]*Session, error) {
 	query := `
-		SELECT id, abs_token_enc, abs_user_id, abs_username, created_at, last_used_at
+		SELECT id, abs_token_enc, abs_user_id, abs_username, abs_user_type, created_at, last_used_at
 		FROM sessions ORDER BY last_used_at DESC
 	`
 	rows, err := s.db.Query(query)
@@ -124,6 +135,7 @@ func (s *SessionStore) List() ([]*Session, error) {
 			&session.ABSTokenEnc,
 			&session.ABSUserID,
 			&session.ABSUsername,
+			&session.ABSUserType,
 			&createdAt,
 			&lastUsedAt,
 		)
@@ -144,7 +156,7 @@ func (s *SessionStore) List() ([]*Session, error) {
 func (s *SessionStore) ListActive() ([]*Session, error) {
 	cutoff := time.Now().Add(-24 * time.Hour).Unix()
 	query := `
-		SELECT id, abs_token_enc, abs_user_id, abs_username, created_at, last_used_at
+		SELECT id, abs_token_enc, abs_user_id, abs_username, abs_user_type, created_at, last_used_at
 		FROM sessions WHERE last_used_at > ? ORDER BY last_used_at DESC
 	`
 	rows, err := s.db.Query(query, cutoff)
@@ -163,6 +175,7 @@ func (s *SessionStore) ListActive() ([]*Session, error) {
 			&session.ABSTokenEnc,
 			&session.ABSUserID,
 			&session.ABSUsername,
+			&session.ABSUserType,
 			&createdAt,
 			&lastUsedAt,
 		)
@@ -1,7 +1,11 @@
 package store
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -161,6 +165,27 @@ func TestDeviceStore_CRUD(t *testing.T) {
 		t.Errorf("expected updated name 'Kitchen', got '%s'", retrieved.Name)
 	}
 
+	// Sink formats are kept when a later discovery couldn't query them
+	device.SinkFormats = []string{"audio/mpeg", "audio/flac"}
+	if err := store.Upsert(device); err != nil {
+		t.Fatalf("failed to update sink formats: %v", err)
+	}
+	device.SinkFormats = nil
+	if err := store.Upsert(device); err != nil {
+		t.Fatalf("failed to update device: %v", err)
+	}
+
+	retrieved, _ = store.Get("uuid:RINCON_123456")
+	if len(retrieved.SinkFormats) != 2 {
+		t.Errorf("expected 2 sink formats, got %v", retrieved.SinkFormats)
+	}
+	if !retrieved.AcceptsFormat("AUDIO/FLAC") {
+		t.Error("expected device to accept audio/flac")
+	}
+	if retrieved.AcceptsFormat("audio/ogg") {
+		t.Error("expected device not to accept audio/ogg")
+	}
+
 	// SetReachable
 	err = store.SetReachable("uuid:RINCON_123456", false)
 	if err != nil {
@@ -240,6 +265,19 @@ func TestCacheStore_CRUD(t *testing.T) {
 	if retrieved.DurationSec == nil || *retrieved.DurationSec != 3600 {
 		t.Error("expected duration 3600")
 	}
+	if retrieved.SourceType != SourceTypeLocal {
+		t.Errorf("expected default source type 'local', got '%s'", retrieved.SourceType)
+	}
+
+	// UpdateSourceType
+	if err := store.UpdateSourceType("item-123", SourceTypeABS); err != nil {
+		t.Fatalf("failed to update source type: %v", err)
+	}
+
+	retrieved, _ = store.Get("item-123")
+	if retrieved.SourceType != SourceTypeABS {
+		t.Errorf("expected source type 'abs', got '%s'", retrieved.SourceType)
+	}
 
 	// MarkInProgress
 	err = store.MarkInProgress("item-123")
@@ -467,6 +505,795 @@ func TestPlaybackStore_CRUD(t *testing.T) {
 	}
 }
 
+func TestPlaybackStore_EpisodeID(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionStore := NewSessionStore(db)
+	sessionStore.Create(&Session{
+		ID:          "session-123",
+		ABSTokenEnc: []byte("token"),
+		ABSUserID:   "user-1",
+		ABSUsername: "test",
+		CreatedAt:   time.Now(),
+		LastUsedAt:  time.Now(),
+	})
+
+	deviceStore := NewDeviceStore(db)
+	deviceStore.Upsert(&SonosDevice{
+		UUID:         "uuid:RINCON_123",
+		Name:         "Test",
+		IPAddress:    "192.168.1.100",
+		IsReachable:  true,
+		DiscoveredAt: time.Now(),
+		LastSeenAt:   time.Now(),
+	})
+
+	store := NewPlaybackStore(db)
+	err := store.Create(&PlaybackSession{
+		ID:                 "playback-ep",
+		SessionID:          "session-123",
+		ItemID:             "podcast-1",
+		EpisodeID:          "ep-7",
+		PlaylistID:         "playlist-1",
+		SonosUUID:          "uuid:RINCON_123",
+		StreamToken:        "token",
+		StartedAt:          time.Now(),
+		LastPositionUpdate: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create playback session: %v", err)
+	}
+
+	retrieved, err := store.GetBySessionID("session-123")
+	if err != nil || retrieved == nil {
+		t.Fatalf("failed to get playback session: %v", err)
+	}
+	if retrieved.EpisodeID != "ep-7" {
+		t.Errorf("expected episode ID ep-7, got %s", retrieved.EpisodeID)
+	}
+	if retrieved.CacheKey() != "podcast-1~ep-7" {
+		t.Errorf("expected cache key podcast-1~ep-7, got %s", retrieved.CacheKey())
+	}
+	if retrieved.PlaylistID != "playlist-1" {
+		t.Errorf("expected playlist ID playlist-1, got %s", retrieved.PlaylistID)
+	}
+	if retrieved.Speed != 1 {
+		t.Errorf("expected normal speed by default, got %v", retrieved.Speed)
+	}
+
+	if err := store.UpdateProfile("playback-ep", "1.5x", 1.5, StreamModeFile, 1, []int{0, 6900, 13750}); err != nil {
+		t.Fatalf("failed to update profile: %v", err)
+	}
+	retrieved, _ = store.Get("playback-ep")
+	if retrieved.Profile != "1.5x" || retrieved.Speed != 1.5 || retrieved.CurrentSegment != 1 {
+		t.Errorf("unexpected session after profile update: %+v", retrieved)
+	}
+	if !reflect.DeepEqual(retrieved.SegmentStarts, []int{0, 6900, 13750}) {
+		t.Errorf("expected segment starts [0 6900 13750], got %v", retrieved.SegmentStarts)
+	}
+	if retrieved.CacheKey() != "podcast-1~ep-7@1.5x" {
+		t.Errorf("expected cache key podcast-1~ep-7@1.5x, got %s", retrieved.CacheKey())
+	}
+	if retrieved.IsHLS() {
+		t.Error("expected file stream mode")
+	}
+
+	if err := store.UpdateStreamLayout("playback-ep", StreamModeHLS, 0, nil); err != nil {
+		t.Fatalf("failed to update stream layout: %v", err)
+	}
+	retrieved, _ = store.Get("playback-ep")
+	if !retrieved.IsHLS() || retrieved.CurrentSegment != 0 || retrieved.SegmentStarts != nil {
+		t.Errorf("unexpected session after stream layout update: %+v", retrieved)
+	}
+
+	if err := store.UpdateStreamLayout("playback-ep", StreamModeLive, 0, nil); err != nil {
+		t.Fatalf("failed to update stream layout: %v", err)
+	}
+	if err := store.UpdateStreamOffset("playback-ep", 2400); err != nil {
+		t.Fatalf("failed to update stream offset: %v", err)
+	}
+	retrieved, _ = store.Get("playback-ep")
+	if !retrieved.IsLive() || retrieved.StreamOffset != 2400 {
+		t.Errorf("unexpected session after stream offset update: %+v", retrieved)
+	}
+}
+
+func TestPlaybackSession_Positions(t *testing.T) {
+	// Speed variant at 1.5x, segmented into 7200s of output
+	ps := &PlaybackSession{Speed: 1.5, CurrentSegment: 1, SegmentStarts: []int{0, 7200}}
+
+	if got := ps.GlobalPosition(600); got != 11700 {
+		t.Errorf("GlobalPosition(600) = %d, want 11700", got)
+	}
+	segment, local := ps.SegmentPosition(11700)
+	if segment != 1 || local != 600 {
+		t.Errorf("SegmentPosition(11700) = %d, %d, want 1, 600", segment, local)
+	}
+
+	// Segments cut at chapter boundaries
+	ps = &PlaybackSession{Speed: 1, CurrentSegment: 2, SegmentStarts: []int{0, 6950, 13400}}
+	if got := ps.GlobalPosition(100); got != 13500 {
+		t.Errorf("GlobalPosition(100) = %d, want 13500", got)
+	}
+	segment, local = ps.SegmentPosition(7000)
+	if segment != 1 || local != 50 {
+		t.Errorf("SegmentPosition(7000) = %d, %d, want 1, 50", segment, local)
+	}
+
+	// Normal speed, single file
+	ps = &PlaybackSession{Speed: 1}
+	if got := ps.GlobalPosition(600); got != 600 {
+		t.Errorf("GlobalPosition(600) = %d, want 600", got)
+	}
+	if got := ps.VariantPosition(600); got != 600 {
+		t.Errorf("VariantPosition(600) = %d, want 600", got)
+	}
+
+	// Live stream of a speed variant, started 2400s into its output
+	ps = &PlaybackSession{Speed: 1.5, StreamMode: StreamModeLive, StreamOffset: 2400}
+	if got := ps.GlobalPosition(600); got != 4500 {
+		t.Errorf("GlobalPosition(600) = %d, want 4500", got)
+	}
+}
+
+func TestListeningStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionStore := NewSessionStore(db)
+	sessionStore.Create(&Session{
+		ID:          "session-123",
+		ABSTokenEnc: []byte("token"),
+		ABSUserID:   "user-1",
+		ABSUsername: "test",
+		CreatedAt:   time.Now(),
+		LastUsedAt:  time.Now(),
+	})
+
+	deviceStore := NewDeviceStore(db)
+	deviceStore.Upsert(&SonosDevice{
+		UUID:         "uuid:RINCON_123",
+		Name:         "Test",
+		IPAddress:    "192.168.1.100",
+		IsReachable:  true,
+		DiscoveredAt: time.Now(),
+		LastSeenAt:   time.Now(),
+	})
+
+	playbackStore := NewPlaybackStore(db)
+	playbackStore.Create(&PlaybackSession{
+		ID:                 "playback-1",
+		SessionID:          "session-123",
+		ItemID:             "item-1",
+		SonosUUID:          "uuid:RINCON_123",
+		StreamToken:        "token",
+		StartedAt:          time.Now(),
+		LastPositionUpdate: time.Now(),
+	})
+
+	store := NewListeningStore(db)
+	err := store.Create(&ListeningSession{
+		ID:           "abs-session-1",
+		PlaybackID:   "playback-1",
+		SessionID:    "session-123",
+		ItemID:       "item-1",
+		PositionSec:  100,
+		DurationSec:  3600,
+		StartedAt:    time.Now(),
+		LastTickAt:   time.Now(),
+		LastSyncedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create listening session: %v", err)
+	}
+
+	// Accumulate listening time
+	store.AddListened("abs-session-1", 5, 105)
+	store.AddListened("abs-session-1", 5, 110)
+
+	ls, err := store.GetByPlaybackID("playback-1")
+	if err != nil || ls == nil {
+		t.Fatalf("failed to get listening session: %v", err)
+	}
+	if ls.UnsyncedSec != 10 {
+		t.Errorf("expected 10 unsynced seconds, got %d", ls.UnsyncedSec)
+	}
+	if ls.PositionSec != 110 {
+		t.Errorf("expected position 110, got %d", ls.PositionSec)
+	}
+
+	// Time listened during the sync request is kept
+	store.AddListened("abs-session-1", 5, 115)
+	store.MarkSynced("abs-session-1", 10)
+
+	ls, _ = store.GetByPlaybackID("playback-1")
+	if ls.UnsyncedSec != 5 {
+		t.Errorf("expected 5 unsynced seconds after sync, got %d", ls.UnsyncedSec)
+	}
+
+	// Not orphaned while the playback session exists
+	orphaned, err := store.ListOrphaned()
+	if err != nil {
+		t.Fatalf("failed to list orphaned sessions: %v", err)
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("expected 0 orphaned sessions, got %d", len(orphaned))
+	}
+
+	playbackStore.Delete("playback-1")
+
+	orphaned, _ = store.ListOrphaned()
+	if len(orphaned) != 1 {
+		t.Errorf("expected 1 orphaned session, got %d", len(orphaned))
+	}
+
+	// Delete
+	store.Delete("abs-session-1")
+	sessions, _ := store.ListBySessionID("session-123")
+	if len(sessions) != 0 {
+		t.Errorf("expected 0 sessions after delete, got %d", len(sessions))
+	}
+}
+
+func TestCacheStore_LastAccess(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewCacheStore(db)
+
+	for _, id := range []string{"item-a", "item-b", "item-c"} {
+		store.Create(&CacheEntry{
+			ItemID:         id,
+			SourcePath:     "/media/" + id,
+			SourceMtime:    time.Now(),
+			ProfileVersion: "v3",
+			CachePath:      "/cache/" + id,
+			Status:         CacheStatusReady,
+		})
+	}
+	store.MarkFailed("item-c", "failed")
+
+	entry, _ := store.Get("item-a")
+	if !entry.LastAccessedAt.IsZero() {
+		t.Errorf("expected no last access for new entry, got %v", entry.LastAccessedAt)
+	}
+
+	if err := store.TouchAccess("item-a", time.Minute); err != nil {
+		t.Fatalf("failed to touch access: %v", err)
+	}
+	entry, _ = store.Get("item-a")
+	if entry.LastAccessedAt.IsZero() {
+		t.Error("expected last access to be set")
+	}
+
+	// Never accessed entries count as older than accessed ones
+	db.Conn().Exec(`UPDATE cache_index SET updated_at = ? WHERE item_id = 'item-b'`, time.Now().Add(-time.Hour).Unix())
+
+	entries, err := store.ListReadyByLastAccess()
+	if err != nil {
+		t.Fatalf("failed to list entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 ready entries, got %d", len(entries))
+	}
+	if entries[0].ItemID != "item-b" || entries[1].ItemID != "item-a" {
+		t.Errorf("unexpected order: %s, %s", entries[0].ItemID, entries[1].ItemID)
+	}
+}
+
+func TestPathMappingStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPathMappingStore(db)
+
+	mapping := &PathMapping{
+		FolderID:    "fol-1",
+		LibraryID:   "lib-1",
+		LibraryName: "Hörbücher",
+		ABSPath:     "/audiobooks",
+		LocalPath:   "/media/audiobooks",
+		SamplePath:  "/audiobooks/Author/Book",
+		SampleFound: true,
+		ResolvedAt:  time.Now(),
+	}
+	if err := store.Upsert(mapping); err != nil {
+		t.Fatalf("failed to upsert path mapping: %v", err)
+	}
+	if err := store.Upsert(&PathMapping{FolderID: "fol-2", LibraryID: "lib-2", ABSPath: "/podcasts", ResolvedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to upsert path mapping: %v", err)
+	}
+
+	// Upsert replaces the mapping of a folder
+	mapping.LocalPath = "/media/books"
+	if err := store.Upsert(mapping); err != nil {
+		t.Fatalf("failed to update path mapping: %v", err)
+	}
+
+	mappings, err := store.List()
+	if err != nil {
+		t.Fatalf("failed to list path mappings: %v", err)
+	}
+	if len(mappings) != 2 {
+		t.Fatalf("expected 2 path mappings, got %d", len(mappings))
+	}
+
+	var found *PathMapping
+	for _, m := range mappings {
+		if m.FolderID == "fol-1" {
+			found = m
+		}
+	}
+	if found == nil || found.LocalPath != "/media/books" || !found.SampleFound {
+		t.Errorf("unexpected mapping: %+v", found)
+	}
+
+	// DeleteExcept leaves libraries that weren't listed alone
+	if err := store.DeleteExcept([]string{"lib-1"}, []string{"fol-1"}); err != nil {
+		t.Fatalf("failed to delete path mappings: %v", err)
+	}
+	mappings, _ = store.List()
+	if len(mappings) != 2 {
+		t.Errorf("expected mappings of unlisted libraries to remain, got %d mappings", len(mappings))
+	}
+
+	// DeleteExcept removes folders that no longer exist
+	if err := store.DeleteExcept([]string{"lib-1", "lib-2"}, []string{"fol-1"}); err != nil {
+		t.Fatalf("failed to delete path mappings: %v", err)
+	}
+	mappings, _ = store.List()
+	if len(mappings) != 1 || mappings[0].FolderID != "fol-1" {
+		t.Errorf("expected only fol-1 to remain, got %d mappings", len(mappings))
+	}
+}
+
+func TestItemSettingsStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewItemSettingsStore(db)
+
+	preset, err := store.GetAudioPreset("item-1")
+	if err != nil {
+		t.Fatalf("failed to get audio preset: %v", err)
+	}
+	if preset != "" {
+		t.Errorf("expected no preset, got %q", preset)
+	}
+
+	if err := store.SetAudioPreset("item-1", "night"); err != nil {
+		t.Fatalf("failed to set audio preset: %v", err)
+	}
+	if err := store.SetAudioPreset("item-1", "speech"); err != nil {
+		t.Fatalf("failed to update audio preset: %v", err)
+	}
+	if preset, _ := store.GetAudioPreset("item-1"); preset != "speech" {
+		t.Errorf("expected speech, got %q", preset)
+	}
+
+	// An empty preset removes the override
+	if err := store.SetAudioPreset("item-1", ""); err != nil {
+		t.Fatalf("failed to clear audio preset: %v", err)
+	}
+	if preset, _ := store.GetAudioPreset("item-1"); preset != "" {
+		t.Errorf("expected no preset after clearing, got %q", preset)
+	}
+}
+
+func TestPinStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewPinStore(db)
+
+	if err := store.Pin("user-1", "item-1"); err != nil {
+		t.Fatalf("failed to pin item: %v", err)
+	}
+	if err := store.Pin("user-1", "item-1"); err != nil {
+		t.Fatalf("failed to pin item twice: %v", err)
+	}
+	if err := store.Pin("user-2", "item-2"); err != nil {
+		t.Fatalf("failed to pin item: %v", err)
+	}
+
+	if pinned, _ := store.IsPinned("user-1", "item-1"); !pinned {
+		t.Error("expected item-1 to be pinned by user-1")
+	}
+	if pinned, _ := store.IsPinned("user-2", "item-1"); pinned {
+		t.Error("expected pins to be per user")
+	}
+
+	items, err := store.ListByUser("user-1")
+	if err != nil {
+		t.Fatalf("failed to list pins: %v", err)
+	}
+	if !reflect.DeepEqual(items, []string{"item-1"}) {
+		t.Errorf("expected [item-1], got %v", items)
+	}
+
+	if err := store.Unpin("user-1", "item-1"); err != nil {
+		t.Fatalf("failed to unpin item: %v", err)
+	}
+	if items, _ := store.ListByUser("user-1"); len(items) != 0 {
+		t.Errorf("expected no pins after unpinning, got %v", items)
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewJobStore(db)
+	now := time.Now()
+
+	for _, job := range []struct {
+		itemID   string
+		priority int
+	}{
+		{"bulk", 0},
+		{"warmup", 10},
+		{"later", 10},
+	} {
+		if queued, err := store.Enqueue(job.itemID, job.priority, "{}"); err != nil || !queued {
+			t.Fatalf("failed to enqueue %s: %v", job.itemID, err)
+		}
+	}
+
+	// Queueing again keeps one job with the higher priority
+	if queued, _ := store.Enqueue("bulk", 20, `{"new":true}`); !queued {
+		t.Error("expected queued job to be updated")
+	}
+	if queued, _ := store.Enqueue("warmup", 0, "{}"); !queued {
+		t.Error("expected queued job to be updated")
+	}
+	if count, _ := store.CountQueued(); count != 3 {
+		t.Errorf("expected 3 queued jobs, got %d", count)
+	}
+
+	job, err := store.Claim(now, 0)
+	if err != nil || job == nil {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+	if job.ItemID != "bulk" || job.Priority != 20 || job.Payload != `{"new":true}` {
+		t.Errorf("expected raised bulk job, got %+v", job)
+	}
+	if job.Status != JobStatusRunning || job.Attempts != 1 {
+		t.Errorf("expected running first attempt, got %s %d", job.Status, job.Attempts)
+	}
+
+	// Running jobs aren't queued again
+	if queued, _ := store.Enqueue("bulk", 0, "{}"); queued {
+		t.Error("expected running job not to be queued")
+	}
+
+	// Jobs that aren't due are skipped
+	if err := store.Retry("bulk", now.Add(time.Minute), "failed"); err != nil {
+		t.Fatalf("failed to retry job: %v", err)
+	}
+	if job, _ := store.Claim(now, 0); job == nil || job.ItemID != "warmup" || job.Priority != 10 {
+		t.Errorf("expected warmup job, got %+v", job)
+	}
+	if job, _ := store.Claim(now, 0); job == nil || job.ItemID != "later" {
+		t.Errorf("expected later job, got %+v", job)
+	}
+	if job, _ := store.Claim(now, 0); job != nil {
+		t.Errorf("expected no due job, got %+v", job)
+	}
+
+	job, _ = store.Get("bulk")
+	if job.Status != JobStatusQueued || job.LastError != "failed" || job.Attempts != 1 {
+		t.Errorf("unexpected job after retry: %+v", job)
+	}
+
+	// A higher priority ends the wait for a retry
+	store.Enqueue("bulk", 30, "{}")
+	if job, _ := store.Claim(now, 0); job == nil || job.ItemID != "bulk" || job.Attempts != 2 {
+		t.Errorf("expected bulk job to be due, got %+v", job)
+	}
+
+	jobs, err := store.List()
+	if err != nil || len(jobs) != 3 {
+		t.Fatalf("expected 3 jobs, got %d, %v", len(jobs), err)
+	}
+
+	if n, _ := store.ResetRunning(); n != 3 {
+		t.Errorf("expected 3 reset jobs, got %d", n)
+	}
+	if count, _ := store.CountQueued(); count != 3 {
+		t.Errorf("expected 3 queued jobs after reset, got %d", count)
+	}
+
+	if deleted, _ := store.Delete("bulk"); !deleted {
+		t.Error("expected job to be deleted")
+	}
+	if deleted, _ := store.Delete("bulk"); deleted {
+		t.Error("expected no job to delete")
+	}
+	if job, _ := store.Get("bulk"); job != nil {
+		t.Error("expected deleted job to be gone")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	if got := CacheKey("item-1", ""); got != "item-1" {
+		t.Errorf("expected item-1, got %s", got)
+	}
+	if got := CacheKey("li_1", "ep_1"); got != "li_1~ep_1" {
+		t.Errorf("expected li_1~ep_1, got %s", got)
+	}
+
+	// Legacy ABS IDs contain "_", the key still splits into the same IDs
+	itemID, episodeID := SplitCacheKey(CacheKey("li_1", "ep_1"))
+	if itemID != "li_1" || episodeID != "ep_1" {
+		t.Errorf("expected li_1 and ep_1, got %s and %s", itemID, episodeID)
+	}
+	itemID, episodeID = SplitCacheKey("li_1")
+	if itemID != "li_1" || episodeID != "" {
+		t.Errorf("expected li_1 without episode, got %s and %s", itemID, episodeID)
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	if got := VariantKey("item-1", ""); got != "item-1" {
+		t.Errorf("expected item-1, got %s", got)
+	}
+	if got := VariantKey("item-1~ep-1", "hq"); got != "item-1~ep-1@hq" {
+		t.Errorf("expected item-1~ep-1@hq, got %s", got)
+	}
+
+	key, variant := SplitVariantKey("item-1~ep-1@hq")
+	if key != "item-1~ep-1" || variant != "hq" {
+		t.Errorf("expected item-1~ep-1 and hq, got %s and %s", key, variant)
+	}
+	key, variant = SplitVariantKey("item-1")
+	if key != "item-1" || variant != "" {
+		t.Errorf("expected item-1 without variant, got %s and %s", key, variant)
+	}
+}
+
+func TestSegmentStarts(t *testing.T) {
+	starts := []int{0, 6950, 13400}
+	tests := []struct {
+		global  int
+		segment int
+		local   int
+	}{
+		{0, 0, 0},
+		{6949, 0, 6949},
+		{6950, 1, 0},
+		{13500, 2, 100},
+	}
+	for _, tt := range tests {
+		segment, local := GlobalToSegment(tt.global, starts)
+		if segment != tt.segment || local != tt.local {
+			t.Errorf("GlobalToSegment(%d) = %d, %d, want %d, %d", tt.global, segment, local, tt.segment, tt.local)
+		}
+		if got := SegmentToGlobal(segment, local, starts); got != tt.global {
+			t.Errorf("SegmentToGlobal(%d, %d) = %d, want %d", segment, local, got, tt.global)
+		}
+	}
+
+	if got := SegmentLength(1, starts); got != 6450 {
+		t.Errorf("SegmentLength(1) = %d, want 6450", got)
+	}
+	if got := SegmentLength(2, starts); got != 0 {
+		t.Errorf("expected open-ended last segment, got %d", got)
+	}
+	if segment, local := GlobalToSegment(500, nil); segment != 0 || local != 500 {
+		t.Errorf("expected single-file position unchanged, got %d, %d", segment, local)
+	}
+}
+
+func TestCacheStore_SegmentStarts(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewCacheStore(db)
+	for _, id := range []string{"item-chapters", "item-legacy"} {
+		if err := store.Create(&CacheEntry{ItemID: id, SourceMtime: time.Now(), Status: CacheStatusPending}); err != nil {
+			t.Fatalf("failed to create cache entry: %v", err)
+		}
+	}
+
+	if err := store.MarkReadyWithSegments("item-chapters", 20000, "mp4", []int{0, 6950, 13400}); err != nil {
+		t.Fatalf("failed to mark ready: %v", err)
+	}
+	entry, _ := store.Get("item-chapters")
+	if entry.SegmentCount != 3 || !reflect.DeepEqual(entry.SegmentStarts, []int{0, 6950, 13400}) {
+		t.Errorf("unexpected segment layout: %d segments at %v", entry.SegmentCount, entry.SegmentStarts)
+	}
+
+	// Entries written before segment offsets only recorded a fixed duration
+	if _, err := db.Conn().Exec(`UPDATE cache_index SET status = 'ready', segment_count = 3, segment_duration_sec = 7200 WHERE item_id = 'item-legacy'`); err != nil {
+		t.Fatalf("failed to write legacy layout: %v", err)
+	}
+	entry, _ = store.Get("item-legacy")
+	if !reflect.DeepEqual(entry.SegmentStarts, []int{0, 7200, 14400}) {
+		t.Errorf("expected fixed segment starts for legacy entry, got %v", entry.SegmentStarts)
+	}
+}
+
+func TestCacheStore_SourcePaths(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewCacheStore(db)
+	paths := []string{"/media/book/01.mp3", "/media/book/02.mp3"}
+	if err := store.Create(&CacheEntry{ItemID: "item-1", SourcePath: paths[0], SourcePaths: paths, SourceMtime: time.Now(), Status: CacheStatusPending}); err != nil {
+		t.Fatalf("failed to create cache entry: %v", err)
+	}
+	if err := store.Create(&CacheEntry{ItemID: "item-2", SourceMtime: time.Now(), Status: CacheStatusPending}); err != nil {
+		t.Fatalf("failed to create cache entry: %v", err)
+	}
+
+	entry, _ := store.Get("item-1")
+	if !reflect.DeepEqual(entry.SourcePaths, paths) {
+		t.Errorf("expected source paths %v, got %v", paths, entry.SourcePaths)
+	}
+	entry, _ = store.Get("item-2")
+	if entry.SourcePaths != nil {
+		t.Errorf("expected no source paths, got %v", entry.SourcePaths)
+	}
+
+	if err := store.UpdateSourcePaths("item-2", []string{"/media/single.m4b"}); err != nil {
+		t.Fatalf("failed to update source paths: %v", err)
+	}
+	entry, _ = store.Get("item-2")
+	if !reflect.DeepEqual(entry.SourcePaths, []string{"/media/single.m4b"}) {
+		t.Errorf("unexpected source paths after update: %v", entry.SourcePaths)
+	}
+}
+
+func TestJobStore_Attempts(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewJobStore(db)
+	started := time.Now().Add(-time.Minute)
+
+	for i := 1; i <= attemptsPerItem+2; i++ {
+		a := &TranscodeAttempt{
+			ItemID:     "item-1",
+			Attempt:    i,
+			ErrorClass: "corrupt_input",
+			Error:      fmt.Sprintf("attempt %d failed", i),
+			Log:        fmt.Sprintf("log %d", i),
+			StartedAt:  started,
+			FinishedAt: time.Now(),
+		}
+		if err := store.AddAttempt(a); err != nil || a.ID == 0 {
+			t.Fatalf("failed to add attempt %d: %v", i, err)
+		}
+	}
+	if err := store.AddAttempt(&TranscodeAttempt{ItemID: "item-2", Attempt: 1, Log: "ok", StartedAt: started, FinishedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to add attempt: %v", err)
+	}
+
+	// Only the newest attempts are kept, without their logs
+	attempts, err := store.ListAttempts("item-1")
+	if err != nil {
+		t.Fatalf("failed to list attempts: %v", err)
+	}
+	if len(attempts) != attemptsPerItem {
+		t.Fatalf("expected %d attempts, got %d", attemptsPerItem, len(attempts))
+	}
+	if attempts[0].Attempt != attemptsPerItem+2 || attempts[len(attempts)-1].Attempt != 3 {
+		t.Errorf("expected attempts %d to 3, got %d to %d", attemptsPerItem+2, attempts[0].Attempt, attempts[len(attempts)-1].Attempt)
+	}
+	if attempts[0].Log != "" || !attempts[0].Failed() {
+		t.Errorf("expected failed attempt without log, got %+v", attempts[0])
+	}
+
+	a, err := store.GetAttempt(attempts[0].ID)
+	if err != nil || a == nil {
+		t.Fatalf("failed to get attempt: %v", err)
+	}
+	if a.Log != fmt.Sprintf("log %d", attemptsPerItem+2) || a.ErrorClass != "corrupt_input" {
+		t.Errorf("unexpected attempt %+v", a)
+	}
+	if a, _ := store.GetAttempt(-1); a != nil {
+		t.Error("expected nil for unknown attempt")
+	}
+
+	latest, err := store.LatestAttempts()
+	if err != nil {
+		t.Fatalf("failed to get latest attempts: %v", err)
+	}
+	if len(latest) != 2 || latest["item-1"].ID != attempts[0].ID || latest["item-2"].Failed() {
+		t.Errorf("unexpected latest attempts %+v", latest)
+	}
+}
+
+func TestRevocationStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	store := NewRevocationStore(db)
+	issued := time.Now().Add(-time.Minute)
+
+	if revoked, err := store.IsRevoked("token-1", "playback-1", "session-1", issued); err != nil || revoked {
+		t.Fatalf("expected token not to be revoked, got %v (err: %v)", revoked, err)
+	}
+
+	if err := store.Revoke(RevokePlayback, "playback-1"); err != nil {
+		t.Fatalf("failed to revoke playback: %v", err)
+	}
+	if revoked, _ := store.IsRevoked("token-1", "playback-1", "session-1", issued); !revoked {
+		t.Error("expected token of revoked playback to be revoked")
+	}
+	if revoked, _ := store.IsRevoked("token-2", "playback-2", "session-1", issued); revoked {
+		t.Error("expected token of another playback to stay valid")
+	}
+	if revoked, _ := store.IsRevoked("token-3", "playback-1", "session-1", time.Now().Add(time.Minute)); revoked {
+		t.Error("expected token issued after the revocation to stay valid")
+	}
+	if revoked, _ := store.IsRevoked("token-3", "playback-1", "session-1", time.Now().Add(2*time.Millisecond)); revoked {
+		t.Error("expected token issued in the same second after the revocation to stay valid")
+	}
+
+	if err := store.Revoke(RevokeSession, "session-1"); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+	if revoked, _ := store.IsRevoked("token-2", "playback-2", "session-1", issued); !revoked {
+		t.Error("expected token of revoked session to be revoked")
+	}
+
+	if err := store.Revoke(RevokeToken, "token-4"); err != nil {
+		t.Fatalf("failed to revoke token: %v", err)
+	}
+	if revoked, _ := store.IsRevoked("token-4", "", "session-2", issued); !revoked {
+		t.Error("expected revoked token to be revoked")
+	}
+	if revoked, _ := store.IsRevoked("token-5", "", "", issued); revoked {
+		t.Error("expected empty IDs not to match")
+	}
+
+	n, err := store.DeleteBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to delete revocations: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 deleted revocations, got %d", n)
+	}
+}
+
+func TestRevocationStore_MigratesSeconds(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	// Revocations stored in seconds by earlier versions
+	conn, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Now().Add(-time.Minute)
+	_, err = conn.Exec(`
+		CREATE TABLE stream_token_revocations (scope TEXT NOT NULL, subject TEXT NOT NULL, revoked_at INTEGER NOT NULL, PRIMARY KEY (scope, subject));
+		INSERT INTO stream_token_revocations (scope, subject, revoked_at) VALUES ('playback', 'playback-1', ?);
+	`, revokedAt.Unix())
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewRevocationStore(db)
+	if revoked, _ := store.IsRevoked("token-1", "playback-1", "", revokedAt); !revoked {
+		t.Error("expected migrated revocation to cover tokens issued in its second")
+	}
+	if revoked, _ := store.IsRevoked("token-1", "playback-1", "", revokedAt.Add(time.Second)); revoked {
+		t.Error("expected tokens issued after the migrated revocation to stay valid")
+	}
+	if err := store.Revoke(RevokePlayback, "playback-2"); err != nil {
+		t.Errorf("failed to revoke after migration: %v", err)
+	}
+}
+
 func TestDatabaseMigrations(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -5,6 +5,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -12,6 +13,7 @@ import (
 	"strings"
 
 	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
 )
 
 // segmentPattern matches segment file names like "segment_000.m4a"
@@ -22,6 +24,9 @@ type Handler struct {
 	tokenGen   *TokenGenerator
 	cacheIndex *cache.Index
 	publicURL  string
+	networks   *NetworkFilter // nil allows all clients
+	hls        *cache.HLS     // nil disables HLS playlists
+	live       *cache.Live    // nil disables live streams of uncached items
 }
 
 // NewHandler creates a new stream handler.
@@ -33,6 +38,21 @@ func NewHandler(tokenGen *TokenGenerator, cacheIndex *cache.Index, publicURL str
 	}
 }
 
+// SetNetworkFilter restricts streaming to the clients allowed by filter.
+func (h *Handler) SetNetworkFilter(filter *NetworkFilter) {
+	h.networks = filter
+}
+
+// SetHLS enables HLS playlists and segments, cut by segmenter.
+func (h *Handler) SetHLS(segmenter *cache.HLS) {
+	h.hls = segmenter
+}
+
+// SetLive enables live streams of uncached items, transcoded by live.
+func (h *Handler) SetLive(live *cache.Live) {
+	h.live = live
+}
+
 // GetStreamURL returns the full URL for streaming an item.
 // format should be "mp3", "mp4", "flac", "ogg", or "asf".
 func (h *Handler) GetStreamURL(token string, format string) string {
@@ -52,7 +72,9 @@ func (h *Handler) GetSegmentStreamURL(token string, segmentIndex int, format str
 	return fmt.Sprintf("%s/stream/%s/segment_%03d%s", h.publicURL, token, segmentIndex, ext)
 }
 
-// HandleStream handles GET /stream/{token}/audio.* or /stream/{token}/segment_*.* requests.
+// HandleStream handles GET /stream/{token}/audio.*, /stream/{token}/segment_*.*
+// HLS (/stream/{token}/index.m3u8, /stream/{token}/hls_*.ts) and live
+// (/stream/{token}/live_*.mp3) requests.
 func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 	// Extract token and filename from path
 	// Path format: /stream/{token}/audio.* or /stream/{token}/segment_000.*
@@ -65,6 +87,15 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 	tokenStr := pathParts[1]
 	fileName := pathParts[2]
 
+	// Only clients in the allowed networks may stream
+	if h.networks != nil {
+		if client, ok := h.networks.Allowed(r); !ok {
+			slog.Warn("stream request from disallowed network", "client", client, "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Validate token
 	payload, err := h.tokenGen.Validate(tokenStr)
 	if err != nil {
@@ -73,6 +104,13 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Tokens bound to a speaker may only be used by that speaker
+	if payload.SpeakerIP != "" && !h.fromSpeaker(r, payload.SpeakerIP) {
+		slog.Warn("stream token used by another client", "item_id", payload.ItemID, "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	// Look up cache entry to get the correct format and path
 	entry, err := h.cacheIndex.GetEntry(payload.ItemID)
 	if err != nil {
@@ -86,6 +124,16 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if fileName == cache.HLSPlaylistName || hlsSegmentPattern.MatchString(fileName) {
+		h.serveHLS(w, r, entry, fileName)
+		return
+	}
+
+	if livePattern.MatchString(fileName) {
+		h.serveLive(w, r, entry, fileName)
+		return
+	}
+
 	// Determine the cache file path
 	var cachePath string
 
@@ -136,8 +184,18 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		cachePath = h.cacheIndex.GetCachePathFromEntry(entry)
 	}
 
+	// Get MIME type from cache entry format
+	mimeType := cache.GetContentType(entry.CacheFormat)
+
 	// Check if file exists
 	fileInfo, err := os.Stat(cachePath)
+	if os.IsNotExist(err) && entry.Status == store.CacheStatusInProgress {
+		// Still being encoded: serve the output while it grows
+		h.touchAccess(entry.ItemID)
+		slog.Debug("streaming partial file", "item_id", payload.ItemID, "format", entry.CacheFormat, "path", cachePath)
+		h.servePartial(w, r, entry, cachePath, mimeType)
+		return
+	}
 	if os.IsNotExist(err) {
 		slog.Warn("cache file not found", "item_id", payload.ItemID, "path", cachePath, "format", entry.CacheFormat)
 		http.Error(w, "not found", http.StatusNotFound)
@@ -160,10 +218,36 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 
 	fileSize := fileInfo.Size()
 
-	// Get MIME type from cache entry format
-	mimeType := cache.GetContentType(entry.CacheFormat)
+	h.touchAccess(entry.ItemID)
+
 	slog.Debug("streaming cached file", "item_id", payload.ItemID, "format", entry.CacheFormat, "mime_type", mimeType, "size", fileSize)
 
+	h.serveFile(w, r, file, fileSize, mimeType)
+}
+
+// fromSpeaker reports whether a request was sent by the speaker at speakerIP.
+func (h *Handler) fromSpeaker(r *http.Request, speakerIP string) bool {
+	speaker, err := netip.ParseAddr(speakerIP)
+	if err != nil {
+		return false
+	}
+
+	client, ok := parseRemoteAddr(r.RemoteAddr)
+	if h.networks != nil {
+		client, ok = h.networks.ClientAddr(r)
+	}
+	return ok && client == speaker.Unmap()
+}
+
+// touchAccess records the access for least-recently-played eviction.
+func (h *Handler) touchAccess(itemID string) {
+	if err := h.cacheIndex.TouchAccess(itemID); err != nil {
+		slog.Warn("failed to record cache access", "item_id", itemID, "error", err)
+	}
+}
+
+// serveFile serves a complete cache file, honoring Range requests.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, file *os.File, fileSize int64, mimeType string) {
 	// Handle Range requests (RFC 7233)
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" {
@@ -185,7 +269,7 @@ func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
 		slog.Debug("stream copy error", "error", err)
 	}
 
-	slog.Debug("streamed full file", "item_id", payload.ItemID, "size", fileSize)
+	slog.Debug("streamed full file", "path", file.Name(), "size", fileSize)
 }
 
 // handleRangeRequest handles HTTP Range requests for partial content.
@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// hlsSegmentPattern matches HLS segment file names like "hls_00042.ts"
+var hlsSegmentPattern = regexp.MustCompile(`^hls_(\d{5})\.ts$`)
+
+// serveHLS serves the HLS playlist of an entry or one of its segments.
+// Segments are cut from the cached output on first request, so HLS is only
+// available once the entry is ready.
+func (h *Handler) serveHLS(w http.ResponseWriter, r *http.Request, entry *store.CacheEntry, fileName string) {
+	if h.hls == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !cache.HLSAvailable(entry) {
+		slog.Warn("HLS requested but cache is not ready", "item_id", entry.ItemID, "status", entry.Status)
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	h.touchAccess(entry.ItemID)
+
+	if fileName == cache.HLSPlaylistName {
+		playlist := cache.HLSPlaylist(entry)
+		w.Header().Set("Content-Type", cache.HLSContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if _, err := strings.NewReader(playlist).WriteTo(w); err != nil {
+			slog.Debug("playlist write error", "error", err)
+		}
+		slog.Debug("served HLS playlist", "item_id", entry.ItemID, "segments", cache.HLSSegmentCount(entry))
+		return
+	}
+
+	index, _ := strconv.Atoi(hlsSegmentPattern.FindStringSubmatch(fileName)[1])
+	if index >= cache.HLSSegmentCount(entry) {
+		slog.Warn("HLS segment index out of range", "item_id", entry.ItemID, "requested_segment", index)
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	path, err := h.hls.Segment(r.Context(), entry, index)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// The player gave up waiting; the cut continues for its next request
+			return
+		}
+		slog.Error("failed to cut HLS segment", "item_id", entry.ItemID, "segment", index, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Error("failed to open HLS segment", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		slog.Error("failed to stat HLS segment", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.serveFile(w, r, file, fileInfo.Size(), cache.HLSSegmentType)
+}
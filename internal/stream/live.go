@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// livePattern matches live stream file names like "live_3600.mp3"
+var livePattern = regexp.MustCompile(`^live_(\d+)\.mp3$`)
+
+// serveLive streams an entry transcoded from its source files, starting at
+// the offset in the file name. Live streams can't seek; players seek by
+// requesting a stream with another offset.
+func (h *Handler) serveLive(w http.ResponseWriter, r *http.Request, entry *store.CacheEntry, fileName string) {
+	if h.live == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !cache.LiveSourcesAvailable(entry.SourcePaths) {
+		slog.Warn("live stream requested but source files are not available", "item_id", entry.ItemID)
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	offsetSec, _ := strconv.Atoi(livePattern.FindStringSubmatch(fileName)[1])
+
+	h.touchAccess(entry.ItemID)
+
+	w.Header().Set("Content-Type", cache.LiveContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	slog.Debug("starting live stream", "item_id", entry.ItemID, "offset_sec", offsetSec)
+	if err := h.live.Stream(r.Context(), w, entry, offsetSec); err != nil {
+		if errors.Is(err, context.Canceled) || r.Context().Err() != nil {
+			// The player stopped or requested another offset
+			return
+		}
+		if errors.Is(err, cache.ErrLiveBusy) {
+			slog.Warn("too many live streams", "item_id", entry.ItemID, "offset_sec", offsetSec)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "too many live streams", http.StatusServiceUnavailable)
+			return
+		}
+		slog.Error("live stream failed", "item_id", entry.ItemID, "offset_sec", offsetSec, "error", err)
+	}
+}
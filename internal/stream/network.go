@@ -0,0 +1,135 @@
+package stream
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// NetworkFilter restricts streaming to clients in allowed networks. Requests
+// forwarded by a trusted reverse proxy are attributed to the client named in
+// X-Forwarded-For or X-Real-IP.
+type NetworkFilter struct {
+	allowed []netip.Prefix // Empty allows all clients
+	proxies []netip.Prefix
+}
+
+// NewNetworkFilter creates a filter from CIDR ranges or single IP addresses.
+func NewNetworkFilter(allowed, trustedProxies []string) (*NetworkFilter, error) {
+	allowedPrefixes, err := ParsePrefixes(allowed)
+	if err != nil {
+		return nil, fmt.Errorf("allowed networks: %w", err)
+	}
+	proxyPrefixes, err := ParsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	return &NetworkFilter{allowed: allowedPrefixes, proxies: proxyPrefixes}, nil
+}
+
+// ParsePrefixes parses CIDR ranges like "192.168.0.0/16" and single IP
+// addresses, which match only themselves.
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether the client of a request may stream, and returns
+// the client address it checked.
+func (f *NetworkFilter) Allowed(r *http.Request) (netip.Addr, bool) {
+	client, ok := f.ClientAddr(r)
+	if !ok {
+		return client, len(f.allowed) == 0
+	}
+	if len(f.allowed) == 0 {
+		return client, true
+	}
+	return client, containsAddr(f.allowed, client)
+}
+
+// ClientAddr returns the address of the client that sent a request. If the
+// request comes from a trusted proxy, X-Forwarded-For is read from right to
+// left and the first address that isn't a trusted proxy is the client;
+// X-Real-IP is used if there is no X-Forwarded-For header. Returns false if
+// the forwarded client can't be determined from malformed headers, along
+// with the last trusted hop.
+func (f *NetworkFilter) ClientAddr(r *http.Request) (netip.Addr, bool) {
+	addr, ok := parseRemoteAddr(r.RemoteAddr)
+	if !ok || !containsAddr(f.proxies, addr) {
+		return addr, ok
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		header := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+		if header == "" {
+			return addr, true
+		}
+		realIP, err := netip.ParseAddr(header)
+		if err != nil {
+			return addr, false
+		}
+		return realIP.Unmap(), true
+	}
+
+	var hops []string
+	for _, header := range forwarded {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A malformed entry may hide the real client
+			return addr, false
+		}
+		addr = hop.Unmap()
+		if !containsAddr(f.proxies, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// parseRemoteAddr parses the address of http.Request.RemoteAddr.
+func parseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
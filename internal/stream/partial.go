@@ -0,0 +1,278 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// partialPollInterval is how often a reader that got ahead of the encoder
+// checks for new data.
+var partialPollInterval = 250 * time.Millisecond
+
+// errEncodeAborted is returned when a progressive encode stops before its
+// output is complete.
+var errEncodeAborted = errors.New("encode aborted")
+
+// partialFile is the output of a progressive encode that is still being
+// written. Reads past the written data block until the encoder catches up.
+type partialFile struct {
+	ctx       context.Context
+	file      *os.File
+	finalPath string      // The partial file is renamed to finalPath when complete
+	aborted   func() bool // Reports whether the encode stopped without completing
+	complete  bool
+}
+
+// openPartial waits for the output at finalPath to appear, either still being
+// written (finalPath + cache.PartialSuffix) or already complete.
+func openPartial(ctx context.Context, finalPath string, aborted func() bool) (*partialFile, error) {
+	for {
+		if file, err := os.Open(finalPath); err == nil {
+			return &partialFile{ctx: ctx, file: file, finalPath: finalPath, aborted: aborted, complete: true}, nil
+		}
+		if file, err := os.Open(finalPath + cache.PartialSuffix); err == nil {
+			return &partialFile{ctx: ctx, file: file, finalPath: finalPath, aborted: aborted}, nil
+		}
+
+		// The segment may not have been started yet
+		if aborted() {
+			return nil, errEncodeAborted
+		}
+		if err := sleepContext(ctx, partialPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the underlying file.
+func (p *partialFile) Close() error {
+	return p.file.Close()
+}
+
+// isComplete reports whether the encoder has finished writing the file.
+// The encoder renames the partial file when it is done, so the open file is
+// complete once it can be found under its final name.
+func (p *partialFile) isComplete() bool {
+	if p.complete {
+		return true
+	}
+
+	finalInfo, err := os.Stat(p.finalPath)
+	if err != nil {
+		return false
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		return false
+	}
+	p.complete = os.SameFile(finalInfo, info)
+	return p.complete
+}
+
+// Size returns the number of bytes written so far.
+func (p *partialFile) Size() (int64, error) {
+	info, err := p.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// waitFor blocks until more than offset bytes have been written or the file
+// is complete. Returns the current size.
+func (p *partialFile) waitFor(offset int64) (int64, error) {
+	for {
+		// Check completion first, so no data written before the rename is missed
+		complete := p.isComplete()
+
+		size, err := p.Size()
+		if err != nil {
+			return 0, err
+		}
+		if size > offset || complete {
+			return size, nil
+		}
+
+		if p.aborted() {
+			return 0, errEncodeAborted
+		}
+		if err := sleepContext(p.ctx, partialPollInterval); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Read reads from the current position, waiting for the encoder if needed.
+// Returns io.EOF only once the file is complete.
+func (p *partialFile) Read(b []byte) (int, error) {
+	for {
+		n, err := p.file.Read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		offset, err := p.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		size, err := p.waitFor(offset)
+		if err != nil {
+			return 0, err
+		}
+		if size <= offset && p.complete {
+			return 0, io.EOF
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// servePartial streams a cache file that is still being encoded.
+//
+// Without a Range header (or from byte 0) the response follows the file until
+// the encode completes. A range starting further in is held until the encoder
+// has written past the start of the range and is answered with the data
+// available so far; the total length is unknown ("*"), so the client requests
+// the rest later.
+func (h *Handler) servePartial(w http.ResponseWriter, r *http.Request, entry *store.CacheEntry, cachePath string, mimeType string) {
+	// A job that failed or was queued again won't finish this file
+	aborted := func() bool {
+		current, err := h.cacheIndex.GetEntry(entry.ItemID)
+		if err != nil || current == nil {
+			return true
+		}
+		return current.Status != store.CacheStatusInProgress && current.Status != store.CacheStatusReady
+	}
+
+	file, err := openPartial(r.Context(), cachePath, aborted)
+	if err != nil {
+		if errors.Is(err, errEncodeAborted) {
+			slog.Warn("progressive encode aborted", "item_id", entry.ItemID, "path", cachePath)
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+		return
+	}
+	defer file.Close()
+
+	if file.complete {
+		info, err := file.file.Stat()
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		h.serveFile(w, r, file.file, info.Size(), mimeType)
+		return
+	}
+
+	start, end, ok := parseOpenRange(r.Header.Get("Range"))
+	if !ok || start == 0 {
+		// Follow the growing file until the encode completes
+		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		written, err := io.Copy(w, file)
+		if err != nil {
+			slog.Debug("partial stream copy error", "item_id", entry.ItemID, "error", err)
+		}
+		slog.Debug("streamed partial file", "item_id", entry.ItemID, "size", written)
+		return
+	}
+
+	// Hold the request until the encoder has reached the requested position
+	size, err := file.waitFor(start)
+	if err != nil {
+		if errors.Is(err, errEncodeAborted) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	if file.complete {
+		h.handleRangeRequest(w, r, file.file, size, r.Header.Get("Range"), mimeType)
+		return
+	}
+
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	if _, err := file.file.Seek(start, io.SeekStart); err != nil {
+		http.Error(w, "seek error", http.StatusInternalServerError)
+		return
+	}
+
+	contentLength := end - start + 1
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusPartialContent)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := io.CopyN(w, file.file, contentLength); err != nil {
+		slog.Debug("partial range copy error", "error", err)
+	}
+
+	slog.Debug("streamed partial range", "item_id", entry.ItemID, "start", start, "end", end)
+}
+
+// parseOpenRange parses a "bytes=start-end" or "bytes=start-" Range header.
+// end is -1 for open ranges. Suffix ranges need the total length and are
+// not supported while it is unknown.
+func parseOpenRange(rangeHeader string) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(rangeHeader, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found || startStr == "" {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+
+	end = -1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+
+	return start, end, true
+}
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -94,6 +95,347 @@ func TestTokenGenerator_Validate_InvalidEncoding(t *testing.T) {
 }
 
 // setupTestCacheIndex creates a test cache index with database.
+func TestTokenGenerator_Renew(t *testing.T) {
+	expired := NewTokenGenerator("test-secret", -time.Hour)
+	token, err := expired.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := NewTokenGenerator("test-secret", time.Hour)
+	payload, err := gen.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse of expired token failed: %v", err)
+	}
+	if payload.ItemID != "item-123" {
+		t.Errorf("expected item-123, got %q", payload.ItemID)
+	}
+
+	renewed, err := gen.Renew(token)
+	if err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	payload, err = gen.Validate(renewed)
+	if err != nil {
+		t.Fatalf("renewed token is invalid: %v", err)
+	}
+	if payload.ItemID != "item-123" || payload.UserID != "user-456" || payload.SessionID != "session-789" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if time.Until(payload.ExpiresAt) < 59*time.Minute {
+		t.Errorf("expected renewed token to expire in 1h, got %v", payload.ExpiresAt)
+	}
+
+	if _, err := NewTokenGenerator("other-secret", time.Hour).Renew(token); err == nil {
+		t.Error("expected error renewing a token with another secret")
+	}
+}
+
+func TestTokenGenerator_Revocation(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gen := NewTokenGenerator("test-secret", time.Hour)
+	gen.SetRevocations(store.NewRevocationStore(db))
+
+	token, err := gen.GenerateForPlayback("item-123", "user-456", "session-789", "playback-1", "192.168.1.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := gen.Validate(token)
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if payload.TokenID == "" || payload.PlaybackID != "playback-1" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+	if payload.SpeakerIP != "" {
+		t.Errorf("expected no speaker binding by default, got %q", payload.SpeakerIP)
+	}
+
+	other, err := gen.GenerateForPlayback("item-123", "user-456", "session-789", "playback-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gen.RevokeToken(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gen.Validate(other); err != ErrTokenRevoked {
+		t.Errorf("expected revoked token, got %v", err)
+	}
+	if _, err := gen.Validate(token); err != nil {
+		t.Errorf("expected token of other playback to stay valid, got %v", err)
+	}
+
+	if err := gen.RevokePlayback("playback-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gen.Validate(token); err != ErrTokenRevoked {
+		t.Errorf("expected token of revoked playback to be rejected, got %v", err)
+	}
+	if _, err := gen.Renew(token); err != ErrTokenRevoked {
+		t.Errorf("expected revoked token not to be renewed, got %v", err)
+	}
+
+	sessionToken, err := gen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.RevokeSession("session-789"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gen.Validate(sessionToken); err != ErrTokenRevoked {
+		t.Errorf("expected token of revoked session to be rejected, got %v", err)
+	}
+}
+
+func TestHandler_HandleStream_SpeakerBinding(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, tmpDir)
+	createTestCacheEntry(t, cacheIndex, "item-123", "mp3")
+	itemDir := filepath.Join(tmpDir, "item-123")
+	if err := os.MkdirAll(itemDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(itemDir, "audio.mp3"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	tokenGen.SetSpeakerBinding(true)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+
+	token, err := tokenGen.GenerateForPlayback("item-123", "user-456", "session-789", "playback-1", "192.168.1.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := tokenGen.Renew(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		token      string
+		remoteAddr string
+		want       int
+	}{
+		{token, "192.168.1.50:40000", http.StatusOK},
+		{token, "192.168.1.51:40000", http.StatusForbidden},
+		{renewed, "192.168.1.50:40000", http.StatusOK},
+		{renewed, "192.168.1.51:40000", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/stream/"+tt.token+"/audio.mp3", nil)
+		req.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+
+		handler.HandleStream(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("request from %s: expected status %d, got %d", tt.remoteAddr, tt.want, w.Code)
+		}
+	}
+}
+
+func TestHandler_HandleStream_HLS(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, tmpDir)
+	createTestCacheEntry(t, cacheIndex, "item-123", "mp3")
+
+	// A segment that was cut before is served without running ffmpeg
+	hlsDir := filepath.Join(tmpDir, "item-123", "hls")
+	if err := os.MkdirAll(hlsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(hlsDir, "hls_00000.ts"), []byte("segment"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+	token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HLS is disabled until a segmenter is set
+	req := httptest.NewRequest("GET", "/stream/"+token+"/index.m3u8", nil)
+	w := httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without HLS, got %d", w.Code)
+	}
+
+	handler.SetHLS(cache.NewHLS(cacheIndex))
+
+	req = httptest.NewRequest("GET", "/stream/"+token+"/index.m3u8", nil)
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for playlist, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != cache.HLSContentType {
+		t.Errorf("expected playlist content type %s, got %s", cache.HLSContentType, ct)
+	}
+	// 300 seconds in 10 second segments
+	if got := strings.Count(w.Body.String(), "#EXTINF:10.000,"); got != 30 {
+		t.Errorf("expected 30 segments in playlist, got %d", got)
+	}
+
+	req = httptest.NewRequest("GET", "/stream/"+token+"/hls_00000.ts", nil)
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "segment" {
+		t.Errorf("expected segment to be served, got status %d, body %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != cache.HLSSegmentType {
+		t.Errorf("expected segment content type %s, got %s", cache.HLSSegmentType, ct)
+	}
+
+	req = httptest.NewRequest("GET", "/stream/"+token+"/hls_00030.ts", nil)
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for segment out of range, got %d", w.Code)
+	}
+}
+
+func TestHandler_HandleStream_Live(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, tmpDir)
+	if err := cacheIndex.CreateEntryWithFormat("item-123", "/test/source.mp3", 1000, time.Now(), "mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+	token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Live streams are disabled until a live transcoder is set
+	req := httptest.NewRequest("GET", "/stream/"+token+"/live_600.mp3", nil)
+	w := httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without live streaming, got %d", w.Code)
+	}
+
+	handler.SetLive(cache.NewLive(2))
+
+	// The entry doesn't record its source files yet
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without source files, got %d", w.Code)
+	}
+
+	sourcePath := filepath.Join(tmpDir, "source.mp3")
+	if err := os.WriteFile(sourcePath, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheIndex.SetSourcePaths("item-123", []string{sourcePath}); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest("HEAD", "/stream/"+token+"/live_600.mp3", nil)
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for live stream, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != cache.LiveContentType {
+		t.Errorf("expected live content type %s, got %s", cache.LiveContentType, ct)
+	}
+	if ar := w.Header().Get("Accept-Ranges"); ar != "none" {
+		t.Errorf("expected live stream without ranges, got %q", ar)
+	}
+}
+
+func TestNetworkFilter_ClientAddr(t *testing.T) {
+	filter, err := NewNetworkFilter([]string{"192.168.0.0/16"}, []string{"10.0.0.1", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+		allowed    bool
+	}{
+		{"direct", "192.168.1.20:5000", "", "", "192.168.1.20", true},
+		{"direct outside", "8.8.8.8:5000", "", "", "8.8.8.8", false},
+		{"ipv4-mapped", "[::ffff:192.168.1.20]:5000", "", "", "192.168.1.20", true},
+		{"untrusted forwarded", "8.8.8.8:5000", "192.168.1.20", "", "8.8.8.8", false},
+		{"trusted proxy", "10.0.0.1:5000", "192.168.1.20", "", "192.168.1.20", true},
+		{"proxy chain", "10.0.0.1:5000", "8.8.8.8, 192.168.1.20, 172.17.0.2", "", "192.168.1.20", true},
+		{"spoofed hop", "10.0.0.1:5000", "192.168.1.20, 8.8.8.8", "", "8.8.8.8", false},
+		{"real ip", "10.0.0.1:5000", "", "192.168.1.30", "192.168.1.30", true},
+		{"proxy only", "10.0.0.1:5000", "", "", "10.0.0.1", false},
+		{"malformed hop", "10.0.0.1:5000", "garbage, 172.17.0.2", "", "172.17.0.2", false},
+		{"malformed real ip", "10.0.0.1:5000", "", "garbage", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/stream/token/audio.mp3", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			client, allowed := filter.Allowed(req)
+			if client.String() != tt.want {
+				t.Errorf("expected client %s, got %s", tt.want, client)
+			}
+			if allowed != tt.allowed {
+				t.Errorf("expected allowed=%v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestNetworkFilter_MalformedForwardedFromAllowedProxy(t *testing.T) {
+	// The proxy itself is in an allowed network
+	filter, err := NewNetworkFilter([]string{"192.168.0.0/16"}, []string{"192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/stream/token/audio.mp3", nil)
+	req.RemoteAddr = "192.168.1.1:5000"
+	req.Header.Set("X-Forwarded-For", "not-an-address")
+
+	if _, allowed := filter.Allowed(req); allowed {
+		t.Error("expected request with a malformed X-Forwarded-For to be rejected")
+	}
+}
+
+func TestNetworkFilter_EmptyAllowsAll(t *testing.T) {
+	filter, err := NewNetworkFilter(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/stream/token/audio.mp3", nil)
+	req.RemoteAddr = "8.8.8.8:5000"
+	if _, allowed := filter.Allowed(req); !allowed {
+		t.Error("expected empty allowlist to allow all clients")
+	}
+
+	if _, err := NewNetworkFilter([]string{"not-a-network"}, nil); err == nil {
+		t.Error("expected error for invalid network")
+	}
+}
+
 func setupTestCacheIndex(t *testing.T, cacheDir string) *cache.Index {
 	t.Helper()
 
@@ -223,6 +565,48 @@ func TestHandler_HandleStream_RangeRequest(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleStream_Passthrough(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, filepath.Join(tmpDir, "cache"))
+
+	// The source file lives on the media volume, outside the cache
+	sourcePath := filepath.Join(tmpDir, "media", "book.m4b")
+	if err := os.MkdirAll(filepath.Dir(sourcePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sourcePath, []byte("0123456789ABCDEF"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheIndex.CreateEntryWithFormat("item-123", sourcePath, 16, time.Now(), "mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheIndex.MarkPassthrough("item-123", 300, "mp4", sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+	token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/stream/"+token+"/audio.m4a", nil)
+	req.Header.Set("Range", "bytes=10-15")
+	w := httptest.NewRecorder()
+	handler.HandleStream(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", w.Code)
+	}
+	if w.Body.String() != "ABCDEF" {
+		t.Errorf("expected body %q, got %q", "ABCDEF", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "audio/mp4" {
+		t.Errorf("expected content type audio/mp4, got %s", ct)
+	}
+}
+
 func TestHandler_HandleStream_InvalidToken(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -242,6 +626,35 @@ func TestHandler_HandleStream_InvalidToken(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleStream_DisallowedNetwork(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, tmpDir)
+	createTestCacheEntry(t, cacheIndex, "item-123", "mp3")
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+	filter, err := NewNetworkFilter([]string{"192.168.0.0/16"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetNetworkFilter(filter)
+
+	token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/stream/"+token+"/audio.mp3", nil)
+	req.RemoteAddr = "8.8.8.8:5000"
+	w := httptest.NewRecorder()
+
+	handler.HandleStream(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
 func TestHandler_HandleStream_FileNotFound(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -267,6 +680,120 @@ func TestHandler_HandleStream_FileNotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_HandleStream_PartialFile(t *testing.T) {
+	partialPollInterval = 10 * time.Millisecond
+
+	tmpDir := t.TempDir()
+	cacheIndex := setupTestCacheIndex(t, tmpDir)
+	if err := cacheIndex.CreateEntryWithFormat("item-123", "/test/source.mp3", 1000, time.Now(), "mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheIndex.MarkInProgress("item-123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheIndex.EnsureDirectory("item-123"); err != nil {
+		t.Fatal(err)
+	}
+
+	audioFile := filepath.Join(tmpDir, "item-123", "audio.mp3")
+	partialFile := audioFile + cache.PartialSuffix
+	if err := os.WriteFile(partialFile, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenGen := NewTokenGenerator("test-secret", time.Hour)
+	handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+	token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Range within the written data
+	req := httptest.NewRequest("GET", "/stream/"+token+"/audio.mp3", nil)
+	req.Header.Set("Range", "bytes=5-")
+	w := httptest.NewRecorder()
+	handler.HandleStream(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status 206, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Range") != "bytes 5-9/*" {
+		t.Errorf("expected Content-Range 'bytes 5-9/*', got %q", w.Header().Get("Content-Range"))
+	}
+	if w.Body.String() != "56789" {
+		t.Errorf("expected body %q, got %q", "56789", w.Body.String())
+	}
+
+	// The encoder appends more data and completes while the full request is read
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, err := os.OpenFile(partialFile, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		f.Write([]byte("ABCDEF"))
+		f.Close()
+		os.Rename(partialFile, audioFile)
+	}()
+
+	req = httptest.NewRequest("GET", "/stream/"+token+"/audio.mp3", nil)
+	w = httptest.NewRecorder()
+	handler.HandleStream(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != "0123456789ABCDEF" {
+		t.Errorf("expected full body, got %q", w.Body.String())
+	}
+}
+
+func TestHandler_HandleStream_PartialFileAborted(t *testing.T) {
+	partialPollInterval = 10 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		abort func(idx *cache.Index) error
+	}{
+		{"failed", func(idx *cache.Index) error { return idx.MarkFailed("item-123", "ffmpeg failed") }},
+		{"queued for retry", func(idx *cache.Index) error { return idx.MarkPending("item-123", "ffmpeg failed") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			cacheIndex := setupTestCacheIndex(t, tmpDir)
+			if err := cacheIndex.CreateEntryWithFormat("item-123", "/test/source.mp3", 1000, time.Now(), "mp3"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cacheIndex.MarkInProgress("item-123"); err != nil {
+				t.Fatal(err)
+			}
+
+			tokenGen := NewTokenGenerator("test-secret", time.Hour)
+			handler := NewHandler(tokenGen, cacheIndex, "http://localhost:8080")
+			token, err := tokenGen.Generate("item-123", "user-456", "session-789")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The encode stops before it writes any output
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				tt.abort(cacheIndex)
+			}()
+
+			req := httptest.NewRequest("GET", "/stream/"+token+"/audio.mp3", nil)
+			w := httptest.NewRecorder()
+			handler.HandleStream(w, req)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d", w.Code)
+			}
+		})
+	}
+}
+
 func TestHandler_GetStreamURL(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -2,26 +2,40 @@ package stream
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
 )
 
+// ErrTokenRevoked is returned by Validate for revoked tokens.
+var ErrTokenRevoked = errors.New("token revoked")
+
 // TokenPayload contains the data encoded in a stream token.
 type TokenPayload struct {
-	ItemID    string    `json:"item_id"`
-	UserID    string    `json:"user_id"`
-	SessionID string    `json:"session_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	TokenID    string    `json:"jti"`
+	ItemID     string    `json:"item_id"`
+	UserID     string    `json:"user_id"`
+	SessionID  string    `json:"session_id"`
+	PlaybackID string    `json:"playback_id,omitempty"` // Playback session the token was issued for
+	SpeakerIP  string    `json:"speaker_ip,omitempty"`  // Only this address may stream, if set
+	IssuedAt   time.Time `json:"issued_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
 }
 
 // TokenGenerator creates and validates HMAC-signed stream tokens.
 type TokenGenerator struct {
-	secret []byte
-	ttl    time.Duration
+	secret      []byte
+	ttl         time.Duration
+	revocations *store.RevocationStore // nil disables revocation
+	bindSpeaker bool
 }
 
 // NewTokenGenerator creates a new token generator.
@@ -32,14 +46,48 @@ func NewTokenGenerator(secret string, ttl time.Duration) *TokenGenerator {
 	}
 }
 
+// SetRevocations makes Validate reject tokens revoked in revocations.
+func (g *TokenGenerator) SetRevocations(revocations *store.RevocationStore) {
+	g.revocations = revocations
+}
+
+// SetSpeakerBinding sets whether tokens issued for a playback may only be
+// used by the speaker they were issued for.
+func (g *TokenGenerator) SetSpeakerBinding(enabled bool) {
+	g.bindSpeaker = enabled
+}
+
 // Generate creates a new signed token for streaming.
 func (g *TokenGenerator) Generate(itemID, userID, sessionID string) (string, error) {
+	return g.GenerateForPlayback(itemID, userID, sessionID, "", "")
+}
+
+// GenerateForPlayback creates a new signed token for streaming to a playback
+// session on the speaker at speakerIP. The token is bound to the speaker if
+// speaker binding is enabled.
+func (g *TokenGenerator) GenerateForPlayback(itemID, userID, sessionID, playbackID, speakerIP string) (string, error) {
+	now := time.Now()
 	payload := TokenPayload{
-		ItemID:    itemID,
-		UserID:    userID,
-		SessionID: sessionID,
-		ExpiresAt: time.Now().Add(g.ttl),
+		ItemID:     itemID,
+		UserID:     userID,
+		SessionID:  sessionID,
+		PlaybackID: playbackID,
+		IssuedAt:   now,
+		ExpiresAt:  now.Add(g.ttl),
+	}
+	if g.bindSpeaker {
+		payload.SpeakerIP = speakerIP
 	}
+	return g.sign(payload)
+}
+
+// sign creates a token with a new token ID for a payload.
+func (g *TokenGenerator) sign(payload TokenPayload) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	payload.TokenID = hex.EncodeToString(id)
 
 	// Encode payload to JSON
 	payloadBytes, err := json.Marshal(payload)
@@ -48,7 +96,7 @@ func (g *TokenGenerator) Generate(itemID, userID, sessionID string) (string, err
 	}
 
 	// Create HMAC signature
-	sig := g.sign(payloadBytes)
+	sig := g.mac(payloadBytes)
 
 	// Combine payload and signature
 	token := Token{
@@ -71,8 +119,105 @@ type Token struct {
 	Signature []byte `json:"s"`
 }
 
+// TTL returns the validity of new tokens.
+func (g *TokenGenerator) TTL() time.Duration {
+	return g.ttl
+}
+
 // Validate verifies a token and returns its payload.
 func (g *TokenGenerator) Validate(tokenStr string) (*TokenPayload, error) {
+	payload, err := g.Parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check expiration
+	if time.Now().After(payload.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	if err := g.checkRevoked(payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Renew creates a new token for the item, user, session, playback and
+// speaker of a token that is valid or has expired. Revoked tokens aren't
+// renewed.
+func (g *TokenGenerator) Renew(tokenStr string) (string, error) {
+	payload, err := g.Parse(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	if err := g.checkRevoked(payload); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	renewed := *payload
+	renewed.IssuedAt = now
+	renewed.ExpiresAt = now.Add(g.ttl)
+	return g.sign(renewed)
+}
+
+// checkRevoked returns ErrTokenRevoked if the token was revoked. Tokens
+// can't be used if the revocation list can't be read.
+func (g *TokenGenerator) checkRevoked(payload *TokenPayload) error {
+	if g.revocations == nil {
+		return nil
+	}
+	revoked, err := g.revocations.IsRevoked(payload.TokenID, payload.PlaybackID, payload.SessionID, payload.IssuedAt)
+	if err != nil {
+		slog.Error("failed to check stream token revocation", "error", err)
+		return errors.New("revocation check failed")
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// RevokePlayback revokes all tokens issued for a playback session.
+func (g *TokenGenerator) RevokePlayback(playbackID string) error {
+	return g.revoke(store.RevokePlayback, playbackID)
+}
+
+// RevokeSession revokes all tokens issued to a web session.
+func (g *TokenGenerator) RevokeSession(sessionID string) error {
+	return g.revoke(store.RevokeSession, sessionID)
+}
+
+// RevokeToken revokes a single token. Tokens that can't be parsed are
+// ignored, as they aren't valid anyway.
+func (g *TokenGenerator) RevokeToken(tokenStr string) error {
+	payload, err := g.Parse(tokenStr)
+	if err != nil || payload.TokenID == "" {
+		return nil
+	}
+	return g.revoke(store.RevokeToken, payload.TokenID)
+}
+
+func (g *TokenGenerator) revoke(scope store.RevocationScope, subject string) error {
+	if g.revocations == nil || subject == "" {
+		return nil
+	}
+	return g.revocations.Revoke(scope, subject)
+}
+
+// PruneRevocations removes revocations that are older than the token TTL,
+// as all tokens they apply to have expired.
+func (g *TokenGenerator) PruneRevocations() (int64, error) {
+	if g.revocations == nil {
+		return 0, nil
+	}
+	return g.revocations.DeleteBefore(time.Now().Add(-g.ttl))
+}
+
+// Parse verifies a token's signature and returns its payload without
+// checking its expiration.
+func (g *TokenGenerator) Parse(tokenStr string) (*TokenPayload, error) {
 	// Decode base64
 	tokenBytes, err := base64.URLEncoding.DecodeString(tokenStr)
 	if err != nil {
@@ -86,7 +231,7 @@ func (g *TokenGenerator) Validate(tokenStr string) (*TokenPayload, error) {
 	}
 
 	// Verify signature
-	expectedSig := g.sign(token.Payload)
+	expectedSig := g.mac(token.Payload)
 	if !hmac.Equal(token.Signature, expectedSig) {
 		return nil, errors.New("invalid token signature")
 	}
@@ -97,16 +242,11 @@ func (g *TokenGenerator) Validate(tokenStr string) (*TokenPayload, error) {
 		return nil, errors.New("invalid payload format")
 	}
 
-	// Check expiration
-	if time.Now().After(payload.ExpiresAt) {
-		return nil, errors.New("token expired")
-	}
-
 	return &payload, nil
 }
 
-// sign creates an HMAC-SHA256 signature.
-func (g *TokenGenerator) sign(data []byte) []byte {
+// mac creates an HMAC-SHA256 signature.
+func (g *TokenGenerator) mac(data []byte) []byte {
 	mac := hmac.New(sha256.New, g.secret)
 	mac.Write(data)
 	return mac.Sum(nil)
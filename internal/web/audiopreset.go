@@ -0,0 +1,86 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// AudioPresetOption is an audio preset choice on the item page.
+type AudioPresetOption struct {
+	Value string
+	Label string
+}
+
+// audioPresetLabels are the display names of the audio presets.
+var audioPresetLabels = map[string]string{
+	cache.PresetNone:     "Aus",
+	cache.PresetLoudnorm: "Lautheit angleichen",
+	cache.PresetNight:    "Nachtmodus",
+	cache.PresetSpeech:   "Sprache (Mono)",
+}
+
+// audioPresetOptions returns the choices for an item's audio preset.
+// The first option keeps the global preset.
+func audioPresetOptions(globalPreset string) []AudioPresetOption {
+	options := []AudioPresetOption{
+		{Value: "", Label: "Standard (" + audioPresetLabels[globalPreset] + ")"},
+	}
+	for _, preset := range cache.AudioPresets {
+		options = append(options, AudioPresetOption{Value: preset, Label: audioPresetLabels[preset]})
+	}
+	return options
+}
+
+// itemAudioPreset returns the audio preset chosen for an item, or "" if it
+// uses the global preset.
+func itemAudioPreset(itemSettings *store.ItemSettingsStore, itemID string) string {
+	preset, err := itemSettings.GetAudioPreset(itemID)
+	if err != nil {
+		slog.Warn("failed to get audio preset", "item_id", itemID, "error", err)
+		return ""
+	}
+	return preset
+}
+
+// resolveAudioPreset returns the audio preset to play an item with: its own
+// preset, or the global one.
+func resolveAudioPreset(itemSettings *store.ItemSettingsStore, itemID, globalPreset string) string {
+	if preset := itemAudioPreset(itemSettings, itemID); preset != "" {
+		return preset
+	}
+	return globalPreset
+}
+
+// HandleSetAudioPreset handles POST /item/{id}/audio-preset requests.
+// An empty preset makes the item use the global preset again. The preset
+// applies from the next time the item is played.
+func (h *LibraryHandler) HandleSetAudioPreset(w http.ResponseWriter, r *http.Request) {
+	itemID := r.PathValue("id")
+	if itemID == "" {
+		http.Error(w, "Item ID required", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	preset := r.FormValue("preset")
+	if preset != "" && !cache.IsAudioPreset(preset) {
+		http.Error(w, "unknown audio preset", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.itemSettings.SetAudioPreset(itemID, preset); err != nil {
+		slog.Error("failed to save audio preset", "item_id", itemID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("audio preset changed", "item_id", itemID, "preset", preset)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestLibraryHandler_HandleSetAudioPreset(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	itemSettings := store.NewItemSettingsStore(db)
+	h := NewLibraryHandler(nil, nil, nil, itemSettings, nil, "loudnorm")
+
+	tests := []struct {
+		preset   string
+		expected int
+		resolved string
+	}{
+		{"night", http.StatusOK, "night"},
+		{"none", http.StatusOK, "none"},
+		{"louder", http.StatusBadRequest, "none"},
+		{"", http.StatusOK, "loudnorm"}, // Back to the global preset
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			body := url.Values{"preset": {tt.preset}}.Encode()
+			req := httptest.NewRequest("POST", "/item/item-1/audio-preset", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetPathValue("id", "item-1")
+			rec := httptest.NewRecorder()
+
+			h.HandleSetAudioPreset(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if got := resolveAudioPreset(itemSettings, "item-1", "loudnorm"); got != tt.resolved {
+				t.Errorf("expected resolved preset %q, got %q", tt.resolved, got)
+			}
+		})
+	}
+}
+
+func TestAudioPresetOptions(t *testing.T) {
+	options := audioPresetOptions("night")
+	if len(options) != 5 {
+		t.Fatalf("expected 5 options, got %d", len(options))
+	}
+	if options[0].Value != "" || options[0].Label != "Standard (Nachtmodus)" {
+		t.Errorf("unexpected first option: %+v", options[0])
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"audiobookshelf-sonos-bridge/internal/abs"
@@ -22,11 +23,32 @@ const (
 	sessionIDLength   = 32
 )
 
+// loginHookTimeout bounds the background work started after a login.
+const loginHookTimeout = 5 * time.Minute
+
+// userTypeTTL is how long a session's ABS user type is trusted before
+// RequireAdmin checks it against ABS again.
+const userTypeTTL = 5 * time.Minute
+
+// LoginHook is run in the background after a user has logged in, with an
+// ABS client that uses the user's token.
+type LoginHook func(ctx context.Context, client *abs.Client)
+
+// TokenRevoker revokes the stream tokens of a web session.
+type TokenRevoker interface {
+	RevokeSession(sessionID string) error
+}
+
 // AuthHandler handles authentication-related HTTP requests.
 type AuthHandler struct {
 	absClient    *abs.Client
 	sessionStore *store.SessionStore
 	sessionKey   []byte // 32 bytes for AES-256
+	loginHooks   []LoginHook
+	tokenRevoker TokenRevoker
+
+	mu              sync.Mutex
+	userTypeChecked map[string]time.Time // When each session's user type was last checked
 }
 
 // NewAuthHandler creates a new authentication handler.
@@ -35,12 +57,37 @@ func NewAuthHandler(absClient *abs.Client, sessionStore *store.SessionStore, ses
 	key := deriveKey(sessionSecret)
 
 	return &AuthHandler{
-		absClient:    absClient,
-		sessionStore: sessionStore,
-		sessionKey:   key,
+		absClient:       absClient,
+		sessionStore:    sessionStore,
+		sessionKey:      key,
+		userTypeChecked: make(map[string]time.Time),
 	}, nil
 }
 
+// OnLogin registers a hook that runs after every successful login.
+func (h *AuthHandler) OnLogin(hook LoginHook) {
+	h.loginHooks = append(h.loginHooks, hook)
+}
+
+// SetTokenRevoker sets where the stream tokens of ended sessions are revoked.
+func (h *AuthHandler) SetTokenRevoker(revoker TokenRevoker) {
+	h.tokenRevoker = revoker
+}
+
+// deleteSession removes a session and revokes its stream tokens, so URLs
+// handed to speakers stop working as well.
+func (h *AuthHandler) deleteSession(sessionID string) {
+	h.sessionStore.Delete(sessionID)
+	h.mu.Lock()
+	delete(h.userTypeChecked, sessionID)
+	h.mu.Unlock()
+	if h.tokenRevoker != nil {
+		if err := h.tokenRevoker.RevokeSession(sessionID); err != nil {
+			slog.Warn("failed to revoke stream tokens of session", "session_id", sessionID, "error", err)
+		}
+	}
+}
+
 // deriveKey creates a 32-byte key from a secret string.
 func deriveKey(secret string) []byte {
 	// Simple key derivation: take first 32 bytes or pad with zeros
@@ -105,6 +152,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		ABSTokenEnc: encryptedToken,
 		ABSUserID:   user.ID,
 		ABSUsername: user.Username,
+		ABSUserType: user.Type,
 		CreatedAt:   time.Now(),
 		LastUsedAt:  time.Now(),
 	}
@@ -113,6 +161,17 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	h.markUserTypeChecked(sessionID)
+
+	// Run login hooks detached from the request, which ends with the redirect
+	client := h.absClient.WithToken(user.Token)
+	for _, hook := range h.loginHooks {
+		go func(hook LoginHook) {
+			ctx, cancel := context.WithTimeout(context.Background(), loginHookTimeout)
+			defer cancel()
+			hook(ctx, client)
+		}(hook)
+	}
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
@@ -133,8 +192,10 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// Get session ID from cookie
 	cookie, err := r.Cookie(sessionCookieName)
 	if err == nil && cookie.Value != "" {
-		// Delete session from database
-		h.sessionStore.Delete(cookie.Value)
+		// Delete session from database; unknown IDs aren't recorded as revoked
+		if session, err := h.sessionStore.Get(cookie.Value); err == nil && session != nil {
+			h.deleteSession(session.ID)
+		}
 	}
 
 	// Clear cookie
@@ -197,7 +258,7 @@ func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
 			if err != nil {
 				slog.Warn("session token invalid, clearing session", "error", err, "session_id", session.ID)
 				// Delete the invalid session from database
-				h.sessionStore.Delete(session.ID)
+				h.deleteSession(session.ID)
 				// Clear the invalid cookie
 				http.SetCookie(w, &http.Cookie{
 					Name:     sessionCookieName,
@@ -230,6 +291,77 @@ func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
 	})
 }
 
+// RequireAdmin is middleware that limits a handler to ABS admin and root users.
+// It must be wrapped by RequireAuth. The user type stored with the session is
+// checked against ABS again once it is older than userTypeTTL, so users who
+// were demoted or disabled in ABS lose access without logging out.
+func (h *AuthHandler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := SessionFromContext(r.Context())
+		if session == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if session.ABSUserType == "" || !h.userTypeFresh(session.ID) {
+			client, err := h.GetABSClientForSession(session)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			user, err := client.GetMe(r.Context())
+			if errors.Is(err, abs.ErrUnauthorized) {
+				// The ABS token no longer works, e.g. the user was disabled
+				slog.Warn("admin access denied, ABS token rejected", "username", session.ABSUsername, "path", r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				slog.Warn("failed to get ABS user", "error", err)
+				http.Error(w, "Failed to check permissions", http.StatusBadGateway)
+				return
+			}
+			if user.Type != session.ABSUserType {
+				session.ABSUserType = user.Type
+				if err := h.sessionStore.UpdateUserType(session.ID, user.Type); err != nil {
+					slog.Warn("failed to store ABS user type", "error", err)
+				}
+			}
+			h.markUserTypeChecked(session.ID)
+		}
+
+		if !session.IsAdmin() {
+			slog.Warn("admin access denied", "username", session.ABSUsername, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userTypeFresh reports whether the session's user type was checked against
+// ABS within userTypeTTL.
+func (h *AuthHandler) userTypeFresh(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	checked, ok := h.userTypeChecked[sessionID]
+	return ok && time.Since(checked) < userTypeTTL
+}
+
+// markUserTypeChecked records that the session's user type was just checked.
+// Expired checks are dropped, so sessions that ended don't pile up.
+func (h *AuthHandler) markUserTypeChecked(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, checked := range h.userTypeChecked {
+		if time.Since(checked) >= userTypeTTL {
+			delete(h.userTypeChecked, id)
+		}
+	}
+	h.userTypeChecked[sessionID] = time.Now()
+}
+
 // SessionFromContext retrieves the session from the request context.
 func SessionFromContext(ctx context.Context) *store.Session {
 	session, _ := ctx.Value(sessionContextKey).(*store.Session)
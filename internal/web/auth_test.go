@@ -10,6 +10,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"audiobookshelf-sonos-bridge/internal/abs"
 	"audiobookshelf-sonos-bridge/internal/store"
@@ -56,6 +57,10 @@ func setupAuthTest(t *testing.T) (*AuthHandler, *store.DB, *httptest.Server, fun
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if r.URL.Path == "/api/me" {
+			json.NewEncoder(w).Encode(abs.User{ID: "user-123", Username: "testuser", Type: "admin"})
+			return
+		}
 	}))
 
 	absClient := abs.NewClient(absServer.URL)
@@ -162,6 +167,9 @@ func TestHandleLogout(t *testing.T) {
 	authHandler, db, _, cleanup := setupAuthTest(t)
 	defer cleanup()
 
+	revoker := &recordingRevoker{}
+	authHandler.SetTokenRevoker(revoker)
+
 	// First create a session
 	sessionStore := store.NewSessionStore(db)
 	session := &store.Session{
@@ -195,6 +203,11 @@ func TestHandleLogout(t *testing.T) {
 		t.Error("session should be deleted")
 	}
 
+	// Stream tokens of the session should be revoked
+	if len(revoker.sessions) != 1 || revoker.sessions[0] != "test-session-id" {
+		t.Errorf("expected stream tokens of test-session-id to be revoked, got %v", revoker.sessions)
+	}
+
 	// Cookie should be cleared
 	cookies := rec.Result().Cookies()
 	for _, c := range cookies {
@@ -204,6 +217,37 @@ func TestHandleLogout(t *testing.T) {
 	}
 }
 
+// recordingRevoker records the sessions whose stream tokens were revoked.
+type recordingRevoker struct {
+	sessions []string
+}
+
+func (r *recordingRevoker) RevokeSession(sessionID string) error {
+	r.sessions = append(r.sessions, sessionID)
+	return nil
+}
+
+func TestHandleLogout_UnknownSession(t *testing.T) {
+	authHandler, _, _, cleanup := setupAuthTest(t)
+	defer cleanup()
+
+	revoker := &recordingRevoker{}
+	authHandler.SetTokenRevoker(revoker)
+
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "bridge_session", Value: "unknown-session"})
+	rec := httptest.NewRecorder()
+
+	authHandler.HandleLogout(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("expected status 303, got %d", rec.Code)
+	}
+	if len(revoker.sessions) != 0 {
+		t.Errorf("expected no revocation for an unknown session, got %v", revoker.sessions)
+	}
+}
+
 func TestRequireAuth_NoSession(t *testing.T) {
 	authHandler, _, _, cleanup := setupAuthTest(t)
 	defer cleanup()
@@ -291,6 +335,133 @@ func TestRequireAuth_APIRequest(t *testing.T) {
 	}
 }
 
+func TestRequireAdmin(t *testing.T) {
+	authHandler, db, _, cleanup := setupAuthTest(t)
+	defer cleanup()
+
+	sessionStore := store.NewSessionStore(db)
+	encryptedToken, _ := authHandler.EncryptToken("abs-token-xyz")
+
+	handler := authHandler.RequireAuth(authHandler.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		name     string
+		userType string
+		expected int
+	}{
+		{"user", "user", http.StatusForbidden},
+		{"guest", "guest", http.StatusForbidden},
+		{"admin", "admin", http.StatusOK},
+		{"root", "root", http.StatusOK},
+		{"unknown type is looked up", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID := "session-" + tt.userType
+			sessionStore.Create(&store.Session{
+				ID:          sessionID,
+				ABSTokenEnc: encryptedToken,
+				ABSUserID:   "user-123",
+				ABSUsername: "testuser",
+				ABSUserType: tt.userType,
+			})
+			if tt.userType != "" {
+				authHandler.markUserTypeChecked(sessionID)
+			}
+
+			req := httptest.NewRequest("GET", "/admin/cache", nil)
+			req.AddCookie(&http.Cookie{Name: "bridge_session", Value: sessionID})
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+		})
+	}
+
+	// The looked up user type is stored with the session
+	session, _ := sessionStore.Get("session-")
+	if session == nil || session.ABSUserType != "admin" {
+		t.Errorf("expected stored user type admin, got %+v", session)
+	}
+}
+
+func TestRequireAdmin_RecheckUserType(t *testing.T) {
+	authHandler, db, _, cleanup := setupAuthTest(t)
+	defer cleanup()
+
+	meStatus := http.StatusOK
+	userType := "admin"
+	absServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if meStatus != http.StatusOK {
+			w.WriteHeader(meStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(abs.User{ID: "user-123", Username: "testuser", Type: userType})
+	}))
+	defer absServer.Close()
+	authHandler.absClient = abs.NewClient(absServer.URL)
+
+	sessionStore := store.NewSessionStore(db)
+	encryptedToken, _ := authHandler.EncryptToken("abs-token-xyz")
+	sessionStore.Create(&store.Session{
+		ID:          "admin-session",
+		ABSTokenEnc: encryptedToken,
+		ABSUserID:   "user-123",
+		ABSUsername: "testuser",
+		ABSUserType: "admin",
+	})
+	authHandler.markUserTypeChecked("admin-session")
+
+	handler := authHandler.RequireAuth(authHandler.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	request := func() int {
+		req := httptest.NewRequest("GET", "/admin/cache", nil)
+		req.AddCookie(&http.Cookie{Name: "bridge_session", Value: "admin-session"})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	expire := func() {
+		authHandler.mu.Lock()
+		authHandler.userTypeChecked["admin-session"] = time.Now().Add(-userTypeTTL)
+		authHandler.mu.Unlock()
+	}
+
+	// The user is demoted in ABS; the checked type is trusted until it expires
+	userType = "user"
+	if code := request(); code != http.StatusOK {
+		t.Errorf("expected status 200 within the TTL, got %d", code)
+	}
+
+	expire()
+	if code := request(); code != http.StatusForbidden {
+		t.Errorf("expected status 403 after the demotion was checked, got %d", code)
+	}
+	session, _ := sessionStore.Get("admin-session")
+	if session == nil || session.ABSUserType != "user" {
+		t.Errorf("expected stored user type user, got %+v", session)
+	}
+
+	// Promoted again, then disabled: ABS rejects the token
+	userType = "admin"
+	expire()
+	if code := request(); code != http.StatusOK {
+		t.Errorf("expected status 200 after the promotion was checked, got %d", code)
+	}
+	meStatus = http.StatusUnauthorized
+	expire()
+	if code := request(); code != http.StatusForbidden {
+		t.Errorf("expected status 403 for a rejected token, got %d", code)
+	}
+}
+
 func TestTokenEncryption(t *testing.T) {
 	authHandler, _, _, cleanup := setupAuthTest(t)
 	defer cleanup()
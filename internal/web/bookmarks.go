@@ -0,0 +1,260 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/sonos"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// BookmarkResponse is the JSON representation of a bookmark.
+type BookmarkResponse struct {
+	Title   string  `json:"title"`
+	Time    float64 `json:"time"`
+	TimeStr string  `json:"time_str"`
+}
+
+// bookmarkPlayback resolves the playback and ABS client for a bookmark request.
+// Bookmarks belong to the item in ABS, so podcast episodes are not supported.
+func (h *PlayerHandler) bookmarkPlayback(w http.ResponseWriter, r *http.Request) (*store.Session, *store.PlaybackSession, *abs.Client, bool) {
+	session := GetSession(r.Context())
+	if session == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, nil, nil, false
+	}
+
+	playback, err := h.playbackStore.GetBySessionID(session.ID)
+	if err != nil || playback == nil {
+		http.Error(w, "no active playback", http.StatusNotFound)
+		return nil, nil, nil, false
+	}
+
+	if playback.EpisodeID != "" {
+		http.Error(w, "bookmarks are not supported for podcast episodes", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		slog.Error("failed to get ABS client for session", "error", err)
+		http.Error(w, "session error", http.StatusInternalServerError)
+		return nil, nil, nil, false
+	}
+
+	return session, playback, absClient, true
+}
+
+// playbackAVTransport returns the AVTransport of the group coordinator playing a session.
+func (h *PlayerHandler) playbackAVTransport(ctx context.Context, playback *store.PlaybackSession) (*sonos.AVTransport, error) {
+	device, err := h.sonosStore.Get(playback.SonosUUID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device not found: %s", playback.SonosUUID)
+	}
+	return sonos.NewAVTransport(h.getCoordinatorIP(ctx, device.IPAddress)), nil
+}
+
+// parseBookmarkTime parses the {time} path value of a bookmark route.
+func parseBookmarkTime(r *http.Request) (float64, bool) {
+	timeSec, err := strconv.ParseFloat(r.PathValue("time"), 64)
+	if err != nil || timeSec < 0 {
+		return 0, false
+	}
+	return timeSec, true
+}
+
+func toBookmarkResponse(b abs.Bookmark) BookmarkResponse {
+	return BookmarkResponse{
+		Title:   b.Title,
+		Time:    b.Time,
+		TimeStr: formatDurationSec(int(b.Time)),
+	}
+}
+
+// HandleListBookmarks handles GET /bookmarks requests for the playing item.
+func (h *PlayerHandler) HandleListBookmarks(w http.ResponseWriter, r *http.Request) {
+	_, playback, absClient, ok := h.bookmarkPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	bookmarks, err := absClient.GetBookmarks(r.Context(), playback.ItemID)
+	if err != nil {
+		slog.Error("failed to get bookmarks", "item_id", playback.ItemID, "error", err)
+		http.Error(w, "failed to get bookmarks", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]BookmarkResponse, 0, len(bookmarks))
+	for _, b := range bookmarks {
+		response = append(response, toBookmarkResponse(b))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleCreateBookmark handles POST /bookmarks requests.
+// The bookmark is created at the current Sonos position.
+func (h *PlayerHandler) HandleCreateBookmark(w http.ResponseWriter, r *http.Request) {
+	_, playback, absClient, ok := h.bookmarkPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	avt, err := h.playbackAVTransport(ctx, playback)
+	if err != nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	positionSec, err := h.currentPosition(ctx, avt, playback)
+	if err != nil {
+		slog.Error("failed to get position for bookmark", "error", err)
+		http.Error(w, "failed to get position", http.StatusInternalServerError)
+		return
+	}
+
+	title := r.FormValue("title")
+	if title == "" {
+		title = "Lesezeichen " + formatDurationSec(positionSec)
+	}
+
+	bookmark, err := absClient.CreateBookmark(ctx, playback.ItemID, abs.BookmarkRequest{
+		Title: title,
+		Time:  float64(positionSec),
+	})
+	if err != nil {
+		slog.Error("failed to create bookmark", "item_id", playback.ItemID, "error", err)
+		http.Error(w, "failed to create bookmark", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("bookmark created",
+		"item_id", playback.ItemID,
+		"position_sec", positionSec,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toBookmarkResponse(*bookmark))
+}
+
+// HandleJumpToBookmark handles POST /bookmarks/{time}/jump requests.
+func (h *PlayerHandler) HandleJumpToBookmark(w http.ResponseWriter, r *http.Request) {
+	session, playback, _, ok := h.bookmarkPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	timeSec, ok := parseBookmarkTime(r)
+	if !ok {
+		http.Error(w, "invalid bookmark time", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	avt, err := h.playbackAVTransport(ctx, playback)
+	if err != nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	targetGlobalPositionSec := int(timeSec)
+	if playback.DurationSec > 0 && targetGlobalPositionSec > playback.DurationSec {
+		targetGlobalPositionSec = playback.DurationSec
+	}
+
+	if err := h.seekTo(ctx, session, avt, playback, targetGlobalPositionSec); err != nil {
+		slog.Error("failed to seek to bookmark", "error", err)
+		http.Error(w, "failed to seek", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleUpdateBookmark handles PATCH /bookmarks/{time} requests to rename a bookmark.
+func (h *PlayerHandler) HandleUpdateBookmark(w http.ResponseWriter, r *http.Request) {
+	_, playback, absClient, ok := h.bookmarkPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	timeSec, ok := parseBookmarkTime(r)
+	if !ok {
+		http.Error(w, "invalid bookmark time", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	title := r.FormValue("title")
+	if title == "" {
+		http.Error(w, "title required", http.StatusBadRequest)
+		return
+	}
+
+	bookmark, err := absClient.UpdateBookmark(r.Context(), playback.ItemID, abs.BookmarkRequest{
+		Title: title,
+		Time:  timeSec,
+	})
+	if err == abs.ErrNotFound {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to update bookmark", "item_id", playback.ItemID, "error", err)
+		http.Error(w, "failed to update bookmark", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toBookmarkResponse(*bookmark))
+}
+
+// HandleDeleteBookmark handles DELETE /bookmarks/{time} requests.
+func (h *PlayerHandler) HandleDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	_, playback, absClient, ok := h.bookmarkPlayback(w, r)
+	if !ok {
+		return
+	}
+
+	timeSec, ok := parseBookmarkTime(r)
+	if !ok {
+		http.Error(w, "invalid bookmark time", http.StatusBadRequest)
+		return
+	}
+
+	err := absClient.DeleteBookmark(r.Context(), playback.ItemID, timeSec)
+	if err == abs.ErrNotFound {
+		http.Error(w, "bookmark not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete bookmark", "item_id", playback.ItemID, "error", err)
+		http.Error(w, "failed to delete bookmark", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("bookmark deleted", "item_id", playback.ItemID, "time", timeSec)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleBookmarks_Unauthorized(t *testing.T) {
+	h := &PlayerHandler{}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"list", "GET", "/bookmarks", h.HandleListBookmarks},
+		{"create", "POST", "/bookmarks", h.HandleCreateBookmark},
+		{"jump", "POST", "/bookmarks/120/jump", h.HandleJumpToBookmark},
+		{"update", "PATCH", "/bookmarks/120", h.HandleUpdateBookmark},
+		{"delete", "DELETE", "/bookmarks/120", h.HandleDeleteBookmark},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("expected status 401, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestParseBookmarkTime(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		ok       bool
+	}{
+		{"120", 120, true},
+		{"95.5", 95.5, true},
+		{"0", 0, true},
+		{"-5", 0, false},
+		{"abc", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/bookmarks/x/jump", nil)
+			req.SetPathValue("time", tt.value)
+
+			got, ok := parseBookmarkTime(req)
+			if ok != tt.ok || got != tt.expected {
+				t.Errorf("parseBookmarkTime(%q) = %v, %v; expected %v, %v", tt.value, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
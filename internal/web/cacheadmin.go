@@ -0,0 +1,670 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// enqueueTimeout bounds a background run that queues a whole series or library.
+const enqueueTimeout = 2 * time.Hour
+
+// enqueuePageSize is the number of library items fetched per request when queueing.
+const enqueuePageSize = 100
+
+// CacheAdminHandler serves the cache administration page and its JSON API.
+// All routes are limited to ABS admin and root users.
+type CacheAdminHandler struct {
+	authHandler   *AuthHandler
+	cacheStore    *store.CacheStore
+	cacheIndex    *cache.Index
+	cacheWorker   *cache.Worker
+	playbackStore *store.PlaybackStore
+	pathMapper    PathMapper
+	maxBytes      int64
+}
+
+// NewCacheAdminHandler creates a new cache admin handler.
+// maxBytes is the configured cache quota (0 = unlimited).
+func NewCacheAdminHandler(
+	authHandler *AuthHandler,
+	cacheStore *store.CacheStore,
+	cacheIndex *cache.Index,
+	cacheWorker *cache.Worker,
+	playbackStore *store.PlaybackStore,
+	pathMapper PathMapper,
+	maxBytes int64,
+) *CacheAdminHandler {
+	return &CacheAdminHandler{
+		authHandler:   authHandler,
+		cacheStore:    cacheStore,
+		cacheIndex:    cacheIndex,
+		cacheWorker:   cacheWorker,
+		playbackStore: playbackStore,
+		pathMapper:    pathMapper,
+		maxBytes:      maxBytes,
+	}
+}
+
+// CacheEntryInfo is a cache entry as shown on the admin page and returned by the API.
+type CacheEntryInfo struct {
+	ItemID       string     `json:"item_id"` // Cache key (item ID, or item and episode ID)
+	Profile      string     `json:"profile"` // Transcode profile variant ("" = default)
+	Status       string     `json:"status"`
+	Format       string     `json:"format"`
+	SegmentCount int        `json:"segment_count"`
+	DurationSec  int        `json:"duration_sec"`
+	SizeBytes    int64      `json:"size_bytes"`
+	SourceType   string     `json:"source_type"`
+	Passthrough  bool       `json:"passthrough"` // Served from the source file, without a cached copy
+	Error        string     `json:"error,omitempty"`
+	ErrorClass   string     `json:"error_class,omitempty"` // Class of the last failed attempt, e.g. "corrupt_input"
+	LastPlayedAt *time.Time `json:"last_played_at,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	InUse        bool       `json:"in_use"` // A playback session is using the entry
+	Job          *JobInfo   `json:"job,omitempty"`
+	LogURL       string     `json:"log_url,omitempty"` // ffmpeg log of the last attempt
+}
+
+// JobInfo is the transcoding job of a cache entry in the queue.
+type JobInfo struct {
+	Status        string     `json:"status"` // "queued" or "running"
+	Priority      int        `json:"priority"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // Set while waiting for a retry
+}
+
+// AttemptInfo is a recorded transcoding attempt, returned by
+// GET /api/cache/{id}/attempts.
+type AttemptInfo struct {
+	ID         int64     `json:"id"`
+	Attempt    int       `json:"attempt"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	LogURL     string    `json:"log_url"`
+}
+
+// attemptLogURL returns the URL of an attempt's ffmpeg log.
+func attemptLogURL(itemID string, attemptID int64) string {
+	return fmt.Sprintf("/api/cache/%s/attempts/%d/log", url.PathEscape(itemID), attemptID)
+}
+
+// CacheOverview is the response of GET /api/cache.
+type CacheOverview struct {
+	Entries          []CacheEntryInfo `json:"entries"`
+	TotalBytes       int64            `json:"total_bytes"`
+	MaxBytes         int64            `json:"max_bytes"`
+	QueueLength      int              `json:"queue_length"`
+	BackgroundPaused bool             `json:"background_paused"` // Outside the hours for background jobs
+}
+
+// overview collects all cache entries with their on-disk size.
+func (h *CacheAdminHandler) overview() (*CacheOverview, error) {
+	entries, err := h.cacheStore.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := h.inUse()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := h.cacheWorker.Jobs()
+	if err != nil {
+		return nil, err
+	}
+	jobsByItem := make(map[string]store.TranscodeJob, len(jobs))
+	for _, job := range jobs {
+		jobsByItem[job.ItemID] = job
+	}
+	attempts, err := h.cacheWorker.LatestAttempts()
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &CacheOverview{
+		Entries:          make([]CacheEntryInfo, 0, len(entries)),
+		TotalBytes:       h.cacheIndex.TotalDiskUsage(),
+		MaxBytes:         h.maxBytes,
+		QueueLength:      h.cacheWorker.QueueLength(),
+		BackgroundPaused: h.cacheWorker.BackgroundPaused(),
+	}
+	for _, e := range entries {
+		_, profile := store.SplitVariantKey(e.ItemID)
+		info := CacheEntryInfo{
+			ItemID:       e.ItemID,
+			Profile:      profile,
+			Status:       string(e.Status),
+			Format:       e.CacheFormat,
+			SegmentCount: e.SegmentCount,
+			SizeBytes:    h.cacheIndex.DiskUsage(e.ItemID),
+			SourceType:   e.SourceType,
+			Passthrough:  e.Passthrough,
+			Error:        e.ErrorText,
+			UpdatedAt:    e.UpdatedAt,
+			InUse:        inUse[e.ItemID],
+		}
+		if e.DurationSec != nil {
+			info.DurationSec = *e.DurationSec
+		}
+		if !e.LastAccessedAt.IsZero() {
+			lastPlayed := e.LastAccessedAt
+			info.LastPlayedAt = &lastPlayed
+		}
+		if job, ok := jobsByItem[e.ItemID]; ok {
+			info.Job = &JobInfo{
+				Status:   string(job.Status),
+				Priority: job.Priority,
+				Attempts: job.Attempts,
+			}
+			if job.Status == store.JobStatusQueued && job.NextAttemptAt.After(time.Now()) {
+				next := job.NextAttemptAt
+				info.Job.NextAttemptAt = &next
+			}
+		}
+		if a, ok := attempts[e.ItemID]; ok {
+			info.LogURL = attemptLogURL(e.ItemID, a.ID)
+			if e.Status == store.CacheStatusFailed || info.Job != nil {
+				info.ErrorClass = a.ErrorClass
+			}
+		}
+		overview.Entries = append(overview.Entries, info)
+	}
+
+	return overview, nil
+}
+
+// inUse returns the cache keys of all items with a playback session.
+func (h *CacheAdminHandler) inUse() (map[string]bool, error) {
+	sessions, err := h.playbackStore.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(sessions))
+	for _, ps := range sessions {
+		keys[ps.CacheKey()] = true
+	}
+	return keys, nil
+}
+
+// HandlePage renders GET /admin/cache.
+func (h *CacheAdminHandler) HandlePage(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	overview, err := h.overview()
+	if err != nil {
+		slog.Error("failed to list cache entries", "error", err)
+		http.Error(w, "Failed to load cache entries", http.StatusInternalServerError)
+		return
+	}
+
+	// Libraries are only needed for the enqueue form, so errors are not fatal
+	var libraries []abs.Library
+	if absClient, err := h.authHandler.GetABSClientForSession(session); err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if libraries, err = absClient.GetLibraries(ctx); err != nil {
+			slog.Warn("failed to get libraries", "error", err)
+		}
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Cache-Verwaltung",
+		"ShowHeader": true,
+		"Username":   session.ABSUsername,
+		"IsAdmin":    session.IsAdmin(),
+		"Overview":   overview,
+		"Libraries":  libraries,
+	}
+
+	renderPage(w, "cache-admin.html", data)
+}
+
+// HandleList handles GET /api/cache.
+func (h *CacheAdminHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	overview, err := h.overview()
+	if err != nil {
+		slog.Error("failed to list cache entries", "error", err)
+		http.Error(w, "failed to list cache entries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview)
+}
+
+// HandleRetry handles POST /api/cache/{id}/retry.
+// Queues a failed or pending entry again, keeping files that already exist.
+func (h *CacheAdminHandler) HandleRetry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryFromPath(w, r)
+	if !ok {
+		return
+	}
+	if entry.Status != store.CacheStatusFailed && entry.Status != store.CacheStatusPending {
+		http.Error(w, "only failed or pending entries can be retried", http.StatusConflict)
+		return
+	}
+
+	job, ok := h.jobForEntry(w, r, entry.ItemID)
+	if !ok {
+		return
+	}
+
+	if err := h.cacheStore.UpdateStatus(entry.ItemID, store.CacheStatusPending, ""); err != nil {
+		slog.Error("failed to reset cache entry", "item_id", entry.ItemID, "error", err)
+		http.Error(w, "failed to update cache entry", http.StatusInternalServerError)
+		return
+	}
+	job.Priority = cache.PriorityInteractive
+	if !h.cacheWorker.Enqueue(job) {
+		http.Error(w, "failed to queue job", http.StatusConflict)
+		return
+	}
+
+	slog.Info("cache entry queued for retry", "item_id", entry.ItemID)
+	writeStatus(w, http.StatusAccepted, "queued")
+}
+
+// HandleRetranscode handles POST /api/cache/{id}/retranscode.
+// Removes the cached files and transcodes the item from scratch.
+func (h *CacheAdminHandler) HandleRetranscode(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryFromPath(w, r)
+	if !ok || !h.checkRemovable(w, entry) {
+		return
+	}
+
+	job, ok := h.jobForEntry(w, r, entry.ItemID)
+	if !ok {
+		return
+	}
+
+	if err := h.cacheIndex.Remove(entry.ItemID); err != nil {
+		slog.Error("failed to remove cache entry", "item_id", entry.ItemID, "error", err)
+		http.Error(w, "failed to remove cache entry", http.StatusInternalServerError)
+		return
+	}
+	if err := h.cacheIndex.CreateEntryForJob(job); err != nil {
+		slog.Error("failed to create cache entry", "item_id", entry.ItemID, "error", err)
+		http.Error(w, "failed to create cache entry", http.StatusInternalServerError)
+		return
+	}
+	job.Priority = cache.PriorityInteractive
+	if !h.cacheWorker.Enqueue(job) {
+		http.Error(w, "failed to queue job", http.StatusConflict)
+		return
+	}
+
+	slog.Info("cache entry queued for re-transcoding", "item_id", entry.ItemID)
+	writeStatus(w, http.StatusAccepted, "queued")
+}
+
+// HandleCancel handles POST /api/cache/{id}/cancel.
+// Removes the entry's job from the queue and stops it if it is running.
+func (h *CacheAdminHandler) HandleCancel(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	cancelled, err := h.cacheWorker.Cancel(entry.ItemID)
+	if err != nil {
+		slog.Error("failed to cancel job", "item_id", entry.ItemID, "error", err)
+		http.Error(w, "failed to cancel job", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "no job to cancel", http.StatusConflict)
+		return
+	}
+
+	slog.Info("cache job cancelled", "item_id", entry.ItemID)
+	writeStatus(w, http.StatusOK, "cancelled")
+}
+
+// HandleAttempts handles GET /api/cache/{id}/attempts.
+// Lists the recorded transcoding attempts of an item, newest first.
+func (h *CacheAdminHandler) HandleAttempts(w http.ResponseWriter, r *http.Request) {
+	itemID := r.PathValue("id")
+	attempts, err := h.cacheWorker.Attempts(itemID)
+	if err != nil {
+		slog.Error("failed to list transcoding attempts", "item_id", itemID, "error", err)
+		http.Error(w, "failed to list attempts", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]AttemptInfo, 0, len(attempts))
+	for _, a := range attempts {
+		infos = append(infos, AttemptInfo{
+			ID:         a.ID,
+			Attempt:    a.Attempt,
+			ErrorClass: a.ErrorClass,
+			Error:      a.Error,
+			StartedAt:  a.StartedAt,
+			FinishedAt: a.FinishedAt,
+			LogURL:     attemptLogURL(itemID, a.ID),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// HandleAttemptLog handles GET /api/cache/{id}/attempts/{attempt}/log.
+// Returns the ffmpeg commands and output of an attempt as plain text.
+func (h *CacheAdminHandler) HandleAttemptLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("attempt"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid attempt id", http.StatusBadRequest)
+		return
+	}
+
+	a, err := h.cacheWorker.Attempt(id)
+	if err != nil {
+		slog.Error("failed to get transcoding attempt", "attempt_id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if a == nil || a.ItemID != r.PathValue("id") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Item: %s\nAttempt: %d\nStarted: %s\nFinished: %s\n",
+		a.ItemID, a.Attempt, a.StartedAt.Format(time.RFC3339), a.FinishedAt.Format(time.RFC3339))
+	if a.Failed() {
+		fmt.Fprintf(w, "Error (%s): %s\n", a.ErrorClass, a.Error)
+	}
+	fmt.Fprintf(w, "\n%s", a.Log)
+}
+
+// HandleDelete handles DELETE /api/cache/{id}.
+func (h *CacheAdminHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.entryFromPath(w, r)
+	if !ok || !h.checkRemovable(w, entry) {
+		return
+	}
+
+	if err := h.cacheIndex.Remove(entry.ItemID); err != nil {
+		slog.Error("failed to remove cache entry", "item_id", entry.ItemID, "error", err)
+		http.Error(w, "failed to remove cache entry", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("cache entry deleted", "item_id", entry.ItemID)
+	writeStatus(w, http.StatusOK, "deleted")
+}
+
+// HandleEnqueue handles POST /api/cache/enqueue with a library_id and an
+// optional series_id. Items are queued in the background, as a library may
+// contain far more items than the job queue holds.
+func (h *CacheAdminHandler) HandleEnqueue(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	libraryID := r.FormValue("library_id")
+	seriesID := r.FormValue("series_id")
+	if libraryID == "" {
+		http.Error(w, "library_id required", http.StatusBadRequest)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), enqueueTimeout)
+		defer cancel()
+
+		queued, err := h.enqueueLibrary(ctx, absClient, libraryID, seriesID)
+		if err != nil {
+			slog.Error("failed to queue items", "library_id", libraryID, "series_id", seriesID, "queued", queued, "error", err)
+			return
+		}
+		slog.Info("queued items for caching", "library_id", libraryID, "series_id", seriesID, "queued", queued)
+	}()
+
+	writeStatus(w, http.StatusAccepted, "queued")
+}
+
+// enqueueLibrary queues all items of a library, or of one series in it.
+// Podcasts are queued with all their episodes. Returns the number of queued jobs.
+func (h *CacheAdminHandler) enqueueLibrary(ctx context.Context, absClient *abs.Client, libraryID, seriesID string) (int, error) {
+	opts := abs.ItemsOptions{
+		Limit: enqueuePageSize,
+		Sort:  "media.metadata.title",
+	}
+	if seriesID != "" {
+		opts.Filter = "series." + base64.URLEncoding.EncodeToString([]byte(seriesID))
+	}
+
+	queued := 0
+	for {
+		resp, err := absClient.GetLibraryItems(ctx, libraryID, opts)
+		if err != nil {
+			return queued, fmt.Errorf("failed to get library items: %w", err)
+		}
+
+		for _, result := range resp.Results {
+			// List responses may omit audio files and episodes, so fetch the full item
+			item, err := absClient.GetItem(ctx, result.ID)
+			if err != nil {
+				slog.Warn("failed to get item", "item_id", result.ID, "error", err)
+				continue
+			}
+
+			var jobs []cache.Job
+			if item.IsPodcast() {
+				for i := range item.Media.Episodes {
+					if job, ok := cacheJobForItem(item, &item.Media.Episodes[i], absClient, h.pathMapper, cache.ProfileDefault); ok {
+						jobs = append(jobs, job)
+					}
+				}
+			} else if job, ok := cacheJobForItem(item, nil, absClient, h.pathMapper, cache.ProfileDefault); ok {
+				jobs = append(jobs, job)
+			}
+
+			for _, job := range jobs {
+				if h.enqueue(job) {
+					queued++
+				}
+			}
+			if ctx.Err() != nil {
+				return queued, ctx.Err()
+			}
+		}
+
+		opts.Page++
+		if len(resp.Results) == 0 || opts.Page*enqueuePageSize >= resp.Total {
+			return queued, nil
+		}
+	}
+}
+
+// enqueue queues a job unless the item is already cached or being transcoded.
+// Cached items whose source files changed are queued again.
+func (h *CacheAdminHandler) enqueue(job cache.Job) bool {
+	if _, err := h.cacheIndex.InvalidateIfChanged(job); err != nil {
+		slog.Warn("failed to check source files", "item_id", job.ItemID, "error", err)
+	}
+	if cached, err := h.cacheIndex.IsCached(job.ItemID); err != nil || cached {
+		return false
+	}
+
+	entry, err := h.cacheIndex.GetEntry(job.ItemID)
+	if err != nil {
+		return false
+	}
+	if entry != nil && entry.Status == store.CacheStatusInProgress {
+		return false
+	}
+	if entry == nil {
+		if err := h.cacheIndex.CreateEntryForJob(job); err != nil {
+			slog.Warn("failed to create cache entry", "item_id", job.ItemID, "error", err)
+			return false
+		}
+	}
+
+	job.Priority = cache.PriorityBulk
+	return h.cacheWorker.Enqueue(job)
+}
+
+// entryFromPath looks up the cache entry named by the {id} path value.
+// Writes an error response and returns false if there is none.
+func (h *CacheAdminHandler) entryFromPath(w http.ResponseWriter, r *http.Request) (*store.CacheEntry, bool) {
+	itemID := r.PathValue("id")
+	if itemID == "" {
+		http.Error(w, "item id required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	entry, err := h.cacheStore.Get(itemID)
+	if err != nil {
+		slog.Error("failed to get cache entry", "item_id", itemID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if entry == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	return entry, true
+}
+
+// checkRemovable rejects removing files that are being written or played.
+func (h *CacheAdminHandler) checkRemovable(w http.ResponseWriter, entry *store.CacheEntry) bool {
+	if entry.Status == store.CacheStatusInProgress {
+		http.Error(w, "entry is being transcoded", http.StatusConflict)
+		return false
+	}
+
+	inUse, err := h.inUse()
+	if err != nil {
+		slog.Error("failed to list playback sessions", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if inUse[entry.ItemID] {
+		http.Error(w, "entry is used by a playback session", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// jobForEntry builds the transcoding job for a cache entry from the current ABS item.
+// Writes an error response and returns false on failure.
+func (h *CacheAdminHandler) jobForEntry(w http.ResponseWriter, r *http.Request, cacheKey string) (cache.Job, bool) {
+	session := SessionFromContext(r.Context())
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return cache.Job{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Variant entries are rebuilt with their own transcode profile
+	baseKey, profile := store.SplitVariantKey(cacheKey)
+
+	item, episode, err := resolveCacheKey(ctx, absClient, baseKey)
+	if err != nil {
+		if errors.Is(err, abs.ErrNotFound) {
+			http.Error(w, "item not found in Audiobookshelf", http.StatusNotFound)
+			return cache.Job{}, false
+		}
+		slog.Error("failed to get item", "item_id", cacheKey, "error", err)
+		http.Error(w, "failed to get item", http.StatusBadGateway)
+		return cache.Job{}, false
+	}
+
+	job, ok := cacheJobForItem(item, episode, absClient, h.pathMapper, profile)
+	if !ok {
+		http.Error(w, "no audio files", http.StatusBadRequest)
+		return cache.Job{}, false
+	}
+	return job, true
+}
+
+// NewCacheJobResolver returns a cache.JobResolver that builds the job for a
+// cache key, including its variant's profile, from the current ABS item.
+func NewCacheJobResolver(pathMapper PathMapper) cache.JobResolver {
+	return func(ctx context.Context, absClient *abs.Client, cacheKey string) (cache.Job, error) {
+		baseKey, profile := store.SplitVariantKey(cacheKey)
+		item, episode, err := resolveCacheKey(ctx, absClient, baseKey)
+		if err != nil {
+			return cache.Job{}, err
+		}
+		job, ok := cacheJobForItem(item, episode, absClient, pathMapper, profile)
+		if !ok {
+			return cache.Job{}, fmt.Errorf("no audio files in item %s", cacheKey)
+		}
+		return job, nil
+	}
+}
+
+// resolveCacheKey finds the ABS item and episode a cache key belongs to.
+func resolveCacheKey(ctx context.Context, absClient *abs.Client, cacheKey string) (*abs.LibraryItem, *abs.PodcastEpisode, error) {
+	itemID, episodeID := store.SplitCacheKey(cacheKey)
+	item, err := absClient.GetItem(ctx, itemID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Podcasts are only cached per episode
+	if episodeID == "" {
+		if item.IsPodcast() {
+			return nil, nil, abs.ErrNotFound
+		}
+		return item, nil, nil
+	}
+
+	episode := item.GetEpisode(episodeID)
+	if episode == nil {
+		return nil, nil, abs.ErrNotFound
+	}
+	return item, episode, nil
+}
+
+// formatBytes formats a size in bytes with a binary unit, e.g. "1.5 GB".
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// writeStatus writes a JSON {"status": ...} response.
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
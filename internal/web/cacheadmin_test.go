@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestResolveCacheKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/items/li_book":
+			w.Write([]byte(`{"id":"li_book","mediaType":"book"}`))
+		case "/api/items/li_pod":
+			w.Write([]byte(`{"id":"li_pod","mediaType":"podcast","media":{"episodes":[{"id":"ep_1"},{"id":"ep_2"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := abs.NewClient(server.URL).WithToken("test-token")
+
+	tests := []struct {
+		cacheKey  string
+		itemID    string
+		episodeID string
+		notFound  bool
+	}{
+		{"li_book", "li_book", "", false},
+		{"li_pod~ep_2", "li_pod", "ep_2", false},
+		{"li_pod~ep_3", "", "", true},
+		{"li_book~ep_2", "", "", true},
+		{"li_pod", "", "", true}, // Podcasts are only cached per episode
+		{"li_missing", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cacheKey, func(t *testing.T) {
+			item, episode, err := resolveCacheKey(context.Background(), client, tt.cacheKey)
+			if tt.notFound {
+				if !errors.Is(err, abs.ErrNotFound) {
+					t.Errorf("expected ErrNotFound, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if item.ID != tt.itemID {
+				t.Errorf("expected item %s, got %s", tt.itemID, item.ID)
+			}
+			if (episode == nil && tt.episodeID != "") || (episode != nil && episode.ID != tt.episodeID) {
+				t.Errorf("expected episode %q, got %+v", tt.episodeID, episode)
+			}
+		})
+	}
+}
+
+func TestCacheAdmin_HandleDelete(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cacheStore := store.NewCacheStore(db)
+	playbackStore := store.NewPlaybackStore(db)
+	cacheIndex := cache.NewIndex(cacheStore, t.TempDir())
+	h := NewCacheAdminHandler(nil, cacheStore, cacheIndex, nil, playbackStore, nil, 0)
+
+	for _, id := range []string{"item-1", "item-2", "item-3"} {
+		cacheIndex.CreateEntryWithFormat(id, "/media/"+id, 0, time.Now(), "mp4")
+		cacheIndex.EnsureDirectory(id)
+		os.WriteFile(filepath.Join(cacheIndex.GetCacheDir(id), "audio.m4a"), []byte("audio"), 0644)
+	}
+	cacheIndex.MarkInProgress("item-2")
+
+	// item-3 is being played
+	store.NewSessionStore(db).Create(&store.Session{ID: "session-1", ABSTokenEnc: []byte("token")})
+	store.NewDeviceStore(db).Upsert(&store.SonosDevice{UUID: "uuid:RINCON_123", Name: "Test"})
+	playbackStore.Create(&store.PlaybackSession{
+		ID:          "playback-1",
+		SessionID:   "session-1",
+		ItemID:      "item-3",
+		SonosUUID:   "uuid:RINCON_123",
+		StreamToken: "token",
+	})
+
+	tests := []struct {
+		itemID   string
+		expected int
+		removed  bool
+	}{
+		{"item-1", http.StatusOK, true},
+		{"item-2", http.StatusConflict, false},
+		{"item-3", http.StatusConflict, false},
+		{"item-4", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.itemID, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", "/api/cache/"+tt.itemID, nil)
+			req.SetPathValue("id", tt.itemID)
+			rec := httptest.NewRecorder()
+
+			h.HandleDelete(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			_, err := os.Stat(cacheIndex.GetCacheDir(tt.itemID))
+			if removed := os.IsNotExist(err); removed != tt.removed && tt.expected != http.StatusNotFound {
+				t.Errorf("expected removed=%v, got %v", tt.removed, removed)
+			}
+		})
+	}
+
+	entry, _ := cacheStore.Get("item-1")
+	if entry != nil {
+		t.Error("expected cache entry to be deleted")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{50 << 30, "50.0 GB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.bytes); got != tt.expected {
+			t.Errorf("formatBytes(%d) = %s, expected %s", tt.bytes, got, tt.expected)
+		}
+	}
+}
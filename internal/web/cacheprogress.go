@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// cacheEventsKeepAlive is how often the cache event stream sends a comment to
+// keep idle connections open.
+const cacheEventsKeepAlive = 15 * time.Second
+
+// cacheStatusResponse is the JSON response of GET /cache/status/{id} and the
+// payload of "status" events.
+type cacheStatusResponse struct {
+	Status   string          `json:"status"`
+	Progress *cache.Progress `json:"progress,omitempty"`
+}
+
+// HandleCacheEvents handles GET /cache/status/{id}/events requests.
+// It streams the transcoding progress of a cache entry as server-sent events:
+// a "progress" event with percent, ETA and segment for each ffmpeg update, and
+// a final "status" event once the entry is no longer pending or in progress.
+func (h *PlayerHandler) HandleCacheEvents(w http.ResponseWriter, r *http.Request) {
+	itemID := r.PathValue("id")
+	if itemID == "" {
+		http.Error(w, "item_id required", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading the status, so no update is missed
+	updates, cancel := h.cacheWorker.SubscribeProgress(itemID)
+	defer cancel()
+
+	status, err := h.cacheIndex.GetStatus(itemID)
+	if err != nil {
+		http.Error(w, "error", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("failed to clear write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if status != store.CacheStatusPending && status != store.CacheStatusInProgress {
+		writeCacheEvent(w, rc, "status", cacheStatusResponse{Status: string(status)})
+		return
+	}
+	if progress, ok := h.cacheWorker.Progress(itemID); ok {
+		writeCacheEvent(w, rc, "progress", progress)
+	} else {
+		rc.Flush()
+	}
+
+	keepAlive := time.NewTicker(cacheEventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			rc.Flush()
+		case progress, ok := <-updates:
+			if ok {
+				writeCacheEvent(w, rc, "progress", progress)
+				continue
+			}
+
+			// The job has ended
+			status, err := h.cacheIndex.GetStatus(itemID)
+			if err != nil {
+				slog.Warn("failed to get cache status", "item_id", itemID, "error", err)
+				return
+			}
+			writeCacheEvent(w, rc, "status", cacheStatusResponse{Status: string(status)})
+			return
+		}
+	}
+}
+
+// writeCacheEvent writes a server-sent event with a JSON payload.
+func writeCacheEvent(w http.ResponseWriter, rc *http.ResponseController, event string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode cache event", "event", event, "error", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	rc.Flush()
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestPlayerHandler_CacheStatus(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	cacheStore := store.NewCacheStore(db)
+	cacheIndex := cache.NewIndex(cacheStore, t.TempDir())
+	h := &PlayerHandler{
+		cacheIndex:  cacheIndex,
+		cacheWorker: cache.NewWorker(cacheIndex, cache.NewTranscoder(), store.NewJobStore(db), 1),
+	}
+
+	cacheIndex.CreateEntryWithFormat("item-1", "/media/item-1", 0, time.Now(), "mp3")
+	if err := cacheIndex.MarkReadyWithFormat("item-1", 3600, "mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/cache/status/item-1", nil)
+		req.Header.Set("Accept", "application/json")
+		req.SetPathValue("id", "item-1")
+		rec := httptest.NewRecorder()
+
+		h.HandleCacheStatus(rec, req)
+
+		var response cacheStatusResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Status != string(store.CacheStatusReady) || response.Progress != nil {
+			t.Errorf("unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		// Entries that aren't being transcoded end the stream at once
+		req := httptest.NewRequest("GET", "/cache/status/item-1/events", nil)
+		req.SetPathValue("id", "item-1")
+		rec := httptest.NewRecorder()
+
+		h.HandleCacheEvents(rec, req)
+
+		if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("expected event stream, got %q", ct)
+		}
+		expected := "event: status\ndata: {\"status\":\"ready\"}\n\n"
+		if body := rec.Body.String(); !strings.Contains(body, expected) {
+			t.Errorf("expected %q, got %q", expected, body)
+		}
+	})
+}
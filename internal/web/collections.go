@@ -0,0 +1,369 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// CollectionItem represents a collection or playlist with composite cover data.
+type CollectionItem struct {
+	ID        string
+	Name      string
+	URL       string
+	ItemCount int
+	CoverURLs []string // Up to 4 cover URLs for composite
+}
+
+// PlaylistEntry is a book or podcast episode on the playlist detail page.
+type PlaylistEntry struct {
+	ItemID      string
+	EpisodeID   string
+	Title       string
+	Subtitle    string // Author, or podcast title for episodes
+	CoverURL    string
+	DurationSec int
+	ProgressPct int
+	IsFinished  bool
+}
+
+// selectLibrary returns the requested library, falling back to the first book library.
+func selectLibrary(libraries []abs.Library, libraryID string) (string, string) {
+	if libraryID == "" {
+		for _, lib := range libraries {
+			if lib.MediaType == "book" {
+				libraryID = lib.ID
+				break
+			}
+		}
+		if libraryID == "" && len(libraries) > 0 {
+			libraryID = libraries[0].ID
+		}
+	}
+
+	libraryName := "Bibliothek"
+	for _, lib := range libraries {
+		if lib.ID == libraryID {
+			libraryName = lib.Name
+			break
+		}
+	}
+	return libraryID, libraryName
+}
+
+// compositeCovers returns up to 4 cover URLs for a composite cover.
+func compositeCovers(items []abs.LibraryItem) []string {
+	coverURLs := make([]string, 0, 4)
+	for _, item := range items {
+		if len(coverURLs) == 4 {
+			break
+		}
+		if item.Media.CoverPath != "" {
+			coverURLs = append(coverURLs, fmt.Sprintf("/cover/%s", item.ID))
+		}
+	}
+	return coverURLs
+}
+
+// HandleCollections renders the collections of a library.
+func (h *LibraryHandler) HandleCollections(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	libraries, _ := absClient.GetLibraries(ctx)
+	libraryID, libraryName := selectLibrary(libraries, r.URL.Query().Get("library"))
+
+	collections, err := absClient.GetCollections(ctx, libraryID)
+	if err != nil {
+		if err == abs.ErrUnauthorized {
+			http.Redirect(w, r, "/login?error=session_expired", http.StatusSeeOther)
+			return
+		}
+		slog.Error("failed to fetch collections", "library_id", libraryID, "error", err)
+		http.Error(w, "Failed to fetch collections", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]CollectionItem, 0, len(collections))
+	for _, c := range collections {
+		items = append(items, CollectionItem{
+			ID:        c.ID,
+			Name:      c.Name,
+			URL:       fmt.Sprintf("/library/collections/%s?library=%s", c.ID, libraryID),
+			ItemCount: len(c.Books),
+			CoverURLs: compositeCovers(c.Books),
+		})
+	}
+
+	data := map[string]interface{}{
+		"Title":             "Sammlungen - " + libraryName,
+		"ShowHeader":        true,
+		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
+		"Heading":           "Sammlungen",
+		"EmptyText":         "In dieser Bibliothek gibt es keine Sammlungen.",
+		"Collections":       items,
+		"Libraries":         libraries,
+		"SelectedLibraryID": libraryID,
+		"LibraryName":       libraryName,
+		"ActiveTab":         "collections",
+	}
+
+	h.render(w, "collections.html", data)
+}
+
+// HandleCollectionDetail renders the books of a collection.
+func (h *LibraryHandler) HandleCollectionDetail(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	collectionID := r.PathValue("id")
+	if collectionID == "" {
+		http.Error(w, "Collection ID required", http.StatusBadRequest)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	collection, err := absClient.GetCollection(ctx, collectionID)
+	if err != nil {
+		if err == abs.ErrUnauthorized {
+			http.Redirect(w, r, "/login?error=session_expired", http.StatusSeeOther)
+			return
+		}
+		if err == abs.ErrNotFound {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to fetch collection", "collection_id", collectionID, "error", err)
+		http.Error(w, "Failed to fetch collection", http.StatusInternalServerError)
+		return
+	}
+
+	libraries, _ := absClient.GetLibraries(ctx)
+	libraryID, libraryName := selectLibrary(libraries, collection.LibraryID)
+
+	// Books keep the order curated in the collection
+	books := make([]SimplifiedItem, 0, len(collection.Books))
+	for i := range collection.Books {
+		books = append(books, convertItem(&collection.Books[i]))
+	}
+
+	data := map[string]interface{}{
+		"Title":             collection.Name + " - " + libraryName,
+		"ShowHeader":        true,
+		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
+		"Collection":        collection,
+		"Books":             books,
+		"BookCount":         len(books),
+		"Libraries":         libraries,
+		"SelectedLibraryID": libraryID,
+		"LibraryName":       libraryName,
+		"ActiveTab":         "collections",
+	}
+
+	h.render(w, "collection-detail.html", data)
+}
+
+// HandlePlaylists renders the user's playlists in a library.
+func (h *LibraryHandler) HandlePlaylists(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	libraries, _ := absClient.GetLibraries(ctx)
+	libraryID, libraryName := selectLibrary(libraries, r.URL.Query().Get("library"))
+
+	playlists, err := absClient.GetPlaylists(ctx, libraryID)
+	if err != nil {
+		if err == abs.ErrUnauthorized {
+			http.Redirect(w, r, "/login?error=session_expired", http.StatusSeeOther)
+			return
+		}
+		slog.Error("failed to fetch playlists", "library_id", libraryID, "error", err)
+		http.Error(w, "Failed to fetch playlists", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]CollectionItem, 0, len(playlists))
+	for _, p := range playlists {
+		entries := make([]abs.LibraryItem, 0, len(p.Items))
+		for _, entry := range p.Items {
+			if entry.LibraryItem != nil {
+				entries = append(entries, *entry.LibraryItem)
+			}
+		}
+		items = append(items, CollectionItem{
+			ID:        p.ID,
+			Name:      p.Name,
+			URL:       fmt.Sprintf("/library/playlists/%s", p.ID),
+			ItemCount: len(p.Items),
+			CoverURLs: compositeCovers(entries),
+		})
+	}
+
+	data := map[string]interface{}{
+		"Title":             "Playlists - " + libraryName,
+		"ShowHeader":        true,
+		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
+		"Heading":           "Playlists",
+		"EmptyText":         "In dieser Bibliothek hast du keine Playlists.",
+		"Collections":       items,
+		"Libraries":         libraries,
+		"SelectedLibraryID": libraryID,
+		"LibraryName":       libraryName,
+		"ActiveTab":         "playlists",
+	}
+
+	h.render(w, "collections.html", data)
+}
+
+// HandlePlaylistDetail renders the entries of a playlist with playback controls.
+func (h *LibraryHandler) HandlePlaylistDetail(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	playlistID := r.PathValue("id")
+	if playlistID == "" {
+		http.Error(w, "Playlist ID required", http.StatusBadRequest)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	playlist, err := absClient.GetPlaylist(ctx, playlistID)
+	if err != nil {
+		if err == abs.ErrUnauthorized {
+			http.Redirect(w, r, "/login?error=session_expired", http.StatusSeeOther)
+			return
+		}
+		if err == abs.ErrNotFound {
+			http.Error(w, "Playlist not found", http.StatusNotFound)
+			return
+		}
+		slog.Error("failed to fetch playlist", "playlist_id", playlistID, "error", err)
+		http.Error(w, "Failed to fetch playlist", http.StatusInternalServerError)
+		return
+	}
+
+	libraries, _ := absClient.GetLibraries(ctx)
+	libraryID, libraryName := selectLibrary(libraries, playlist.LibraryID)
+
+	// Progress is keyed like the cache: item ID plus episode ID for podcasts
+	progressByKey := make(map[string]abs.Progress)
+	mediaProgress, err := absClient.GetMediaProgress(ctx)
+	if err != nil {
+		slog.Warn("failed to fetch media progress for playlist", "playlist_id", playlistID, "error", err)
+	}
+	for _, p := range mediaProgress {
+		progressByKey[store.CacheKey(p.LibraryItemID, p.EpisodeID)] = p
+	}
+
+	entries := make([]PlaylistEntry, 0, len(playlist.Items))
+	for _, item := range playlist.Items {
+		entries = append(entries, convertPlaylistItem(item, progressByKey[store.CacheKey(item.LibraryItemID, item.EpisodeID)]))
+	}
+
+	// "Play" continues with the first entry that isn't finished
+	var resume *PlaylistEntry
+	for i := range entries {
+		if !entries[i].IsFinished {
+			resume = &entries[i]
+			break
+		}
+	}
+	if resume == nil && len(entries) > 0 {
+		resume = &entries[0]
+	}
+
+	data := map[string]interface{}{
+		"Title":             playlist.Name,
+		"ShowHeader":        true,
+		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
+		"Playlist":          playlist,
+		"Entries":           entries,
+		"Resume":            resume,
+		"Libraries":         libraries,
+		"SelectedLibraryID": libraryID,
+		"LibraryName":       libraryName,
+		"ActiveTab":         "playlists",
+	}
+
+	h.render(w, "playlist-detail.html", data)
+}
+
+// convertPlaylistItem converts a playlist item for the playlist detail page.
+func convertPlaylistItem(item abs.PlaylistItem, progress abs.Progress) PlaylistEntry {
+	entry := PlaylistEntry{
+		ItemID:      item.LibraryItemID,
+		EpisodeID:   item.EpisodeID,
+		CoverURL:    fmt.Sprintf("/cover/%s", item.LibraryItemID),
+		ProgressPct: int(progress.Progress * 100),
+		IsFinished:  progress.IsFinished,
+	}
+
+	if item.LibraryItem != nil {
+		entry.Title = item.LibraryItem.Media.Metadata.Title
+		entry.Subtitle = item.LibraryItem.GetAuthorName()
+		entry.DurationSec = int(item.LibraryItem.Media.Duration)
+	}
+	if item.Episode != nil {
+		if item.LibraryItem != nil {
+			entry.Subtitle = item.LibraryItem.Media.Metadata.Title
+		}
+		entry.Title = item.Episode.Title
+		entry.DurationSec = int(item.Episode.GetDuration())
+	}
+	return entry
+}
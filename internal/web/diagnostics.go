@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/pathmap"
+)
+
+// DiagnosticsHandler serves pages that help to debug the bridge setup.
+type DiagnosticsHandler struct {
+	authHandler *AuthHandler
+	resolver    *pathmap.Resolver
+}
+
+// NewDiagnosticsHandler creates a new diagnostics handler.
+func NewDiagnosticsHandler(authHandler *AuthHandler, resolver *pathmap.Resolver) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		authHandler: authHandler,
+		resolver:    resolver,
+	}
+}
+
+// PathMappingRow is a library folder on the path diagnostics page.
+type PathMappingRow struct {
+	LibraryName  string
+	ABSPath      string
+	LocalPath    string
+	LocalExists  bool
+	SamplePath   string // ABS path of the sample item
+	SampleLocal  string // Local path the sample is mapped to for playback
+	SampleExists bool
+	Verified     bool // Mapping was derived by finding the sample
+	ResolvedAt   time.Time
+}
+
+// HandlePathMappings renders the derived path mapping of each library folder.
+// File checks are done on every request, so the page reflects the current mounts.
+func (h *DiagnosticsHandler) HandlePathMappings(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	mappings, err := h.resolver.List()
+	if err != nil {
+		slog.Error("failed to list path mappings", "error", err)
+		http.Error(w, "Failed to load path mappings", http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]PathMappingRow, 0, len(mappings))
+	for _, m := range mappings {
+		row := PathMappingRow{
+			LibraryName: m.LibraryName,
+			ABSPath:     m.ABSPath,
+			LocalPath:   m.LocalPath,
+			SamplePath:  m.SamplePath,
+			Verified:    m.SampleFound,
+			ResolvedAt:  m.ResolvedAt,
+		}
+		if m.LocalPath != "" {
+			row.LocalExists = fileExists(m.LocalPath)
+		}
+		if m.SamplePath != "" {
+			row.SampleLocal = h.resolver.Map(m.SamplePath)
+			row.SampleExists = fileExists(row.SampleLocal)
+		}
+		rows = append(rows, row)
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Pfad-Diagnose",
+		"ShowHeader": true,
+		"Username":   session.ABSUsername,
+		"Mappings":   rows,
+		"IsAdmin":    session.IsAdmin(),
+		"Resolved":   r.URL.Query().Get("resolved") == "1",
+		"Error":      r.URL.Query().Get("error"),
+	}
+
+	renderPage(w, "diagnostics-paths.html", data)
+}
+
+// HandleResolvePathMappings re-runs the path mapping resolver with the user's token.
+func (h *DiagnosticsHandler) HandleResolvePathMappings(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	if err := h.resolver.Resolve(ctx, absClient); err != nil {
+		slog.Error("failed to resolve path mappings", "error", err)
+		http.Redirect(w, r, "/diagnostics/paths?error=resolve_failed", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/diagnostics/paths?resolved=1", http.StatusSeeOther)
+}
+
+// fileExists reports whether a file or directory exists at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"fmt"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// useHLS reports whether a player streams a cache entry as an HLS playlist.
+// Entries that are still being transcoded are streamed as files; HLS segments
+// are cut from the finished output.
+func (h *PlayerHandler) useHLS(device *store.SonosDevice, entry *store.CacheEntry) bool {
+	return h.hls && cache.SupportsHLS(device) && cache.HLSAvailable(entry)
+}
+
+// streamLayout returns how a player streams a cache entry of the given
+// profile from realSec in the item: the stream mode, the segment to start
+// with and the segment layout to record on the playback session. HLS
+// playlists cover the whole item, so they have no segment layout.
+func (h *PlayerHandler) streamLayout(device *store.SonosDevice, entry *store.CacheEntry, profile cache.TranscodeProfile, realSec int) (string, int, []int) {
+	if h.useHLS(device, entry) {
+		return store.StreamModeHLS, 0, nil
+	}
+	if entry.IsSegmented() {
+		segment, _ := store.GlobalToSegment(profile.OutputTime(realSec), entry.SegmentStarts)
+		return store.StreamModeFile, segment, entry.SegmentStarts
+	}
+	return store.StreamModeFile, 0, nil
+}
+
+// streamURL returns the URL a player streams a playback session from,
+// starting with the given segment.
+func (h *PlayerHandler) streamURL(token string, playback *store.PlaybackSession, entry *store.CacheEntry, segment int) string {
+	fileName := cache.GetCacheFileName(entry.CacheFormat)
+	switch {
+	case playback.IsLive():
+		fileName = cache.LiveFileName(playback.StreamOffset)
+	case playback.IsHLS():
+		fileName = cache.HLSPlaylistName
+	case entry.IsSegmented():
+		fileName = entry.GetSegmentFileName(segment)
+	}
+	return fmt.Sprintf("%s/stream/%s/%s", h.publicURL, token, fileName)
+}
+
+// streamContentType returns the MIME type of a playback session's stream.
+func streamContentType(playback *store.PlaybackSession, entry *store.CacheEntry) string {
+	switch {
+	case playback.IsLive():
+		return cache.LiveContentType
+	case playback.IsHLS():
+		return cache.HLSContentType
+	}
+	return cache.GetContentType(entry.CacheFormat)
+}
@@ -20,17 +20,23 @@ import (
 
 // LibraryHandler handles library-related HTTP requests.
 type LibraryHandler struct {
-	authHandler *AuthHandler
-	templates   *template.Template
-	cacheStore  *store.CacheStore
+	authHandler  *AuthHandler
+	templates    *template.Template
+	cacheStore   *store.CacheStore
+	itemSettings *store.ItemSettingsStore
+	pinStore     *store.PinStore
+	audioPreset  string // Global audio preset
 }
 
 // NewLibraryHandler creates a new library handler.
-func NewLibraryHandler(authHandler *AuthHandler, templates *template.Template, cacheStore *store.CacheStore) *LibraryHandler {
+func NewLibraryHandler(authHandler *AuthHandler, templates *template.Template, cacheStore *store.CacheStore, itemSettings *store.ItemSettingsStore, pinStore *store.PinStore, audioPreset string) *LibraryHandler {
 	return &LibraryHandler{
-		authHandler: authHandler,
-		templates:   templates,
-		cacheStore:  cacheStore,
+		authHandler:  authHandler,
+		templates:    templates,
+		cacheStore:   cacheStore,
+		itemSettings: itemSettings,
+		pinStore:     pinStore,
+		audioPreset:  audioPreset,
 	}
 }
 
@@ -85,6 +91,7 @@ func (h *LibraryHandler) HandleLibraries(w http.ResponseWriter, r *http.Request)
 		"Title":             "Bibliotheken",
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"Libraries":         libraries,
 		"SelectedLibraryID": selectedLibraryID,
 		"ActiveTab":         "",
@@ -193,9 +200,11 @@ func (h *LibraryHandler) HandleLibraryItems(w http.ResponseWriter, r *http.Reque
 	// Get libraries for navigation
 	libraries, _ := absClient.GetLibraries(ctx)
 	libraryName := "Library"
+	mediaType := "book"
 	for _, lib := range libraries {
 		if lib.ID == libraryID {
 			libraryName = lib.Name
+			mediaType = lib.MediaType
 			break
 		}
 	}
@@ -212,8 +221,10 @@ func (h *LibraryHandler) HandleLibraryItems(w http.ResponseWriter, r *http.Reque
 		"Title":             libraryName,
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"LibraryID":         libraryID,
 		"LibraryName":       libraryName,
+		"MediaType":         mediaType,
 		"Items":             items,
 		"Total":             itemsResp.Total,
 		"Query":             query,
@@ -389,6 +400,7 @@ func (h *LibraryHandler) HandleRecent(w http.ResponseWriter, r *http.Request) {
 		"Title":             "Zuletzt gehört",
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"Items":             items,
 		"Libraries":         libraries,
 		"SelectedLibraryID": selectedLibraryID,
@@ -467,7 +479,6 @@ func (h *LibraryHandler) HandleSeries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-
 	// Build series items with composite covers (limit to first 4 book covers per series)
 	seriesItems := make([]SeriesItem, 0, len(filterData.Series))
 	for _, s := range filterData.Series {
@@ -509,6 +520,7 @@ func (h *LibraryHandler) HandleSeries(w http.ResponseWriter, r *http.Request) {
 		"Title":             "Serien - " + libraryName,
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"Series":            seriesItems,
 		"Libraries":         libraries,
 		"SelectedLibraryID": libraryID,
@@ -666,6 +678,7 @@ func (h *LibraryHandler) HandleSeriesDetail(w http.ResponseWriter, r *http.Reque
 		"Title":             seriesName + " - " + libraryName,
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"SeriesName":        seriesName,
 		"SeriesID":          seriesID,
 		"Books":             books,
@@ -772,6 +785,7 @@ func (h *LibraryHandler) HandleAuthors(w http.ResponseWriter, r *http.Request) {
 		"Title":             "Autoren - " + libraryName,
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"Authors":           authors,
 		"Libraries":         libraries,
 		"SelectedLibraryID": libraryID,
@@ -861,6 +875,7 @@ func (h *LibraryHandler) HandleGenres(w http.ResponseWriter, r *http.Request) {
 		"Title":             "Genres - " + libraryName,
 		"ShowHeader":        true,
 		"Username":          session.ABSUsername,
+		"IsAdmin":           session.IsAdmin(),
 		"Genres":            genres,
 		"Libraries":         libraries,
 		"SelectedLibraryID": libraryID,
@@ -909,6 +924,11 @@ func (h *LibraryHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if item.IsPodcast() {
+		h.renderPodcast(ctx, w, session, absClient, item)
+		return
+	}
+
 	// Get progress
 	progress, _ := absClient.GetProgress(ctx, itemID)
 	progressPercent := 0.0
@@ -946,16 +966,97 @@ func (h *LibraryHandler) HandleItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Title":      item.Media.Metadata.Title,
-		"ShowHeader": true,
-		"Username":   session.ABSUsername,
-		"Item":       simplifiedItem,
-		"LibraryID":  item.LibraryID,
+		"Title":              item.Media.Metadata.Title,
+		"ShowHeader":         true,
+		"Username":           session.ABSUsername,
+		"IsAdmin":            session.IsAdmin(),
+		"Item":               simplifiedItem,
+		"LibraryID":          item.LibraryID,
+		"AudioPreset":        itemAudioPreset(h.itemSettings, item.ID),
+		"AudioPresetOptions": audioPresetOptions(h.audioPreset),
+		"Pinned":             isPinned(h.pinStore, session.UserID, item.ID),
+	}
+
+	h.render(w, "item.html", data)
+}
+
+// renderPodcast renders the item detail page for a podcast with its episode list.
+func (h *LibraryHandler) renderPodcast(ctx context.Context, w http.ResponseWriter, session *store.Session, absClient *abs.Client, item *abs.LibraryItem) {
+	// Episode progress is keyed by episode ID
+	progressByEpisode := make(map[string]abs.Progress)
+	mediaProgress, err := absClient.GetMediaProgress(ctx)
+	if err != nil {
+		slog.Warn("failed to fetch media progress for podcast", "item_id", item.ID, "error", err)
+	}
+	for _, p := range mediaProgress {
+		if p.LibraryItemID == item.ID && p.EpisodeID != "" {
+			progressByEpisode[p.EpisodeID] = p
+		}
+	}
+
+	episodes := make([]EpisodeItem, 0, len(item.Media.Episodes))
+	for _, ep := range item.Media.Episodes {
+		durationSec := int(ep.GetDuration())
+		episode := EpisodeItem{
+			ID:          ep.ID,
+			Title:       ep.Title,
+			DurationSec: durationSec,
+			PublishedAt: ep.PublishedAt,
+		}
+		if ep.PublishedAt > 0 {
+			episode.PublishedDate = time.UnixMilli(ep.PublishedAt).Format("02.01.2006")
+		}
+		if p, ok := progressByEpisode[ep.ID]; ok {
+			episode.Progress = p.Progress
+			episode.ProgressPct = int(p.Progress * 100)
+			episode.IsFinished = p.IsFinished
+		}
+		episodes = append(episodes, episode)
+	}
+
+	// Newest episodes first
+	sort.Slice(episodes, func(i, j int) bool {
+		return episodes[i].PublishedAt > episodes[j].PublishedAt
+	})
+
+	simplifiedItem := DetailedItem{
+		ID:          item.ID,
+		LibraryID:   item.LibraryID,
+		Title:       item.Media.Metadata.Title,
+		Author:      getAuthorName(item),
+		Description: item.Media.Metadata.Description,
+		CoverURL:    fmt.Sprintf("/cover/%s", item.ID),
+	}
+
+	data := map[string]interface{}{
+		"Title":              item.Media.Metadata.Title,
+		"ShowHeader":         true,
+		"Username":           session.ABSUsername,
+		"IsAdmin":            session.IsAdmin(),
+		"Item":               simplifiedItem,
+		"IsPodcast":          true,
+		"Episodes":           episodes,
+		"LibraryID":          item.LibraryID,
+		"AudioPreset":        itemAudioPreset(h.itemSettings, item.ID),
+		"AudioPresetOptions": audioPresetOptions(h.audioPreset),
+		"Pinned":             isPinned(h.pinStore, session.UserID, item.ID),
 	}
 
 	h.render(w, "item.html", data)
 }
 
+// EpisodeItem is a podcast episode for the detail page.
+type EpisodeItem struct {
+	ID            string
+	Title         string
+	PublishedAt   int64
+	PublishedDate string
+	DurationSec   int
+	Progress      float64
+	ProgressPct   int
+	IsFinished    bool
+}
+
 // DetailedItem is an item with full details for the detail page.
 type DetailedItem struct {
 	ID           string
@@ -971,10 +1072,7 @@ type DetailedItem struct {
 }
 
 func getAuthorName(item *abs.LibraryItem) string {
-	if len(item.Media.Metadata.Authors) > 0 {
-		return item.Media.Metadata.Authors[0].Name
-	}
-	return ""
+	return item.GetAuthorName()
 }
 
 // SimplifiedItem is a simplified item for templates.
@@ -988,10 +1086,7 @@ type SimplifiedItem struct {
 }
 
 func convertItem(item *abs.LibraryItem) SimplifiedItem {
-	author := ""
-	if len(item.Media.Metadata.Authors) > 0 {
-		author = item.Media.Metadata.Authors[0].Name
-	}
+	author := item.GetAuthorName()
 
 	return SimplifiedItem{
 		ID:          item.ID,
@@ -1003,6 +1098,11 @@ func convertItem(item *abs.LibraryItem) SimplifiedItem {
 }
 
 func (h *LibraryHandler) render(w http.ResponseWriter, name string, data interface{}) {
+	renderPage(w, name, data)
+}
+
+// renderPage renders a page template within the shared layout.
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// Parse templates fresh for each request to avoid Clone issues
@@ -1028,8 +1128,9 @@ func (h *LibraryHandler) render(w http.ResponseWriter, name string, data interfa
 			}
 			return float64(position) / float64(duration) * 100
 		},
-		"plus1": func(i int) int { return i + 1 },
-		"minus": func(a, b int) int { return a - b },
+		"plus1":       func(i int) int { return i + 1 },
+		"minus":       func(a, b int) int { return a - b },
+		"formatBytes": formatBytes,
 		"json": func(v interface{}) template.JS {
 			b, err := json.Marshal(v)
 			if err != nil {
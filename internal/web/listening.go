@@ -0,0 +1,235 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+	"audiobookshelf-sonos-bridge/internal/version"
+)
+
+// listeningClientName identifies the bridge in the ABS session history.
+const listeningClientName = "Sonos Bridge"
+
+// ListeningTracker mirrors bridge playback into Audiobookshelf playback sessions,
+// so listening on Sonos shows up in the ABS listening stats and session history.
+type ListeningTracker struct {
+	listeningStore *store.ListeningStore
+}
+
+// NewListeningTracker creates a new listening tracker.
+func NewListeningTracker(listeningStore *store.ListeningStore) *ListeningTracker {
+	return &ListeningTracker{
+		listeningStore: listeningStore,
+	}
+}
+
+// Open closes any ABS session left open by the web session and starts a new one
+// for the playback. Failures are logged; playback then falls back to plain
+// progress updates.
+func (t *ListeningTracker) Open(ctx context.Context, client *abs.Client, playback *store.PlaybackSession) {
+	t.CloseAll(ctx, client, playback.SessionID)
+	t.open(ctx, client, playback)
+}
+
+func (t *ListeningTracker) open(ctx context.Context, client *abs.Client, playback *store.PlaybackSession) *store.ListeningSession {
+	absSession, err := client.StartPlaybackSession(ctx, playback.ItemID, playback.EpisodeID, abs.PlaybackSessionRequest{
+		DeviceInfo: abs.DeviceInfo{
+			DeviceID:      playback.SonosUUID,
+			ClientName:    listeningClientName,
+			ClientVersion: version.Short(),
+			Manufacturer:  "Sonos",
+		},
+		// The bridge streams from its own cache, ABS must not start a transcode
+		ForceDirectPlay: true,
+		MediaPlayer:     "sonos",
+	})
+	if err != nil {
+		slog.Warn("failed to start ABS playback session",
+			"item_id", playback.ItemID,
+			"episode_id", playback.EpisodeID,
+			"error", err,
+		)
+		return nil
+	}
+
+	now := time.Now()
+	ls := &store.ListeningSession{
+		ID:           absSession.ID,
+		PlaybackID:   playback.ID,
+		SessionID:    playback.SessionID,
+		ItemID:       playback.ItemID,
+		EpisodeID:    playback.EpisodeID,
+		PositionSec:  playback.PositionSec,
+		DurationSec:  playback.DurationSec,
+		StartedAt:    now,
+		LastTickAt:   now,
+		LastSyncedAt: now,
+	}
+	if err := t.listeningStore.Create(ls); err != nil {
+		slog.Warn("failed to save ABS playback session", "abs_session_id", absSession.ID, "error", err)
+		return nil
+	}
+
+	slog.Info("ABS playback session started",
+		"abs_session_id", ls.ID,
+		"item_id", ls.ItemID,
+		"episode_id", ls.EpisodeID,
+	)
+	return ls
+}
+
+// Tick records listening time for a playing session at the observed position.
+// Time only counts while the position advances and never exceeds the distance
+// moved, so seeks and stalled playback are not counted as listening.
+func (t *ListeningTracker) Tick(playback *store.PlaybackSession, positionSec int) {
+	ls, err := t.listeningStore.GetByPlaybackID(playback.ID)
+	if err != nil || ls == nil {
+		return
+	}
+
+	listened := 0
+	if advanced := positionSec - ls.PositionSec; advanced > 0 {
+		listened = int(time.Since(ls.LastTickAt).Seconds())
+		if listened > advanced {
+			listened = advanced
+		}
+	}
+
+	if err := t.listeningStore.AddListened(ls.ID, listened, positionSec); err != nil {
+		slog.Debug("failed to record listening time", "abs_session_id", ls.ID, "error", err)
+	}
+}
+
+// Sync reports the position and the unsynced listening time to the ABS session.
+// It returns false if the playback has no ABS session or the sync failed, so the
+// caller can fall back to a plain progress update. A session that no longer
+// exists in ABS (e.g. after an ABS restart) is replaced by a new one.
+func (t *ListeningTracker) Sync(ctx context.Context, client *abs.Client, playback *store.PlaybackSession) bool {
+	ls, err := t.listeningStore.GetByPlaybackID(playback.ID)
+	if err != nil || ls == nil {
+		return false
+	}
+
+	sync := abs.SessionSync{
+		CurrentTime:  float64(playback.PositionSec),
+		TimeListened: float64(ls.UnsyncedSec),
+		Duration:     float64(playback.DurationSec),
+	}
+
+	err = client.SyncPlaybackSession(ctx, ls.ID, sync)
+	if errors.Is(err, abs.ErrNotFound) {
+		slog.Info("ABS playback session expired, starting a new one",
+			"abs_session_id", ls.ID,
+			"item_id", playback.ItemID,
+		)
+		t.listeningStore.Delete(ls.ID)
+		if ls = t.open(ctx, client, playback); ls == nil {
+			return false
+		}
+		err = client.SyncPlaybackSession(ctx, ls.ID, sync)
+	}
+	if err != nil {
+		slog.Warn("failed to sync ABS playback session",
+			"abs_session_id", ls.ID,
+			"item_id", playback.ItemID,
+			"error", err,
+		)
+		return false
+	}
+
+	t.listeningStore.MarkSynced(ls.ID, int(sync.TimeListened))
+
+	slog.Debug("synced ABS playback session",
+		"abs_session_id", ls.ID,
+		"position_sec", playback.PositionSec,
+		"time_listened_sec", int(sync.TimeListened),
+	)
+	return true
+}
+
+// SessionID returns the ABS session ID of a playback, or "" if it has none.
+func (t *ListeningTracker) SessionID(playbackID string) string {
+	ls, err := t.listeningStore.GetByPlaybackID(playbackID)
+	if err != nil || ls == nil {
+		return ""
+	}
+	return ls.ID
+}
+
+// Close syncs the final state and closes the ABS session of a playback.
+// It returns false if the playback has no ABS session or the final sync
+// failed, so the caller can fall back to a plain progress update.
+func (t *ListeningTracker) Close(ctx context.Context, client *abs.Client, playback *store.PlaybackSession) bool {
+	ls, err := t.listeningStore.GetByPlaybackID(playback.ID)
+	if err != nil || ls == nil {
+		return false
+	}
+
+	return t.close(ctx, client, ls, abs.SessionSync{
+		CurrentTime:  float64(playback.PositionSec),
+		TimeListened: float64(ls.UnsyncedSec),
+		Duration:     float64(playback.DurationSec),
+	})
+}
+
+// Discard forgets the ABS session of a playback without syncing or closing
+// it; ABS expires it on its own.
+func (t *ListeningTracker) Discard(playback *store.PlaybackSession) {
+	ls, err := t.listeningStore.GetByPlaybackID(playback.ID)
+	if err != nil || ls == nil {
+		return
+	}
+	if err := t.listeningStore.Delete(ls.ID); err != nil {
+		slog.Warn("failed to delete ABS playback session", "abs_session_id", ls.ID, "error", err)
+	}
+}
+
+// CloseAll closes all ABS sessions of a web session at their last known state.
+func (t *ListeningTracker) CloseAll(ctx context.Context, client *abs.Client, sessionID string) {
+	sessions, err := t.listeningStore.ListBySessionID(sessionID)
+	if err != nil {
+		slog.Warn("failed to list ABS playback sessions", "session_id", sessionID, "error", err)
+		return
+	}
+
+	for _, ls := range sessions {
+		t.CloseStored(ctx, client, ls)
+	}
+}
+
+// CloseStored closes a stored ABS session at its last known state.
+// Used for sessions whose playback session no longer exists.
+func (t *ListeningTracker) CloseStored(ctx context.Context, client *abs.Client, ls *store.ListeningSession) {
+	t.close(ctx, client, ls, abs.SessionSync{
+		CurrentTime:  float64(ls.PositionSec),
+		TimeListened: float64(ls.UnsyncedSec),
+		Duration:     float64(ls.DurationSec),
+	})
+}
+
+func (t *ListeningTracker) close(ctx context.Context, client *abs.Client, ls *store.ListeningSession, sync abs.SessionSync) bool {
+	err := client.ClosePlaybackSession(ctx, ls.ID, sync)
+	if err != nil && !errors.Is(err, abs.ErrNotFound) {
+		slog.Warn("failed to close ABS playback session",
+			"abs_session_id", ls.ID,
+			"item_id", ls.ItemID,
+			"error", err,
+		)
+	} else {
+		slog.Info("ABS playback session closed",
+			"abs_session_id", ls.ID,
+			"item_id", ls.ItemID,
+			"episode_id", ls.EpisodeID,
+		)
+	}
+
+	// Dropped either way so a failing close is not retried forever
+	if err := t.listeningStore.Delete(ls.ID); err != nil {
+		slog.Warn("failed to delete ABS playback session", "abs_session_id", ls.ID, "error", err)
+	}
+	return err == nil
+}
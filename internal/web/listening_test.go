@@ -0,0 +1,228 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// fakeSessionServer records ABS playback session requests.
+type fakeSessionServer struct {
+	mu      sync.Mutex
+	started int
+	syncs   map[string][]abs.SessionSync
+	closed  []string
+	expired map[string]bool
+}
+
+func (f *fakeSessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == "POST" && r.URL.Path == "/api/items/item-1/play" {
+		f.started++
+		json.NewEncoder(w).Encode(abs.PlaybackSession{ID: fmt.Sprintf("abs-session-%d", f.started)})
+		return
+	}
+
+	// /api/session/{id}/{action}
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/session/"), "/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if f.expired[id] {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var sync abs.SessionSync
+	json.NewDecoder(r.Body).Decode(&sync)
+	switch action {
+	case "sync":
+		f.syncs[id] = append(f.syncs[id], sync)
+	case "close":
+		f.closed = append(f.closed, id)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func setupListeningTest(t *testing.T) (*ListeningTracker, *store.ListeningStore, *abs.Client, *fakeSessionServer, func()) {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "listening_test_*.db")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	dbPath := f.Name()
+	f.Close()
+
+	db, err := store.New(dbPath)
+	if err != nil {
+		os.Remove(dbPath)
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	store.NewSessionStore(db).Create(&store.Session{
+		ID:          "session-1",
+		ABSTokenEnc: []byte("token"),
+		ABSUserID:   "user-1",
+		ABSUsername: "test",
+		CreatedAt:   time.Now(),
+		LastUsedAt:  time.Now(),
+	})
+
+	fake := &fakeSessionServer{
+		syncs:   make(map[string][]abs.SessionSync),
+		expired: make(map[string]bool),
+	}
+	absServer := httptest.NewServer(fake)
+
+	listeningStore := store.NewListeningStore(db)
+	tracker := NewListeningTracker(listeningStore)
+	client := abs.NewClient(absServer.URL).WithToken("test-token")
+
+	cleanup := func() {
+		absServer.Close()
+		db.Close()
+		os.Remove(dbPath)
+	}
+
+	return tracker, listeningStore, client, fake, cleanup
+}
+
+func TestListeningTracker_OpenSyncClose(t *testing.T) {
+	tracker, listeningStore, client, fake, cleanup := setupListeningTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	playback := &store.PlaybackSession{
+		ID:          "playback-1",
+		SessionID:   "session-1",
+		ItemID:      "item-1",
+		SonosUUID:   "uuid:RINCON_123",
+		PositionSec: 100,
+		DurationSec: 3600,
+	}
+
+	tracker.Open(ctx, client, playback)
+
+	ls, err := listeningStore.GetByPlaybackID("playback-1")
+	if err != nil || ls == nil {
+		t.Fatalf("expected listening session to be stored: %v", err)
+	}
+	if ls.ID != "abs-session-1" {
+		t.Errorf("expected abs-session-1, got %s", ls.ID)
+	}
+
+	// Pretend the previous tick was 30 seconds ago
+	listeningStore.Delete(ls.ID)
+	ls.LastTickAt = time.Now().Add(-30 * time.Second)
+	listeningStore.Create(ls)
+
+	// Position advanced by 20 seconds: only 20 seconds count as listened
+	tracker.Tick(playback, 120)
+
+	playback.PositionSec = 120
+	if !tracker.Sync(ctx, client, playback) {
+		t.Fatal("expected sync to succeed")
+	}
+
+	syncs := fake.syncs["abs-session-1"]
+	if len(syncs) != 1 {
+		t.Fatalf("expected 1 sync, got %d", len(syncs))
+	}
+	if syncs[0].TimeListened != 20 {
+		t.Errorf("expected 20 seconds listened, got %f", syncs[0].TimeListened)
+	}
+	if syncs[0].CurrentTime != 120 {
+		t.Errorf("expected current time 120, got %f", syncs[0].CurrentTime)
+	}
+
+	ls, _ = listeningStore.GetByPlaybackID("playback-1")
+	if ls.UnsyncedSec != 0 {
+		t.Errorf("expected no unsynced time after sync, got %d", ls.UnsyncedSec)
+	}
+
+	if !tracker.Close(ctx, client, playback) {
+		t.Error("expected close to report the final position as synced")
+	}
+
+	if len(fake.closed) != 1 || fake.closed[0] != "abs-session-1" {
+		t.Errorf("expected abs-session-1 to be closed, got %v", fake.closed)
+	}
+	ls, _ = listeningStore.GetByPlaybackID("playback-1")
+	if ls != nil {
+		t.Error("expected listening session to be deleted after close")
+	}
+
+	// Without an ABS session the caller has to save the progress itself
+	if tracker.Close(ctx, client, playback) {
+		t.Error("expected close without an ABS session to report no sync")
+	}
+}
+
+func TestListeningTracker_OpenClosesPrevious(t *testing.T) {
+	tracker, listeningStore, client, fake, cleanup := setupListeningTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	first := &store.PlaybackSession{ID: "playback-1", SessionID: "session-1", ItemID: "item-1"}
+	second := &store.PlaybackSession{ID: "playback-2", SessionID: "session-1", ItemID: "item-1"}
+
+	tracker.Open(ctx, client, first)
+	tracker.Open(ctx, client, second)
+
+	if len(fake.closed) != 1 || fake.closed[0] != "abs-session-1" {
+		t.Errorf("expected previous session to be closed, got %v", fake.closed)
+	}
+
+	sessions, _ := listeningStore.ListBySessionID("session-1")
+	if len(sessions) != 1 || sessions[0].PlaybackID != "playback-2" {
+		t.Errorf("expected only the new listening session, got %d", len(sessions))
+	}
+}
+
+func TestListeningTracker_SyncReopensExpired(t *testing.T) {
+	tracker, listeningStore, client, fake, cleanup := setupListeningTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	playback := &store.PlaybackSession{ID: "playback-1", SessionID: "session-1", ItemID: "item-1"}
+
+	tracker.Open(ctx, client, playback)
+	fake.expired["abs-session-1"] = true
+
+	if !tracker.Sync(ctx, client, playback) {
+		t.Fatal("expected sync to succeed with a new session")
+	}
+
+	if len(fake.syncs["abs-session-2"]) != 1 {
+		t.Errorf("expected sync on the new session, got %v", fake.syncs)
+	}
+
+	ls, _ := listeningStore.GetByPlaybackID("playback-1")
+	if ls == nil || ls.ID != "abs-session-2" {
+		t.Errorf("expected abs-session-2 to be stored, got %v", ls)
+	}
+}
+
+func TestListeningTracker_SyncWithoutSession(t *testing.T) {
+	tracker, _, client, _, cleanup := setupListeningTest(t)
+	defer cleanup()
+
+	playback := &store.PlaybackSession{ID: "playback-1", SessionID: "session-1", ItemID: "item-1"}
+	if tracker.Sync(context.Background(), client, playback) {
+		t.Error("expected sync without ABS session to report false")
+	}
+}
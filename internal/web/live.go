@@ -0,0 +1,79 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// prepareUncached makes an item that isn't cached yet playable from startSec
+// in the item. Items whose source files are on the local media volume are
+// streamed live while the cache job runs in the background, unless the file
+// can be served as it is; others are transcoded progressively. Returns true
+// if the item is streamed live.
+func (h *PlayerHandler) prepareUncached(ctx context.Context, job cache.Job, startSec int) (bool, error) {
+	if h.live == nil || !cache.LiveSourcesAvailable(job.SourcePaths) {
+		return false, h.cacheWorker.StartProgressive(ctx, job, startSec)
+	}
+	if direct, err := h.cacheWorker.ServeDirectly(ctx, job); direct || err != nil {
+		return false, err
+	}
+
+	// The stream handler transcodes from the entry's source files
+	if err := h.cacheIndex.SetSourcePaths(job.ItemID, job.SourcePaths); err != nil {
+		return false, err
+	}
+	job.Priority = cache.PriorityInteractive
+	h.cacheWorker.Enqueue(job)
+	return true, nil
+}
+
+// streamStart returns the segment to stream a playback from, starting at
+// realSec in the item, and the position to seek to within it. Live streams
+// start at the position instead of seeking; their new offset is recorded.
+func (h *PlayerHandler) streamStart(playback *store.PlaybackSession, realSec int) (int, int) {
+	if !playback.IsLive() {
+		return playback.SegmentPosition(realSec)
+	}
+
+	playback.StreamOffset = playback.VariantPosition(realSec)
+	if err := h.playbackStore.UpdateStreamOffset(playback.ID, playback.StreamOffset); err != nil {
+		slog.Warn("failed to update stream offset", "error", err)
+	}
+	return 0, 0
+}
+
+// forgetLive drops the live transcoder state of a playback that ended.
+func (h *PlayerHandler) forgetLive(playback *store.PlaybackSession) {
+	if h.live == nil || !playback.IsLive() {
+		return
+	}
+	h.live.Forget(store.VariantKey(store.CacheKey(playback.ItemID, playback.EpisodeID), playback.Profile))
+}
+
+// leaveLive moves a live playback to its cache entry once the entry is ready.
+// The cache takes over the next time the stream is set on the player, e.g.
+// on seek, resume or token renewal.
+func (h *PlayerHandler) leaveLive(playback *store.PlaybackSession, entry *store.CacheEntry) {
+	if !playback.IsLive() || entry.Status != store.CacheStatusReady {
+		return
+	}
+
+	profile, ok := cache.ProfileByName(playback.Profile)
+	if !ok {
+		profile = cache.DefaultProfile
+	}
+	device, _ := h.sonosStore.Get(playback.SonosUUID)
+
+	streamMode, segment, segmentStarts := h.streamLayout(device, entry, profile, playback.PositionSec)
+	if err := h.playbackStore.UpdateStreamLayout(playback.ID, streamMode, segment, segmentStarts); err != nil {
+		slog.Warn("failed to update stream mode", "error", err)
+	}
+	playback.StreamMode = streamMode
+	playback.CurrentSegment = segment
+	playback.SegmentStarts = segmentStarts
+
+	slog.Info("cache took over live stream", "item_id", playback.ItemID, "stream_mode", streamMode, "segment", segment)
+}
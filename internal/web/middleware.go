@@ -34,6 +34,12 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// Unwrap returns the underlying writer, so http.ResponseController can flush
+// streamed responses.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // LoggingMiddleware creates middleware that logs HTTP requests.
 // Sensitive information (tokens, passwords, session IDs) is redacted.
 func LoggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// isPinned reports whether the user pinned an item to keep it cached.
+func isPinned(pinStore *store.PinStore, userID, itemID string) bool {
+	pinned, err := pinStore.IsPinned(userID, itemID)
+	if err != nil {
+		slog.Warn("failed to get pin", "item_id", itemID, "error", err)
+		return false
+	}
+	return pinned
+}
+
+// HandleSetPinned handles POST /item/{id}/pin requests.
+// Pinned items are transcoded by the cache warmup ahead of playback; for
+// podcasts, the latest episode is kept cached.
+func (h *LibraryHandler) HandleSetPinned(w http.ResponseWriter, r *http.Request) {
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID := r.PathValue("id")
+	if itemID == "" {
+		http.Error(w, "Item ID required", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	pinned, err := strconv.ParseBool(r.FormValue("pinned"))
+	if err != nil {
+		http.Error(w, "invalid pinned value", http.StatusBadRequest)
+		return
+	}
+
+	if pinned {
+		err = h.pinStore.Pin(session.UserID, itemID)
+	} else {
+		err = h.pinStore.Unpin(session.UserID, itemID)
+	}
+	if err != nil {
+		slog.Error("failed to save pin", "item_id", itemID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("item pin changed", "item_id", itemID, "user", session.ABSUsername, "pinned", pinned)
+	w.WriteHeader(http.StatusOK)
+}
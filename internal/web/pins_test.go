@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestLibraryHandler_HandleSetPinned(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	pinStore := store.NewPinStore(db)
+	h := NewLibraryHandler(nil, nil, nil, store.NewItemSettingsStore(db), pinStore, "none")
+	session := &store.Session{ID: "session-1", UserID: "user-1"}
+
+	tests := []struct {
+		pinned   string
+		expected int
+		isPinned bool
+	}{
+		{"true", http.StatusOK, true},
+		{"yes", http.StatusBadRequest, true},
+		{"false", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pinned, func(t *testing.T) {
+			body := url.Values{"pinned": {tt.pinned}}.Encode()
+			req := httptest.NewRequest("POST", "/item/item-1/pin", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetPathValue("id", "item-1")
+			req = req.WithContext(context.WithValue(req.Context(), sessionContextKey, session))
+			rec := httptest.NewRecorder()
+
+			h.HandleSetPinned(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if got := isPinned(pinStore, "user-1", "item-1"); got != tt.isPinned {
+				t.Errorf("expected pinned %v, got %v", tt.isPinned, got)
+			}
+		})
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,7 +36,13 @@ type PlayerHandler struct {
 	templates     *template.Template
 	sonosStore    *store.DeviceStore
 	playbackStore *store.PlaybackStore
+	listening     *ListeningTracker
+	remote        *RemoteProgressWatcher
 	pathMapper    PathMapper
+	itemSettings  *store.ItemSettingsStore
+	audioPreset   string      // Global audio preset
+	hls           bool        // Stream HLS playlists to players that support them
+	live          *cache.Live // Streams uncached items transcoded on the fly (nil = disabled)
 }
 
 // NewPlayerHandler creates a new player handler.
@@ -47,7 +55,13 @@ func NewPlayerHandler(
 	templates *template.Template,
 	sonosStore *store.DeviceStore,
 	playbackStore *store.PlaybackStore,
+	listening *ListeningTracker,
+	remote *RemoteProgressWatcher,
 	pathMapper PathMapper,
+	itemSettings *store.ItemSettingsStore,
+	audioPreset string,
+	hls bool,
+	live *cache.Live,
 ) *PlayerHandler {
 	return &PlayerHandler{
 		authHandler:   authHandler,
@@ -58,10 +72,23 @@ func NewPlayerHandler(
 		templates:     templates,
 		sonosStore:    sonosStore,
 		playbackStore: playbackStore,
+		listening:     listening,
+		remote:        remote,
 		pathMapper:    pathMapper,
+		itemSettings:  itemSettings,
+		audioPreset:   audioPreset,
+		hls:           hls,
+		live:          live,
 	}
 }
 
+// profileFor returns the transcode profile for playing an item on a player:
+// the player's profile with the item's audio preset and the speed applied.
+func (h *PlayerHandler) profileFor(device *store.SonosDevice, itemID string, speed float64) cache.TranscodeProfile {
+	profile := cache.ApplyPreset(cache.ProfileForDevice(device), resolveAudioPreset(h.itemSettings, itemID, h.audioPreset))
+	return cache.ApplySpeed(profile, speed)
+}
+
 // getCoordinatorIP returns the IP address of the group coordinator for the given device.
 // If the device is standalone or an error occurs, returns the original device IP.
 // This ensures that all AVTransport commands go to the coordinator, which controls the entire group.
@@ -102,7 +129,7 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 
 	// Parse form - try multipart first, then regular form
 	contentType := r.Header.Get("Content-Type")
-	var itemID, sonosUUID string
+	var itemID, episodeID, sonosUUID, playlistID string
 
 	if strings.HasPrefix(contentType, "multipart/form-data") {
 		if err := r.ParseMultipartForm(32 << 10); err != nil {
@@ -111,7 +138,9 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		itemID = r.FormValue("item_id")
+		episodeID = r.FormValue("episode_id")
 		sonosUUID = r.FormValue("sonos_uuid")
+		playlistID = r.FormValue("playlist_id")
 	} else {
 		if err := r.ParseForm(); err != nil {
 			slog.Error("failed to parse form", "error", err)
@@ -119,10 +148,12 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		itemID = r.FormValue("item_id")
+		episodeID = r.FormValue("episode_id")
 		sonosUUID = r.FormValue("sonos_uuid")
+		playlistID = r.FormValue("playlist_id")
 	}
 
-	slog.Debug("play request received", "item_id", itemID, "sonos_uuid", sonosUUID, "form_values", r.Form)
+	slog.Debug("play request received", "item_id", itemID, "episode_id", episodeID, "sonos_uuid", sonosUUID, "playlist_id", playlistID, "form_values", r.Form)
 
 	if itemID == "" || sonosUUID == "" {
 		slog.Warn("play request missing parameters", "item_id", itemID, "sonos_uuid", sonosUUID)
@@ -140,101 +171,172 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := h.startPlayback(ctx, session, absClient, itemID, episodeID, sonosUUID, playlistID); err != nil {
+		var pe *playError
+		if errors.As(err, &pe) {
+			http.Error(w, pe.message, pe.status)
+			return
+		}
+		http.Error(w, "failed to start playback", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON with redirect URL (HX-Redirect header not accessible from JS fetch due to CORS)
+	redirectURL := playerURL(itemID, episodeID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("HX-Redirect", redirectURL) // Keep for htmx compatibility
+	json.NewEncoder(w).Encode(map[string]string{
+		"redirect": redirectURL,
+	})
+}
+
+// playError is a failure to start playback with the HTTP status to report.
+type playError struct {
+	status  int
+	message string
+}
+
+func (e *playError) Error() string {
+	return e.message
+}
+
+// startPlayback prepares an item or podcast episode for streaming and starts
+// it on a Sonos device, resuming from the saved ABS progress.
+// playlistID is set when the item is played as part of an ABS playlist.
+func (h *PlayerHandler) startPlayback(ctx context.Context, session *store.Session, absClient *abs.Client, itemID, episodeID, sonosUUID, playlistID string) (*store.PlaybackSession, error) {
 	// Get item details from ABS
 	item, err := absClient.GetItem(ctx, itemID)
 	if err != nil {
 		slog.Error("failed to get item from ABS", "item_id", itemID, "error", err)
-		http.Error(w, "failed to get item", http.StatusInternalServerError)
-		return
+		return nil, &playError{status: http.StatusInternalServerError, message: "failed to get item"}
+	}
+
+	// Podcast episodes are played (and cached) individually
+	var episode *abs.PodcastEpisode
+	if episodeID != "" {
+		episode = item.GetEpisode(episodeID)
+		if episode == nil {
+			slog.Error("episode not found in item", "item_id", itemID, "episode_id", episodeID)
+			return nil, &playError{status: http.StatusNotFound, message: "episode not found"}
+		}
+	} else if item.IsPodcast() {
+		slog.Warn("play request for podcast without episode", "item_id", itemID)
+		return nil, &playError{status: http.StatusBadRequest, message: "episode_id required for podcasts"}
 	}
 
+	// Get Sonos device
+	slog.Debug("getting Sonos device", "uuid", sonosUUID)
+	device, err := h.sonosStore.Get(sonosUUID)
+	if err != nil || device == nil {
+		slog.Error("failed to get Sonos device", "uuid", sonosUUID, "error", err)
+		return nil, &playError{status: http.StatusNotFound, message: "device not found"}
+	}
+
+	// The previous playback of the web session, if any; its speed is kept
+	existingSession, _ := h.playbackStore.GetBySessionID(session.ID)
+	speed := 1.0
+	if existingSession != nil {
+		speed = existingSession.Speed
+	}
+
+	// Each player gets the cache variant matching the formats it accepts,
+	// processed with the item's audio preset, at the chosen speed
+	profile := h.profileFor(device, itemID, speed)
+	cacheKey := store.VariantKey(store.CacheKey(itemID, episodeID), profile.Name)
+
 	// Get all audio files and map their paths
-	if len(item.Media.AudioFiles) == 0 {
+	job, ok := cacheJobForItem(item, episode, absClient, h.pathMapper, profile.Name)
+	if !ok {
 		slog.Error("no audio files in item", "item_id", itemID)
-		http.Error(w, "no audio files", http.StatusBadRequest)
-		return
+		return nil, &playError{status: http.StatusBadRequest, message: "no audio files"}
 	}
+	slog.Debug("audio files mapped", "item_id", itemID, "file_count", len(job.SourcePaths))
 
-	// Sort audio files by index to ensure correct order
-	audioFiles := make([]abs.AudioFile, len(item.Media.AudioFiles))
-	copy(audioFiles, item.Media.AudioFiles)
-	sort.Slice(audioFiles, func(i, j int) bool {
-		return audioFiles[i].Index < audioFiles[j].Index
-	})
-	sourcePaths := make([]string, 0, len(audioFiles))
-	for _, af := range audioFiles {
-		absPath := af.Metadata.Path
-		localPath := h.pathMapper(absPath)
-		sourcePaths = append(sourcePaths, localPath)
+	// A replaced or re-tagged book is transcoded again
+	if _, err := h.cacheIndex.InvalidateIfChanged(job); err != nil {
+		slog.Warn("failed to check source files", "cache_key", cacheKey, "error", err)
 	}
-	slog.Debug("audio files mapped", "item_id", itemID, "file_count", len(sourcePaths))
 
 	// Check cache status
-	slog.Debug("checking cache status", "item_id", itemID)
-	cached, err := h.cacheIndex.IsCached(itemID)
+	slog.Debug("checking cache status", "cache_key", cacheKey)
+	cached, err := h.cacheIndex.IsCached(cacheKey)
 	if err != nil {
-		slog.Error("failed to check cache status", "item_id", itemID, "error", err)
-		http.Error(w, "cache error", http.StatusInternalServerError)
-		return
+		slog.Error("failed to check cache status", "cache_key", cacheKey, "error", err)
+		return nil, &playError{status: http.StatusInternalServerError, message: "cache error"}
+	}
+	slog.Debug("cache status checked", "cache_key", cacheKey, "cached", cached)
+
+	// Get saved progress from ABS (need this early for segment calculation)
+	progress, _ := absClient.GetEpisodeProgress(ctx, itemID, episodeID)
+	startPositionSec := 0
+	// Finished episodes are played from the start; books resume as before
+	finishedEpisode := episodeID != "" && progress != nil && progress.IsFinished
+	if progress != nil && progress.CurrentTime > 0 && !finishedEpisode {
+		startPositionSec = int(progress.CurrentTime)
+		slog.Info("resuming from saved position", "item_id", itemID, "episode_id", episodeID, "position_sec", startPositionSec)
 	}
-	slog.Debug("cache status checked", "item_id", itemID, "cached", cached)
 
+	live := false
 	if !cached {
 		// Start on-demand transcoding
-		entry, _ := h.cacheIndex.GetEntry(itemID)
+		entry, _ := h.cacheIndex.GetEntry(cacheKey)
 
 		if entry == nil {
 			// Create new entry (use first path for backwards compatibility)
-			if err := h.cacheIndex.CreateEntry(itemID, sourcePaths[0], 0, time.Now()); err != nil {
-				slog.Error("failed to create cache entry", "item_id", itemID, "error", err)
-				http.Error(w, "cache error", http.StatusInternalServerError)
-				return
+			if err := h.cacheIndex.CreateEntryForJob(job); err != nil {
+				slog.Error("failed to create cache entry", "cache_key", cacheKey, "error", err)
+				return nil, &playError{status: http.StatusInternalServerError, message: "cache error"}
 			}
 		}
 
-		// Do synchronous transcoding for immediate playback (with all files)
-		if err := h.cacheWorker.TranscodeSyncMultiple(ctx, itemID, sourcePaths); err != nil {
-			slog.Error("transcoding failed", "item_id", itemID, "error", err)
-			http.Error(w, "transcoding failed", http.StatusInternalServerError)
-			return
+		// Stream local files live while the cache job runs, or transcode in
+		// the background and stream the output while it is being written.
+		// Segmented output starts with the segment to resume in. Files that
+		// aren't available locally are downloaded from ABS first.
+		live, err = h.prepareUncached(ctx, job, startPositionSec)
+		if err != nil {
+			slog.Error("transcoding failed", "cache_key", cacheKey, "error", err)
+			return nil, &playError{status: http.StatusInternalServerError, message: "transcoding failed"}
 		}
 	}
 
 	// Get cache entry to determine the correct file format
-	cacheEntry, err := h.cacheIndex.GetEntry(itemID)
+	cacheEntry, err := h.cacheIndex.GetEntry(cacheKey)
 	if err != nil || cacheEntry == nil {
-		slog.Error("failed to get cache entry for format", "item_id", itemID, "error", err)
-		http.Error(w, "cache error", http.StatusInternalServerError)
-		return
+		slog.Error("failed to get cache entry for format", "cache_key", cacheKey, "error", err)
+		return nil, &playError{status: http.StatusInternalServerError, message: "cache error"}
 	}
 
-	// Generate stream token
-	slog.Debug("generating stream token", "item_id", itemID)
-	token, err := h.tokenGen.Generate(itemID, session.UserID, session.ID)
+	// Get coordinator IP for group support (sends commands to coordinator instead of member)
+	targetIP := h.getCoordinatorIP(ctx, device.IPAddress)
+
+	// Generate stream token for the new playback session; the coordinator fetches the stream
+	playbackID := generateID()
+	slog.Debug("generating stream token", "cache_key", cacheKey)
+	token, err := h.tokenGen.GenerateForPlayback(cacheKey, session.UserID, session.ID, playbackID, targetIP)
 	if err != nil {
 		slog.Error("failed to generate stream token", "error", err)
-		http.Error(w, "token error", http.StatusInternalServerError)
-		return
+		return nil, &playError{status: http.StatusInternalServerError, message: "token error"}
 	}
 
-	// Get saved progress from ABS (need this early for segment calculation)
-	progress, _ := absClient.GetProgress(ctx, itemID)
-	startPositionSec := 0
-	if progress != nil && progress.CurrentTime > 0 {
-		startPositionSec = int(progress.CurrentTime)
-		slog.Info("resuming from saved position", "item_id", itemID, "position_sec", startPositionSec)
-	}
-
-	// Build stream URL - handle segmented vs non-segmented
+	// Build stream URL - handle live, HLS, segmented and non-segmented
 	var streamURL string
-	var currentSegment int
-	var segmentDurationSec int
-
-	if cacheEntry.IsSegmented() {
-		// Calculate which segment to start with based on position
-		currentSegment, _ = store.GlobalToSegment(startPositionSec, cacheEntry.SegmentDurationSec)
-		segmentDurationSec = cacheEntry.SegmentDurationSec
-
+	var streamOffset int
+	streamMode, currentSegment, segmentStarts := h.streamLayout(device, cacheEntry, profile, startPositionSec)
+	if live {
+		streamMode, currentSegment, segmentStarts = store.StreamModeLive, 0, nil
+		streamOffset = profile.OutputTime(startPositionSec)
+	}
+
+	if streamMode == store.StreamModeLive {
+		// Transcoded from the sources, starting at the saved position
+		streamURL = fmt.Sprintf("%s/stream/%s/%s", h.publicURL, token, cache.LiveFileName(streamOffset))
+		slog.Debug("live stream URL generated", "url", streamURL, "offset_sec", streamOffset)
+	} else if streamMode == store.StreamModeHLS {
+		// One playlist over the whole item, seekable like a single file
+		streamURL = fmt.Sprintf("%s/stream/%s/%s", h.publicURL, token, cache.HLSPlaylistName)
+		slog.Debug("HLS stream URL generated", "url", streamURL, "format", cacheEntry.CacheFormat)
+	} else if cacheEntry.IsSegmented() {
 		// Build segment URL
 		ext := ".m4a"
 		switch cacheEntry.CacheFormat {
@@ -256,52 +358,44 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 		slog.Debug("stream URL generated", "url", streamURL, "format", cacheEntry.CacheFormat)
 	}
 
-	// Get Sonos device
-	slog.Debug("getting Sonos device", "uuid", sonosUUID)
-	device, err := h.sonosStore.Get(sonosUUID)
-	if err != nil || device == nil {
-		slog.Error("failed to get Sonos device", "uuid", sonosUUID, "error", err)
-		http.Error(w, "device not found", http.StatusNotFound)
-		return
-	}
-
-	// Get coordinator IP for group support (sends commands to coordinator instead of member)
-	targetIP := h.getCoordinatorIP(ctx, device.IPAddress)
-
 	// Create AVTransport client using coordinator IP
 	avt := sonos.NewAVTransport(targetIP)
 
 	// Build DIDL-Lite metadata with correct MIME type
 	mimeType := cache.GetContentType(cacheEntry.CacheFormat)
-	metadata := buildDIDLMetadata(item, streamURL, mimeType)
+	switch streamMode {
+	case store.StreamModeLive:
+		mimeType = cache.LiveContentType
+	case store.StreamModeHLS:
+		mimeType = cache.HLSContentType
+	}
+	metadata := buildDIDLMetadata(item, episode, streamURL, mimeType)
 	slog.Debug("DIDL metadata built", "mime_type", mimeType)
 
 	// Set AV Transport URI
 	if err := avt.SetAVTransportURI(ctx, streamURL, metadata); err != nil {
 		slog.Error("failed to set transport URI", "error", err)
-		http.Error(w, "failed to set URI on Sonos", http.StatusInternalServerError)
-		return
+		return nil, &playError{status: http.StatusInternalServerError, message: "failed to set URI on Sonos"}
 	}
 
 	// Start playback
 	if err := avt.Play(ctx); err != nil {
 		slog.Error("failed to start playback", "error", err)
-		http.Error(w, "failed to start playback", http.StatusInternalServerError)
-		return
+		return nil, &playError{status: http.StatusInternalServerError, message: "failed to start playback"}
 	}
 
-	// Seek to saved position if needed
-	if startPositionSec > 0 {
+	// Seek to saved position if needed; live streams already start there
+	if startPositionSec > 0 && streamMode != store.StreamModeLive {
 		// For segmented playback, seek to local position within the segment
 		var seekPosition int
-		if cacheEntry.IsSegmented() {
-			_, seekPosition = store.GlobalToSegment(startPositionSec, cacheEntry.SegmentDurationSec)
+		if streamMode != store.StreamModeHLS && cacheEntry.IsSegmented() {
+			_, seekPosition = store.GlobalToSegment(profile.OutputTime(startPositionSec), cacheEntry.SegmentStarts)
 			slog.Debug("seeking to local position in segment",
 				"global_position", startPositionSec,
 				"segment", currentSegment,
 				"local_position", seekPosition)
 		} else {
-			seekPosition = startPositionSec
+			seekPosition = profile.OutputTime(startPositionSec)
 		}
 
 		// Small delay to ensure playback has started
@@ -314,15 +408,16 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 
 	// Calculate total duration (use Media.Duration, or sum audio files if 0)
 	totalDuration := item.Media.Duration
-	if totalDuration == 0 && len(item.Media.AudioFiles) > 0 {
+	if episode != nil {
+		totalDuration = episode.GetDuration()
+	} else if totalDuration == 0 && len(item.Media.AudioFiles) > 0 {
 		for _, af := range item.Media.AudioFiles {
 			totalDuration += af.Duration
 		}
 	}
 
 	// Check if there's an existing session with a different item - clear its sleep timer
-	existingSession, _ := h.playbackStore.GetBySessionID(session.ID)
-	if existingSession != nil && existingSession.ItemID != itemID {
+	if existingSession != nil && store.CacheKey(existingSession.ItemID, existingSession.EpisodeID) != store.CacheKey(itemID, episodeID) {
 		// User is starting a different book - clear any active sleep timer
 		if existingSession.SleepAt != nil {
 			slog.Info("clearing sleep timer on book change",
@@ -335,40 +430,75 @@ func (h *PlayerHandler) HandlePlay(w http.ResponseWriter, r *http.Request) {
 
 	// Create/update playback session
 	playbackSession := &store.PlaybackSession{
-		ID:                 generateID(),
+		ID:                 playbackID,
 		SessionID:          session.ID,
 		ItemID:             itemID,
+		EpisodeID:          episodeID,
 		SonosUUID:          sonosUUID,
 		StreamToken:        token,
 		IsPlaying:          true,
 		PositionSec:        startPositionSec,
 		DurationSec:        int(totalDuration),
 		CurrentSegment:     currentSegment,
-		SegmentDurationSec: segmentDurationSec,
+		SegmentStarts:      segmentStarts,
+		StreamMode:         streamMode,
+		StreamOffset:       streamOffset,
+		PlaylistID:         playlistID,
+		Profile:            profile.Name,
+		Speed:              profile.Tempo(),
 		StartedAt:          time.Now(),
 		LastPositionUpdate: time.Now(),
 	}
 
 	if err := h.playbackStore.Create(playbackSession); err != nil {
 		slog.Warn("failed to save playback session", "error", err)
+	} else {
+		// Open an ABS playback session so listening time shows up in ABS stats
+		h.listening.Open(ctx, absClient, playbackSession)
+	}
+
+	// The new playback replaces the previous one of the web session; its sleep
+	// timer is kept if the same item is played again
+	if existingSession != nil {
+		if existingSession.SleepAt != nil && store.CacheKey(existingSession.ItemID, existingSession.EpisodeID) == store.CacheKey(itemID, episodeID) {
+			if err := h.playbackStore.SetSleepTimer(playbackSession.ID, *existingSession.SleepAt); err != nil {
+				slog.Warn("failed to carry over sleep timer", "playback_id", playbackSession.ID, "error", err)
+			} else {
+				playbackSession.SleepAt = existingSession.SleepAt
+			}
+		}
+		h.supersede(existingSession)
 	}
 
 	slog.Info("playback started",
 		"item_id", itemID,
+		"episode_id", episodeID,
 		"sonos_uuid", sonosUUID,
 		"user_id", session.UserID,
+		"playlist_id", playlistID,
 		"duration_sec", playbackSession.DurationSec,
 		"audio_files", len(item.Media.AudioFiles),
 		"current_segment", currentSegment,
 		"segmented", cacheEntry.IsSegmented(),
+		"stream_mode", streamMode,
+		"profile", profile.Name,
+		"speed", profile.Tempo(),
 	)
 
-	// Return JSON with redirect URL (HX-Redirect header not accessible from JS fetch due to CORS)
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("HX-Redirect", "/player/"+itemID) // Keep for htmx compatibility
-	json.NewEncoder(w).Encode(map[string]string{
-		"redirect": "/player/" + itemID,
-	})
+	return playbackSession, nil
+}
+
+// supersede removes a playback session that a new playback replaced and
+// revokes its stream URLs, so neither the speaker nor the token renewal can
+// pick the old stream up again.
+func (h *PlayerHandler) supersede(playback *store.PlaybackSession) {
+	if err := h.playbackStore.Delete(playback.ID); err != nil {
+		slog.Warn("failed to delete replaced playback session", "playback_id", playback.ID, "error", err)
+	}
+	if err := h.tokenGen.RevokePlayback(playback.ID); err != nil {
+		slog.Warn("failed to revoke stream tokens of replaced playback session", "playback_id", playback.ID, "error", err)
+	}
+	h.forgetLive(playback)
 }
 
 // HandlePause handles POST /transport/pause requests.
@@ -409,16 +539,14 @@ func (h *PlayerHandler) HandlePause(w http.ResponseWriter, r *http.Request) {
 	if posInfo != nil {
 		localPos := int(sonos.ParseDuration(posInfo.RelTime).Seconds())
 
-		// Calculate global position for segmented playback
-		var globalPos int
-		if playback.SegmentDurationSec > 0 {
-			globalPos = store.SegmentToGlobal(playback.CurrentSegment, localPos, playback.SegmentDurationSec)
-		} else {
-			globalPos = localPos
-		}
+		// Calculate global position for segmented playback and speed variants
+		globalPos := playback.GlobalPosition(localPos)
 
 		playback.PositionSec = globalPos
 		h.playbackStore.UpdatePosition(playback.ID, globalPos)
+
+		// Count listening time up to the pause
+		h.listening.Tick(playback, globalPos)
 	}
 
 	// Pause on Sonos
@@ -438,22 +566,8 @@ func (h *PlayerHandler) HandlePause(w http.ResponseWriter, r *http.Request) {
 
 	// Sync progress to ABS immediately (like HandleStop does)
 	if playback.PositionSec > 0 && playback.DurationSec > 0 {
-		absClient, err := h.authHandler.GetABSClientForSession(session)
-		if err == nil {
-			progress := abs.ProgressUpdate{
-				CurrentTime: float64(playback.PositionSec),
-				Duration:    float64(playback.DurationSec),
-				Progress:    float64(playback.PositionSec) / float64(playback.DurationSec),
-			}
-			if err := absClient.UpdateProgress(ctx, playback.ItemID, progress); err != nil {
-				slog.Warn("failed to sync progress to ABS on pause", "error", err)
-			} else {
-				slog.Debug("synced progress to ABS on pause",
-					"item_id", playback.ItemID,
-					"position_sec", playback.PositionSec,
-					"progress_pct", int(progress.Progress*100),
-				)
-			}
+		if absClient, err := h.authHandler.GetABSClientForSession(session); err == nil {
+			writeProgress(ctx, h.listening, h.remote, absClient, session.UserID, playback, progressSync)
 		}
 	}
 
@@ -522,16 +636,55 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 		}
 		slog.Debug("found new device", "name", newDevice.Name, "ip", newDevice.IPAddress)
 
+		// Get item for metadata
+		absClient, err := h.authHandler.GetABSClientForSession(session)
+		if err != nil {
+			http.Error(w, "session error", http.StatusInternalServerError)
+			return
+		}
+		item, err := absClient.GetItem(ctx, playback.ItemID)
+		if err != nil {
+			http.Error(w, "failed to get item", http.StatusInternalServerError)
+			return
+		}
+
+		// The new player may need a different cache variant
+		newProfile := h.profileFor(newDevice, playback.ItemID, playback.Speed)
+		if newProfile.Name != playback.Profile {
+			if err := h.switchProfile(ctx, playback, newDevice, item, absClient, newProfile); err != nil {
+				slog.Error("failed to prepare cache for new device", "device", newDevice.Name, "profile", newProfile.Name, "error", err)
+				http.Error(w, "transcoding failed", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Get cache entry for stream URL
-		cacheEntry, err := h.cacheIndex.GetEntry(playback.ItemID)
+		cacheEntry, err := h.cacheIndex.GetEntry(playback.CacheKey())
 		if err != nil || cacheEntry == nil {
-			slog.Error("cache entry not found for player switch", "item_id", playback.ItemID, "error", err)
+			slog.Error("cache entry not found for player switch", "cache_key", playback.CacheKey(), "error", err)
 			http.Error(w, "cache entry not found", http.StatusNotFound)
 			return
 		}
 
+		// The new player may stream the variant another way, e.g. as HLS.
+		// Live streams continue live until the cache is ready.
+		if !playback.IsLive() || cacheEntry.Status == store.CacheStatusReady {
+			streamMode, segment, segmentStarts := h.streamLayout(newDevice, cacheEntry, newProfile, playback.PositionSec)
+			if streamMode != playback.StreamMode {
+				if err := h.playbackStore.UpdateStreamLayout(playback.ID, streamMode, segment, segmentStarts); err != nil {
+					slog.Warn("failed to update stream mode", "error", err)
+				}
+				playback.StreamMode = streamMode
+				playback.CurrentSegment = segment
+				playback.SegmentStarts = segmentStarts
+			}
+		}
+
+		// Get coordinator IP for new device (for group support)
+		newCoordinatorIP := h.getCoordinatorIP(ctx, newDevice.IPAddress)
+
 		// Generate fresh stream token to avoid expiration issues
-		newToken, err := h.tokenGen.Generate(playback.ItemID, session.UserID, session.ID)
+		newToken, err := h.tokenGen.GenerateForPlayback(playback.CacheKey(), session.UserID, session.ID, playback.ID, newCoordinatorIP)
 		if err != nil {
 			slog.Error("failed to generate new stream token", "error", err)
 			http.Error(w, "token generation failed", http.StatusInternalServerError)
@@ -543,15 +696,14 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 		// For segmented files, use the correct segment based on current position
 		var streamURL string
 		var localSeekPos int // Position to seek within the segment
-		if cacheEntry.IsSegmented() {
-			// Calculate which segment the current position falls into
-			segmentDuration := cacheEntry.SegmentDurationSec
-			if segmentDuration <= 0 {
-				segmentDuration = 7200 // Default 2 hours
-			}
+		if playback.IsLive() {
+			// Live streams start at the position
+			_, localSeekPos = h.streamStart(playback, playback.PositionSec)
+			streamURL = h.streamURL(newToken, playback, cacheEntry, 0)
+		} else if !playback.IsHLS() && cacheEntry.IsSegmented() {
 			currentSegment := playback.CurrentSegment
 			// Calculate local position within the segment
-			localSeekPos = playback.PositionSec - (currentSegment * segmentDuration)
+			localSeekPos = playback.VariantPosition(playback.PositionSec) - store.SegmentToGlobal(currentSegment, 0, cacheEntry.SegmentStarts)
 			if localSeekPos < 0 {
 				localSeekPos = 0
 			}
@@ -570,29 +722,13 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 				"local_seek_pos", localSeekPos,
 				"stream_url", streamURL)
 		} else {
-			cacheFileName := cache.GetCacheFileName(cacheEntry.CacheFormat)
-			streamURL = fmt.Sprintf("%s/stream/%s/%s", h.publicURL, newToken, cacheFileName)
-			localSeekPos = playback.PositionSec
-		}
-
-		// Get item for metadata
-		absClient, err := h.authHandler.GetABSClientForSession(session)
-		if err != nil {
-			http.Error(w, "session error", http.StatusInternalServerError)
-			return
-		}
-		item, err := absClient.GetItem(ctx, playback.ItemID)
-		if err != nil {
-			http.Error(w, "failed to get item", http.StatusInternalServerError)
-			return
+			streamURL = h.streamURL(newToken, playback, cacheEntry, 0)
+			localSeekPos = playback.VariantPosition(playback.PositionSec)
 		}
 
 		// Build DIDL metadata
-		mimeType := cache.GetContentType(cacheEntry.CacheFormat)
-		metadata := buildDIDLMetadata(item, streamURL, mimeType)
-
-		// Get coordinator IP for new device (for group support)
-		newCoordinatorIP := h.getCoordinatorIP(ctx, newDevice.IPAddress)
+		mimeType := streamContentType(playback, cacheEntry)
+		metadata := buildDIDLMetadata(item, item.GetEpisode(playback.EpisodeID), streamURL, mimeType)
 
 		// Set URI on new device (via coordinator)
 		newAVT := sonos.NewAVTransport(newCoordinatorIP)
@@ -627,6 +763,10 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 		if err := h.playbackStore.UpdateStreamToken(playback.ID, newToken); err != nil {
 			slog.Warn("failed to update stream token in database", "error", err)
 		}
+		// The old device was stopped and must not fetch the stream anymore
+		if err := h.tokenGen.RevokeToken(playback.StreamToken); err != nil {
+			slog.Warn("failed to revoke stream token of old device", "error", err)
+		}
 
 		slog.Info("player switch completed successfully",
 			"new_device", newDevice.Name,
@@ -653,7 +793,7 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 	var absPositionSec int
 	absClient, err := h.authHandler.GetABSClientForSession(session)
 	if err == nil {
-		progress, err := absClient.GetProgress(ctx, playback.ItemID)
+		progress, err := absClient.GetEpisodeProgress(ctx, playback.ItemID, playback.EpisodeID)
 		if err == nil && progress != nil {
 			absPositionSec = int(progress.CurrentTime)
 			slog.Debug("fetched ABS progress on resume",
@@ -687,6 +827,23 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The speaker still holds the old stream URI; set it again with a fresh
+	// token if that expires soon. Live streams can't be continued and start
+	// again at the position, on the cache if it is ready by now.
+	if playback.IsLive() || h.streamTokenExpiring(playback) {
+		resumePosition := playback.PositionSec
+		if needsSeek {
+			resumePosition = targetPosition
+		}
+		if err := h.renewStream(ctx, avt, playback, resumePosition); err != nil {
+			slog.Error("failed to resume with renewed stream token", "error", err)
+			http.Error(w, "failed to resume", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	if err := avt.Play(ctx); err != nil {
 		// Error 701 = "Transition not available" - device may already be playing
 		if strings.Contains(err.Error(), "errorCode>701") {
@@ -701,12 +858,7 @@ func (h *PlayerHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
 	// Seek to ABS position if it changed
 	if needsSeek {
 		// For segmented playback, calculate local position within segment
-		var seekPosition int
-		if playback.SegmentDurationSec > 0 {
-			_, seekPosition = store.GlobalToSegment(targetPosition, playback.SegmentDurationSec)
-		} else {
-			seekPosition = targetPosition
-		}
+		_, seekPosition := playback.SegmentPosition(targetPosition)
 
 		time.Sleep(300 * time.Millisecond) // Brief delay for playback to start
 		if err := avt.Seek(ctx, time.Duration(seekPosition)*time.Second); err != nil {
@@ -782,23 +934,14 @@ func (h *PlayerHandler) HandleSeek(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Get current local position
-		posInfo, err := avt.GetPositionInfo(ctx)
+		// Get current global position and apply offset
+		currentGlobalPos, err := h.currentPosition(ctx, avt, playback)
 		if err != nil {
 			slog.Error("failed to get position", "error", err)
 			http.Error(w, "failed to get position", http.StatusInternalServerError)
 			return
 		}
-
-		localPos := int(sonos.ParseDuration(posInfo.RelTime).Seconds())
-
-		// Convert to global position and apply offset
-		if playback.SegmentDurationSec > 0 {
-			currentGlobalPos := store.SegmentToGlobal(playback.CurrentSegment, localPos, playback.SegmentDurationSec)
-			targetGlobalPositionSec = currentGlobalPos + offsetSec
-		} else {
-			targetGlobalPositionSec = localPos + offsetSec
-		}
+		targetGlobalPositionSec = currentGlobalPos + offsetSec
 
 		if targetGlobalPositionSec < 0 {
 			targetGlobalPositionSec = 0
@@ -811,9 +954,39 @@ func (h *PlayerHandler) HandleSeek(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.seekTo(ctx, session, avt, playback, targetGlobalPositionSec); err != nil {
+		slog.Error("failed to seek", "error", err)
+		http.Error(w, "failed to seek", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// currentPosition returns the global playback position read from Sonos.
+// For segmented playback the offset of the current segment is added, for
+// speed variants the position is mapped to the item's timeline.
+func (h *PlayerHandler) currentPosition(ctx context.Context, avt *sonos.AVTransport, playback *store.PlaybackSession) (int, error) {
+	posInfo, err := avt.GetPositionInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	localPos := int(sonos.ParseDuration(posInfo.RelTime).Seconds())
+	return playback.GlobalPosition(localPos), nil
+}
+
+// seekTo seeks to a global position, switching segments for segmented playback.
+// The position is on the item's timeline and mapped to the speed variant.
+func (h *PlayerHandler) seekTo(ctx context.Context, session *store.Session, avt *sonos.AVTransport, playback *store.PlaybackSession, targetGlobalPositionSec int) error {
+	// Live streams can't seek; the stream is started again at the target
+	if playback.IsLive() {
+		return h.renewStream(ctx, avt, playback, targetGlobalPositionSec)
+	}
+
 	// Handle segmented playback - check if we need to switch segments
-	if playback.SegmentDurationSec > 0 {
-		targetSegment, localPosition := store.GlobalToSegment(targetGlobalPositionSec, playback.SegmentDurationSec)
+	if playback.IsSegmented() {
+		targetSegment, localPosition := playback.SegmentPosition(targetGlobalPositionSec)
 
 		if targetSegment != playback.CurrentSegment {
 			// Need to switch to a different segment
@@ -824,18 +997,19 @@ func (h *PlayerHandler) HandleSeek(w http.ResponseWriter, r *http.Request) {
 				"local_position", localPosition)
 
 			// Get cache entry for segment info
-			cacheEntry, err := h.cacheIndex.GetEntry(playback.ItemID)
-			if err != nil || cacheEntry == nil {
-				slog.Error("failed to get cache entry for seek", "error", err)
-				http.Error(w, "cache error", http.StatusInternalServerError)
-				return
+			cacheEntry, err := h.cacheIndex.GetEntry(playback.CacheKey())
+			if err != nil {
+				return fmt.Errorf("failed to get cache entry: %w", err)
+			}
+			if cacheEntry == nil {
+				return fmt.Errorf("cache entry not found: %s", playback.CacheKey())
 			}
 
 			// Validate segment index
 			if targetSegment >= cacheEntry.SegmentCount {
 				targetSegment = cacheEntry.SegmentCount - 1
 				// Recalculate local position for last segment
-				localPosition = playback.DurationSec - (targetSegment * playback.SegmentDurationSec)
+				localPosition = playback.VariantPosition(playback.DurationSec) - store.SegmentToGlobal(targetSegment, 0, cacheEntry.SegmentStarts)
 			}
 
 			// Build URL for target segment
@@ -855,22 +1029,18 @@ func (h *PlayerHandler) HandleSeek(w http.ResponseWriter, r *http.Request) {
 				item, err := absClient.GetItem(ctx, playback.ItemID)
 				if err == nil && item != nil {
 					mimeType := cache.GetContentType(cacheEntry.CacheFormat)
-					metadata = buildDIDLMetadata(item, segmentURL, mimeType)
+					metadata = buildDIDLMetadata(item, item.GetEpisode(playback.EpisodeID), segmentURL, mimeType)
 				}
 			}
 
 			// Set new segment URI
 			if err := avt.SetAVTransportURI(ctx, segmentURL, metadata); err != nil {
-				slog.Error("failed to set segment URI for seek", "error", err)
-				http.Error(w, "failed to seek", http.StatusInternalServerError)
-				return
+				return fmt.Errorf("failed to set segment URI: %w", err)
 			}
 
 			// Start playback and seek
 			if err := avt.Play(ctx); err != nil {
-				slog.Error("failed to start after segment change", "error", err)
-				http.Error(w, "failed to seek", http.StatusInternalServerError)
-				return
+				return fmt.Errorf("failed to start after segment change: %w", err)
 			}
 
 			// Wait briefly for playback to start
@@ -888,23 +1058,19 @@ func (h *PlayerHandler) HandleSeek(w http.ResponseWriter, r *http.Request) {
 		} else {
 			// Same segment - just seek locally
 			if err := avt.Seek(ctx, time.Duration(localPosition)*time.Second); err != nil {
-				slog.Error("failed to seek", "error", err)
-				http.Error(w, "failed to seek", http.StatusInternalServerError)
-				return
+				return err
 			}
 			h.playbackStore.UpdatePosition(playback.ID, targetGlobalPositionSec)
 		}
 	} else {
 		// Non-segmented playback - simple seek
-		if err := avt.Seek(ctx, time.Duration(targetGlobalPositionSec)*time.Second); err != nil {
-			slog.Error("failed to seek", "error", err)
-			http.Error(w, "failed to seek", http.StatusInternalServerError)
-			return
+		if err := avt.Seek(ctx, time.Duration(playback.VariantPosition(targetGlobalPositionSec))*time.Second); err != nil {
+			return err
 		}
 		h.playbackStore.UpdatePosition(playback.ID, targetGlobalPositionSec)
 	}
 
-	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
 // HandleStatus handles GET /status requests.
@@ -956,6 +1122,7 @@ func (h *PlayerHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"active":       true,
 			"item_id":      playback.ItemID,
+			"episode_id":   playback.EpisodeID,
 			"is_playing":   isPlaying,
 			"position_sec": playback.PositionSec,
 			"duration_sec": playback.DurationSec,
@@ -968,35 +1135,31 @@ func (h *PlayerHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	trackDuration := sonos.ParseDuration(posInfo.TrackDuration)
 	localPositionSec := int(relTime.Seconds())
 
-	// Calculate global position (across all segments)
-	var globalPositionSec int
-	if playback.SegmentDurationSec > 0 {
-		// Segmented playback: global = segment * segment_duration + local
-		globalPositionSec = store.SegmentToGlobal(playback.CurrentSegment, localPositionSec, playback.SegmentDurationSec)
-
+	// Calculate global position (across all segments, on the item's timeline
+	// for speed variants)
+	globalPositionSec := playback.GlobalPosition(localPositionSec)
+	if segmentLength := store.SegmentLength(playback.CurrentSegment, playback.SegmentStarts); segmentLength > 0 {
 		// Check if we're near segment end and need to switch to next segment
-		segmentEndThreshold := playback.SegmentDurationSec - 5 // 5 seconds before end
+		segmentEndThreshold := segmentLength - 5 // 5 seconds before end
 		if isPlaying && localPositionSec >= segmentEndThreshold {
 			h.handleSegmentTransition(r.Context(), playback, device)
 		}
-	} else {
-		// Non-segmented playback: global = local
-		globalPositionSec = localPositionSec
 	}
 
 	// Use stored total duration (always correct for global duration)
 	durationSec := playback.DurationSec
 	if durationSec == 0 {
 		// Fallback to track duration if stored duration is 0
-		durationSec = int(trackDuration.Seconds())
+		durationSec = playback.RealPosition(int(trackDuration.Seconds()))
 	}
 
 	slog.Debug("status position check",
 		"local_position", localPositionSec,
 		"global_position", globalPositionSec,
 		"current_segment", playback.CurrentSegment,
-		"segment_duration", playback.SegmentDurationSec,
+		"segment_length", store.SegmentLength(playback.CurrentSegment, playback.SegmentStarts),
 		"total_duration", durationSec,
+		"speed", playback.Speed,
 	)
 
 	// Update stored global position
@@ -1019,6 +1182,7 @@ func (h *PlayerHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"active":       true,
 		"item_id":      playback.ItemID,
+		"episode_id":   playback.EpisodeID,
 		"is_playing":   isPlaying,
 		"position_sec": globalPositionSec,
 		"duration_sec": durationSec,
@@ -1026,19 +1190,63 @@ func (h *PlayerHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		"duration_str": formatDurationSec(durationSec),
 		"volume":       volume,
 		"muted":        muted,
+		"speed":        playback.Speed,
+		"cache_key":    playback.CacheKey(),
+	}
+	if playback.PlaylistID != "" {
+		// Lets the player page follow when the playlist advances to the next item
+		response["playlist_id"] = playback.PlaylistID
+		response["player_url"] = playerURL(playback.ItemID, playback.EpisodeID)
 	}
 	if sleepTimerRemainingSec != nil {
 		response["sleep_timer_remaining_sec"] = *sleepTimerRemainingSec
 	}
+
+	// Offer a jump if the item was played further on another device
+	playback.PositionSec = globalPositionSec
+	if rp := h.remote.Ahead(session.UserID, playback, h.listening.SessionID(playback.ID)); rp != nil {
+		response["remote_progress"] = map[string]interface{}{
+			"position_sec": int(rp.CurrentTime),
+			"position_str": formatDurationSec(int(rp.CurrentTime)),
+			"device":       rp.DeviceDescription,
+			"updated_at":   rp.UpdatedAt.Unix(),
+		}
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// HandleDismissRemoteProgress handles POST /transport/remote-progress/dismiss requests.
+// The Sonos position is kept and will overwrite the progress made on another device.
+func (h *PlayerHandler) HandleDismissRemoteProgress(w http.ResponseWriter, r *http.Request) {
+	session := GetSession(r.Context())
+	if session == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	playback, err := h.playbackStore.GetBySessionID(session.ID)
+	if err != nil || playback == nil {
+		http.Error(w, "no active playback", http.StatusNotFound)
+		return
+	}
+
+	h.remote.Dismiss(session.UserID, playback.ItemID, playback.EpisodeID)
+
+	slog.Info("remote progress dismissed",
+		"item_id", playback.ItemID,
+		"episode_id", playback.EpisodeID,
+		"user_id", session.UserID,
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // handleSegmentTransition handles the transition to the next segment.
 func (h *PlayerHandler) handleSegmentTransition(ctx context.Context, playback *store.PlaybackSession, device *store.SonosDevice) {
 	// Get cache entry to check segment count
-	cacheEntry, err := h.cacheIndex.GetEntry(playback.ItemID)
+	cacheEntry, err := h.cacheIndex.GetEntry(playback.CacheKey())
 	if err != nil || cacheEntry == nil {
-		slog.Warn("failed to get cache entry for segment transition", "item_id", playback.ItemID, "error", err)
+		slog.Warn("failed to get cache entry for segment transition", "cache_key", playback.CacheKey(), "error", err)
 		return
 	}
 
@@ -1064,7 +1272,16 @@ func (h *PlayerHandler) handleSegmentTransition(ctx context.Context, playback *s
 	case "flac":
 		ext = ".flac"
 	}
-	nextStreamURL := fmt.Sprintf("%s/stream/%s/segment_%03d%s", h.publicURL, playback.StreamToken, nextSegment, ext)
+	// The next segment is a new URI, so an expiring token is replaced for free
+	token := playback.StreamToken
+	if h.streamTokenExpiring(playback) {
+		if renewed, err := h.tokenGen.Renew(token); err == nil {
+			token = renewed
+		} else {
+			slog.Warn("failed to renew stream token for segment transition", "error", err)
+		}
+	}
+	nextStreamURL := fmt.Sprintf("%s/stream/%s/segment_%03d%s", h.publicURL, token, nextSegment, ext)
 
 	// Get item for metadata
 	session := &store.Session{ID: playback.SessionID}
@@ -1079,7 +1296,7 @@ func (h *PlayerHandler) handleSegmentTransition(ctx context.Context, playback *s
 		item, err := absClient.GetItem(ctx, playback.ItemID)
 		if err == nil && item != nil {
 			mimeType := cache.GetContentType(cacheEntry.CacheFormat)
-			metadata = buildDIDLMetadata(item, nextStreamURL, mimeType)
+			metadata = buildDIDLMetadata(item, item.GetEpisode(playback.EpisodeID), nextStreamURL, mimeType)
 		}
 	}
 
@@ -1101,6 +1318,11 @@ func (h *PlayerHandler) handleSegmentTransition(ctx context.Context, playback *s
 
 	// Update playback session with new segment
 	h.playbackStore.UpdateCurrentSegment(playback.ID, nextSegment)
+	if token != playback.StreamToken {
+		if err := h.playbackStore.UpdateStreamToken(playback.ID, token); err != nil {
+			slog.Warn("failed to update stream token in database", "error", err)
+		}
+	}
 
 	slog.Info("segment transition complete",
 		"item_id", playback.ItemID,
@@ -1144,17 +1366,40 @@ func (h *PlayerHandler) HandlePlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	title := item.Media.Metadata.Title
+
+	// Podcast episodes are addressed via ?episode=<id>
+	var episode *abs.PodcastEpisode
+	if episodeID := r.URL.Query().Get("episode"); episodeID != "" {
+		episode = item.GetEpisode(episodeID)
+		if episode == nil {
+			http.Error(w, "episode not found", http.StatusNotFound)
+			return
+		}
+		// Chapters in the transport controls refer to the episode
+		item.Media.Chapters = episode.Chapters
+		title = episode.Title
+	}
+
 	// Get playback session
 	playback, _ := h.playbackStore.GetBySessionID(session.ID)
+	speed := 1.0
+	if playback != nil {
+		speed = playback.Speed
+	}
 
 	// Build template data with layout requirements
 	data := map[string]interface{}{
-		"Title":      item.Media.Metadata.Title,
-		"ShowHeader": true,
-		"Username":   session.ABSUsername,
-		"Item":       item,
-		"Playback":   playback,
-		"LibraryID":  item.LibraryID,
+		"Title":        title,
+		"ShowHeader":   true,
+		"Username":     session.ABSUsername,
+		"IsAdmin":      session.IsAdmin(),
+		"Item":         item,
+		"Episode":      episode,
+		"Playback":     playback,
+		"LibraryID":    item.LibraryID,
+		"Speed":        speedValue(speed),
+		"SpeedOptions": speedOptions(),
 	}
 
 	h.renderPlayerPage(w, "player.html", data)
@@ -1244,10 +1489,22 @@ func (h *PlayerHandler) HandleCacheStatus(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	response := cacheStatusResponse{Status: string(status)}
+	if progress, ok := h.cacheWorker.Progress(itemID); ok {
+		response.Progress = &progress
+	}
+
+	if r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
 	// Render HTML template for htmx
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	data := map[string]interface{}{
-		"Status": string(status),
+		"Status":   response.Status,
+		"Progress": response.Progress,
 	}
 	if err := h.templates.ExecuteTemplate(w, "cache-status-badge", data); err != nil {
 		slog.Error("template execute error", "error", err)
@@ -1298,8 +1555,11 @@ func (h *PlayerHandler) HandleStop(w http.ResponseWriter, r *http.Request) {
 		posInfo, _ := avt.GetPositionInfo(ctx)
 		if posInfo != nil {
 			relTime := sonos.ParseDuration(posInfo.RelTime)
-			playback.PositionSec = int(relTime.Seconds())
+			playback.PositionSec = playback.GlobalPosition(int(relTime.Seconds()))
 			h.playbackStore.UpdatePosition(playback.ID, playback.PositionSec)
+
+			// Count listening time up to the stop
+			h.listening.Tick(playback, playback.PositionSec)
 		}
 
 		// Stop playback on the playback session's device
@@ -1334,39 +1594,141 @@ func (h *PlayerHandler) HandleStop(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Save progress to ABS
-	if playback.PositionSec > 0 {
-		absClient, err := h.authHandler.GetABSClientForSession(session)
-		if err == nil {
-			progress := abs.ProgressUpdate{
-				CurrentTime: float64(playback.PositionSec),
-				Duration:    float64(playback.DurationSec),
-				Progress:    float64(playback.PositionSec) / float64(playback.DurationSec),
-			}
-			if err := absClient.UpdateProgress(ctx, playback.ItemID, progress); err != nil {
-				slog.Warn("failed to sync progress to ABS", "error", err)
-			}
-		}
+	// Close the ABS playback session, which syncs the final position as well;
+	// without one, the progress is saved directly
+	if absClient, err := h.authHandler.GetABSClientForSession(session); err == nil {
+		writeProgress(ctx, h.listening, h.remote, absClient, session.UserID, playback, progressClose)
 	}
 
-	// Delete playback session (user explicitly stopped)
+	// Delete playback session (user explicitly stopped) and revoke its stream URLs
 	if err := h.playbackStore.Delete(playback.ID); err != nil {
 		slog.Warn("failed to delete playback session", "error", err)
 	}
+	if err := h.tokenGen.RevokePlayback(playback.ID); err != nil {
+		slog.Warn("failed to revoke stream tokens of playback session", "error", err)
+	}
+	h.forgetLive(playback)
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// switchProfile moves a playback session to the cache variant of another
+// transcode profile, streamed the way device supports. If the variant isn't
+// cached yet, it is streamed live or transcoded progressively, starting at the
+// current position.
+func (h *PlayerHandler) switchProfile(ctx context.Context, playback *store.PlaybackSession, device *store.SonosDevice, item *abs.LibraryItem, absClient *abs.Client, profile cache.TranscodeProfile) error {
+	job, ok := cacheJobForItem(item, item.GetEpisode(playback.EpisodeID), absClient, h.pathMapper, profile.Name)
+	if !ok {
+		return fmt.Errorf("no audio files")
+	}
+
+	if _, err := h.cacheIndex.InvalidateIfChanged(job); err != nil {
+		slog.Warn("failed to check source files", "cache_key", job.ItemID, "error", err)
+	}
+
+	cached, err := h.cacheIndex.IsCached(job.ItemID)
+	if err != nil {
+		return err
+	}
+	live := false
+	if !cached {
+		if entry, _ := h.cacheIndex.GetEntry(job.ItemID); entry == nil {
+			if err := h.cacheIndex.CreateEntryForJob(job); err != nil {
+				return err
+			}
+		}
+		if live, err = h.prepareUncached(ctx, job, playback.PositionSec); err != nil {
+			return err
+		}
+	}
+
+	entry, err := h.cacheIndex.GetEntry(job.ItemID)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("cache entry not found: %s", job.ItemID)
+	}
+
+	streamMode, segment, segmentStarts := h.streamLayout(device, entry, profile, playback.PositionSec)
+	if live {
+		streamMode, segment, segmentStarts = store.StreamModeLive, 0, nil
+	}
+	if err := h.playbackStore.UpdateProfile(playback.ID, profile.Name, profile.Tempo(), streamMode, segment, segmentStarts); err != nil {
+		return err
+	}
+
+	slog.Info("switched cache profile",
+		"item_id", playback.ItemID,
+		"old_profile", playback.Profile,
+		"new_profile", profile.Name,
+		"stream_mode", streamMode,
+		"segment", segment,
+	)
+
+	playback.Profile = profile.Name
+	playback.Speed = profile.Tempo()
+	playback.StreamMode = streamMode
+	playback.CurrentSegment = segment
+	playback.SegmentStarts = segmentStarts
+	return nil
+}
+
+// cacheJobForItem builds the transcoding job for an item or podcast episode,
+// with all audio files in playback order, for the given transcode profile.
+// Returns false if there are no audio files.
+func cacheJobForItem(item *abs.LibraryItem, episode *abs.PodcastEpisode, absClient *abs.Client, pathMapper PathMapper, profile string) (cache.Job, bool) {
+	var audioFiles []abs.AudioFile
+	var chapters []abs.Chapter
+	var itemMtimeMs int64
+	episodeID := ""
+	if episode != nil {
+		audioFiles = []abs.AudioFile{episode.AudioFile}
+		chapters = episode.Chapters
+		episodeID = episode.ID
+	} else {
+		chapters = item.Media.Chapters
+		itemMtimeMs = item.Mtimems
+		// Sort audio files by index to ensure correct order
+		audioFiles = make([]abs.AudioFile, len(item.Media.AudioFiles))
+		copy(audioFiles, item.Media.AudioFiles)
+		sort.Slice(audioFiles, func(i, j int) bool {
+			return audioFiles[i].Index < audioFiles[j].Index
+		})
+	}
+	if len(audioFiles) == 0 {
+		return cache.Job{}, false
+	}
+
+	job := cache.Job{
+		ItemID:      store.VariantKey(store.CacheKey(item.ID, episodeID), profile),
+		Profile:     profile,
+		SourcePaths: make([]string, 0, len(audioFiles)),
+		RemoteFiles: make([]cache.RemoteFile, 0, len(audioFiles)),
+		ABSClient:   absClient,
+		Chapters:    chapters,
+		Fingerprint: cache.NewFingerprint(itemMtimeMs, audioFiles),
+	}
+	for _, af := range audioFiles {
+		job.SourcePaths = append(job.SourcePaths, pathMapper(af.Metadata.Path))
+		job.RemoteFiles = append(job.RemoteFiles, cache.RemoteFile{ItemID: item.ID, Ino: af.Ino})
+	}
+	return job, true
+}
+
 // buildDIDLMetadata creates DIDL-Lite XML for Sonos.
-func buildDIDLMetadata(item *abs.LibraryItem, streamURL string, mimeType string) string {
+// For podcast episodes, the episode title is shown and the podcast title is used as creator.
+func buildDIDLMetadata(item *abs.LibraryItem, episode *abs.PodcastEpisode, streamURL string, mimeType string) string {
 	title := item.Media.Metadata.Title
 	if title == "" {
 		title = "Audiobook"
 	}
 
-	author := ""
-	if len(item.Media.Metadata.Authors) > 0 {
-		author = item.Media.Metadata.Authors[0].Name
+	author := item.GetAuthorName()
+
+	if episode != nil {
+		author = title
+		title = episode.Title
 	}
 
 	// Minimal DIDL-Lite that Sonos accepts
@@ -1381,6 +1743,14 @@ func buildDIDLMetadata(item *abs.LibraryItem, streamURL string, mimeType string)
 </DIDL-Lite>`, escapeXML(title), escapeXML(author), mimeType, escapeXML(streamURL))
 }
 
+// playerURL returns the player page URL for an item or podcast episode.
+func playerURL(itemID, episodeID string) string {
+	if episodeID != "" {
+		return "/player/" + itemID + "?episode=" + url.QueryEscape(episodeID)
+	}
+	return "/player/" + itemID
+}
+
 // escapeXML escapes special XML characters.
 func escapeXML(s string) string {
 	// Must escape & first, before other entities that contain &
@@ -4,10 +4,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+	"audiobookshelf-sonos-bridge/internal/stream"
 )
 
 func TestHandlePlay_MissingParams(t *testing.T) {
@@ -262,7 +266,7 @@ func TestBuildDIDLMetadata(t *testing.T) {
 		},
 	}
 
-	metadata := buildDIDLMetadata(item, "http://example.com/stream", "audio/mp4")
+	metadata := buildDIDLMetadata(item, nil, "http://example.com/stream", "audio/mp4")
 
 	// Should contain DIDL-Lite namespace
 	if !strings.Contains(metadata, "DIDL-Lite") {
@@ -289,3 +293,89 @@ func TestBuildDIDLMetadata(t *testing.T) {
 		t.Error("expected metadata to contain author")
 	}
 }
+
+func TestBuildDIDLMetadata_Episode(t *testing.T) {
+	item := &abs.LibraryItem{
+		MediaType: "podcast",
+		Media: abs.BookMedia{
+			Metadata: abs.BookMetadata{
+				Title:  "Test Podcast",
+				Author: "Test Host",
+			},
+		},
+	}
+	episode := &abs.PodcastEpisode{ID: "ep-1", Title: "Episode One"}
+
+	metadata := buildDIDLMetadata(item, episode, "http://example.com/stream", "audio/mpeg")
+
+	if !strings.Contains(metadata, "<dc:title>Episode One</dc:title>") {
+		t.Error("expected metadata to contain episode title")
+	}
+	if !strings.Contains(metadata, "<dc:creator>Test Podcast</dc:creator>") {
+		t.Error("expected metadata to contain podcast title as creator")
+	}
+}
+
+func TestPlayerURL(t *testing.T) {
+	if got := playerURL("item-1", ""); got != "/player/item-1" {
+		t.Errorf("expected /player/item-1, got %s", got)
+	}
+	if got := playerURL("item-1", "ep 1"); got != "/player/item-1?episode=ep+1" {
+		t.Errorf("expected /player/item-1?episode=ep+1, got %s", got)
+	}
+}
+
+func TestSupersede_RevokesReplacedPlayback(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tokenGen := stream.NewTokenGenerator("test-secret", time.Hour)
+	tokenGen.SetRevocations(store.NewRevocationStore(db))
+	playbackStore := store.NewPlaybackStore(db)
+	h := &PlayerHandler{playbackStore: playbackStore, tokenGen: tokenGen}
+
+	store.NewSessionStore(db).Create(&store.Session{ID: "session-1", ABSTokenEnc: []byte("token")})
+	store.NewDeviceStore(db).Upsert(&store.SonosDevice{UUID: "uuid:RINCON_123", Name: "Test"})
+
+	var tokens []string
+	var playbacks []*store.PlaybackSession
+	for i, id := range []string{"playback-1", "playback-2"} {
+		token, err := tokenGen.GenerateForPlayback("item-1", "user-1", "session-1", id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		playback := &store.PlaybackSession{
+			ID:          id,
+			SessionID:   "session-1",
+			ItemID:      "item-1",
+			SonosUUID:   "uuid:RINCON_123",
+			StreamToken: token,
+			IsPlaying:   true,
+			StartedAt:   time.Now().Add(time.Duration(i) * time.Second),
+		}
+		if err := playbackStore.Create(playback); err != nil {
+			t.Fatalf("failed to create playback session: %v", err)
+		}
+		tokens = append(tokens, token)
+		playbacks = append(playbacks, playback)
+	}
+
+	// A new playback of the web session replaces the previous one
+	h.supersede(playbacks[0])
+
+	if _, err := tokenGen.Validate(tokens[0]); err != stream.ErrTokenRevoked {
+		t.Errorf("expected token of replaced playback to be rejected, got %v", err)
+	}
+	if _, err := tokenGen.Validate(tokens[1]); err != nil {
+		t.Errorf("expected token of new playback to stay valid, got %v", err)
+	}
+	if old, _ := playbackStore.Get("playback-1"); old != nil {
+		t.Error("expected replaced playback session to be deleted")
+	}
+	if current, _ := playbackStore.GetBySessionID("session-1"); current == nil || current.ID != "playback-2" {
+		t.Errorf("expected new playback session to remain, got %+v", current)
+	}
+}
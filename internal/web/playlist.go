@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// PlayNext starts the playlist entry following a finished playback on the same
+// Sonos device. It returns nil if the playback was the last entry.
+// A running sleep timer is carried over to the next entry.
+func (h *PlayerHandler) PlayNext(ctx context.Context, session *store.Session, absClient *abs.Client, playback *store.PlaybackSession) (*store.PlaybackSession, error) {
+	if playback.PlaylistID == "" {
+		return nil, nil
+	}
+
+	// Load the playlist on every transition so edits made in ABS are respected
+	playlist, err := absClient.GetPlaylist(ctx, playback.PlaylistID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
+	}
+
+	next := playlist.Next(playback.ItemID, playback.EpisodeID)
+	if next == nil {
+		slog.Info("playlist finished",
+			"playlist_id", playback.PlaylistID,
+			"item_id", playback.ItemID,
+			"episode_id", playback.EpisodeID,
+		)
+		return nil, nil
+	}
+
+	slog.Info("playing next playlist item",
+		"playlist_id", playback.PlaylistID,
+		"item_id", next.LibraryItemID,
+		"episode_id", next.EpisodeID,
+		"sonos_uuid", playback.SonosUUID,
+	)
+
+	nextPlayback, err := h.startPlayback(ctx, session, absClient, next.LibraryItemID, next.EpisodeID, playback.SonosUUID, playback.PlaylistID)
+	if err != nil {
+		return nil, err
+	}
+
+	if playback.SleepAt != nil && playback.SleepAt.After(nextPlayback.StartedAt) {
+		if err := h.playbackStore.SetSleepTimer(nextPlayback.ID, *playback.SleepAt); err != nil {
+			slog.Warn("failed to carry over sleep timer", "playback_id", nextPlayback.ID, "error", err)
+		}
+	}
+
+	return nextPlayback, nil
+}
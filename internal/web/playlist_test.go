@@ -0,0 +1,62 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestPlayNext_NoNextItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/playlists/playlist-1" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"playlist-1","items":[{"libraryItemId":"item-1"},{"libraryItemId":"item-2"}]}`))
+	}))
+	defer server.Close()
+
+	h := &PlayerHandler{}
+	client := abs.NewClient(server.URL).WithToken("test-token")
+	session := &store.Session{ID: "session-1"}
+
+	tests := []struct {
+		name     string
+		playback *store.PlaybackSession
+	}{
+		{"not part of a playlist", &store.PlaybackSession{ItemID: "item-1"}},
+		{"last playlist entry", &store.PlaybackSession{ItemID: "item-2", PlaylistID: "playlist-1"}},
+		{"removed from playlist", &store.PlaybackSession{ItemID: "item-3", PlaylistID: "playlist-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := h.PlayNext(context.Background(), session, client, tt.playback)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if next != nil {
+				t.Errorf("expected no next playback, got %+v", next)
+			}
+		})
+	}
+}
+
+func TestPlayNext_PlaylistError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	h := &PlayerHandler{}
+	client := abs.NewClient(server.URL).WithToken("test-token")
+	playback := &store.PlaybackSession{ItemID: "item-1", PlaylistID: "deleted"}
+
+	if _, err := h.PlayNext(context.Background(), &store.Session{}, client, playback); err == nil {
+		t.Error("expected error for missing playlist")
+	}
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
@@ -15,12 +16,24 @@ type TokenDecrypter interface {
 	DecryptToken(encrypted []byte) (string, error)
 }
 
+// PlaylistPlayer starts the next playlist entry after an item has finished.
+type PlaylistPlayer interface {
+	PlayNext(ctx context.Context, session *store.Session, absClient *abs.Client, playback *store.PlaybackSession) (*store.PlaybackSession, error)
+}
+
+// playlistEndSlack is how close to the item duration a playback must end to
+// count as finished, closing its ABS session and advancing its playlist.
+const playlistEndSlack = 5
+
 // ProgressSyncer handles background progress synchronization.
 type ProgressSyncer struct {
 	absClient     *abs.Client
 	playbackStore *store.PlaybackStore
 	sessionStore  *store.SessionStore
 	deviceStore   *store.DeviceStore
+	listening     *ListeningTracker
+	remote        *RemoteProgressWatcher
+	playlists     PlaylistPlayer
 	tokenDecrypt  TokenDecrypter
 	pollInterval  time.Duration
 	syncInterval  time.Duration
@@ -33,6 +46,9 @@ func NewProgressSyncer(
 	playbackStore *store.PlaybackStore,
 	sessionStore *store.SessionStore,
 	deviceStore *store.DeviceStore,
+	listening *ListeningTracker,
+	remote *RemoteProgressWatcher,
+	playlists PlaylistPlayer,
 	tokenDecrypt TokenDecrypter,
 ) *ProgressSyncer {
 	return &ProgressSyncer{
@@ -40,6 +56,9 @@ func NewProgressSyncer(
 		playbackStore: playbackStore,
 		sessionStore:  sessionStore,
 		deviceStore:   deviceStore,
+		listening:     listening,
+		remote:        remote,
+		playlists:     playlists,
 		tokenDecrypt:  tokenDecrypt,
 		pollInterval:  5 * time.Second,
 		syncInterval:  30 * time.Second,
@@ -50,6 +69,7 @@ func NewProgressSyncer(
 func (s *ProgressSyncer) Start(ctx context.Context) {
 	ctx, s.cancel = context.WithCancel(ctx)
 
+	go s.closeOrphaned(ctx)
 	go s.pollLoop(ctx)
 	go s.syncLoop(ctx)
 
@@ -133,9 +153,9 @@ func (s *ProgressSyncer) pollSession(ctx context.Context, playback *store.Playba
 		return
 	}
 
-	// Parse position
+	// Parse position and map it to the item's timeline
 	relTime := sonos.ParseDuration(posInfo.RelTime)
-	positionSec := int(relTime.Seconds())
+	positionSec := playback.GlobalPosition(int(relTime.Seconds()))
 
 	// Check if playback has ended
 	trackDuration := sonos.ParseDuration(posInfo.TrackDuration)
@@ -146,15 +166,118 @@ func (s *ProgressSyncer) pollSession(ctx context.Context, playback *store.Playba
 			"item_id", playback.ItemID,
 		)
 		s.playbackStore.UpdatePlaying(playback.ID, false)
+
+		// The end of a chapter or segment only finishes the item if it is
+		// the last one; listening continues in the same ABS session
+		if positionSec < playback.DurationSec-playlistEndSlack {
+			return
+		}
+
+		if playback.PlaylistID != "" {
+			playback.PositionSec = playback.DurationSec
+			s.playbackStore.UpdatePosition(playback.ID, playback.PositionSec)
+			s.finishPlaylistItem(ctx, playback)
+			return
+		}
+
+		s.finishSession(ctx, playback)
 		return
 	}
 
+	// Count listening time for the ABS playback session
+	s.listening.Tick(playback, positionSec)
+
 	// Update stored position
 	if positionSec != playback.PositionSec {
 		s.playbackStore.UpdatePosition(playback.ID, positionSec)
 	}
 }
 
+// finishSession syncs the final progress and closes the ABS playback session
+// of a playback that has reached the end.
+func (s *ProgressSyncer) finishSession(ctx context.Context, playback *store.PlaybackSession) {
+	session, err := s.sessionStore.Get(playback.SessionID)
+	if err != nil || session == nil {
+		slog.Warn("session not found for finished playback", "session_id", playback.SessionID, "error", err)
+		return
+	}
+
+	client, err := s.clientForSession(playback.SessionID)
+	if err != nil {
+		slog.Warn("failed to close ABS playback session", "session_id", playback.SessionID, "error", err)
+		return
+	}
+
+	writeProgress(ctx, s.listening, s.remote, client, session.UserID, playback, progressClose)
+}
+
+// finishPlaylistItem marks a finished playlist item as finished in ABS and
+// starts the next playlist entry on the same speaker.
+func (s *ProgressSyncer) finishPlaylistItem(ctx context.Context, playback *store.PlaybackSession) {
+	session, err := s.sessionStore.Get(playback.SessionID)
+	if err != nil || session == nil {
+		slog.Warn("session not found for playlist playback", "session_id", playback.SessionID, "error", err)
+		return
+	}
+
+	client, err := s.clientForSession(playback.SessionID)
+	if err != nil {
+		slog.Warn("failed to finish playlist item", "session_id", playback.SessionID, "error", err)
+		return
+	}
+
+	writeProgress(ctx, s.listening, s.remote, client, session.UserID, playback, progressFinish)
+
+	// Starting the next item may have to transcode it first
+	go func() {
+		if _, err := s.playlists.PlayNext(ctx, session, client, playback); err != nil {
+			slog.Error("failed to play next playlist item",
+				"playlist_id", playback.PlaylistID,
+				"item_id", playback.ItemID,
+				"error", err,
+			)
+		}
+	}()
+}
+
+// closeOrphaned closes ABS playback sessions whose playback session was deleted,
+// e.g. by the startup cleanup of stale playback sessions.
+func (s *ProgressSyncer) closeOrphaned(ctx context.Context) {
+	sessions, err := s.listening.listeningStore.ListOrphaned()
+	if err != nil {
+		slog.Error("failed to list orphaned ABS playback sessions", "error", err)
+		return
+	}
+
+	for _, ls := range sessions {
+		client, err := s.clientForSession(ls.SessionID)
+		if err != nil {
+			slog.Warn("failed to close orphaned ABS playback session", "abs_session_id", ls.ID, "error", err)
+			s.listening.listeningStore.Delete(ls.ID)
+			continue
+		}
+		s.listening.CloseStored(ctx, client, ls)
+	}
+}
+
+// clientForSession returns an ABS client with the token of a web session.
+func (s *ProgressSyncer) clientForSession(sessionID string) (*abs.Client, error) {
+	session, err := s.sessionStore.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	token, err := s.tokenDecrypt.DecryptToken(session.ABSTokenEnc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	return s.absClient.WithToken(token), nil
+}
+
 // syncAllActive syncs progress for all active sessions to Audiobookshelf.
 func (s *ProgressSyncer) syncAllActive(ctx context.Context) {
 	sessions, err := s.playbackStore.ListActive()
@@ -187,36 +310,10 @@ func (s *ProgressSyncer) syncSession(ctx context.Context, playback *store.Playba
 	// Create client with user's token
 	client := s.absClient.WithToken(token)
 
-	// Build progress update
-	progress := float64(0)
-	if playback.DurationSec > 0 {
-		progress = float64(playback.PositionSec) / float64(playback.DurationSec)
-	}
-
-	update := abs.ProgressUpdate{
-		CurrentTime: float64(playback.PositionSec),
-		Duration:    float64(playback.DurationSec),
-		Progress:    progress,
-	}
-
-	// Sync to ABS
-	if err := client.UpdateProgress(ctx, playback.ItemID, update); err != nil {
-		slog.Warn("failed to sync progress",
-			"session_id", playback.SessionID,
-			"item_id", playback.ItemID,
-			"error", err,
-		)
-		return
+	// Don't overwrite newer progress made on another device until the user decides
+	if writeProgress(ctx, s.listening, s.remote, client, session.UserID, playback, progressSync) {
+		s.playbackStore.UpdateABSSyncTime(playback.ID)
 	}
-
-	// Update sync timestamp
-	s.playbackStore.UpdateABSSyncTime(playback.ID)
-
-	slog.Debug("synced progress to ABS",
-		"item_id", playback.ItemID,
-		"position_sec", playback.PositionSec,
-		"progress", progress,
-	)
 }
 
 // SyncNow forces an immediate sync for a specific session.
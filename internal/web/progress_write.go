@@ -0,0 +1,83 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// progressWrite is how a playback's progress is written to ABS.
+type progressWrite int
+
+const (
+	progressSync   progressWrite = iota // Sync the position, the ABS session stays open
+	progressClose                       // Sync the final position and close the ABS session
+	progressFinish                      // Close the ABS session and mark the item finished
+)
+
+// writeProgress writes the position of a playback to ABS through its ABS
+// playback session, or as a plain progress update if it has none. Nothing is
+// written while the item was played further on another device since the
+// bridge last synced, so that progress isn't overwritten until the user
+// decides. Returns true if the progress was written.
+func writeProgress(ctx context.Context, listening *ListeningTracker, remote *RemoteProgressWatcher, client *abs.Client, userID string, playback *store.PlaybackSession, write progressWrite) bool {
+	if remote != nil {
+		if rp := remote.Ahead(userID, playback, listening.SessionID(playback.ID)); rp != nil {
+			slog.Warn("skipping progress sync, item was played further on another device",
+				"session_id", playback.SessionID,
+				"item_id", playback.ItemID,
+				"position_sec", playback.PositionSec,
+				"remote_position_sec", int(rp.CurrentTime),
+				"device", rp.DeviceDescription,
+			)
+			if write != progressSync {
+				// Closing would sync the older position as well
+				listening.Discard(playback)
+			}
+			return false
+		}
+	}
+
+	var synced bool
+	if write == progressSync {
+		synced = listening.Sync(ctx, client, playback)
+	} else {
+		synced = listening.Close(ctx, client, playback)
+	}
+	if synced && write != progressFinish {
+		return true
+	}
+
+	update := abs.ProgressUpdate{
+		CurrentTime: float64(playback.PositionSec),
+		Duration:    float64(playback.DurationSec),
+	}
+	if playback.DurationSec > 0 {
+		update.Progress = float64(playback.PositionSec) / float64(playback.DurationSec)
+	}
+	if write == progressFinish {
+		update.CurrentTime = float64(playback.DurationSec)
+		update.Progress = 1
+		update.IsFinished = true
+	}
+
+	if err := client.UpdateEpisodeProgress(ctx, playback.ItemID, playback.EpisodeID, update); err != nil {
+		slog.Warn("failed to sync progress to ABS",
+			"session_id", playback.SessionID,
+			"item_id", playback.ItemID,
+			"episode_id", playback.EpisodeID,
+			"error", err,
+		)
+		return false
+	}
+
+	slog.Debug("synced progress to ABS",
+		"item_id", playback.ItemID,
+		"episode_id", playback.EpisodeID,
+		"position_sec", playback.PositionSec,
+		"progress", update.Progress,
+	)
+	return true
+}
@@ -0,0 +1,49 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func TestWriteProgress_RemoteAhead(t *testing.T) {
+	tracker, listeningStore, client, fake, cleanup := setupListeningTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	playback := &store.PlaybackSession{
+		ID:          "playback-1",
+		SessionID:   "session-1",
+		ItemID:      "item-1",
+		PositionSec: 600,
+		DurationSec: 3600,
+		StartedAt:   time.Now().Add(-10 * time.Minute),
+	}
+	tracker.Open(ctx, client, playback)
+
+	remote := NewRemoteProgressWatcher(nil, nil, nil)
+	remote.update("user-1", progressEvent("mobile", 1200, time.Now()))
+
+	// Stopping must not close the ABS session at the older Sonos position
+	if writeProgress(ctx, tracker, remote, client, "user-1", playback, progressClose) {
+		t.Error("expected no progress to be written while another device is ahead")
+	}
+	if len(fake.closed) != 0 || len(fake.syncs["abs-session-1"]) != 0 {
+		t.Errorf("expected no sync or close, got syncs %v, closed %v", fake.syncs, fake.closed)
+	}
+	if ls, _ := listeningStore.GetByPlaybackID("playback-1"); ls != nil {
+		t.Error("expected the ABS session to be discarded")
+	}
+
+	// Once the user keeps the Sonos position, progress is written again
+	tracker.Open(ctx, client, playback)
+	remote.Dismiss("user-1", "item-1", "")
+	if !writeProgress(ctx, tracker, remote, client, "user-1", playback, progressClose) {
+		t.Error("expected progress to be written after dismissing the remote progress")
+	}
+	if len(fake.closed) != 1 {
+		t.Errorf("expected the ABS session to be closed, got %v", fake.closed)
+	}
+}
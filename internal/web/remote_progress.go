@@ -0,0 +1,277 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+const (
+	// remoteProgressAheadSec is how far remote progress must be ahead of the
+	// Sonos position to count as listened elsewhere.
+	remoteProgressAheadSec = 30
+	// remoteProgressSlack covers the bridge's own updates arriving around the
+	// time of its last sync.
+	remoteProgressSlack = 5 * time.Second
+)
+
+// RemoteProgress is the latest progress of an item as reported by ABS.
+type RemoteProgress struct {
+	ItemID            string
+	EpisodeID         string
+	CurrentTime       float64
+	Duration          float64
+	IsFinished        bool
+	SessionID         string // ABS playback session that made the update
+	DeviceDescription string
+	UpdatedAt         time.Time
+	Dismissed         bool // User chose to keep the Sonos position
+}
+
+// RemoteProgressWatcher keeps a per-user view of ABS progress current by
+// subscribing to the ABS socket of every user with an active session.
+// It detects progress made on other devices while a Sonos session runs.
+type RemoteProgressWatcher struct {
+	absClient       *abs.Client
+	sessionStore    *store.SessionStore
+	tokenDecrypt    TokenDecrypter
+	refreshInterval time.Duration
+	retryDelay      time.Duration
+	mu              sync.RWMutex
+	subscribers     map[string]context.CancelFunc         // by ABS user ID
+	progress        map[string]map[string]*RemoteProgress // by ABS user ID and cache key
+	cancel          context.CancelFunc
+}
+
+// NewRemoteProgressWatcher creates a new remote progress watcher.
+func NewRemoteProgressWatcher(
+	absClient *abs.Client,
+	sessionStore *store.SessionStore,
+	tokenDecrypt TokenDecrypter,
+) *RemoteProgressWatcher {
+	return &RemoteProgressWatcher{
+		absClient:       absClient,
+		sessionStore:    sessionStore,
+		tokenDecrypt:    tokenDecrypt,
+		refreshInterval: time.Minute,
+		retryDelay:      10 * time.Second,
+		subscribers:     make(map[string]context.CancelFunc),
+		progress:        make(map[string]map[string]*RemoteProgress),
+	}
+}
+
+// Start begins watching the sockets of all active users.
+func (w *RemoteProgressWatcher) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	go w.refreshLoop(ctx)
+
+	slog.Info("remote progress watcher started", "refresh_interval", w.refreshInterval)
+}
+
+// Stop closes all socket subscriptions.
+func (w *RemoteProgressWatcher) Stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	slog.Info("remote progress watcher stopped")
+}
+
+// refreshLoop periodically matches the subscriptions to the active users.
+func (w *RemoteProgressWatcher) refreshLoop(ctx context.Context) {
+	w.refresh(ctx)
+
+	ticker := time.NewTicker(w.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.refresh(ctx)
+		}
+	}
+}
+
+// refresh subscribes new active users and drops users without an active session.
+func (w *RemoteProgressWatcher) refresh(ctx context.Context) {
+	sessions, err := w.sessionStore.ListActive()
+	if err != nil {
+		slog.Error("failed to list active sessions", "error", err)
+		return
+	}
+
+	// Sessions are ordered by last use, so the first one per user has the freshest token
+	active := make(map[string]*store.Session)
+	for _, session := range sessions {
+		if _, ok := active[session.UserID]; !ok {
+			active[session.UserID] = session
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for userID, cancel := range w.subscribers {
+		if _, ok := active[userID]; !ok {
+			cancel()
+			delete(w.subscribers, userID)
+			delete(w.progress, userID)
+		}
+	}
+
+	for userID, session := range active {
+		if _, ok := w.subscribers[userID]; ok {
+			continue
+		}
+
+		token, err := w.tokenDecrypt.DecryptToken(session.ABSTokenEnc)
+		if err != nil {
+			slog.Warn("failed to decrypt token for socket", "user_id", userID, "error", err)
+			continue
+		}
+
+		subCtx, cancel := context.WithCancel(ctx)
+		w.subscribers[userID] = cancel
+		go w.subscribe(subCtx, userID, w.absClient.WithToken(token))
+	}
+}
+
+// subscribe keeps a socket subscription for a user open until the context is cancelled.
+func (w *RemoteProgressWatcher) subscribe(ctx context.Context, userID string, client *abs.Client) {
+	for {
+		// Load the current state, the socket only delivers changes
+		if progress, err := client.GetMediaProgress(ctx); err == nil {
+			for _, p := range progress {
+				w.update(userID, abs.ProgressUpdatedEvent{Data: p})
+			}
+		}
+
+		subscriber := client.Subscribe()
+		subscriber.OnProgressUpdated(func(event abs.ProgressUpdatedEvent) {
+			w.update(userID, event)
+		})
+
+		slog.Debug("subscribing to ABS socket", "user_id", userID)
+		err := subscriber.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, abs.ErrUnauthorized) {
+			// Drop the subscription, the next refresh retries with a fresher session
+			slog.Warn("ABS socket rejected token", "user_id", userID)
+			w.mu.Lock()
+			delete(w.subscribers, userID)
+			w.mu.Unlock()
+			return
+		}
+
+		slog.Debug("ABS socket disconnected, reconnecting", "user_id", userID, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.retryDelay):
+		}
+	}
+}
+
+// update stores a progress update unless a newer one is already known.
+func (w *RemoteProgressWatcher) update(userID string, event abs.ProgressUpdatedEvent) {
+	p := event.Data
+	if p.LibraryItemID == "" {
+		return
+	}
+
+	rp := &RemoteProgress{
+		ItemID:            p.LibraryItemID,
+		EpisodeID:         p.EpisodeID,
+		CurrentTime:       p.CurrentTime,
+		Duration:          p.Duration,
+		IsFinished:        p.IsFinished,
+		SessionID:         event.SessionID,
+		DeviceDescription: event.DeviceDescription,
+		UpdatedAt:         time.UnixMilli(p.LastUpdate),
+	}
+	key := store.CacheKey(p.LibraryItemID, p.EpisodeID)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	userProgress := w.progress[userID]
+	if userProgress == nil {
+		userProgress = make(map[string]*RemoteProgress)
+		w.progress[userID] = userProgress
+	}
+	if existing := userProgress[key]; existing != nil && existing.UpdatedAt.After(rp.UpdatedAt) {
+		return
+	}
+	userProgress[key] = rp
+
+	if event.SessionID != "" {
+		slog.Debug("remote progress updated",
+			"user_id", userID,
+			"item_id", rp.ItemID,
+			"episode_id", rp.EpisodeID,
+			"current_time", rp.CurrentTime,
+			"device", rp.DeviceDescription,
+		)
+	}
+}
+
+// Get returns the latest known progress of an item for a user, or nil.
+func (w *RemoteProgressWatcher) Get(userID, itemID, episodeID string) *RemoteProgress {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	rp := w.progress[userID][store.CacheKey(itemID, episodeID)]
+	if rp == nil {
+		return nil
+	}
+	copied := *rp
+	return &copied
+}
+
+// Ahead returns the remote progress of the playback's item if it was made on
+// another device after the bridge last synced and is ahead of the Sonos
+// position. ownSessionID is the ABS session of the playback, whose updates
+// are never counted.
+func (w *RemoteProgressWatcher) Ahead(userID string, playback *store.PlaybackSession, ownSessionID string) *RemoteProgress {
+	rp := w.Get(userID, playback.ItemID, playback.EpisodeID)
+	if rp == nil || rp.Dismissed {
+		return nil
+	}
+	if ownSessionID != "" && rp.SessionID == ownSessionID {
+		return nil
+	}
+
+	since := playback.StartedAt
+	if playback.ABSProgressSyncedAt.After(since) {
+		since = playback.ABSProgressSyncedAt
+	}
+	if !rp.UpdatedAt.After(since.Add(remoteProgressSlack)) {
+		return nil
+	}
+
+	if rp.CurrentTime < float64(playback.PositionSec+remoteProgressAheadSec) {
+		return nil
+	}
+	return rp
+}
+
+// Dismiss keeps the Sonos position for an item; the known remote progress is
+// ignored until a newer update arrives.
+func (w *RemoteProgressWatcher) Dismiss(userID, itemID, episodeID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if rp := w.progress[userID][store.CacheKey(itemID, episodeID)]; rp != nil {
+		rp.Dismissed = true
+	}
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/abs"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+func progressEvent(sessionID string, currentTime float64, updatedAt time.Time) abs.ProgressUpdatedEvent {
+	return abs.ProgressUpdatedEvent{
+		SessionID:         sessionID,
+		DeviceDescription: "iPhone",
+		Data: abs.Progress{
+			LibraryItemID: "item-1",
+			CurrentTime:   currentTime,
+			LastUpdate:    updatedAt.UnixMilli(),
+		},
+	}
+}
+
+func TestRemoteProgressWatcher_Ahead(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	playback := &store.PlaybackSession{
+		ID:          "playback-1",
+		ItemID:      "item-1",
+		PositionSec: 600,
+		StartedAt:   startedAt,
+	}
+
+	tests := []struct {
+		name  string
+		event abs.ProgressUpdatedEvent
+		ahead bool
+	}{
+		{"played further on another device", progressEvent("mobile", 1200, time.Now()), true},
+		{"own ABS session", progressEvent("own-session", 1200, time.Now()), false},
+		{"older than playback start", progressEvent("mobile", 1200, startedAt.Add(-time.Minute)), false},
+		{"behind Sonos position", progressEvent("mobile", 300, time.Now()), false},
+		{"within threshold", progressEvent("mobile", 620, time.Now()), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewRemoteProgressWatcher(nil, nil, nil)
+			w.update("user-1", tt.event)
+
+			rp := w.Ahead("user-1", playback, "own-session")
+			if (rp != nil) != tt.ahead {
+				t.Errorf("expected ahead=%v, got %v", tt.ahead, rp)
+			}
+		})
+	}
+}
+
+func TestRemoteProgressWatcher_Dismiss(t *testing.T) {
+	playback := &store.PlaybackSession{
+		ID:          "playback-1",
+		ItemID:      "item-1",
+		PositionSec: 600,
+		StartedAt:   time.Now().Add(-10 * time.Minute),
+	}
+
+	w := NewRemoteProgressWatcher(nil, nil, nil)
+	w.update("user-1", progressEvent("mobile", 1200, time.Now().Add(-time.Second)))
+
+	if w.Ahead("user-1", playback, "") == nil {
+		t.Fatal("expected remote progress to be ahead")
+	}
+
+	w.Dismiss("user-1", "item-1", "")
+	if w.Ahead("user-1", playback, "") != nil {
+		t.Error("expected dismissed remote progress to be ignored")
+	}
+
+	// A newer update is shown again
+	w.update("user-1", progressEvent("mobile", 1500, time.Now()))
+	if rp := w.Ahead("user-1", playback, ""); rp == nil || rp.CurrentTime != 1500 {
+		t.Errorf("expected newer remote progress, got %v", rp)
+	}
+
+	// Older updates don't replace newer ones
+	w.update("user-1", progressEvent("mobile", 900, time.Now().Add(-time.Minute)))
+	if rp := w.Get("user-1", "item-1", ""); rp == nil || rp.CurrentTime != 1500 {
+		t.Errorf("expected newest progress to be kept, got %v", rp)
+	}
+
+	// Progress is tracked per user
+	if w.Get("user-2", "item-1", "") != nil {
+		t.Error("expected no progress for other user")
+	}
+}
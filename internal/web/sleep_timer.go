@@ -15,6 +15,8 @@ type SleepTimerWorker struct {
 	playbackStore *store.PlaybackStore
 	sessionStore  *store.SessionStore
 	deviceStore   *store.DeviceStore
+	listening     *ListeningTracker
+	remote        *RemoteProgressWatcher
 	absClient     *abs.Client
 	tokenDecrypt  TokenDecrypter
 	checkInterval time.Duration
@@ -26,6 +28,8 @@ func NewSleepTimerWorker(
 	playbackStore *store.PlaybackStore,
 	sessionStore *store.SessionStore,
 	deviceStore *store.DeviceStore,
+	listening *ListeningTracker,
+	remote *RemoteProgressWatcher,
 	absClient *abs.Client,
 	tokenDecrypt TokenDecrypter,
 ) *SleepTimerWorker {
@@ -33,6 +37,8 @@ func NewSleepTimerWorker(
 		playbackStore: playbackStore,
 		sessionStore:  sessionStore,
 		deviceStore:   deviceStore,
+		listening:     listening,
+		remote:        remote,
 		absClient:     absClient,
 		tokenDecrypt:  tokenDecrypt,
 		checkInterval: 10 * time.Second,
@@ -119,7 +125,7 @@ func (w *SleepTimerWorker) triggerSleepTimer(ctx context.Context, session *store
 	} else {
 		// Update position in database
 		relTime := sonos.ParseDuration(posInfo.RelTime)
-		positionSec := int(relTime.Seconds())
+		positionSec := session.GlobalPosition(int(relTime.Seconds()))
 		if err := w.playbackStore.UpdatePosition(session.ID, positionSec); err != nil {
 			slog.Warn("failed to update position before sleep pause",
 				"session_id", session.SessionID,
@@ -127,6 +133,9 @@ func (w *SleepTimerWorker) triggerSleepTimer(ctx context.Context, session *store
 			)
 		}
 		session.PositionSec = positionSec
+
+		// Count listening time up to the pause
+		w.listening.Tick(session, positionSec)
 	}
 
 	// Pause playback on Sonos
@@ -187,34 +196,7 @@ func (w *SleepTimerWorker) syncProgressToABS(ctx context.Context, session *store
 	// Create client with user's token
 	client := w.absClient.WithToken(token)
 
-	// Build progress update
-	progress := float64(0)
-	if session.DurationSec > 0 {
-		progress = float64(session.PositionSec) / float64(session.DurationSec)
-	}
-
-	update := abs.ProgressUpdate{
-		CurrentTime: float64(session.PositionSec),
-		Duration:    float64(session.DurationSec),
-		Progress:    progress,
+	if writeProgress(ctx, w.listening, w.remote, client, userSession.UserID, session, progressSync) {
+		w.playbackStore.UpdateABSSyncTime(session.ID)
 	}
-
-	// Sync to ABS
-	if err := client.UpdateProgress(ctx, session.ItemID, update); err != nil {
-		slog.Warn("failed to sync progress to ABS after sleep timer",
-			"session_id", session.SessionID,
-			"item_id", session.ItemID,
-			"error", err,
-		)
-		return
-	}
-
-	// Update sync timestamp
-	w.playbackStore.UpdateABSSyncTime(session.ID)
-
-	slog.Debug("synced progress to ABS after sleep timer",
-		"item_id", session.ItemID,
-		"position_sec", session.PositionSec,
-		"progress", progress,
-	)
 }
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/cache"
+	"audiobookshelf-sonos-bridge/internal/sonos"
+)
+
+// SpeedOption is a playback speed choice on the player page.
+type SpeedOption struct {
+	Value string
+	Label string
+}
+
+// speedOptions returns the playback speed choices, starting with normal speed.
+func speedOptions() []SpeedOption {
+	options := []SpeedOption{{Value: speedValue(1), Label: speedLabel(1)}}
+	for _, speed := range cache.PlaybackSpeeds {
+		options = append(options, SpeedOption{Value: speedValue(speed), Label: speedLabel(speed)})
+	}
+	return options
+}
+
+// speedValue formats a speed as form value, e.g. "1.5".
+func speedValue(speed float64) string {
+	if speed <= 0 {
+		speed = 1
+	}
+	return strconv.FormatFloat(speed, 'f', -1, 64)
+}
+
+// speedLabel formats a speed for display, e.g. "1,5×".
+func speedLabel(speed float64) string {
+	return strings.ReplaceAll(speedValue(speed), ".", ",") + "×"
+}
+
+// HandleSetSpeed handles POST /transport/speed requests.
+// Sonos players can't change the playback rate, so the session switches to
+// the cache variant transcoded at the new speed and continues at the same
+// position in the item. Variants that aren't cached yet are transcoded
+// progressively, starting at the current position.
+func (h *PlayerHandler) HandleSetSpeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := GetSession(r.Context())
+	if session == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	speed, ok := cache.ParseSpeed(r.FormValue("speed"))
+	if !ok {
+		http.Error(w, "invalid speed", http.StatusBadRequest)
+		return
+	}
+
+	playback, err := h.playbackStore.GetBySessionID(session.ID)
+	if err != nil || playback == nil {
+		http.Error(w, "no active playback", http.StatusNotFound)
+		return
+	}
+	if playback.Speed == speed {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	device, err := h.sonosStore.Get(playback.SonosUUID)
+	if err != nil || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Get coordinator IP for group support
+	targetIP := h.getCoordinatorIP(ctx, device.IPAddress)
+	avt := sonos.NewAVTransport(targetIP)
+
+	// Position in the item, read at the old speed
+	if positionSec, err := h.currentPosition(ctx, avt, playback); err == nil {
+		playback.PositionSec = positionSec
+	}
+
+	absClient, err := h.authHandler.GetABSClientForSession(session)
+	if err != nil {
+		http.Error(w, "session error", http.StatusInternalServerError)
+		return
+	}
+	item, err := absClient.GetItem(ctx, playback.ItemID)
+	if err != nil {
+		http.Error(w, "failed to get item", http.StatusInternalServerError)
+		return
+	}
+
+	oldSpeed := playback.Speed
+	profile := h.profileFor(device, playback.ItemID, speed)
+	if err := h.switchProfile(ctx, playback, device, item, absClient, profile); err != nil {
+		slog.Error("failed to prepare cache for speed change", "speed", speed, "profile", profile.Name, "error", err)
+		http.Error(w, "transcoding failed", http.StatusInternalServerError)
+		return
+	}
+
+	cacheEntry, err := h.cacheIndex.GetEntry(playback.CacheKey())
+	if err != nil || cacheEntry == nil {
+		slog.Error("cache entry not found for speed change", "cache_key", playback.CacheKey(), "error", err)
+		http.Error(w, "cache entry not found", http.StatusNotFound)
+		return
+	}
+
+	// Stream tokens are bound to the cache variant
+	token, err := h.tokenGen.GenerateForPlayback(playback.CacheKey(), session.UserID, session.ID, playback.ID, targetIP)
+	if err != nil {
+		slog.Error("failed to generate stream token", "error", err)
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	// Continue at the same position in the item, on the new variant's timeline
+	segment, localSeekPos := h.streamStart(playback, playback.PositionSec)
+	streamURL := h.streamURL(token, playback, cacheEntry, segment)
+
+	mimeType := streamContentType(playback, cacheEntry)
+	metadata := buildDIDLMetadata(item, item.GetEpisode(playback.EpisodeID), streamURL, mimeType)
+
+	if err := avt.SetAVTransportURI(ctx, streamURL, metadata); err != nil {
+		slog.Error("failed to set transport URI for speed change", "error", err)
+		http.Error(w, "failed to set URI on Sonos", http.StatusInternalServerError)
+		return
+	}
+	if err := avt.Play(ctx); err != nil {
+		slog.Error("failed to start playback after speed change", "error", err)
+		http.Error(w, "failed to start playback", http.StatusInternalServerError)
+		return
+	}
+	if localSeekPos > 0 {
+		time.Sleep(500 * time.Millisecond)
+		if err := avt.Seek(ctx, time.Duration(localSeekPos)*time.Second); err != nil {
+			slog.Warn("failed to seek after speed change", "error", err)
+		}
+	}
+
+	if err := h.playbackStore.UpdateStreamToken(playback.ID, token); err != nil {
+		slog.Warn("failed to update stream token in database", "error", err)
+	}
+	h.playbackStore.UpdatePosition(playback.ID, playback.PositionSec)
+	h.playbackStore.UpdatePlaying(playback.ID, true)
+
+	slog.Info("playback speed changed",
+		"item_id", playback.ItemID,
+		"old_speed", oldSpeed,
+		"new_speed", speed,
+		"profile", profile.Name,
+		"position_sec", playback.PositionSec,
+		"segment", segment,
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,221 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/sonos"
+	"audiobookshelf-sonos-bridge/internal/store"
+)
+
+// maxTokenRenewalMargin is how long before expiry the stream token of a
+// playback is renewed at most. Shorter TTLs renew after three quarters of
+// the token's lifetime.
+const maxTokenRenewalMargin = 10 * time.Minute
+
+// revocationPruneInterval is how often revocations of expired stream tokens
+// are removed.
+const revocationPruneInterval = time.Hour
+
+// tokenRenewalMargin returns how long before expiry a stream token with the
+// given TTL is renewed.
+func tokenRenewalMargin(ttl time.Duration) time.Duration {
+	return min(ttl/4, maxTokenRenewalMargin)
+}
+
+// StreamTokenRenewer renews the stream tokens of playing sessions that
+// outlive the token TTL. Speakers request the stream again while buffering,
+// so the URI they play must always carry a valid token. Paused sessions are
+// renewed when they are resumed. Revocations of expired tokens are pruned
+// along the way.
+type StreamTokenRenewer struct {
+	player        *PlayerHandler
+	checkInterval time.Duration
+	cancel        context.CancelFunc
+}
+
+// NewStreamTokenRenewer creates a new stream token renewer.
+func NewStreamTokenRenewer(player *PlayerHandler) *StreamTokenRenewer {
+	return &StreamTokenRenewer{
+		player:        player,
+		checkInterval: min(time.Minute, tokenRenewalMargin(player.tokenGen.TTL())/2),
+	}
+}
+
+// Start begins the background renewal process.
+func (r *StreamTokenRenewer) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	go r.checkLoop(ctx)
+
+	slog.Info("stream token renewer started", "check_interval", r.checkInterval, "token_ttl", r.player.tokenGen.TTL())
+}
+
+// Stop stops the background renewal process.
+func (r *StreamTokenRenewer) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	slog.Info("stream token renewer stopped")
+}
+
+// checkLoop periodically renews expiring stream tokens and prunes
+// revocations.
+func (r *StreamTokenRenewer) checkLoop(ctx context.Context) {
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(revocationPruneInterval)
+	defer pruneTicker.Stop()
+
+	r.pruneRevocations()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.renewExpiring(ctx)
+		case <-pruneTicker.C:
+			r.pruneRevocations()
+		}
+	}
+}
+
+// pruneRevocations removes revocations that only apply to expired tokens.
+func (r *StreamTokenRenewer) pruneRevocations() {
+	if pruned, err := r.player.tokenGen.PruneRevocations(); err != nil {
+		slog.Warn("failed to prune stream token revocations", "error", err)
+	} else if pruned > 0 {
+		slog.Info("pruned expired stream token revocations", "count", pruned)
+	}
+}
+
+// renewExpiring renews the tokens of playing sessions that expire soon.
+func (r *StreamTokenRenewer) renewExpiring(ctx context.Context) {
+	sessions, err := r.player.playbackStore.ListActive()
+	if err != nil {
+		slog.Error("failed to list active sessions", "error", err)
+		return
+	}
+
+	for _, playback := range currentPlaybacks(sessions) {
+		if !playback.IsPlaying || !r.player.streamTokenExpiring(playback) {
+			continue
+		}
+		if err := r.player.renewPlayingStream(ctx, playback); err != nil {
+			slog.Warn("failed to renew stream token",
+				"session_id", playback.SessionID,
+				"item_id", playback.ItemID,
+				"error", err,
+			)
+		}
+	}
+}
+
+// currentPlaybacks returns the playbacks that haven't been replaced by a newer
+// playback on the same speaker or of the same web session. Renewing a replaced
+// playback would take its speaker back. sessions must be sorted newest first,
+// as returned by ListActive.
+func currentPlaybacks(sessions []*store.PlaybackSession) []*store.PlaybackSession {
+	speakers := make(map[string]bool)
+	webSessions := make(map[string]bool)
+
+	var current []*store.PlaybackSession
+	for _, playback := range sessions {
+		if speakers[playback.SonosUUID] || webSessions[playback.SessionID] {
+			continue
+		}
+		speakers[playback.SonosUUID] = true
+		webSessions[playback.SessionID] = true
+		current = append(current, playback)
+	}
+	return current
+}
+
+// streamTokenExpiring reports whether the stream token of a playback expires
+// within the renewal margin. Tokens that can't be parsed, e.g. after the
+// session secret changed, always need renewal.
+func (h *PlayerHandler) streamTokenExpiring(playback *store.PlaybackSession) bool {
+	payload, err := h.tokenGen.Parse(playback.StreamToken)
+	if err != nil {
+		return true
+	}
+	return time.Until(payload.ExpiresAt) < tokenRenewalMargin(h.tokenGen.TTL())
+}
+
+// renewPlayingStream renews the stream token of a playing session at the
+// speaker's current position.
+func (h *PlayerHandler) renewPlayingStream(ctx context.Context, playback *store.PlaybackSession) error {
+	device, err := h.sonosStore.Get(playback.SonosUUID)
+	if err != nil || device == nil {
+		return fmt.Errorf("device %s not found", playback.SonosUUID)
+	}
+	avt := sonos.NewAVTransport(h.getCoordinatorIP(ctx, device.IPAddress))
+
+	positionSec := playback.PositionSec
+	if posInfo, err := avt.GetPositionInfo(ctx); err == nil {
+		positionSec = playback.GlobalPosition(int(sonos.ParseDuration(posInfo.RelTime).Seconds()))
+	}
+
+	return h.renewStream(ctx, avt, playback, positionSec)
+}
+
+// renewStream issues a new stream token for a playback and sets the stream on
+// the speaker again, continuing at positionSec on the item's timeline.
+func (h *PlayerHandler) renewStream(ctx context.Context, avt *sonos.AVTransport, playback *store.PlaybackSession, positionSec int) error {
+	session, err := h.authHandler.sessionStore.Get(playback.SessionID)
+	if err != nil || session == nil {
+		return fmt.Errorf("session not found: %w", err)
+	}
+
+	cacheEntry, err := h.cacheIndex.GetEntry(playback.CacheKey())
+	if err != nil || cacheEntry == nil {
+		return fmt.Errorf("cache entry %s not found", playback.CacheKey())
+	}
+
+	token, err := h.tokenGen.GenerateForPlayback(playback.CacheKey(), session.UserID, session.ID, playback.ID, avt.DeviceIP())
+	if err != nil {
+		return fmt.Errorf("failed to generate stream token: %w", err)
+	}
+
+	// A live stream is replaced by the cache once it is ready
+	h.leaveLive(playback, cacheEntry)
+	segment, localSeekPos := h.streamStart(playback, positionSec)
+	streamURL := h.streamURL(token, playback, cacheEntry, segment)
+
+	var metadata string
+	if absClient, err := h.authHandler.GetABSClientForSession(session); err == nil {
+		if item, err := absClient.GetItem(ctx, playback.ItemID); err == nil {
+			metadata = buildDIDLMetadata(item, item.GetEpisode(playback.EpisodeID), streamURL, streamContentType(playback, cacheEntry))
+		}
+	}
+
+	if err := avt.SetAVTransportURI(ctx, streamURL, metadata); err != nil {
+		return fmt.Errorf("failed to set transport URI: %w", err)
+	}
+	if err := avt.Play(ctx); err != nil {
+		return fmt.Errorf("failed to start playback: %w", err)
+	}
+	if localSeekPos > 0 {
+		time.Sleep(500 * time.Millisecond)
+		if err := avt.Seek(ctx, time.Duration(localSeekPos)*time.Second); err != nil {
+			slog.Warn("failed to seek after stream token renewal", "error", err)
+		}
+	}
+
+	if err := h.playbackStore.UpdateStreamToken(playback.ID, token); err != nil {
+		return fmt.Errorf("failed to update stream token: %w", err)
+	}
+	h.playbackStore.UpdatePositionAndSegment(playback.ID, positionSec, segment)
+	h.playbackStore.UpdatePlaying(playback.ID, true)
+	playback.StreamToken = token
+
+	slog.Info("stream token renewed",
+		"session_id", playback.SessionID,
+		"item_id", playback.ItemID,
+		"position_sec", positionSec,
+		"segment", segment,
+	)
+	return nil
+}
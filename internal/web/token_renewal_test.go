@@ -0,0 +1,62 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"audiobookshelf-sonos-bridge/internal/store"
+	"audiobookshelf-sonos-bridge/internal/stream"
+)
+
+func TestTokenRenewalMargin(t *testing.T) {
+	if got := tokenRenewalMargin(24 * time.Hour); got != maxTokenRenewalMargin {
+		t.Errorf("expected %v for 24h, got %v", maxTokenRenewalMargin, got)
+	}
+	if got := tokenRenewalMargin(20 * time.Minute); got != 5*time.Minute {
+		t.Errorf("expected 5m for 20m, got %v", got)
+	}
+}
+
+func TestStreamTokenExpiring(t *testing.T) {
+	h := &PlayerHandler{tokenGen: stream.NewTokenGenerator("test-secret", time.Hour)}
+
+	fresh, err := h.tokenGen.Generate("item-1", "user-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.streamTokenExpiring(&store.PlaybackSession{StreamToken: fresh}) {
+		t.Error("expected fresh token not to need renewal")
+	}
+
+	// Issued with a shorter TTL, the token expires within the margin of 10 minutes
+	expiring, err := stream.NewTokenGenerator("test-secret", 5*time.Minute).Generate("item-1", "user-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.streamTokenExpiring(&store.PlaybackSession{StreamToken: expiring}) {
+		t.Error("expected token expiring in 5 minutes to need renewal")
+	}
+
+	if !h.streamTokenExpiring(&store.PlaybackSession{StreamToken: "garbage"}) {
+		t.Error("expected invalid token to need renewal")
+	}
+}
+
+func TestCurrentPlaybacks(t *testing.T) {
+	// Newest first, as listed by ListActive
+	sessions := []*store.PlaybackSession{
+		{ID: "playback-4", SessionID: "session-2", SonosUUID: "uuid:KITCHEN"},
+		{ID: "playback-3", SessionID: "session-1", SonosUUID: "uuid:LIVING"},
+		{ID: "playback-2", SessionID: "session-1", SonosUUID: "uuid:BATH"},
+		{ID: "playback-1", SessionID: "session-3", SonosUUID: "uuid:KITCHEN"},
+	}
+
+	current := currentPlaybacks(sessions)
+	if len(current) != 2 || current[0].ID != "playback-4" || current[1].ID != "playback-3" {
+		var ids []string
+		for _, p := range current {
+			ids = append(ids, p.ID)
+		}
+		t.Errorf("expected playback-4 and playback-3, got %v", ids)
+	}
+}